@@ -33,8 +33,11 @@ import (
 // Aspect is the base interface for advice
 // Aspect 增强点接口的基类
 type Aspect interface {
-	// Order returns the execution order, the smaller the value, the higher the priority
-	// Order 返回执行顺序，值越小，优先级越高
+	// Order returns the execution order, the smaller the value, the higher the priority.
+	// Aspects with the same order keep the order in which they were registered.
+	// Around-style advice (AroundAspect, InterceptAspect) is composed with the smallest order as the outermost layer.
+	// Order 返回执行顺序，值越小，优先级越高。值相同的切面按照注册顺序执行
+	// 环绕类型的增强点(AroundAspect、InterceptAspect)值越小越在外层
 	Order() int
 	// New returns a new instance of the aspect
 	// The method will be called to create a new instance during the initialization of the rule chain.
@@ -82,9 +85,46 @@ type AroundAspect interface {
 	//如果返回false:引擎不会调用下一个节点的OnMsg方法，需要切面执行tellNext方法，否则规则链不会结束。
 	//If it returns true: the engine will call the next node's OnMsg method.
 	//如果返回true：引擎会调用下一个节点的OnMsg方法。
+	//Around advice is composed by Order, the smallest order is the outermost layer and is called first.
+	//Once an advice returns false, the inner advice with a larger order is not called.
+	//Around增强点按照Order组合，值最小的在最外层最先调用；某个增强点返回false后，不再调用值更大的内层增强点。
 	Around(ctx RuleContext, msg RuleMsg, relationType string) (RuleMsg, bool)
 }
 
+// InterceptAspect is the interface for node intercept advice, which can skip the node or rewrite its output
+// without changing the rule chain definition, e.g. turning a node off by a feature flag, stubbing an external call
+// in a staging environment or injecting failures for chaos testing.
+// InterceptAspect 节点拦截增强点接口，可以跳过节点或者改写节点的输出，不需要修改规则链定义
+// 例如：通过特性开关关闭节点、在测试环境替换外部调用或者注入故障进行混沌测试
+type InterceptAspect interface {
+	NodeAspect
+	// InterceptBefore is the advice that executes after the Before and Around advice and before the node OnMsg method.
+	// The returned Msg will be used as the input for the next advice and the node OnMsg method.
+	// If it returns true, the node OnMsg method is not called and the returned Msg is routed through the Success relation,
+	// or through the relation returned by SkipRelation if the aspect implements InterceptSkipAspect.
+	// InterceptBefore 在Before和Around增强点之后，节点 OnMsg 方法执行之前的增强点。返回的Msg将作为下一个增强点和节点 OnMsg 方法的入参。
+	// 如果返回true：跳过节点，不调用节点 OnMsg 方法，返回的Msg通过Success关系或者 InterceptSkipAspect.SkipRelation 返回的关系路由
+	InterceptBefore(nodeCtx NodeCtx, ctx RuleContext, msg RuleMsg, relationType string) (RuleMsg, bool)
+	// InterceptAfter is the advice that executes when the node tells the next nodes, before the After advice.
+	// The returned Msg and relation type are used to route the message to the next nodes.
+	// InterceptBefore is called from the smallest order to the largest and stops at the first advice that skips the node,
+	// InterceptAfter is called in reverse order, so the advice with the smallest order is the outermost layer.
+	// InterceptAfter 节点通知下一个节点时，在After增强点之前执行的增强点。返回的Msg和关系类型用于查找和通知下一个节点
+	// InterceptBefore 按照Order从小到大调用，遇到跳过节点的增强点后停止；InterceptAfter 按照相反的顺序调用，Order值最小的在最外层
+	InterceptAfter(nodeCtx NodeCtx, ctx RuleContext, msg RuleMsg, err error, relationType string) (RuleMsg, string)
+}
+
+// InterceptSkipAspect is the interface for intercept advice that chooses the relation of skipped nodes.
+// If an InterceptAspect also implements this interface, the skipped message is routed through the relation type
+// and error returned by SkipRelation instead of the Success relation.
+// InterceptSkipAspect 指定跳过节点路由关系的拦截增强点接口，实现该接口的切面跳过节点后通过SkipRelation返回的关系和错误路由，代替Success关系
+type InterceptSkipAspect interface {
+	InterceptAspect
+	// SkipRelation returns the relation type and error used to route the skipped message, e.g. Failure and an injected error.
+	// SkipRelation 返回跳过节点后路由消息的关系类型和错误，例如：Failure关系和注入的错误
+	SkipRelation(nodeCtx NodeCtx, ctx RuleContext, msg RuleMsg) (string, error)
+}
+
 // StartAspect is the interface for rule engine pre-execution advice
 // StartAspect 规则引擎 OnMsg 方法执行之前的增强点接口
 type StartAspect interface {
@@ -132,6 +172,74 @@ type OnReloadAspect interface {
 	OnReload(parentCtx NodeCtx, ctx NodeCtx, err error) error
 }
 
+// ReloadDiff describes the nodes changed by an incremental reload of the rule chain.
+// ReloadDiff 增量重新加载规则链时节点的变化
+type ReloadDiff struct {
+	// Added are the ids of the nodes added to the rule chain.
+	// Added 新增的节点ID
+	Added []string
+	// Changed are the ids of the nodes whose type or configuration changed and were re-initialized.
+	// Changed 类型或者配置变化，重新初始化的节点ID
+	Changed []string
+	// Removed are the ids of the nodes removed from the rule chain.
+	// Removed 删除的节点ID
+	Removed []string
+}
+
+// NodeIds returns the ids of all added, changed and removed nodes.
+// NodeIds 返回所有新增、变化和删除的节点ID
+func (d ReloadDiff) NodeIds() []string {
+	var ids []string
+	ids = append(ids, d.Added...)
+	ids = append(ids, d.Changed...)
+	ids = append(ids, d.Removed...)
+	return ids
+}
+
+// OnReloadDiffAspect is the interface for reload advice that needs to know which nodes an incremental reload changed.
+// If an OnReloadAspect also implements this interface, OnReloadDiff is called instead of OnReload for incremental reloads.
+// OnReloadDiffAspect 需要知道增量重新加载变化了哪些节点的重新加载增强点接口，增量重新加载时实现该接口的切面调用OnReloadDiff代替OnReload
+type OnReloadDiffAspect interface {
+	OnReloadAspect
+	// OnReloadDiff is the advice that executes after the rule chain is reloaded incrementally.
+	// OnReloadDiff 规则链增量重新加载之后的增强点，diff为变化的节点
+	OnReloadDiff(chainCtx NodeCtx, diff ReloadDiff, err error) error
+}
+
+// Mutation operations of ChainMutation.
+// ChainMutation 的变更操作
+const (
+	MutationAddNode          = "addNode"
+	MutationUpdateNode       = "updateNode"
+	MutationRemoveNode       = "removeNode"
+	MutationAddConnection    = "addConnection"
+	MutationRemoveConnection = "removeConnection"
+)
+
+// ChainMutation describes a single runtime edit of a rule chain, such as adding a node or removing a connection.
+// ChainMutation 描述一次规则链运行时编辑，例如新增节点或者删除连接
+type ChainMutation struct {
+	// Op is the mutation operation, such as MutationAddNode.
+	// Op 变更操作，例如：MutationAddNode
+	Op string
+	// NodeId is the id of the added, updated or removed node.
+	// NodeId 新增、更新或者删除的节点ID
+	NodeId string
+	// Connections are the added or removed connections, including the connections cascade-deleted with a node.
+	// Connections 新增或者删除的连接，包括删除节点时级联删除的连接
+	Connections []NodeConnection
+}
+
+// OnMutationAspect is the interface for reload advice that needs to know how a rule chain was edited at runtime.
+// If an OnReloadAspect also implements this interface, OnMutation is called instead of OnReload for runtime edits.
+// OnMutationAspect 需要知道规则链运行时编辑内容的重新加载增强点接口，运行时编辑时实现该接口的切面调用OnMutation代替OnReload
+type OnMutationAspect interface {
+	OnReloadAspect
+	// OnMutation is the advice that executes after the rule chain is edited at runtime.
+	// OnMutation 规则链运行时编辑之后的增强点
+	OnMutation(chainCtx NodeCtx, mutation ChainMutation, err error) error
+}
+
 // OnDestroyAspect is the interface for rule engine instance destruction advice
 // OnDestroyAspect 规则引擎实例销毁执行之后增强点接口
 type OnDestroyAspect interface {
@@ -141,21 +249,37 @@ type OnDestroyAspect interface {
 	OnDestroy(chainCtx NodeCtx)
 }
 
-type AspectList []Aspect
+// OnDestroyModeAspect is the interface for destruction advice that needs to know whether the rule chain was shut down gracefully.
+// If an OnDestroyAspect also implements this interface, OnDestroyWithMode is called instead of OnDestroy.
+// OnDestroyModeAspect 需要区分优雅关闭和强制关闭的销毁增强点接口，实现该接口的切面调用OnDestroyWithMode代替OnDestroy
+type OnDestroyModeAspect interface {
+	OnDestroyAspect
+	// OnDestroyWithMode is the advice that executes after the rule engine instance is destroyed.
+	// graceful is true if all in-flight messages completed before the nodes were destroyed,
+	// and false for a forced destruction, a graceful destruction that timed out, or a reload.
+	// OnDestroyWithMode graceful=true：所有正在执行的消息完成后才销毁节点；false：强制销毁、优雅关闭超时或者重新加载规则链
+	OnDestroyWithMode(chainCtx NodeCtx, graceful bool)
+}
 
-// GetNodeAspects 获取节点执行类型增强点切面列表
-func (list AspectList) GetNodeAspects() ([]AroundAspect, []BeforeAspect, []AfterAspect) {
+type AspectList []Aspect
 
-	//从小到大排序
-	sort.Slice(list, func(i, j int) bool {
-		return list[i].Order() < list[j].Order()
+// Sorted 返回按照Order从小到大稳定排序的切面列表副本，Order相同的切面保持注册顺序，不修改原列表
+func (list AspectList) Sorted() AspectList {
+	sorted := make(AspectList, len(list))
+	copy(sorted, list)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Order() < sorted[j].Order()
 	})
+	return sorted
+}
 
+// GetNodeAspects 获取节点执行类型增强点切面列表，按照Order从小到大排序，Order相同保持注册顺序
+func (list AspectList) GetNodeAspects() ([]AroundAspect, []BeforeAspect, []AfterAspect) {
 	var aroundAspects []AroundAspect
 	var beforeAspects []BeforeAspect
 	var afterAspects []AfterAspect
 
-	for _, item := range list {
+	for _, item := range list.Sorted() {
 		if a, ok := item.(AroundAspect); ok {
 			aroundAspects = append(aroundAspects, a)
 		}
@@ -170,18 +294,23 @@ func (list AspectList) GetNodeAspects() ([]AroundAspect, []BeforeAspect, []After
 	return aroundAspects, beforeAspects, afterAspects
 }
 
-// GetChainAspects 获取规则链执行类型增强点切面列表
-func (list AspectList) GetChainAspects() ([]StartAspect, []EndAspect, []CompletedAspect) {
-
-	//从小到大排序
-	sort.Slice(list, func(i, j int) bool {
-		return list[i].Order() < list[j].Order()
-	})
+// GetInterceptAspects 获取节点拦截类型增强点切面列表，按照Order从小到大排序，Order相同保持注册顺序
+func (list AspectList) GetInterceptAspects() []InterceptAspect {
+	var interceptAspects []InterceptAspect
+	for _, item := range list.Sorted() {
+		if a, ok := item.(InterceptAspect); ok {
+			interceptAspects = append(interceptAspects, a)
+		}
+	}
+	return interceptAspects
+}
 
+// GetChainAspects 获取规则链执行类型增强点切面列表，按照Order从小到大排序，Order相同保持注册顺序
+func (list AspectList) GetChainAspects() ([]StartAspect, []EndAspect, []CompletedAspect) {
 	var startAspects []StartAspect
 	var endAspects []EndAspect
 	var completedAspects []CompletedAspect
-	for _, item := range list {
+	for _, item := range list.Sorted() {
 		if a, ok := item.(StartAspect); ok {
 			startAspects = append(startAspects, a)
 		}
@@ -196,19 +325,13 @@ func (list AspectList) GetChainAspects() ([]StartAspect, []EndAspect, []Complete
 	return startAspects, endAspects, completedAspects
 }
 
-// GetEngineAspects 获取规则引擎类型增强点切面列表
+// GetEngineAspects 获取规则引擎类型增强点切面列表，按照Order从小到大排序，Order相同保持注册顺序
 func (list AspectList) GetEngineAspects() ([]OnCreatedAspect, []OnReloadAspect, []OnDestroyAspect) {
-
-	//从小到大排序
-	sort.Slice(list, func(i, j int) bool {
-		return list[i].Order() < list[j].Order()
-	})
-
 	var createdAspects []OnCreatedAspect
 	var reloadAspects []OnReloadAspect
 	var destroyAspects []OnDestroyAspect
 
-	for _, item := range list {
+	for _, item := range list.Sorted() {
 		if a, ok := item.(OnCreatedAspect); ok {
 			createdAspects = append(createdAspects, a)
 		}
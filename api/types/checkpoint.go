@@ -0,0 +1,45 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package types
+
+// Checkpoint 消息执行位置检查点，节点执行前记录，分支执行结束后删除
+// 进程重启后通过 `RuleEngine.ResumePending` 从记录的节点恢复执行
+type Checkpoint struct {
+	//Id 检查点ID，由消息ID和节点ID组成
+	Id string `json:"id"`
+	//ChainId 规则链ID
+	ChainId string `json:"chainId"`
+	//NodeId 即将执行的节点ID
+	NodeId string `json:"nodeId"`
+	//RelationType 进入该节点的关系类型，第一个节点为空
+	RelationType string `json:"relationType"`
+	//Msg 进入该节点的消息
+	Msg RuleMsg `json:"msg"`
+	//Ts 记录时间，单位毫秒
+	Ts int64 `json:"ts"`
+}
+
+// CheckpointStore 检查点存储，通过 `WithCheckpointStore` 配置
+// 实现需要支持并发调用
+type CheckpointStore interface {
+	//Save 保存检查点，相同ID覆盖
+	Save(checkpoint Checkpoint) error
+	//Delete 删除检查点，不存在不返回错误
+	Delete(id string) error
+	//List 获取所有检查点
+	List() ([]Checkpoint, error)
+}
@@ -17,6 +17,7 @@
 package types
 
 import (
+	"context"
 	"github.com/rulego/rulego/api/pool"
 	"math"
 	"time"
@@ -24,6 +25,28 @@ import (
 
 var OnDebug func(ruleChainId string, flowType string, nodeId string, msg RuleMsg, relationType string, err error)
 
+// DebugDetail 节点调试详细信息，参考 Config.OnDebugDetail
+type DebugDetail struct {
+	//RuleChainId 规则链ID
+	RuleChainId string
+	//FlowType IN/OUT,流入(IN)该组件或者流出(OUT)该组件事件类型
+	FlowType string
+	//NodeId 节点ID
+	NodeId string
+	//MsgId 消息ID
+	MsgId string
+	//Msg 当前msg的副本
+	Msg RuleMsg
+	//RelationType 如果flowType=IN，则代表上一个节点和该节点的连接关系；如果flowType=OUT，则代表该节点输出的连接关系
+	RelationType string
+	//NextNodeIds flowType=OUT时，根据RelationType解析到的下一个节点ID列表，为空表示该分支结束
+	NextNodeIds []string
+	//Duration flowType=OUT时，节点从流入到流出的执行耗时
+	Duration time.Duration
+	//Err 错误信息
+	Err error
+}
+
 // Config 规则引擎配置
 type Config struct {
 	//OnDebug 节点调试信息回调函数，只有节点debugMode=true才会调用
@@ -34,6 +57,23 @@ type Config struct {
 	//relationType 如果flowType=IN，则代表上一个节点和该节点的连接关系，例如(True/False);如果flowType=OUT，则代表该节点和下一个节点的连接关系，例如(True/False)
 	//err 错误信息
 	OnDebug func(ruleChainId string, flowType string, nodeId string, msg RuleMsg, relationType string, err error)
+	//OnDebugTrace 消息执行轨迹回调函数，只记录debugMode=true的节点
+	//每条消息所有节点执行完成后调用一次，按发生顺序返回节点流入、流出关系和时间
+	OnDebugTrace func(trace DebugTrace)
+	//OnDebugDetail 节点调试详细信息回调函数，只有节点debugMode=true才会调用，和OnDebug同时生效
+	//比OnDebug多了消息ID、节点执行耗时和流出时解析到的下一个节点ID列表，参考 DebugDetail
+	OnDebugDetail func(detail DebugDetail)
+	//DebugSampling 调试回调采样策略，按消息比例采样和/或限制每秒调试事件数，被丢弃的调试事件计入规则链统计的DebugDropped
+	//规则链DSL配置ruleChain.debugSampling后覆盖该配置，默认不采样，所有调试事件都回调
+	DebugSampling DebugSampling
+	//TraceMaxDataSize 通过 WithTrace 收集的消息快照中，数据和每个元数据值保留的最大字节数，超过部分被截断，默认4096，<=0不截断
+	TraceMaxDataSize int
+	//OnDeadLetter 消息停止在没有连接的关系时的回调函数：Failure关系，或者节点有连接但是没有该关系的连接
+	//和规则链DSL配置ruleChain.deadLetterNodeId同时生效
+	//nodeId 消息停止的节点ID
+	//relationType 没有连接的关系类型
+	//err 节点输出的错误信息
+	OnDeadLetter func(ruleChainId string, nodeId string, msg RuleMsg, relationType string, err error)
 	//Deprecated
 	//使用types.WithEndFunc方式代替
 	//OnEnd 规则链执行完成回调函数，如果有多个结束点，则执行多次
@@ -47,6 +87,22 @@ type Config struct {
 	//	pool, _ := ants.NewPool(math.MaxInt32)
 	//	config := rulego.NewConfig(types.WithPool(pool))
 	Pool Pool
+	//PoolOverflowPolicy 协程池已满(Submit返回错误)时，提交节点执行任务的策略：
+	//OverflowBlock(默认)：OnMsg调用方阻塞等待，协程池中正在执行的任务(包括子规则链调用)由该任务所在的协程直接执行，避免互相等待导致死锁；
+	//OverflowDrop：立即拒绝，该消息分支通过OnEnd回调 engine.ErrPoolExhausted 错误；
+	//OverflowQueue：放入最多PoolMaxPending个任务的队列等待提交，队列已满则拒绝
+	//通过 RuleEngine.PoolStats 查看等待和拒绝的任务数
+	PoolOverflowPolicy string
+	//PoolMaxPending PoolOverflowPolicy=OverflowQueue时，等待提交的最大任务数；开启优先级调度时，为通道中等待执行的最大任务数
+	PoolMaxPending int
+	//PriorityWorkers 优先级调度最多同时执行的节点任务数，>0开启优先级调度，节点执行任务按消息优先级放入高、普通、低三个通道，
+	//优先执行高优先级通道的任务，参考 WithPriority。所有并发数都在执行任务时，新的任务按照PoolOverflowPolicy处理：
+	//OverflowBlock：通道中等待的任务达到PoolMaxPending(没有配置则为PriorityWorkers)时OnMsg调用方阻塞等待；
+	//OverflowDrop：立即拒绝；OverflowQueue：通道中等待的任务达到PoolMaxPending时拒绝
+	//默认0：不开启，节点执行任务直接提交到协程池
+	PriorityWorkers int
+	//PriorityBurst 连续执行更高优先级任务的最大次数，达到后如果有等待的低优先级任务，则先执行一个低优先级任务，默认8
+	PriorityBurst int
 	//ComponentsRegistry 组件库
 	//默认使用`rulego.Registry`
 	ComponentsRegistry ComponentRegistry
@@ -65,6 +121,51 @@ type Config struct {
 	SecretKey string
 	//规则链DSL，endpoint模块是否可用
 	EndpointEnabled bool
+	//DisallowEmptyChain 是否禁止初始化没有任何节点的规则链
+	//默认false：没有节点的规则链会初始化成功，并丢弃所有消息；true：初始化返回错误
+	DisallowEmptyChain bool
+	//AllowCycle 是否允许节点连接存在环路
+	//默认false：规则链初始化时检测节点连接，存在环路(包括节点连接自身)则返回错误；true：不检测，适用于使用守卫节点有意构建循环的规则链
+	AllowCycle bool
+	//OnReloadVerify 规则链重新加载成功后的校验回调函数，例如：发送一条测试消息检查路由是否正确
+	//如果返回错误，则回滚到重新加载前的规则链定义，并且ReloadSelf返回该错误
+	//chainCtx 重新加载后的规则链实例
+	OnReloadVerify func(chainCtx ChainCtx) error
+	//BaseContext 规则链根上下文，所有消息默认继承该上下文，默认context.Background()
+	//可用于统一取消所有正在处理的消息，例如：优雅停机
+	BaseContext context.Context
+	//ReloadDrainTimeout 重新加载规则链时，等待旧节点实例上正在执行的消息完成的最长时间，超时后强制销毁旧节点实例
+	//新的消息在重新加载后立即使用新的节点实例，默认10秒，<=0不等待
+	ReloadDrainTimeout time.Duration
+	//CheckpointStore 检查点存储，配置后每个节点执行前记录消息执行位置，分支执行结束后删除
+	//进程重启后可以通过 `RuleEngine.ResumePending` 从记录的节点恢复执行未完成的消息，默认nil不记录
+	CheckpointStore CheckpointStore
+	//MaxVersions 规则链重新加载时保留的历史定义数量，超过后丢弃最旧的定义，默认5，<=0不保留
+	//可以通过 `RuleEngine.ListVersions` 查看历史定义，通过 `RuleEngine.Rollback` 回滚到指定版本
+	MaxVersions int
+	//ReloadDebounce 规则链重新加载合并窗口，>0时已经初始化的规则引擎ReloadSelf不立即重新加载，
+	//窗口内连续的重新加载只应用最后一个定义，最后一次调用后经过该时间才重新加载，ReloadSelf立即返回nil，
+	//重新加载的错误通过Logger输出，并传递给重新加载切面。需要立即重新加载使用 `RuleEngine.ReloadSelfNow`
+	//默认0：不合并，每次ReloadSelf都立即重新加载
+	ReloadDebounce time.Duration
+	//AllowNodeInitFailure 是否允许节点初始化失败
+	//默认false：任意节点初始化失败，规则链初始化返回错误；true：初始化失败的节点使用占位节点代替，规则链以降级模式初始化，
+	//占位节点把所有消息通过Failure关系发送，元数据nodeInitError为初始化错误，通过 `RuleChainCtx.DegradedNodes` 查看降级的节点，
+	//通过ReloadChild重新加载成功后节点恢复正常
+	AllowNodeInitFailure bool
+	//MaxChainDepth 子规则链调用栈的最大深度，根规则链深度为1
+	//默认0：不允许递归调用，进入调用栈上已有的规则链时通过Failure关系返回递归调用错误；
+	//>0：允许有限次数的递归调用，调用栈深度超过该值时返回错误
+	MaxChainDepth int
+	//AllowDefaultPoolFallback 子规则链在规则引擎显式设置的池和创建规则引擎的池都找不到时，是否从全局默认池DefaultPool查找
+	//默认false：不查找，同一进程中的多个规则链池互相隔离
+	AllowDefaultPoolFallback bool
+	//SharedClients 共享客户端注册表，组件可以通过该注册表复用相同服务器配置的客户端连接，例如：多个节点连接同一个MQTT服务器只建立一个连接
+	//默认使用 `engine.SharedClients`，所有使用默认配置的规则链共享；nil：组件各自创建客户端
+	SharedClients SharedClientRegistry
+	//PanicCrashFast 节点执行发生panic时是否不恢复，直接让进程崩溃，用于开发调试时尽早暴露问题
+	//默认false：恢复panic，转换成包含节点ID和调用栈的错误，通过Failure关系路由到下一个节点
+	PanicCrashFast bool
 }
 
 // RegisterUdf 注册自定义函数
@@ -87,6 +188,9 @@ func NewConfig(opts ...Option) Config {
 		Logger:                 DefaultLogger(),
 		Properties:             NewMetadata(),
 		EndpointEnabled:        true,
+		ReloadDrainTimeout:     time.Second * 10,
+		MaxVersions:            5,
+		TraceMaxDataSize:       4096,
 	}
 
 	// Apply the options to the Config.
@@ -20,10 +20,16 @@ const (
 	CallbackFuncOnRuleChainCompleted = "onRuleChainCompleted"
 	CallbackFuncOnNodeCompleted      = "onNodeCompleted"
 	CallbackFuncDebug                = "onDebug"
+	CallbackFuncOnTrace              = "onTrace"
 )
 
 const (
 	Global  = "global"
 	Vars    = "vars"
 	Secrets = "secrets"
+	// RequiredSecrets 规则链必须提供的secrets key列表，缺失则规则链初始化失败
+	RequiredSecrets = "requiredSecrets"
+	// EnabledIf 节点启用条件表达式，在规则链加载时计算一次，结果为false则不初始化该节点，
+	// 流入该节点的消息直接流转到它除了失败(Failure)关系以外的所有下一个节点
+	EnabledIf = "enabledIf"
 )
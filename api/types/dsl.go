@@ -39,8 +39,47 @@ type RuleChainBaseInfo struct {
 	Configuration Configuration `json:"configuration,omitempty"`
 	// AdditionalInfo is an extension field.
 	AdditionalInfo map[string]string `json:"additionalInfo,omitempty"`
+	// MaxConcurrency is the maximum number of messages the rule chain processes concurrently, 0 means no limit.
+	// Sub-chain invocations count against the sub-chain's own limit, and are rejected instead of waiting when it is reached.
+	MaxConcurrency int `json:"maxConcurrency,omitempty"`
+	// OverflowPolicy is the behavior when MaxConcurrency is reached: OverflowBlock(default), OverflowDrop or OverflowQueue.
+	OverflowPolicy string `json:"overflowPolicy,omitempty"`
+	// MaxPending is the maximum number of messages waiting for a slot when OverflowPolicy is OverflowQueue.
+	MaxPending int `json:"maxPending,omitempty"`
+	// ExecutionTimeoutMs is the maximum time in milliseconds for a message to finish the whole chain, 0 means no limit.
+	// It can be overridden per message by `WithExecutionTimeout` or the ExecutionTimeoutKey metadata.
+	ExecutionTimeoutMs int `json:"executionTimeoutMs,omitempty"`
+	// NodeTimeoutMs is the default execution timeout in milliseconds of the nodes that don't set their own Timeout, 0 means no limit.
+	NodeTimeoutMs int `json:"nodeTimeoutMs,omitempty"`
+	// DeadLetterNodeId is the id of the node that receives the messages stopped at an unmatched relation:
+	// a `Failure` relation, or any relation of a node that has connections but none for that relation.
+	// The source node, relation type and error are added to the message metadata, see engine.DeadLetterFromKey.
+	DeadLetterNodeId string `json:"deadLetterNodeId,omitempty"`
+	// StrictRelations reports the unmatched relations that are not handled by DeadLetterNodeId as an error in OnEnd.
+	StrictRelations bool `json:"strictRelations,omitempty"`
+	// DebugSampling is the sampling policy of the debug callbacks of this rule chain, it overrides `Config.DebugSampling` if set.
+	DebugSampling *DebugSampling `json:"debugSampling,omitempty"`
 }
 
+// DebugSampling defines the sampling policy of the debug callbacks, used to reduce the overhead of debug mode on high-throughput rule chains.
+// Sampled-out debug events are counted in the DebugDropped field of the rule chain stats.
+type DebugSampling struct {
+	// Rate samples 1 in Rate messages, all debug events of a sampled message are kept. 0 or 1 means all messages.
+	Rate int `json:"rate,omitempty"`
+	// MaxPerSecond is the maximum number of debug events per second of the rule chain, 0 means no limit.
+	MaxPerSecond int `json:"maxPerSecond,omitempty"`
+}
+
+// Overflow policies of the rule chain concurrency limit.
+const (
+	// OverflowBlock blocks the caller until a slot is available or the message context is done.
+	OverflowBlock = "block"
+	// OverflowDrop rejects the message and delivers the error to OnEnd.
+	OverflowDrop = "drop"
+	// OverflowQueue blocks the caller while fewer than MaxPending messages are waiting, otherwise rejects the message.
+	OverflowQueue = "queue"
+)
+
 // GetAdditionalInfo retrieves additional information by key.
 func (r RuleChainBaseInfo) GetAdditionalInfo(key string) (string, bool) {
 	if r.AdditionalInfo == nil {
@@ -62,6 +101,12 @@ func (r RuleChainBaseInfo) PutAdditionalInfo(key, value string) {
 type RuleMetadata struct {
 	// FirstNodeIndex is the index of the first node in data flow, default is 0.
 	FirstNodeIndex int `json:"firstNodeIndex"`
+	// FirstNodeId is the id of the first node in data flow. If set, it takes precedence over FirstNodeIndex,
+	// so reordering the nodes does not change where messages enter the chain.
+	FirstNodeId string `json:"firstNodeId,omitempty"`
+	// EntryPoints are the named entry points of the rule chain, key: entry point name, value: node id.
+	// Use `WithEntryPoint` to select the entry point of a message, otherwise messages enter the chain from the first node.
+	EntryPoints map[string]string `json:"entryPoints,omitempty"`
 	// Nodes are the component definitions of the nodes.
 	Endpoints []*EndpointDsl `json:"endpoints,omitempty"`
 	// Nodes are the component definitions of the nodes.
@@ -87,12 +132,38 @@ type RuleNode struct {
 	// DebugMode indicates whether the node is in debug mode. If true, a debug callback function is triggered when the node processes messages.
 	// This setting can be overridden by the RuleChain `DebugMode` configuration.
 	DebugMode bool `json:"debugMode"`
+	// Terminal indicates whether the node is a terminal node. When a message reaches a terminal node and the node finishes,
+	// the branch ends without looking up further connections, and OnEnd is called with the `Completed` relation type
+	// (or the node's relation type if it failed). A rule chain can have multiple terminal nodes.
+	Terminal bool `json:"terminal,omitempty"`
+	// Timeout is the execution timeout of the node in milliseconds. If the node doesn't tell the next nodes within the timeout,
+	// the message is routed to the `Failure` relation with a timeout error, and later tells of the node are ignored.
+	// 0 means the default NodeTimeoutMs of the rule chain is used.
+	Timeout int `json:"timeout,omitempty"`
+	// Retry is the retry policy of the node. If the node tells a retryable relation, the engine invokes the node again
+	// with the original message after a backoff delay, and routes the message onward only after the attempts are exhausted.
+	Retry *RetryPolicy `json:"retry,omitempty"`
 	// Configuration contains the configuration parameters of the node, which vary depending on the node type.
 	// For example, a JS filter node might have a `jsScript` field defining the filtering logic,
 	// while a REST API call node might have a `restEndpointUrlPattern` field defining the URL to call.
 	Configuration Configuration `json:"configuration"`
 }
 
+// RetryPolicy defines the retry policy of a node, see RuleNode.Retry.
+// The delay before the attempt n+1 is InitialDelayMs * Multiplier^(n-1), capped at MaxDelayMs.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts including the first one, values less than 2 disable retries.
+	MaxAttempts int `json:"maxAttempts"`
+	// InitialDelayMs is the delay in milliseconds before the first retry.
+	InitialDelayMs int `json:"initialDelayMs,omitempty"`
+	// Multiplier is the factor applied to the delay after each retry, values less than 1 are treated as 1.
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// MaxDelayMs is the maximum delay in milliseconds between two attempts, 0 means no limit.
+	MaxDelayMs int `json:"maxDelayMs,omitempty"`
+	// RetryOn are the relation types that trigger a retry, default is `Failure`.
+	RetryOn []string `json:"retryOn,omitempty"`
+}
+
 // NodeAdditionalInfo is used for visualization position information (reserved field).
 type NodeAdditionalInfo struct {
 	Description string `json:"description"`
@@ -109,6 +180,10 @@ type NodeConnection struct {
 	// Type is the type of connection, which determines when and how messages are sent from one node to another. It should match one of the connection types supported by the source node type.
 	// For example, a JS filter node might support two connection types: "True" and "False," indicating whether the message passes or fails the filter condition.
 	Type string `json:"type"`
+	// Priority is the dispatch priority of the connection. When a node has several targets for the same connection type,
+	// targets with a higher priority are told first; targets with the same priority keep the order in which they are declared.
+	// Optional, default is 0.
+	Priority int `json:"priority,omitempty"`
 }
 
 // RuleChainConnection defines the connection between a node and a sub-rule chain.
@@ -119,6 +194,8 @@ type RuleChainConnection struct {
 	ToId string `json:"toId"`
 	// Type is the type of connection, which determines when and how messages are sent from one node to another. It should match one of the connection types supported by the source node type.
 	Type string `json:"type"`
+	// Priority is the dispatch priority of the connection, see NodeConnection.Priority.
+	Priority int `json:"priority,omitempty"`
 }
 
 // RuleChainRunSnapshot is a snapshot of the rule chain execution log.
@@ -156,6 +233,100 @@ type RuleNodeRunLog struct {
 	EndTs int64 `json:"endTs"`
 }
 
+// DebugTrace is the ordered execution trace of one message, collected in debug mode and delivered when the message completes.
+type DebugTrace struct {
+	// ChainId is the rule chain ID.
+	ChainId string `json:"chainId"`
+	// MsgId is the message ID.
+	MsgId string `json:"msgId"`
+	// StartTs is the start time of execution.
+	StartTs int64 `json:"startTs"`
+	// EndTs is the end time of execution.
+	EndTs int64 `json:"endTs"`
+	// Items are the node entries and exits in the order they happened.
+	Items []DebugTraceItem `json:"items"`
+}
+
+// DebugTraceItem is one node entry or exit of a DebugTrace.
+type DebugTraceItem struct {
+	// NodeId is the node ID.
+	NodeId string `json:"nodeId"`
+	// FlowType is IN when the message enters the node and OUT when it leaves the node.
+	FlowType string `json:"flowType"`
+	// RelationType is the relation from the previous node (IN) or to the next node (OUT).
+	RelationType string `json:"relationType"`
+	// Ts is the time of the entry or exit in milliseconds.
+	Ts int64 `json:"ts"`
+	// Err is the error information.
+	Err string `json:"err,omitempty"`
+}
+
+// ExecutionTrace is the execution tree of one message collected with `WithTrace` and delivered when the message completes.
+// A node that tells several next nodes has one child per next node, so the branches of the message are kept.
+type ExecutionTrace struct {
+	// ChainId is the rule chain ID.
+	ChainId string `json:"chainId"`
+	// MsgId is the message ID.
+	MsgId string `json:"msgId"`
+	// StartTs is the start time of execution in milliseconds.
+	StartTs int64 `json:"startTs"`
+	// EndTs is the end time of execution in milliseconds.
+	EndTs int64 `json:"endTs"`
+	// DefinitionHash is the hash of the rule chain definition that executed the message, see dsl.DefinitionHash.
+	// It can be used to refuse replaying the message after the definition changed.
+	DefinitionHash string `json:"definitionHash"`
+	// Root is the first node the message entered, nil if the message was rejected before entering any node.
+	Root *TraceNode `json:"root,omitempty"`
+}
+
+// TraceNode is one node execution of an ExecutionTrace.
+type TraceNode struct {
+	// NodeId is the node ID.
+	NodeId string `json:"nodeId"`
+	// RelationType is the relation from the parent node to this node, empty for the root node.
+	RelationType string `json:"relationType"`
+	// StartTs is the time the message entered the node in milliseconds.
+	StartTs int64 `json:"startTs"`
+	// EndTs is the time of the last output of the node in milliseconds, 0 if the node has not finished.
+	EndTs int64 `json:"endTs"`
+	// InMsg is the snapshot of the message entering the node.
+	InMsg TraceMsg `json:"inMsg"`
+	// Outputs are the outputs of the node in the order they happened, a node can output several times.
+	Outputs []TraceOutput `json:"outputs,omitempty"`
+	// Children are the next nodes executed by this node, in the order they were entered.
+	Children []*TraceNode `json:"children,omitempty"`
+}
+
+// TraceOutput is one output of a TraceNode.
+type TraceOutput struct {
+	// RelationType is the relation to the next nodes.
+	RelationType string `json:"relationType"`
+	// Ts is the time of the output in milliseconds.
+	Ts int64 `json:"ts"`
+	// Msg is the snapshot of the output message.
+	Msg TraceMsg `json:"msg"`
+	// Err is the error information.
+	Err string `json:"err,omitempty"`
+}
+
+// TraceMsg is the message snapshot of a TraceNode. Data and metadata values longer than `Config.TraceMaxDataSize`
+// are truncated and end with TraceTruncatedMarker.
+type TraceMsg struct {
+	// Type is the message type.
+	Type string `json:"type"`
+	// DataType is the message data type.
+	DataType DataType `json:"dataType"`
+	// Data is the message data.
+	Data string `json:"data"`
+	// Metadata is the message metadata.
+	Metadata map[string]string `json:"metadata"`
+	// Truncated indicates whether the data or any metadata value is truncated.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// TraceTruncatedMarker is appended to the truncated data and metadata values of a TraceMsg.
+const TraceTruncatedMarker = "...(truncated)"
+
 // EndpointDsl defines the DSL for an endpoint.
 type EndpointDsl struct {
 	// Id is the endpoint ID.
@@ -20,8 +20,10 @@ package endpoint
 
 import (
 	"context"
+	"crypto/tls"
 	"github.com/rulego/rulego/api/types"
 	"net/textproto"
+	"sync"
 )
 
 // Event constants define various event types in the endpoints.
@@ -36,6 +38,10 @@ const (
 	EventCompletedServer = "completedServer"
 )
 
+// RelationTypeKey is the metadata key of the output message that holds the relation type
+// with which the rule chain branch ended. It is set by the executor before the `to` processors run.
+const RelationTypeKey = "endRelationType"
+
 // OnEvent is a function type that listens to named events with optional parameters.
 type OnEvent func(eventName string, params ...interface{})
 
@@ -110,6 +116,20 @@ type Message interface {
 	GetError() error
 }
 
+// TLSMessage is implemented by messages received over a TLS connection, such as HTTP and WebSocket requests.
+// Processors can type-assert exchange.In to TLSMessage to access the peer certificates.
+type TLSMessage interface {
+	// TLSConnectionState returns the TLS connection state, or nil if the message was not received over TLS.
+	TLSConnectionState() *tls.ConnectionState
+}
+
+// StatusCodeMessage is implemented by response messages that record the status code sent to the client, such as HTTP responses.
+// Processors can type-assert exchange.Out to StatusCodeMessage to read the response status, for example for access logs.
+type StatusCodeMessage interface {
+	// StatusCode returns the status code sent to the client.
+	StatusCode() int
+}
+
 // Exchange is a structure containing both inbound and outbound messages.
 type Exchange struct {
 	// In represents the incoming message.
@@ -118,6 +138,29 @@ type Exchange struct {
 	Out Message
 	// Context provides a context for the exchange.
 	Context context.Context
+	// onDoneFuncs are the functions called when the exchange processing finishes.
+	onDoneFuncs []func()
+	lock        sync.Mutex
+}
+
+// OnDone registers a function that is called once when the endpoint finishes processing the exchange,
+// including when a processor interrupts the processing or a panic occurs.
+// Processors use it to release resources acquired for the exchange, such as concurrency slots.
+func (e *Exchange) OnDone(f func()) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.onDoneFuncs = append(e.onDoneFuncs, f)
+}
+
+// Done calls the functions registered by OnDone in reverse order of registration and clears them.
+func (e *Exchange) Done() {
+	e.lock.Lock()
+	funcs := e.onDoneFuncs
+	e.onDoneFuncs = nil
+	e.lock.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
 }
 
 // From is an interface representing the source of data in a routing operation.
@@ -17,6 +17,7 @@
 package types
 
 import (
+	"context"
 	"github.com/rulego/rulego/api/pool"
 	"math"
 	"time"
@@ -41,6 +42,56 @@ func WithOnDebug(onDebug func(ruleChainId string, flowType string, nodeId string
 	}
 }
 
+// WithOnDeadLetter is an option that sets the callback of the messages stopped at an unmatched relation.
+func WithOnDeadLetter(onDeadLetter func(ruleChainId string, nodeId string, msg RuleMsg, relationType string, err error)) Option {
+	return func(c *Config) error {
+		c.OnDeadLetter = onDeadLetter
+		return nil
+	}
+}
+
+// WithOnDebugDetail is an option that sets the callback receiving the node debug detail with duration and resolved next nodes.
+func WithOnDebugDetail(onDebugDetail func(detail DebugDetail)) Option {
+	return func(c *Config) error {
+		c.OnDebugDetail = onDebugDetail
+		return nil
+	}
+}
+
+// WithPoolOverflowPolicy is an option that sets the policy of submitting tasks when the worker pool is full.
+// maxPending is the queue size used by OverflowQueue.
+func WithPoolOverflowPolicy(policy string, maxPending int) Option {
+	return func(c *Config) error {
+		c.PoolOverflowPolicy = policy
+		c.PoolMaxPending = maxPending
+		return nil
+	}
+}
+
+// WithPriorityWorkers is an option that enables the priority dispatch with the maximum number of concurrent node tasks.
+func WithPriorityWorkers(workers int) Option {
+	return func(c *Config) error {
+		c.PriorityWorkers = workers
+		return nil
+	}
+}
+
+// WithDebugSampling is an option that sets the sampling policy of the debug callbacks.
+func WithDebugSampling(sampling DebugSampling) Option {
+	return func(c *Config) error {
+		c.DebugSampling = sampling
+		return nil
+	}
+}
+
+// WithOnDebugTrace is an option that sets the callback receiving the ordered execution trace of each message in debug mode.
+func WithOnDebugTrace(onDebugTrace func(trace DebugTrace)) Option {
+	return func(c *Config) error {
+		c.OnDebugTrace = onDebugTrace
+		return nil
+	}
+}
+
 // WithPool is an option that sets the pool of the Config.
 func WithPool(pool Pool) Option {
 	return func(c *Config) error {
@@ -104,3 +155,108 @@ func WithEndpointEnabled(endpointEnabled bool) Option {
 		return nil
 	}
 }
+
+// WithDisallowEmptyChain is an option that makes the rule chain initialization fail if the chain has no nodes.
+func WithDisallowEmptyChain(disallowEmptyChain bool) Option {
+	return func(c *Config) error {
+		c.DisallowEmptyChain = disallowEmptyChain
+		return nil
+	}
+}
+
+// WithAllowCycle is an option that allows the rule chain connections to contain cycles.
+func WithAllowCycle(allowCycle bool) Option {
+	return func(c *Config) error {
+		c.AllowCycle = allowCycle
+		return nil
+	}
+}
+
+// WithReloadDrainTimeout is an option that sets how long a reload waits for in-flight messages before destroying the previous nodes.
+func WithReloadDrainTimeout(timeout time.Duration) Option {
+	return func(c *Config) error {
+		c.ReloadDrainTimeout = timeout
+		return nil
+	}
+}
+
+// WithMaxVersions is an option that sets how many previous rule chain definitions are kept for rollback.
+func WithMaxVersions(maxVersions int) Option {
+	return func(c *Config) error {
+		c.MaxVersions = maxVersions
+		return nil
+	}
+}
+
+// WithReloadDebounce is an option that coalesces successive ReloadSelf calls within the window, so only the last definition is applied.
+func WithReloadDebounce(window time.Duration) Option {
+	return func(c *Config) error {
+		c.ReloadDebounce = window
+		return nil
+	}
+}
+
+// WithAllowNodeInitFailure is an option that lets the rule chain initialize with failing nodes replaced by placeholders routing to Failure.
+func WithAllowNodeInitFailure(allow bool) Option {
+	return func(c *Config) error {
+		c.AllowNodeInitFailure = allow
+		return nil
+	}
+}
+
+// WithMaxChainDepth is an option that allows bounded sub-chain recursion up to the given chain invocation depth.
+func WithMaxChainDepth(maxDepth int) Option {
+	return func(c *Config) error {
+		c.MaxChainDepth = maxDepth
+		return nil
+	}
+}
+
+// WithAllowDefaultPoolFallback is an option that lets sub-chain lookups fall back to the global default pool.
+func WithAllowDefaultPoolFallback(allow bool) Option {
+	return func(c *Config) error {
+		c.AllowDefaultPoolFallback = allow
+		return nil
+	}
+}
+
+// WithSharedClients is an option that sets the registry used by components to share clients with the same server configuration.
+func WithSharedClients(registry SharedClientRegistry) Option {
+	return func(c *Config) error {
+		c.SharedClients = registry
+		return nil
+	}
+}
+
+// WithPanicCrashFast is an option that sets whether a panic in a node crashes the process instead of being routed to the Failure relation.
+func WithPanicCrashFast(crashFast bool) Option {
+	return func(c *Config) error {
+		c.PanicCrashFast = crashFast
+		return nil
+	}
+}
+
+// WithCheckpointStore is an option that sets the store used to record the execution position of messages for crash recovery.
+func WithCheckpointStore(store CheckpointStore) Option {
+	return func(c *Config) error {
+		c.CheckpointStore = store
+		return nil
+	}
+}
+
+// WithOnReloadVerify is an option that sets the callback used to verify a reloaded rule chain.
+// If the callback returns an error, the rule chain is rolled back to the previous definition.
+func WithOnReloadVerify(onReloadVerify func(chainCtx ChainCtx) error) Option {
+	return func(c *Config) error {
+		c.OnReloadVerify = onReloadVerify
+		return nil
+	}
+}
+
+// WithBaseContext is an option that sets the base context inherited by the root rule context and all messages.
+func WithBaseContext(ctx context.Context) Option {
+	return func(c *Config) error {
+		c.BaseContext = ctx
+		return nil
+	}
+}
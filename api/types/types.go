@@ -18,6 +18,7 @@ package types
 
 import (
 	"context"
+	"time"
 )
 
 // 关系 节点与节点连接的关系，以下是常用的关系，可以自定义
@@ -27,6 +28,8 @@ const (
 	Failure = "Failure"
 	True    = "True"
 	False   = "False"
+	//Completed 消息到达终止节点(terminal)并且处理成功，分支链结束回调使用该关系
+	Completed = "Completed"
 )
 
 // flow direction type
@@ -123,6 +126,41 @@ type Node interface {
 	Destroy()
 }
 
+// HealthChecker 节点健康检查接口，可选实现
+// 持有外部连接的组件(例如：MQTT客户端、数据库客户端)可以实现该接口，检查连接是否可用
+// 没有实现该接口的节点健康状态为未知，不影响规则链的健康状态
+type HealthChecker interface {
+	//Check 检查节点是否可用，不可用返回错误。ctx 用于控制检查超时
+	Check(ctx context.Context) error
+}
+
+// SharedClientRegistry 共享客户端注册表，按照引用计数管理多个节点共享的客户端
+// 组件在Init获取共享客户端，在Destroy释放，最后一个引用释放后关闭客户端(客户端实现了 io.Closer 或者 Close() 方法)。
+// 重新加载节点时，新的节点实例先获取客户端，旧的节点实例再释放，配置没有变化的节点继续使用原来的连接
+type SharedClientRegistry interface {
+	//GetSharedClient 获取kind类型、key标识的共享客户端，引用计数加1，不存在则使用factory创建，创建失败不缓存
+	//key 应该由规范化后的服务器配置生成，配置相同的节点得到同一个客户端
+	GetSharedClient(kind, key string, factory func() (interface{}, error)) (interface{}, error)
+	//ReleaseSharedClient 释放一次引用，引用计数为0时关闭并移除客户端
+	ReleaseSharedClient(kind, key string)
+}
+
+// ChainStartListener 规则链启动回调接口，可选实现
+// Init 在解析规则链时调用，仅校验规则链定义也会调用，组件不应该在Init启动后台消费者等需要规则链运行才有意义的逻辑，
+// 这些逻辑应该放在OnChainStart，规则链所有节点初始化完成并且可以处理消息后调用，重新加载后新的节点实例也会调用
+type ChainStartListener interface {
+	//OnChainStart 规则链启动，ctx 为当前节点的上下文
+	OnChainStart(ctx NodeCtx)
+}
+
+// ChainStopListener 规则链停止回调接口，可选实现
+// 在节点实例 Destroy 之前调用，用于停止 OnChainStart 启动的后台逻辑。
+// 重新加载规则链时，先停止旧的节点实例，再启动新的节点实例
+type ChainStopListener interface {
+	//OnChainStop 规则链停止
+	OnChainStop()
+}
+
 // NodeCtx 规则节点实例化上下文
 type NodeCtx interface {
 	Node
@@ -213,6 +251,72 @@ type RuleContext interface {
 // RuleContextOption 修改RuleContext选项的函数
 type RuleContextOption func(RuleContext)
 
+// ExecutionTimeoutKey 消息元数据中指定该消息执行超时时间(毫秒)的key，覆盖规则链的 ExecutionTimeoutMs 配置
+const ExecutionTimeoutKey = "executionTimeoutMs"
+
+// ExecutionTimeoutSetter 支持设置消息执行超时时间的RuleContext
+type ExecutionTimeoutSetter interface {
+	SetExecutionTimeout(timeout time.Duration)
+}
+
+// 消息优先级，参考 WithPriority
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// PriorityKey 消息元数据中指定该消息优先级的key，值为 PriorityHigh、PriorityNormal 或者 PriorityLow
+const PriorityKey = "priority"
+
+// PrioritySetter 支持设置消息优先级的RuleContext
+type PrioritySetter interface {
+	SetPriority(priority string)
+}
+
+// WithPriority 消息优先级：PriorityHigh、PriorityNormal(默认)或者PriorityLow，覆盖元数据 PriorityKey 的配置，并传递给子规则链
+// 只有 Config.PriorityWorkers>0 开启优先级调度时生效，高优先级消息的节点先执行
+func WithPriority(priority string) RuleContextOption {
+	return func(rc RuleContext) {
+		if setter, ok := rc.(PrioritySetter); ok {
+			setter.SetPriority(priority)
+		}
+	}
+}
+
+// 消息有效期，过期的消息在执行下一个节点前停止，通过OnEnd返回过期错误
+const (
+	// ExpireAtKey 消息元数据中指定消息过期时间的key，值为毫秒时间戳，优先于 TTLKey
+	ExpireAtKey = "expireAt"
+	// TTLKey 消息元数据中指定消息有效期的key，单位毫秒，从消息时间戳 RuleMsg.Ts 开始计算
+	TTLKey = "ttlMs"
+)
+
+// EntryPointSetter 支持选择规则链入口的RuleContext
+type EntryPointSetter interface {
+	SetEntryPoint(name string)
+}
+
+// WithEntryPoint 指定消息从规则链哪个命名入口开始执行，入口在规则链定义的 metadata.entryPoints 中配置
+// 找不到该入口时从第一个节点开始执行
+func WithEntryPoint(name string) RuleContextOption {
+	return func(rc RuleContext) {
+		if setter, ok := rc.(EntryPointSetter); ok {
+			setter.SetEntryPoint(name)
+		}
+	}
+}
+
+// WithExecutionTimeout 消息执行整条规则链的超时时间，覆盖元数据和规则链的配置
+// 超时后不再执行后续节点，并通过OnEnd回调超时错误；支持context的节点会被中断执行
+func WithExecutionTimeout(timeout time.Duration) RuleContextOption {
+	return func(rc RuleContext) {
+		if setter, ok := rc.(ExecutionTimeoutSetter); ok {
+			setter.SetExecutionTimeout(timeout)
+		}
+	}
+}
+
 // WithEndFunc 规则链分支链执行完回调函数
 // 注意：如果规则链有多个结束点，回调函数则会执行多次
 // Deprecated
@@ -235,13 +339,44 @@ func WithOnEnd(endFunc func(ctx RuleContext, msg RuleMsg, err error, relationTyp
 
 // WithContext 上下文
 // 用于不同组件实例数据或者信号量共享
-// 用于超时取消
+// 用于超时取消：上下文取消或者超过截止时间后，不再执行后续节点，OnEnd返回的错误可以通过 errors.Is(err, context.Canceled) 判断，
+// 组件可以通过 RuleContext.GetContext 获取该上下文中断外部调用
 func WithContext(c context.Context) RuleContextOption {
 	return func(rc RuleContext) {
 		rc.SetContext(c)
 	}
 }
 
+// WithoutCancel 返回只保留parent上下文值的上下文，不继承parent的取消信号和截止时间
+// 用于异步处理消息：来源请求结束后上下文被取消，不应该中断异步执行的规则链
+func WithoutCancel(parent context.Context) context.Context {
+	if parent == nil {
+		return context.Background()
+	}
+	return withoutCancelCtx{parent: parent}
+}
+
+// withoutCancelCtx 只读取父上下文的值
+type withoutCancelCtx struct {
+	parent context.Context
+}
+
+func (withoutCancelCtx) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+func (withoutCancelCtx) Done() <-chan struct{} {
+	return nil
+}
+
+func (withoutCancelCtx) Err() error {
+	return nil
+}
+
+func (c withoutCancelCtx) Value(key interface{}) interface{} {
+	return c.parent.Value(key)
+}
+
 // WithOnAllNodeCompleted 规则链执行完回调函数
 func WithOnAllNodeCompleted(onAllNodeCompleted func()) RuleContextOption {
 	return func(rc RuleContext) {
@@ -270,6 +405,15 @@ func WithOnNodeDebug(onDebug func(ruleChainId string, flowType string, nodeId st
 	}
 }
 
+// WithTrace 开启消息执行轨迹，消息所有节点执行完成后回调一次，包括每个经过的节点、关系、时间以及流入流出的消息快照
+// 一个节点通知多个下一个节点时形成分支，按树结构返回。消息快照的数据和元数据值超过 Config.TraceMaxDataSize 会被截断
+// 不依赖节点debugMode，只对使用该选项的消息生效
+func WithTrace(onTrace func(ctx RuleContext, trace ExecutionTrace)) RuleContextOption {
+	return func(rc RuleContext) {
+		rc.SetCallbackFunc(CallbackFuncOnTrace, onTrace)
+	}
+}
+
 // JsEngine JavaScript脚本引擎
 type JsEngine interface {
 	//Execute 执行js脚本指定函数，js脚本在JsEngine实例化的时候进行初始化
@@ -280,6 +424,13 @@ type JsEngine interface {
 	Stop()
 }
 
+// ContextJsEngine 支持通过context中断脚本执行的JavaScript脚本引擎
+type ContextJsEngine interface {
+	JsEngine
+	//ExecuteWithContext 执行js脚本指定函数，ctx 超过截止时间时中断脚本执行
+	ExecuteWithContext(ctx context.Context, functionName string, argumentList ...interface{}) (interface{}, error)
+}
+
 // Parser 规则链定义文件DSL解析器
 // 默认使用json方式，如果使用其他方式定义规则链，可以实现该接口
 // 然后通过该方式注册到规则引擎中：`rulego.NewConfig(WithParser(&MyParser{})`
@@ -296,6 +447,13 @@ type Parser interface {
 	EncodeRuleNode(def interface{}) ([]byte, error)
 }
 
+// RuleChainDefParser 只解析规则链定义，不初始化节点的解析器，Parser 可选实现该接口
+// 增量重新加载规则链时使用该接口获取规则链定义，没有实现则通过 Parser.DecodeRuleChain 解析后获取
+type RuleChainDefParser interface {
+	//DecodeRuleChainDef 从描述文件解析规则链定义
+	DecodeRuleChainDef(dsl []byte) (RuleChain, error)
+}
+
 // Pool 协程池
 type Pool interface {
 	//Submit 往协程池提交一个任务
@@ -324,6 +482,8 @@ type RuleNodeRelation struct {
 	OutId RuleNodeId
 	//关系 如：True、False、Success、Failure 或者其他自定义关系
 	RelationType string
+	//优先级，同一关系的多个出组件按照优先级从高到低排列，优先级相同按照定义顺序排列
+	Priority int
 }
 
 // ScriptFuncSeparator 脚本函数名分割符
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package builder 通过Go代码构建规则链定义，代替手写规则链DSL
+//
+//	def, err := builder.NewChain("chain01").
+//		AddNode("s1", "jsFilter", types.Configuration{"jsScript": "return msg.temperature > 50;"}).
+//		AddNode("s2", "log", types.Configuration{}).
+//		Connect("s1", "s2", types.True).
+//		Build()
+package builder
+
+import (
+	"fmt"
+
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/utils/dsl"
+)
+
+// NodeOption 节点可选配置
+type NodeOption func(node *types.RuleNode)
+
+// WithName 设置节点名称
+func WithName(name string) NodeOption {
+	return func(node *types.RuleNode) {
+		node.Name = name
+	}
+}
+
+// WithDebugMode 设置节点调试模式
+func WithDebugMode(debugMode bool) NodeOption {
+	return func(node *types.RuleNode) {
+		node.DebugMode = debugMode
+	}
+}
+
+// WithTerminal 设置节点为终止节点
+func WithTerminal() NodeOption {
+	return func(node *types.RuleNode) {
+		node.Terminal = true
+	}
+}
+
+// WithTimeout 设置节点执行超时时间，单位毫秒
+func WithTimeout(timeoutMs int) NodeOption {
+	return func(node *types.RuleNode) {
+		node.Timeout = timeoutMs
+	}
+}
+
+// WithRetry 设置节点重试策略
+func WithRetry(policy types.RetryPolicy) NodeOption {
+	return func(node *types.RuleNode) {
+		node.Retry = &policy
+	}
+}
+
+// WithAdditionalInfo 设置节点可视化位置信息
+func WithAdditionalInfo(info types.NodeAdditionalInfo) NodeOption {
+	return func(node *types.RuleNode) {
+		node.AdditionalInfo = info
+	}
+}
+
+// ChainBuilder 规则链构建器，非并发安全
+// 构建过程中的错误在 Build 时一起返回
+type ChainBuilder struct {
+	def types.RuleChain
+	//firstNodeId 通过 FirstNode 指定的第一个节点
+	firstNodeId string
+	problems    []string
+}
+
+// NewChain 创建规则链构建器，id 规则链ID
+func NewChain(id string) *ChainBuilder {
+	return &ChainBuilder{def: types.RuleChain{RuleChain: types.RuleChainBaseInfo{ID: id}}}
+}
+
+// Name 设置规则链名称
+func (b *ChainBuilder) Name(name string) *ChainBuilder {
+	b.def.RuleChain.Name = name
+	return b
+}
+
+// Root 设置是否是根规则链
+func (b *ChainBuilder) Root(root bool) *ChainBuilder {
+	b.def.RuleChain.Root = root
+	return b
+}
+
+// DebugMode 设置规则链调试模式，覆盖节点的调试模式
+func (b *ChainBuilder) DebugMode(debugMode bool) *ChainBuilder {
+	b.def.RuleChain.DebugMode = debugMode
+	return b
+}
+
+// Var 设置规则链变量，节点配置可以通过${vars.key}引用
+func (b *ChainBuilder) Var(key, value string) *ChainBuilder {
+	b.putConfiguration(types.Vars, key, value)
+	return b
+}
+
+// Secret 设置规则链密钥，节点配置可以通过${secrets.key}引用
+func (b *ChainBuilder) Secret(key, value string) *ChainBuilder {
+	b.putConfiguration(types.Secrets, key, value)
+	return b
+}
+
+// Configuration 设置规则链配置项
+func (b *ChainBuilder) Configuration(key string, value interface{}) *ChainBuilder {
+	if b.def.RuleChain.Configuration == nil {
+		b.def.RuleChain.Configuration = types.Configuration{}
+	}
+	b.def.RuleChain.Configuration[key] = value
+	return b
+}
+
+// AdditionalInfo 设置规则链扩展信息
+func (b *ChainBuilder) AdditionalInfo(key, value string) *ChainBuilder {
+	if b.def.RuleChain.AdditionalInfo == nil {
+		b.def.RuleChain.AdditionalInfo = make(map[string]string)
+	}
+	b.def.RuleChain.AdditionalInfo[key] = value
+	return b
+}
+
+// AddNode 增加节点，id 节点ID，nodeType 组件类型，configuration 组件配置
+func (b *ChainBuilder) AddNode(id, nodeType string, configuration types.Configuration, opts ...NodeOption) *ChainBuilder {
+	if configuration == nil {
+		configuration = types.Configuration{}
+	}
+	node := &types.RuleNode{Id: id, Type: nodeType, Configuration: configuration}
+	for _, opt := range opts {
+		opt(node)
+	}
+	if id == "" {
+		b.problems = append(b.problems, fmt.Sprintf("node at index %d: id is empty", len(b.def.Metadata.Nodes)))
+	}
+	b.def.Metadata.Nodes = append(b.def.Metadata.Nodes, node)
+	return b
+}
+
+// Connect 连接两个节点，relationType 关系类型，例如：types.Success
+func (b *ChainBuilder) Connect(fromId, toId, relationType string) *ChainBuilder {
+	b.def.Metadata.Connections = append(b.def.Metadata.Connections, types.NodeConnection{FromId: fromId, ToId: toId, Type: relationType})
+	return b
+}
+
+// ConnectChain 连接节点和子规则链，chainId 子规则链ID
+func (b *ChainBuilder) ConnectChain(fromId, chainId, relationType string) *ChainBuilder {
+	b.def.Metadata.RuleChainConnections = append(b.def.Metadata.RuleChainConnections, types.RuleChainConnection{FromId: fromId, ToId: chainId, Type: relationType})
+	return b
+}
+
+// FirstNode 指定第一个节点，默认是第一个增加的节点
+func (b *ChainBuilder) FirstNode(id string) *ChainBuilder {
+	b.firstNodeId = id
+	return b
+}
+
+// EntryPoint 增加命名入口，参考 types.WithEntryPoint
+func (b *ChainBuilder) EntryPoint(name, nodeId string) *ChainBuilder {
+	if b.def.Metadata.EntryPoints == nil {
+		b.def.Metadata.EntryPoints = make(map[string]string)
+	}
+	b.def.Metadata.EntryPoints[name] = nodeId
+	return b
+}
+
+// Build 校验并返回规则链定义，校验规则参考 dsl.Validate
+// 存在错误返回 *dsl.InvalidRuleChainError，包含所有问题
+func (b *ChainBuilder) Build() (*types.RuleChain, error) {
+	def := b.def
+	problems := append([]string{}, b.problems...)
+	//复制节点和连接，构建后继续增加节点或者连接不影响已经返回的规则链定义
+	def.Metadata.Nodes = make([]*types.RuleNode, 0, len(b.def.Metadata.Nodes))
+	for _, item := range b.def.Metadata.Nodes {
+		node := *item
+		def.Metadata.Nodes = append(def.Metadata.Nodes, &node)
+	}
+	def.Metadata.Connections = append([]types.NodeConnection{}, b.def.Metadata.Connections...)
+	if len(b.def.Metadata.RuleChainConnections) > 0 {
+		def.Metadata.RuleChainConnections = append([]types.RuleChainConnection{}, b.def.Metadata.RuleChainConnections...)
+	}
+	if b.firstNodeId != "" {
+		def.Metadata.FirstNodeIndex = -1
+		for index, item := range def.Metadata.Nodes {
+			if item.Id == b.firstNodeId {
+				def.Metadata.FirstNodeIndex = index
+				break
+			}
+		}
+		if def.Metadata.FirstNodeIndex < 0 {
+			def.Metadata.FirstNodeIndex = 0
+			problems = append(problems, fmt.Sprintf("first node %s is not a declared node", b.firstNodeId))
+		}
+	}
+	if err := dsl.Validate(def); err != nil {
+		problems = append(problems, err.(*dsl.InvalidRuleChainError).Problems...)
+	}
+	if len(problems) > 0 {
+		return nil, &dsl.InvalidRuleChainError{Problems: problems}
+	}
+	return &def, nil
+}
+
+// putConfiguration 设置规则链配置中map类型配置项的值，例如：vars
+func (b *ChainBuilder) putConfiguration(name, key, value string) {
+	if b.def.RuleChain.Configuration == nil {
+		b.def.RuleChain.Configuration = types.Configuration{}
+	}
+	values, ok := b.def.RuleChain.Configuration[name].(map[string]interface{})
+	if !ok {
+		values = make(map[string]interface{})
+		b.def.RuleChain.Configuration[name] = values
+	}
+	values[key] = value
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package builder
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/engine"
+	"github.com/rulego/rulego/test/assert"
+	"github.com/rulego/rulego/utils/dsl"
+	"github.com/rulego/rulego/utils/json"
+)
+
+func TestBuild(t *testing.T) {
+	def, err := NewChain("chain01").
+		Name("测试规则链").
+		Root(true).
+		Var("ip", "127.0.0.1").
+		Secret("password", "123456").
+		AdditionalInfo("tags", "test").
+		AddNode("s1", "jsFilter", types.Configuration{"jsScript": "return msg.temperature > 50;"}, WithName("过滤"), WithDebugMode(true)).
+		AddNode("s2", "jsTransform", types.Configuration{"jsScript": "return {'msg':msg,'metadata':metadata,'msgType':msgType};"}).
+		AddNode("s3", "log", nil, WithAdditionalInfo(types.NodeAdditionalInfo{LayoutX: 10, LayoutY: 20})).
+		Connect("s1", "s2", types.True).
+		Connect("s2", "s3", types.Success).
+		ConnectChain("s1", "chain02", types.False).
+		FirstNode("s1").
+		Build()
+	assert.Nil(t, err)
+
+	handWritten := `{
+		"ruleChain": {"id": "chain01", "name": "测试规则链", "debugMode": false, "root": true,
+			"configuration": {"vars": {"ip": "127.0.0.1"}, "secrets": {"password": "123456"}},
+			"additionalInfo": {"tags": "test"}},
+		"metadata": {
+			"firstNodeIndex": 0,
+			"nodes": [
+				{"id": "s1", "type": "jsFilter", "name": "过滤", "debugMode": true, "configuration": {"jsScript": "return msg.temperature > 50;"}},
+				{"id": "s2", "type": "jsTransform", "name": "", "debugMode": false, "configuration": {"jsScript": "return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},
+				{"id": "s3", "type": "log", "name": "", "debugMode": false, "additionalInfo": {"layoutX": 10, "layoutY": 20}, "configuration": {}}
+			],
+			"connections": [
+				{"fromId": "s1", "toId": "s2", "type": "True"},
+				{"fromId": "s2", "toId": "s3", "type": "Success"}
+			],
+			"ruleChainConnections": [
+				{"fromId": "s1", "toId": "chain02", "type": "False"}
+			]
+		}
+	}`
+	var expected types.RuleChain
+	assert.Nil(t, json.Unmarshal([]byte(handWritten), &expected))
+
+	parser := &engine.JsonParser{}
+	built, err := parser.EncodeRuleChain(def)
+	assert.Nil(t, err)
+	expectedDsl, err := parser.EncodeRuleChain(&expected)
+	assert.Nil(t, err)
+	assert.Equal(t, string(expectedDsl), string(built))
+
+	//第一个节点
+	def, err = NewChain("chain01").
+		AddNode("s1", "log", nil).
+		AddNode("s2", "log", nil).
+		Connect("s2", "s1", types.Success).
+		FirstNode("s2").
+		Build()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, def.Metadata.FirstNodeIndex)
+}
+
+func TestBuildProblems(t *testing.T) {
+	_, err := NewChain("chain01").
+		AddNode("s1", "log", nil).
+		AddNode("s1", "jsFilter", nil).
+		AddNode("", "log", nil).
+		AddNode("s3", "", nil).
+		Connect("s1", "notFound", types.Success).
+		FirstNode("s9").
+		Build()
+	var invalidErr *dsl.InvalidRuleChainError
+	assert.True(t, errors.As(err, &invalidErr))
+	assert.Equal(t, []string{
+		"node at index 2: id is empty",
+		"first node s9 is not a declared node",
+		"duplicate node id s1: node types log and jsFilter",
+		"node s3: type is empty",
+		"connection s1->notFound(Success): toId notFound is not a declared node",
+	}, invalidErr.Problems)
+}
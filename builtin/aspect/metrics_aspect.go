@@ -0,0 +1,364 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package aspect
+
+import (
+	"context"
+	"github.com/rulego/rulego/api/types"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Compile-time check Metrics implements types.BeforeAspect.
+	_ types.BeforeAspect = (*Metrics)(nil)
+	// Compile-time check Metrics implements types.AfterAspect.
+	_ types.AfterAspect = (*Metrics)(nil)
+	// Compile-time check Metrics implements types.StartAspect.
+	_ types.StartAspect = (*Metrics)(nil)
+	// Compile-time check Metrics implements types.EndAspect.
+	_ types.EndAspect = (*Metrics)(nil)
+	// Compile-time check Metrics implements types.CompletedAspect.
+	_ types.CompletedAspect = (*Metrics)(nil)
+)
+
+// Metrics 规则链和节点执行指标统计切面
+// 引擎通过New()创建的切面实例与原切面共享统计数据，可以通过原切面获取指标快照，例如：
+//
+//	metrics := &aspect.Metrics{}
+//	ruleEngine, err := rulego.New("rule01", def, types.WithAspects(metrics))
+//	before := metrics.MetricsSnapshot()
+//	//压测...
+//	diff := aspect.DiffMetrics(before, metrics.MetricsSnapshot())
+//
+// 节点耗时为节点开始处理消息到第一次调用TellNext/TellSuccess/TellFailure的时长，
+// 节点接收消息后没有调用上述方法的消息不统计耗时
+type Metrics struct {
+	once  sync.Once
+	store *metricsStore
+}
+
+func (aspect *Metrics) Order() int {
+	return 800
+}
+
+func (aspect *Metrics) New() types.Aspect {
+	return &Metrics{store: aspect.getStore()}
+}
+
+func (aspect *Metrics) Type() string {
+	return "metrics"
+}
+
+// PointCut 切入点 所有节点都会执行
+func (aspect *Metrics) PointCut(ctx types.RuleContext, msg types.RuleMsg, relationType string) bool {
+	return true
+}
+
+// Start 统计规则链接收的消息数量
+func (aspect *Metrics) Start(ctx types.RuleContext, msg types.RuleMsg) types.RuleMsg {
+	aspect.getStore().update(ruleChainId(ctx), "", func(chain *chainCounter, node *nodeCounter) {
+		atomic.AddInt64(&chain.messages, 1)
+	})
+	return msg
+}
+
+// End 统计规则链执行失败的分支数量
+func (aspect *Metrics) End(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) types.RuleMsg {
+	if err != nil {
+		aspect.getStore().update(ruleChainId(ctx), "", func(chain *chainCounter, node *nodeCounter) {
+			atomic.AddInt64(&chain.errors, 1)
+		})
+	}
+	return msg
+}
+
+// Completed 统计规则链所有分支执行结束的消息数量
+func (aspect *Metrics) Completed(ctx types.RuleContext, msg types.RuleMsg) types.RuleMsg {
+	aspect.getStore().update(ruleChainId(ctx), "", func(chain *chainCounter, node *nodeCounter) {
+		atomic.AddInt64(&chain.completed, 1)
+	})
+	return msg
+}
+
+// Before 统计节点接收的消息数量，并记录开始处理时间
+func (aspect *Metrics) Before(ctx types.RuleContext, msg types.RuleMsg, relationType string) types.RuleMsg {
+	store := aspect.getStore()
+	store.update(ruleChainId(ctx), ctx.GetSelfId(), func(chain *chainCounter, node *nodeCounter) {
+		atomic.AddInt64(&node.messages, 1)
+	})
+	//开始时间保存在当前节点的上下文，同一消息多次进入同一节点互不影响，节点不通知下一个节点也不会残留
+	parent := ctx.GetContext()
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx.SetContext(context.WithValue(parent, nodeStartKey{}, &nodeStart{time: time.Now()}))
+	return msg
+}
+
+// After 统计节点处理耗时和失败数量
+func (aspect *Metrics) After(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) types.RuleMsg {
+	store := aspect.getStore()
+	var latency time.Duration = -1
+	if ctx.GetContext() != nil {
+		//节点多次通知下一个节点只统计第一次
+		if start, ok := ctx.GetContext().Value(nodeStartKey{}).(*nodeStart); ok && atomic.CompareAndSwapInt32(&start.observed, 0, 1) {
+			latency = time.Since(start.time)
+		}
+	}
+	store.update(ruleChainId(ctx), ctx.GetSelfId(), func(chain *chainCounter, node *nodeCounter) {
+		if err != nil || relationType == types.Failure {
+			atomic.AddInt64(&node.errors, 1)
+		}
+		if latency >= 0 {
+			atomic.AddInt64(&node.completed, 1)
+			atomic.AddInt64(&node.totalLatency, int64(latency))
+		}
+	})
+	return msg
+}
+
+// MetricsSnapshot 获取当前时间点所有规则链和节点指标的一致性副本，后续统计不会修改返回的快照
+func (aspect *Metrics) MetricsSnapshot() MetricsSnapshot {
+	return aspect.getStore().snapshot()
+}
+
+func (aspect *Metrics) getStore() *metricsStore {
+	aspect.once.Do(func() {
+		if aspect.store == nil {
+			aspect.store = &metricsStore{chains: make(map[string]*chainCounter)}
+		}
+	})
+	return aspect.store
+}
+
+// ruleChainId 获取当前规则链ID
+func ruleChainId(ctx types.RuleContext) string {
+	if ctx.RuleChain() != nil {
+		return ctx.RuleChain().GetNodeId().Id
+	}
+	return ""
+}
+
+// nodeStartKey 节点开始处理时间在上下文中的key
+type nodeStartKey struct{}
+
+// nodeStart 节点开始处理消息的时间
+type nodeStart struct {
+	time time.Time
+	//observed 是否已经统计耗时
+	observed int32
+}
+
+// MetricsSnapshot 指标快照
+type MetricsSnapshot struct {
+	//Time 快照时间
+	Time time.Time
+	//Chains 规则链指标，key:规则链ID
+	Chains map[string]ChainMetrics
+}
+
+// ChainMetrics 规则链指标
+type ChainMetrics struct {
+	//Messages 规则链接收的消息数量
+	Messages int64
+	//Completed 所有分支执行结束的消息数量
+	Completed int64
+	//Errors 执行失败的分支数量
+	Errors int64
+	//Nodes 节点指标，key:节点ID
+	Nodes map[string]NodeMetrics
+}
+
+// NodeMetrics 节点指标
+type NodeMetrics struct {
+	//Messages 节点接收的消息数量
+	Messages int64
+	//Errors 节点处理失败的消息数量
+	Errors int64
+	//Completed 统计了耗时的消息数量
+	Completed int64
+	//TotalLatency 总耗时
+	TotalLatency time.Duration
+}
+
+// AvgLatency 平均耗时
+func (m NodeMetrics) AvgLatency() time.Duration {
+	if m.Completed == 0 {
+		return 0
+	}
+	return m.TotalLatency / time.Duration(m.Completed)
+}
+
+// MetricsDiff 两个指标快照的差异，计数为b相对a的增量
+type MetricsDiff struct {
+	//From a快照时间
+	From time.Time
+	//To b快照时间
+	To time.Time
+	//Chains 规则链指标增量，key:规则链ID
+	Chains map[string]ChainMetricsDiff
+}
+
+// ChainMetricsDiff 规则链指标增量
+type ChainMetricsDiff struct {
+	Messages  int64
+	Completed int64
+	Errors    int64
+	//Nodes 节点指标增量，key:节点ID
+	Nodes map[string]NodeMetricsDiff
+}
+
+// NodeMetricsDiff 节点指标增量
+type NodeMetricsDiff struct {
+	Messages  int64
+	Errors    int64
+	Completed int64
+	//AvgLatency 两个快照之间处理的消息的平均耗时
+	AvgLatency time.Duration
+	//AvgLatencyChange 两个快照之间的平均耗时相对a快照平均耗时的变化
+	AvgLatencyChange time.Duration
+}
+
+// DiffMetrics 比较两个指标快照，a为较早的快照，b为较晚的快照
+// 只在其中一个快照出现的规则链或者节点，另一个快照按照零值计算
+func DiffMetrics(a, b MetricsSnapshot) MetricsDiff {
+	diff := MetricsDiff{From: a.Time, To: b.Time, Chains: make(map[string]ChainMetricsDiff)}
+	var chainIds = make(map[string]struct{})
+	for id := range a.Chains {
+		chainIds[id] = struct{}{}
+	}
+	for id := range b.Chains {
+		chainIds[id] = struct{}{}
+	}
+	for id := range chainIds {
+		chainA, chainB := a.Chains[id], b.Chains[id]
+		chainDiff := ChainMetricsDiff{
+			Messages:  chainB.Messages - chainA.Messages,
+			Completed: chainB.Completed - chainA.Completed,
+			Errors:    chainB.Errors - chainA.Errors,
+			Nodes:     make(map[string]NodeMetricsDiff),
+		}
+		var nodeIds = make(map[string]struct{})
+		for nodeId := range chainA.Nodes {
+			nodeIds[nodeId] = struct{}{}
+		}
+		for nodeId := range chainB.Nodes {
+			nodeIds[nodeId] = struct{}{}
+		}
+		for nodeId := range nodeIds {
+			nodeA, nodeB := chainA.Nodes[nodeId], chainB.Nodes[nodeId]
+			delta := NodeMetrics{
+				Messages:     nodeB.Messages - nodeA.Messages,
+				Errors:       nodeB.Errors - nodeA.Errors,
+				Completed:    nodeB.Completed - nodeA.Completed,
+				TotalLatency: nodeB.TotalLatency - nodeA.TotalLatency,
+			}
+			nodeDiff := NodeMetricsDiff{Messages: delta.Messages, Errors: delta.Errors, Completed: delta.Completed}
+			if delta.Completed > 0 {
+				nodeDiff.AvgLatency = delta.AvgLatency()
+				nodeDiff.AvgLatencyChange = nodeDiff.AvgLatency - nodeA.AvgLatency()
+			}
+			chainDiff.Nodes[nodeId] = nodeDiff
+		}
+		diff.Chains[id] = chainDiff
+	}
+	return diff
+}
+
+// metricsStore 指标数据，多个切面实例共享
+// 统计时持有读锁原子更新计数，快照时持有写锁复制，保证快照是同一时间点的数据
+type metricsStore struct {
+	sync.RWMutex
+	chains map[string]*chainCounter
+}
+
+type chainCounter struct {
+	messages  int64
+	completed int64
+	errors    int64
+	nodes     map[string]*nodeCounter
+}
+
+type nodeCounter struct {
+	messages     int64
+	errors       int64
+	completed    int64
+	totalLatency int64
+}
+
+// update 获取规则链和节点计数器并更新，nodeId为空则只更新规则链计数器
+func (s *metricsStore) update(chainId, nodeId string, f func(chain *chainCounter, node *nodeCounter)) {
+	s.RLock()
+	chain, node, ok := s.counter(chainId, nodeId)
+	if ok {
+		f(chain, node)
+		s.RUnlock()
+		return
+	}
+	s.RUnlock()
+	s.Lock()
+	defer s.Unlock()
+	if chain, ok = s.chains[chainId]; !ok {
+		chain = &chainCounter{nodes: make(map[string]*nodeCounter)}
+		s.chains[chainId] = chain
+	}
+	if nodeId != "" {
+		if node, ok = chain.nodes[nodeId]; !ok {
+			node = &nodeCounter{}
+			chain.nodes[nodeId] = node
+		}
+	}
+	f(chain, node)
+}
+
+func (s *metricsStore) counter(chainId, nodeId string) (*chainCounter, *nodeCounter, bool) {
+	chain, ok := s.chains[chainId]
+	if !ok {
+		return nil, nil, false
+	}
+	if nodeId == "" {
+		return chain, nil, true
+	}
+	node, ok := chain.nodes[nodeId]
+	return chain, node, ok
+}
+
+func (s *metricsStore) snapshot() MetricsSnapshot {
+	s.Lock()
+	defer s.Unlock()
+	result := MetricsSnapshot{Time: time.Now(), Chains: make(map[string]ChainMetrics, len(s.chains))}
+	for id, chain := range s.chains {
+		chainMetrics := ChainMetrics{
+			Messages:  chain.messages,
+			Completed: chain.completed,
+			Errors:    chain.errors,
+			Nodes:     make(map[string]NodeMetrics, len(chain.nodes)),
+		}
+		for nodeId, node := range chain.nodes {
+			chainMetrics.Nodes[nodeId] = NodeMetrics{
+				Messages:     node.messages,
+				Errors:       node.errors,
+				Completed:    node.completed,
+				TotalLatency: time.Duration(node.totalLatency),
+			}
+		}
+		result.Chains[id] = chainMetrics
+	}
+	return result
+}
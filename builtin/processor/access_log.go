@@ -0,0 +1,170 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/json"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// AccessLog 以JSON格式输出访问日志的处理器名称
+const AccessLog = "accessLog"
+
+// 访问日志字段
+const (
+	// AccessLogFieldTime 请求开始时间，RFC3339格式
+	AccessLogFieldTime = "time"
+	// AccessLogFieldMethod 请求方法
+	AccessLogFieldMethod = "method"
+	// AccessLogFieldPath 请求路径
+	AccessLogFieldPath = "path"
+	// AccessLogFieldStatus 响应码
+	AccessLogFieldStatus = "status"
+	// AccessLogFieldLatency 处理耗时，单位毫秒
+	AccessLogFieldLatency = "latency"
+	// AccessLogFieldBytes 响应体字节数
+	AccessLogFieldBytes = "bytes"
+	// AccessLogFieldCorrelationId 关联ID
+	AccessLogFieldCorrelationId = "correlationId"
+	// AccessLogFieldError 错误信息，没有错误则不输出
+	AccessLogFieldError = "error"
+)
+
+const (
+	// AccessLogSinkStdout 输出到标准输出
+	AccessLogSinkStdout = "stdout"
+	// AccessLogSinkLogger 输出到规则引擎配置的Logger
+	AccessLogSinkLogger = "logger"
+)
+
+// AccessLogConfig accessLog 处理器配置
+type AccessLogConfig struct {
+	//Fields 输出的字段，默认输出全部字段
+	Fields []string
+	//Sink 日志输出目标，可选：stdout、logger，默认stdout
+	Sink string
+	//CorrelationHeader 关联ID请求头，默认X-Request-Id，请求头不存在则使用消息ID
+	CorrelationHeader string
+}
+
+// defaultAccessLogFields 默认输出的字段
+var defaultAccessLogFields = []string{AccessLogFieldTime, AccessLogFieldMethod, AccessLogFieldPath, AccessLogFieldStatus,
+	AccessLogFieldLatency, AccessLogFieldBytes, AccessLogFieldCorrelationId, AccessLogFieldError}
+
+// accessLogWriter stdout 输出目标
+var accessLogWriter io.Writer = os.Stdout
+
+// accessLogLock 保证多个请求的日志行不交错
+var accessLogLock sync.Mutex
+
+func init() {
+	//进入时记录请求开始时间，exchange处理结束时输出一行JSON访问日志，不修改响应
+	//需要放在from端处理器列表中，与responseToBody等处理器配合使用
+	Builtins.Register(AccessLog, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := AccessLogConfig{Sink: AccessLogSinkStdout, CorrelationHeader: "X-Request-Id"}
+		if err := getConfig(router, AccessLog, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		if len(config.Fields) == 0 {
+			config.Fields = defaultAccessLogFields
+		}
+		if config.Sink != AccessLogSinkStdout && config.Sink != AccessLogSinkLogger {
+			return abort(exchange, http.StatusInternalServerError, fmt.Errorf("accessLog sink %s not supported", config.Sink))
+		}
+		start := time.Now()
+		correlationId := exchange.In.Headers().Get(config.CorrelationHeader)
+		if correlationId == "" {
+			if msg := exchange.In.GetMsg(); msg != nil {
+				correlationId = msg.Id
+			}
+		}
+		exchange.OnDone(func() {
+			writeAccessLog(router, config, accessLogEntry(exchange, config.Fields, start, correlationId))
+		})
+		return true
+	})
+}
+
+// accessLogEntry 根据配置的字段生成访问日志
+func accessLogEntry(exchange *endpoint.Exchange, fields []string, start time.Time, correlationId string) map[string]interface{} {
+	entry := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case AccessLogFieldTime:
+			entry[field] = start.Format(time.RFC3339)
+		case AccessLogFieldMethod:
+			if r, ok := exchange.In.(interface{ Request() *http.Request }); ok && r.Request() != nil {
+				entry[field] = r.Request().Method
+			}
+		case AccessLogFieldPath:
+			entry[field] = exchange.In.From()
+		case AccessLogFieldStatus:
+			entry[field] = accessLogStatus(exchange)
+		case AccessLogFieldLatency:
+			entry[field] = time.Since(start).Milliseconds()
+		case AccessLogFieldBytes:
+			if exchange.Out != nil {
+				entry[field] = len(exchange.Out.Body())
+			}
+		case AccessLogFieldCorrelationId:
+			entry[field] = correlationId
+		case AccessLogFieldError:
+			if exchange.Out != nil && exchange.Out.GetError() != nil {
+				entry[field] = exchange.Out.GetError().Error()
+			}
+		}
+	}
+	return entry
+}
+
+// accessLogStatus 获取响应码，响应消息没有记录响应码则根据是否有错误推断
+func accessLogStatus(exchange *endpoint.Exchange) int {
+	if exchange.Out == nil {
+		return http.StatusOK
+	}
+	if out, ok := exchange.Out.(endpoint.StatusCodeMessage); ok {
+		return out.StatusCode()
+	}
+	if exchange.Out.GetError() != nil {
+		return http.StatusInternalServerError
+	}
+	return http.StatusOK
+}
+
+// writeAccessLog 把访问日志输出到配置的目标
+func writeAccessLog(router endpoint.Router, config AccessLogConfig, entry map[string]interface{}) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	if config.Sink == AccessLogSinkLogger {
+		if r, ok := router.(interface{ RuleConfig() types.Config }); ok && r.RuleConfig().Logger != nil {
+			r.RuleConfig().Logger.Printf("%s", line)
+			return
+		}
+	}
+	accessLogLock.Lock()
+	defer accessLogLock.Unlock()
+	_, _ = accessLogWriter.Write(append(line, '\n'))
+}
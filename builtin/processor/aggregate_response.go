@@ -0,0 +1,208 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AggregateResponse 把规则链多个分支的处理结果聚合成一个响应的处理器名称
+const AggregateResponse = "aggregateResponse"
+
+// AggregateResponseConfig aggregateResponse 处理器配置
+//
+// 该处理器配置在to端处理器列表，并放在responseToBody等响应处理器之前。
+// 规则链每个分支结束都会执行一次该处理器，收集到所有分支结果之前返回false，不执行后续处理器；
+// 收集到所有分支结果或者等待超时后，把聚合结果作为JSON消息输出，例如：
+//
+//	{"user":{"name":"lala"},"orders":{"error":"timeout"}}
+//
+// 分支的JSON结果原样嵌入，其他类型结果作为字符串嵌入，失败的分支使用{"error":"错误信息"}标记
+//
+// 聚合结果只在endpoint处理请求的协程输出：超时后第一个结束的分支输出聚合结果；
+// to端同步执行(Wait)时，规则链执行完成后如果还没有输出，则输出已经收集的结果，没有收集到的分支标记为超时；
+// 异步执行时，请求的聚合状态在2倍超时时间后清理
+type AggregateResponseConfig struct {
+	//Branches 需要等待的分支名称列表
+	Branches []string
+	//BranchKey 分支名称所在的消息元数据key，默认branch
+	BranchKey string
+	//Timeout 等待所有分支结果的超时时间，从第一个分支结束开始计算，超时后结束的分支结果被忽略，单位秒，默认10
+	Timeout int
+}
+
+// aggregation 一次请求的分支结果，所有字段需要持有 aggregations.lock 访问
+type aggregation struct {
+	results map[string]interface{}
+	//msg 最后一个分支的消息，用于输出聚合结果
+	msg types.RuleMsg
+	//deadline 等待分支结果的截止时间
+	deadline time.Time
+	//done 是否已经输出聚合结果，之后结束的分支会被忽略
+	done bool
+	//replay 规则链执行完成后重新执行to端处理器列表输出聚合结果，该处理器直接放行一次
+	replay bool
+}
+
+// aggregations 正在聚合的请求
+var aggregations = struct {
+	items map[*endpoint.Exchange]*aggregation
+	lock  sync.Mutex
+}{items: make(map[*endpoint.Exchange]*aggregation)}
+
+func init() {
+	//聚合规则链多个分支的处理结果
+	Builtins.Register(AggregateResponse, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := AggregateResponseConfig{BranchKey: "branch", Timeout: 10}
+		if err := getConfig(router, AggregateResponse, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		if len(config.Branches) == 0 {
+			return abort(exchange, http.StatusInternalServerError, errors.New("aggregateResponse branches can not be empty"))
+		}
+		timeout := time.Duration(config.Timeout) * time.Second
+
+		aggregations.lock.Lock()
+		defer aggregations.lock.Unlock()
+		item, ok := aggregations.items[exchange]
+		if ok && item.done {
+			if item.replay {
+				item.replay = false
+				return true
+			}
+			return false
+		}
+		if !ok {
+			item = &aggregation{results: make(map[string]interface{}), deadline: time.Now().Add(timeout)}
+			aggregations.items[exchange] = item
+			if to := toFlow(router); to != nil && to.IsWait() {
+				//同步执行时，endpoint在规则链执行完成后调用，输出还没有输出的聚合结果
+				exchange.OnDone(func() {
+					if flushAggregation(exchange, item, config.Branches, timeout) {
+						for _, process := range to.GetProcessList() {
+							if !process(router, exchange) {
+								break
+							}
+						}
+					}
+				})
+			} else {
+				//异步执行时没有执行完成通知，分支没有全部结束也需要在超时后清理
+				time.AfterFunc(timeout*2, func() {
+					aggregations.lock.Lock()
+					defer aggregations.lock.Unlock()
+					delete(aggregations.items, exchange)
+				})
+			}
+		}
+		timedOut := !time.Now().Before(item.deadline)
+		if msg := exchange.Out.GetMsg(); msg != nil && !timedOut {
+			item.msg = msg.Copy()
+			if name := msg.Metadata.GetValue(config.BranchKey); name != "" {
+				if err := exchange.Out.GetError(); err != nil {
+					item.results[name] = map[string]string{"error": err.Error()}
+				} else {
+					item.results[name] = branchResult(msg)
+				}
+			}
+		}
+		if timedOut {
+			//等待超时，输出已经收集的结果
+			markTimeout(item, config.Branches)
+		}
+		for _, name := range config.Branches {
+			if _, ok := item.results[name]; !ok {
+				return false
+			}
+		}
+		return completeAggregation(exchange, item, timeout)
+	})
+}
+
+// toFlow 获取路由的to端，没有配置返回nil
+func toFlow(router endpoint.Router) endpoint.To {
+	if router == nil || router.GetFrom() == nil {
+		return nil
+	}
+	return router.GetFrom().GetTo()
+}
+
+// markTimeout 把没有收集到结果的分支标记为超时，需要持有锁
+func markTimeout(item *aggregation, branches []string) {
+	for _, name := range branches {
+		if _, ok := item.results[name]; !ok {
+			item.results[name] = map[string]string{"error": "timeout"}
+		}
+	}
+}
+
+// flushAggregation 规则链执行完成后输出还没有输出的聚合结果，没有收集到的分支标记为超时
+// 返回true表示已经输出聚合结果，需要重新执行to端处理器列表
+func flushAggregation(exchange *endpoint.Exchange, item *aggregation, branches []string, timeout time.Duration) bool {
+	aggregations.lock.Lock()
+	defer aggregations.lock.Unlock()
+	if item.done {
+		return false
+	}
+	markTimeout(item, branches)
+	if !completeAggregation(exchange, item, timeout) {
+		return false
+	}
+	item.replay = true
+	return true
+}
+
+// completeAggregation 输出聚合结果，需要持有锁，如果已经输出过返回false
+// 输出后保留timeout时间，忽略之后结束的分支
+func completeAggregation(exchange *endpoint.Exchange, item *aggregation, timeout time.Duration) bool {
+	if item.done {
+		return false
+	}
+	item.done = true
+	time.AfterFunc(timeout, func() {
+		aggregations.lock.Lock()
+		defer aggregations.lock.Unlock()
+		delete(aggregations.items, exchange)
+	})
+	data, err := json.Marshal(item.results)
+	if err != nil {
+		return abort(exchange, http.StatusInternalServerError, err)
+	}
+	msg := item.msg.Copy()
+	msg.Data = string(data)
+	msg.DataType = types.JSON
+	exchange.Out.SetError(nil)
+	exchange.Out.SetMsg(&msg)
+	return true
+}
+
+// branchResult 分支结果，JSON原样嵌入，其他类型作为字符串
+func branchResult(msg *types.RuleMsg) interface{} {
+	if msg.DataType == types.JSON {
+		var v interface{}
+		if err := json.Unmarshal([]byte(msg.Data), &v); err == nil {
+			return v
+		}
+	}
+	return msg.Data
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"fmt"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// AllowContentTypes 请求Content-Type白名单处理器名称
+const AllowContentTypes = "allowContentTypes"
+
+// AllowContentTypesConfig allowContentTypes 处理器配置
+type AllowContentTypesConfig struct {
+	//ContentTypes 允许的媒体类型列表，例如：application/json、text/*
+	//匹配时忽略参数，例如：application/json; charset=utf-8 匹配 application/json
+	ContentTypes []string
+	//AllowEmpty 是否允许没有Content-Type的请求，默认false
+	AllowEmpty bool
+}
+
+func init() {
+	//拒绝Content-Type不在白名单中的请求，响应415 Unsupported Media Type
+	Builtins.Register(AllowContentTypes, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		var config AllowContentTypesConfig
+		if err := getConfig(router, AllowContentTypes, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		var contentType string
+		if headers := exchange.In.Headers(); headers != nil {
+			contentType = headers.Get("Content-Type")
+		}
+		if contentType == "" {
+			if config.AllowEmpty {
+				return true
+			}
+			return abort(exchange, http.StatusUnsupportedMediaType, fmt.Errorf("missing content type"))
+		}
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil {
+			return abort(exchange, http.StatusUnsupportedMediaType, fmt.Errorf("invalid content type: %s", contentType))
+		}
+		for _, item := range config.ContentTypes {
+			if matchMediaType(item, mediaType) {
+				return true
+			}
+		}
+		return abort(exchange, http.StatusUnsupportedMediaType, fmt.Errorf("unsupported content type: %s", mediaType))
+	})
+}
+
+// matchMediaType 判断媒体类型是否匹配，pattern支持参数和 type/* 、*/* 通配符
+func matchMediaType(pattern, mediaType string) bool {
+	if p, _, err := mime.ParseMediaType(pattern); err == nil {
+		pattern = p
+	} else {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+	}
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
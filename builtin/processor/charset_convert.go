@@ -0,0 +1,151 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// CharsetConvert 把请求体从其他字符集转换成UTF-8的处理器名称，配置在from端处理器列表，并放在读取请求体的处理器之前
+const CharsetConvert = "charsetConvert"
+
+// CharsetConvertOut 把响应从UTF-8转换成其他字符集的处理器名称，配置在to端处理器列表，并放在responseToBody之前
+const CharsetConvertOut = "charsetConvertOut"
+
+// CharsetConvertConfig charsetConvert、charsetConvertOut 处理器配置
+// 字符集名称使用WHATWG编码标准的名称或者别名，例如：GBK、GB18030、Big5、Shift_JIS、ISO-8859-1
+type CharsetConvertConfig struct {
+	//Charset charsetConvert：请求Content-Type没有charset参数，并且请求体不是合法的UTF-8时使用的源字符集
+	//charsetConvertOut：响应使用的字符集，为空则使用请求头Accept-Charset中优先级最高的字符集
+	Charset string
+}
+
+func init() {
+	//根据请求Content-Type的charset参数或者配置的字符集，把请求体转换成UTF-8，并把charset参数改为utf-8
+	Builtins.Register(CharsetConvert, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		var config CharsetConvertConfig
+		if err := getConfig(router, CharsetConvert, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		body := exchange.In.Body()
+		headers := exchange.In.Headers()
+		var contentType string
+		if headers != nil {
+			contentType = headers.Get("Content-Type")
+		}
+		mediaType, params, _ := mime.ParseMediaType(contentType)
+		charset := params["charset"]
+		if charset == "" {
+			if utf8.Valid(body) || config.Charset == "" {
+				return true
+			}
+			charset = config.Charset
+		}
+		enc, err := getCharset(charset)
+		if err != nil {
+			return abort(exchange, http.StatusUnsupportedMediaType, err)
+		}
+		if enc == nil {
+			return true
+		}
+		decoded, err := enc.NewDecoder().Bytes(body)
+		if err != nil {
+			return abort(exchange, http.StatusBadRequest, fmt.Errorf("invalid %s body: %w", charset, err))
+		}
+		exchange.In.SetBody(decoded)
+		if msg := exchange.In.GetMsg(); msg != nil {
+			msg.Data = string(decoded)
+		}
+		if mediaType != "" && headers != nil {
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params["charset"] = "utf-8"
+			headers.Set("Content-Type", mime.FormatMediaType(mediaType, params))
+		}
+		return true
+	})
+
+	//把规则链输出的UTF-8响应转换成配置或者客户端要求的字符集，并在Content-Type中输出charset参数
+	//目标字符集无法表示的字符替换为替换字符
+	Builtins.Register(CharsetConvertOut, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		var config CharsetConvertConfig
+		if err := getConfig(router, CharsetConvertOut, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.Out.GetMsg()
+		if msg == nil || exchange.Out.GetError() != nil {
+			return true
+		}
+		charset := config.Charset
+		if charset == "" {
+			if headers := exchange.In.Headers(); headers != nil {
+				for _, item := range parseAccept(headers.Get("Accept-Charset")) {
+					if _, err := getCharset(item.mediaType); err == nil {
+						charset = item.mediaType
+						break
+					}
+				}
+			}
+		}
+		if charset == "" {
+			return true
+		}
+		enc, err := getCharset(charset)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		if enc == nil {
+			return true
+		}
+		encoded, err := encoding.ReplaceUnsupported(enc.NewEncoder()).String(msg.Data)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		name, _ := htmlindex.Name(enc)
+		if headers := exchange.Out.Headers(); headers != nil {
+			headers.Set("Content-Type", mime.FormatMediaType(producedContentType(exchange.Out, msg), map[string]string{"charset": name}))
+		}
+		msg.Data = encoded
+		//避免responseToBody按照JSON类型覆盖Content-Type
+		if msg.DataType == types.JSON {
+			msg.DataType = types.TEXT
+		}
+		return true
+	})
+}
+
+// getCharset 根据名称获取字符集编码，UTF-8返回nil
+func getCharset(name string) (encoding.Encoding, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "utf-8", "utf8":
+		return nil, nil
+	}
+	enc, err := htmlindex.Get(name)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported charset: %s", name)
+	}
+	return enc, nil
+}
@@ -0,0 +1,94 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"crypto/x509"
+	"errors"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+)
+
+// ClientCertAuth 根据mTLS客户端证书CN/SAN鉴权的处理器名称
+//
+// 客户端证书通过exchange.In 实现的endpoint.TLSMessage接口获取，只使用已经校验的证书链(VerifiedChains)。
+// http endpoint需要配置CertFile、CertKeyFile和ClientCaFile，才会校验客户端证书。
+const ClientCertAuth = "clientCertAuth"
+
+// ClientCertAuthConfig clientCertAuth 处理器配置
+type ClientCertAuthConfig struct {
+	//AllowedNames 允许的身份列表，匹配证书CN或者SAN(DNS、Email、URI)
+	AllowedNames []string
+	//IdentityKey 如果配置，则把匹配的身份写入该元数据key
+	IdentityKey string
+}
+
+func init() {
+	//校验客户端证书身份是否在白名单中，否则响应403
+	Builtins.Register(ClientCertAuth, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		var config ClientCertAuthConfig
+		if err := getConfig(router, ClientCertAuth, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		cert := verifiedPeerCertificate(exchange.In)
+		if cert == nil {
+			return abort(exchange, http.StatusForbidden, errors.New("client certificate required"))
+		}
+		allowed := make(map[string]bool, len(config.AllowedNames))
+		for _, item := range config.AllowedNames {
+			allowed[item] = true
+		}
+		for _, identity := range certIdentities(cert) {
+			if allowed[identity] {
+				if config.IdentityKey != "" {
+					if msg := exchange.In.GetMsg(); msg != nil {
+						msg.Metadata.PutValue(config.IdentityKey, identity)
+					}
+				}
+				return true
+			}
+		}
+		return abort(exchange, http.StatusForbidden, errors.New("client certificate not allowed"))
+	})
+}
+
+// verifiedPeerCertificate 获取已校验的客户端证书，没有返回nil
+func verifiedPeerCertificate(message endpoint.Message) *x509.Certificate {
+	tlsMessage, ok := message.(endpoint.TLSMessage)
+	if !ok {
+		return nil
+	}
+	state := tlsMessage.TLSConnectionState()
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
+
+// certIdentities 获取证书CN和SAN身份列表
+func certIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if cert.Subject.CommonName != "" {
+		identities = append(identities, cert.Subject.CommonName)
+	}
+	identities = append(identities, cert.DNSNames...)
+	identities = append(identities, cert.EmailAddresses...)
+	for _, item := range cert.URIs {
+		identities = append(identities, item.String())
+	}
+	return identities
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimit 限制路由并发执行数量的处理器名称
+const ConcurrencyLimit = "concurrencyLimit"
+
+// ConcurrencyLimitConfig concurrencyLimit 处理器配置
+type ConcurrencyLimitConfig struct {
+	//Max 最大并发执行数量
+	Max int
+	//Timeout 并发已满时排队等待的超时时间，单位毫秒，默认0：不等待直接拒绝
+	Timeout int
+	//StatusCode 拒绝请求的响应码，默认429，也可以使用503
+	StatusCode int
+}
+
+// semaphore 路由信号量，每个路由一个实例
+type semaphore struct {
+	//def 创建信号量时的路由定义，路由定义变化后重新创建信号量
+	def    *types.RouterDsl
+	config ConcurrencyLimitConfig
+	slots  chan struct{}
+}
+
+// semaphores 路由信号量，key:路由ID
+var semaphores sync.Map
+
+func init() {
+	//进入时获取并发槽位，exchange处理结束时释放，并发已满则排队等待或者拒绝请求
+	//路由to端为异步模式时，请求分发到规则链后即视为处理结束
+	Builtins.Register(ConcurrencyLimit, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		sem, err := getSemaphore(router)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		if !sem.acquire(time.Duration(sem.config.Timeout) * time.Millisecond) {
+			return abort(exchange, sem.config.StatusCode, errors.New("too many concurrent requests"))
+		}
+		//无论处理器中断、规则链执行结束还是发生panic，都会在exchange处理结束时释放
+		exchange.OnDone(sem.release)
+		return true
+	})
+}
+
+// getSemaphore 获取路由对应的信号量，不存在则根据路由配置创建
+func getSemaphore(router endpoint.Router) (*semaphore, error) {
+	if v, ok := semaphores.Load(router.GetId()); ok && v.(*semaphore).def == router.Definition() {
+		return v.(*semaphore), nil
+	}
+	var config ConcurrencyLimitConfig
+	if err := getConfig(router, ConcurrencyLimit, &config); err != nil {
+		return nil, err
+	}
+	if config.Max <= 0 {
+		return nil, errors.New("concurrencyLimit max must be greater than 0")
+	}
+	if config.StatusCode <= 0 {
+		config.StatusCode = http.StatusTooManyRequests
+	}
+	sem := &semaphore{def: router.Definition(), config: config, slots: make(chan struct{}, config.Max)}
+	semaphores.Store(router.GetId(), sem)
+	return sem, nil
+}
+
+// acquire 获取一个并发槽位，timeout<=0则不等待
+func (s *semaphore) acquire(timeout time.Duration) bool {
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	default:
+	}
+	if timeout <= 0 {
+		return false
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+// release 释放一个并发槽位
+func (s *semaphore) release() {
+	<-s.slots
+}
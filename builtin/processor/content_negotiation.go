@@ -0,0 +1,264 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentNegotiation 根据Accept请求头协商响应内容类型的处理器名称
+//
+// 该处理器配置在to端处理器列表，并放在responseToBody之前，例如：
+//
+//	"to": {
+//	  "path": "chain:default",
+//	  "wait": true,
+//	  "processors": ["contentNegotiation", "responseToBody"]
+//	}
+//
+// 响应内容类型优先使用exchange.Out的Content-Type响应头，否则根据消息数据类型推断：
+// JSON->application/json，TEXT->text/plain，BINARY->application/octet-stream
+const ContentNegotiation = "contentNegotiation"
+
+const (
+	// MimeTypeJson JSON内容类型
+	MimeTypeJson = "application/json"
+	// MimeTypeXml XML内容类型
+	MimeTypeXml = "application/xml"
+	// MimeTypeText 文本内容类型
+	MimeTypeText = "text/plain"
+	// MimeTypeOctetStream 二进制内容类型
+	MimeTypeOctetStream = "application/octet-stream"
+)
+
+// ContentNegotiationConfig contentNegotiation 处理器配置
+type ContentNegotiationConfig struct {
+	//Convert 响应内容类型不被客户端接受时，是否使用注册的转换器转换响应，默认true
+	//false或者没有可用的转换器则响应406 Not Acceptable
+	Convert bool
+}
+
+// ContentConverter 响应内容转换器，把数据从一种内容类型转换成另一种内容类型
+type ContentConverter func(data []byte) ([]byte, error)
+
+// contentConverters 注册的转换器，key:源内容类型->目标内容类型->转换器
+var contentConverters = map[string]map[string]ContentConverter{}
+var contentConvertersLock sync.RWMutex
+
+// RegisterContentConverter 注册contentNegotiation 响应内容转换器，from和to为不带参数的媒体类型，例如：application/json
+func RegisterContentConverter(from, to string, converter ContentConverter) {
+	contentConvertersLock.Lock()
+	defer contentConvertersLock.Unlock()
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	if contentConverters[from] == nil {
+		contentConverters[from] = make(map[string]ContentConverter)
+	}
+	contentConverters[from][to] = converter
+}
+
+// acceptRange Accept请求头中的一个媒体范围
+type acceptRange struct {
+	mediaType string
+	q         float64
+}
+
+func init() {
+	RegisterContentConverter(MimeTypeJson, MimeTypeXml, JsonToXml)
+	//客户端不接受规则链输出的内容类型时，转换响应内容或者响应406 Not Acceptable
+	Builtins.Register(ContentNegotiation, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := ContentNegotiationConfig{Convert: true}
+		if err := getConfig(router, ContentNegotiation, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.Out.GetMsg()
+		//规则链执行失败的响应交给后续处理器处理
+		if msg == nil || exchange.Out.GetError() != nil {
+			return true
+		}
+		var accept string
+		if headers := exchange.In.Headers(); headers != nil {
+			accept = headers.Get("Accept")
+		}
+		ranges := parseAccept(accept)
+		if len(ranges) == 0 {
+			return true
+		}
+		produced := producedContentType(exchange.Out, msg)
+		for _, item := range ranges {
+			if matchMediaType(item.mediaType, produced) {
+				return true
+			}
+		}
+		if config.Convert {
+			if to, converter := findContentConverter(produced, ranges); converter != nil {
+				data, err := converter([]byte(msg.Data))
+				if err != nil {
+					return abort(exchange, http.StatusInternalServerError, err)
+				}
+				msg.Data = string(data)
+				//避免responseToBody按照JSON类型覆盖Content-Type
+				if msg.DataType == types.JSON {
+					msg.DataType = types.TEXT
+				}
+				if headers := exchange.Out.Headers(); headers != nil {
+					headers.Set("Content-Type", to)
+				}
+				return true
+			}
+		}
+		return abort(exchange, http.StatusNotAcceptable, fmt.Errorf("not acceptable content type: %s", produced))
+	})
+}
+
+// parseAccept 解析Accept请求头，去掉q=0的媒体范围，并按照q值从高到低排序
+// Accept为空或者包含 */* 表示接受任意类型，返回nil
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for _, item := range strings.Split(accept, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(item)
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+		if mediaType == "*/*" || mediaType == "*" {
+			return nil
+		}
+		ranges = append(ranges, acceptRange{mediaType: mediaType, q: q})
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	return ranges
+}
+
+// producedContentType 获取规则链输出的内容类型
+func producedContentType(out endpoint.Message, msg *types.RuleMsg) string {
+	if headers := out.Headers(); headers != nil {
+		if mediaType, _, err := mime.ParseMediaType(headers.Get("Content-Type")); err == nil {
+			return mediaType
+		}
+	}
+	switch msg.DataType {
+	case types.JSON:
+		return MimeTypeJson
+	case types.BINARY:
+		return MimeTypeOctetStream
+	default:
+		return MimeTypeText
+	}
+}
+
+// findContentConverter 按照客户端偏好顺序查找可以把produced转换成可接受类型的转换器
+func findContentConverter(produced string, ranges []acceptRange) (string, ContentConverter) {
+	contentConvertersLock.RLock()
+	defer contentConvertersLock.RUnlock()
+	converters := contentConverters[produced]
+	var targets = make([]string, 0, len(converters))
+	for k := range converters {
+		targets = append(targets, k)
+	}
+	sort.Strings(targets)
+	for _, item := range ranges {
+		for _, to := range targets {
+			if matchMediaType(item.mediaType, to) {
+				return to, converters[to]
+			}
+		}
+	}
+	return "", nil
+}
+
+// JsonToXml 把JSON转换成XML，根元素为root
+// 对象的字段转换成同名子元素，数组的元素转换成重复的同名元素，根数组的元素转换成item元素
+func JsonToXml(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	encoder := xml.NewEncoder(&buf)
+	if arr, ok := v.([]interface{}); ok {
+		if err := encodeXmlElement(encoder, "root", map[string]interface{}{"item": arr}); err != nil {
+			return nil, err
+		}
+	} else if err := encodeXmlElement(encoder, "root", v); err != nil {
+		return nil, err
+	}
+	if err := encoder.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeXmlElement 把JSON值编码成XML元素
+func encodeXmlElement(encoder *xml.Encoder, name string, value interface{}) error {
+	if arr, ok := value.([]interface{}); ok {
+		for _, item := range arr {
+			if err := encodeXmlElement(encoder, name, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := encoder.EncodeToken(start); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		var keys = make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if err := encodeXmlElement(encoder, k, v[k]); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := encoder.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+	return encoder.EncodeToken(start.End())
+}
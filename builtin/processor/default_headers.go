@@ -0,0 +1,53 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"net/textproto"
+)
+
+// DefaultHeaders 为请求补充默认请求头的处理器名称
+// 需要放在headersToMetadata、detectDataType等读取请求头的处理器之前
+const DefaultHeaders = "defaultHeaders"
+
+// DefaultHeadersConfig defaultHeaders 处理器配置
+type DefaultHeadersConfig struct {
+	//Headers 默认请求头，例如：{"Content-Type": "application/json", "Accept": "application/json"}
+	Headers map[string]string
+}
+
+func init() {
+	//客户端没有提供的请求头设置为默认值，客户端已经提供的请求头(包括空值)不会被覆盖
+	Builtins.Register(DefaultHeaders, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		var config DefaultHeadersConfig
+		if err := getConfig(router, DefaultHeaders, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		headers := exchange.In.Headers()
+		if headers == nil {
+			return true
+		}
+		for k, v := range config.Headers {
+			if _, ok := headers[textproto.CanonicalMIMEHeaderKey(k)]; !ok {
+				headers.Set(k, v)
+			}
+		}
+		return true
+	})
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"unicode/utf8"
+)
+
+// DetectDataType 根据消息内容嗅探数据类型的处理器名称
+const DetectDataType = "detectDataType"
+
+// defaultSniffLen 默认嗅探的最大字节数
+const defaultSniffLen = 4096
+
+// DetectDataTypeConfig detectDataType 处理器配置
+type DetectDataTypeConfig struct {
+	//Override 是否覆盖请求头Content-Type已声明的数据类型，默认false：请求声明了Content-Type则保持不变
+	Override bool
+	//SniffLen 最多检查消息体前多少个字节，默认4096。
+	//消息体不超过该长度时完整校验JSON，超过则只根据前缀判断
+	SniffLen int
+}
+
+func init() {
+	//根据exchange.In 消息内容嗅探数据类型，并设置msg.DataType
+	Builtins.Register(DetectDataType, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := DetectDataTypeConfig{SniffLen: defaultSniffLen}
+		if err := getConfig(router, DetectDataType, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.In.GetMsg()
+		if msg == nil {
+			return true
+		}
+		if !config.Override {
+			if headers := exchange.In.Headers(); headers != nil && headers.Get("Content-Type") != "" {
+				return true
+			}
+		}
+		msg.DataType = sniffDataType(exchange.In.Body(), config.SniffLen)
+		return true
+	})
+}
+
+// sniffDataType 检查消息体前缀，判断数据类型
+func sniffDataType(body []byte, sniffLen int) types.DataType {
+	if sniffLen <= 0 {
+		sniffLen = defaultSniffLen
+	}
+	truncated := len(body) > sniffLen
+	prefix := body
+	if truncated {
+		prefix = body[:sniffLen]
+	}
+	if truncated {
+		//只检查前缀，以JSON对象或者数组开头并且是有效文本，则认为是JSON
+		trimmed := bytes.TrimLeft(prefix, " \t\r\n")
+		if len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') && isText(prefix, true) {
+			return types.JSON
+		}
+	} else if len(bytes.TrimSpace(prefix)) > 0 && json.Valid(prefix) {
+		return types.JSON
+	}
+	if isText(prefix, truncated) {
+		return types.TEXT
+	}
+	return types.BINARY
+}
+
+// isText 判断是否是UTF-8文本，并且不包含除空白符外的控制字符
+// truncated=true 表示数据被截断，允许末尾存在不完整的UTF-8字符
+func isText(data []byte, truncated bool) bool {
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size <= 1 {
+			if truncated && len(data)-i < utf8.UTFMax && !utf8.FullRune(data[i:]) {
+				return true
+			}
+			return false
+		}
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+		i += size
+	}
+	return true
+}
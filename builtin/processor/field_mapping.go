@@ -0,0 +1,113 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// FieldMapping 把请求字段映射成规则链约定字段的处理器名称
+const FieldMapping = "fieldMapping"
+
+const (
+	// FieldSourceBody 从JSON消息体映射
+	FieldSourceBody = "body"
+	// FieldSourceQuery 从请求参数映射
+	FieldSourceQuery = "query"
+)
+
+// FieldMappingConfig fieldMapping 处理器配置
+type FieldMappingConfig struct {
+	//Mapping 字段映射，key:客户端字段名 value:规则链约定字段名。只处理JSON对象的第一层字段
+	Mapping map[string]string
+	//Sources 映射来源，可选body、query，默认两者都使用，body优先
+	//query来源的值写入消息体对应的约定字段
+	Sources []string
+	//Required 映射后必须存在的约定字段，缺失则响应400
+	Required []string
+}
+
+func init() {
+	//把不同版本客户端的字段名统一映射成规则链约定字段
+	Builtins.Register(FieldMapping, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := FieldMappingConfig{Sources: []string{FieldSourceBody, FieldSourceQuery}}
+		if err := getConfig(router, FieldMapping, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.In.GetMsg()
+		if msg == nil {
+			return true
+		}
+		var body = make(map[string]interface{})
+		if strings.TrimSpace(msg.Data) != "" {
+			if err := json.Unmarshal([]byte(msg.Data), &body); err != nil {
+				return abort(exchange, http.StatusBadRequest, fmt.Errorf("body is not a JSON object: %w", err))
+			}
+		}
+		useBody, useQuery := false, false
+		for _, item := range config.Sources {
+			switch strings.ToLower(item) {
+			case FieldSourceBody:
+				useBody = true
+			case FieldSourceQuery:
+				useQuery = true
+			}
+		}
+		//按字段名排序，保证多个来源字段映射到同一个约定字段时结果确定
+		sources := make([]string, 0, len(config.Mapping))
+		for k := range config.Mapping {
+			sources = append(sources, k)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			target := config.Mapping[source]
+			if _, ok := body[target]; ok {
+				continue
+			}
+			if v, ok := body[source]; ok && useBody {
+				body[target] = v
+				delete(body, source)
+			} else if useQuery {
+				if v := exchange.In.GetParam(source); v != "" {
+					body[target] = v
+				}
+			}
+		}
+		var missing []string
+		for _, item := range config.Required {
+			if _, ok := body[item]; !ok {
+				missing = append(missing, item)
+			}
+		}
+		if len(missing) > 0 {
+			return abort(exchange, http.StatusBadRequest, fmt.Errorf("missing required fields: %s", strings.Join(missing, ",")))
+		}
+		if b, err := json.Marshal(body); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		} else {
+			msg.Data = string(b)
+			msg.DataType = types.JSON
+		}
+		return true
+	})
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"io"
+	"net/http"
+)
+
+// JsonLimits 限制JSON请求体嵌套深度和元素数量的处理器名称
+const JsonLimits = "jsonLimits"
+
+// JsonLimitsConfig jsonLimits 处理器配置
+// 与限制字节数的maxBodySize互补，限制JSON结构复杂度，避免深层嵌套或者超大数组导致解析消耗过多资源
+type JsonLimitsConfig struct {
+	//MaxDepth 最大嵌套深度，顶层对象或数组深度为1，默认32
+	MaxDepth int
+	//MaxElements 最大元素数量，所有对象的字段数和数组的元素数之和，默认10000
+	MaxElements int
+}
+
+// jsonContainer JSON对象或者数组
+type jsonContainer struct {
+	isObject bool
+	//expectKey 对象下一个token是否是字段名
+	expectKey bool
+}
+
+func init() {
+	//校验JSON请求体结构复杂度，超过限制响应400，空请求体不校验
+	Builtins.Register(JsonLimits, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := JsonLimitsConfig{MaxDepth: 32, MaxElements: 10000}
+		if err := getConfig(router, JsonLimits, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		body := bytes.TrimSpace(exchange.In.Body())
+		if len(body) == 0 {
+			return true
+		}
+		if err := checkJsonLimits(body, config); err != nil {
+			return abort(exchange, http.StatusBadRequest, err)
+		}
+		return true
+	})
+}
+
+// checkJsonLimits 逐个读取JSON token校验嵌套深度和元素数量，不构建完整的JSON对象，超过限制立即返回
+func checkJsonLimits(body []byte, config JsonLimitsConfig) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	var stack []*jsonContainer
+	var elements int
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("invalid JSON body: %w", err)
+		}
+		var top *jsonContainer
+		if len(stack) > 0 {
+			top = stack[len(stack)-1]
+		}
+		delim, isDelim := token.(json.Delim)
+		if isDelim && (delim == '}' || delim == ']') {
+			stack = stack[:len(stack)-1]
+			//对象或数组作为字段值结束
+			if len(stack) > 0 && stack[len(stack)-1].isObject {
+				stack[len(stack)-1].expectKey = true
+			}
+			continue
+		}
+		if top != nil && top.isObject && top.expectKey {
+			//字段名
+			top.expectKey = false
+			if elements++; config.MaxElements > 0 && elements > config.MaxElements {
+				return fmt.Errorf("JSON body exceeds the maximum number of elements %d", config.MaxElements)
+			}
+			continue
+		}
+		if top != nil && !top.isObject {
+			//数组元素
+			if elements++; config.MaxElements > 0 && elements > config.MaxElements {
+				return fmt.Errorf("JSON body exceeds the maximum number of elements %d", config.MaxElements)
+			}
+		}
+		if isDelim {
+			stack = append(stack, &jsonContainer{isObject: delim == '{', expectKey: delim == '{'})
+			if config.MaxDepth > 0 && len(stack) > config.MaxDepth {
+				return fmt.Errorf("JSON body exceeds the maximum depth %d", config.MaxDepth)
+			}
+		} else if top != nil && top.isObject {
+			//字段值是基本类型
+			top.expectKey = true
+		}
+	}
+	if len(stack) > 0 {
+		return errors.New("invalid JSON body: unexpected end")
+	}
+	return nil
+}
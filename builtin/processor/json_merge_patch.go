@@ -0,0 +1,146 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/json"
+	"github.com/rulego/rulego/utils/str"
+	"net/http"
+	"sync"
+)
+
+// JsonMergePatch 把JSON Merge Patch(RFC 7386)合并到消息体的处理器名称
+const JsonMergePatch = "jsonMergePatch"
+
+// JsonMergePatchConfig jsonMergePatch 处理器配置
+type JsonMergePatchConfig struct {
+	//Patch 补丁文档，可以是JSON字符串或者对象
+	//字符串类型的值支持${metadata.key}替换消息元数据，${vars.key}替换目标规则链vars变量，替换结果只作为字符串值，不会改变补丁结构
+	Patch interface{}
+}
+
+// parsedMergePatch 路由解析后的补丁文档
+type parsedMergePatch struct {
+	//def 解析补丁时的路由定义，路由定义变化后重新解析
+	def   *types.RouterDsl
+	patch interface{}
+}
+
+// mergePatches 路由解析后的补丁文档，key:路由ID
+var mergePatches sync.Map
+
+func init() {
+	//把补丁文档合并到exchange.In 消息JSON数据
+	Builtins.Register(JsonMergePatch, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		patch, err := getMergePatch(router)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.In.GetMsg()
+		if msg == nil {
+			return true
+		}
+		patch = replacePatchVars(patch, msg.Metadata.Values(), chainVars(router, exchange))
+
+		var target interface{}
+		if err := json.Unmarshal([]byte(msg.Data), &target); err != nil {
+			return abort(exchange, http.StatusBadRequest, fmt.Errorf("body is not valid JSON: %w", err))
+		}
+		if result, err := json.Marshal(mergePatch(target, patch)); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		} else {
+			msg.Data = string(result)
+			msg.DataType = types.JSON
+		}
+		return true
+	})
+}
+
+// getMergePatch 获取路由配置的补丁文档，不存在则解析路由配置
+func getMergePatch(router endpoint.Router) (interface{}, error) {
+	if v, ok := mergePatches.Load(router.GetId()); ok && v.(*parsedMergePatch).def == router.Definition() {
+		return v.(*parsedMergePatch).patch, nil
+	}
+	var config JsonMergePatchConfig
+	if err := getConfig(router, JsonMergePatch, &config); err != nil {
+		return nil, err
+	}
+	if config.Patch == nil {
+		return nil, errors.New("jsonMergePatch patch can not empty")
+	}
+	patchStr, ok := config.Patch.(string)
+	if !ok {
+		if b, err := json.Marshal(config.Patch); err != nil {
+			return nil, err
+		} else {
+			patchStr = string(b)
+		}
+	}
+	var patch interface{}
+	if err := json.Unmarshal([]byte(patchStr), &patch); err != nil {
+		return nil, fmt.Errorf("invalid jsonMergePatch patch: %w", err)
+	}
+	mergePatches.Store(router.GetId(), &parsedMergePatch{def: router.Definition(), patch: patch})
+	return patch, nil
+}
+
+// replacePatchVars 返回替换字符串值中元数据和vars变量占位符后的补丁文档副本，不修改原补丁文档
+func replacePatchVars(patch interface{}, metadata, vars map[string]string) interface{} {
+	switch v := patch.(type) {
+	case string:
+		v = str.SprintfVar(v, types.MetadataKey+".", metadata)
+		return str.SprintfVar(v, types.Vars+".", vars)
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = replacePatchVars(item, metadata, vars)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = replacePatchVars(item, metadata, vars)
+		}
+		return result
+	default:
+		return v
+	}
+}
+
+// mergePatch 按照RFC 7386规则把patch合并到target
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+		} else {
+			targetObj[k] = mergePatch(targetObj[k], v)
+		}
+	}
+	return targetObj
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/json"
+	"github.com/rulego/rulego/utils/str"
+	"net/http"
+	"strings"
+)
+
+// JwtClaims 解析JWT载荷声明的处理器名称
+//
+// 注意：该处理器只解码JWT载荷，不校验签名、过期时间等，只适用于上游网关已经完成JWT校验的场景，
+// 解析出的声明只能用于路由，不能作为鉴权依据。
+const JwtClaims = "jwtClaims"
+
+const (
+	// JwtClaimsStatusOk 成功解析JWT声明
+	JwtClaimsStatusOk = "Claims"
+	// JwtClaimsStatusNone 没有令牌或者令牌格式错误
+	JwtClaimsStatusNone = "NoClaims"
+	// JwtClaimsOnMalformedIgnore 令牌缺失或者格式错误时忽略，继续处理
+	JwtClaimsOnMalformedIgnore = "ignore"
+	// JwtClaimsOnMalformedReject 令牌缺失或者格式错误时响应401
+	JwtClaimsOnMalformedReject = "reject"
+)
+
+// JwtClaimsConfig jwtClaims 处理器配置
+type JwtClaimsConfig struct {
+	//Header 令牌所在请求头，默认Authorization，支持Bearer前缀
+	Header string
+	//Claims 需要写入消息元数据的声明，key:声明名称 value:元数据key
+	//为空则写入所有第一层声明，元数据key使用声明名称
+	Claims map[string]string
+	//StatusKey 如果配置，则把解析状态(Claims/NoClaims)写入该元数据key，用于后续节点按NoClaims分支路由
+	StatusKey string
+	//OnMalformed 令牌缺失或者格式错误的处理方式：ignore(默认)、reject
+	OnMalformed string
+}
+
+func init() {
+	//解码(不校验)Bearer令牌载荷，把指定声明写入msg.Metadata
+	Builtins.Register(JwtClaims, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := JwtClaimsConfig{Header: "Authorization", OnMalformed: JwtClaimsOnMalformedIgnore}
+		if err := getConfig(router, JwtClaims, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.In.GetMsg()
+		if msg == nil {
+			return true
+		}
+		var token string
+		if headers := exchange.In.Headers(); headers != nil {
+			token = headers.Get(config.Header)
+		}
+		claims, err := decodeJwtClaims(token)
+		if err != nil {
+			if config.StatusKey != "" {
+				msg.Metadata.PutValue(config.StatusKey, JwtClaimsStatusNone)
+			}
+			if config.OnMalformed == JwtClaimsOnMalformedReject {
+				return abort(exchange, http.StatusUnauthorized, err)
+			}
+			return true
+		}
+		if len(config.Claims) == 0 {
+			for k, v := range claims {
+				msg.Metadata.PutValue(k, str.ToString(v))
+			}
+		} else {
+			for claim, key := range config.Claims {
+				if v, ok := claims[claim]; ok {
+					msg.Metadata.PutValue(key, str.ToString(v))
+				}
+			}
+		}
+		if config.StatusKey != "" {
+			msg.Metadata.PutValue(config.StatusKey, JwtClaimsStatusOk)
+		}
+		return true
+	})
+}
+
+// decodeJwtClaims 解码JWT载荷，不校验签名
+func decodeJwtClaims(token string) (map[string]interface{}, error) {
+	token = strings.TrimSpace(token)
+	if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+		token = strings.TrimSpace(token[7:])
+	}
+	if token == "" {
+		return nil, errors.New("missing token")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.New("malformed token payload")
+	}
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+	return claims, nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"fmt"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"strings"
+)
+
+// MaxQueryParams 限制请求URL查询参数数量的处理器名称
+const MaxQueryParams = "maxQueryParams"
+
+// MaxQueryParamsConfig maxQueryParams 处理器配置
+// 用于公网端点加固，避免客户端发送大量查询参数导致解析和元数据消耗过多资源
+type MaxQueryParamsConfig struct {
+	//Max 最大查询参数数量，重复的key分别计数，默认100
+	Max int
+}
+
+func init() {
+	//校验请求URL查询参数数量，超过限制响应400，非HTTP请求不校验
+	Builtins.Register(MaxQueryParams, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := MaxQueryParamsConfig{Max: 100}
+		if err := getConfig(router, MaxQueryParams, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		r, ok := exchange.In.(interface{ Request() *http.Request })
+		if !ok || r.Request() == nil || r.Request().URL == nil || config.Max <= 0 {
+			return true
+		}
+		if countQueryParams(r.Request().URL.RawQuery, config.Max) > config.Max {
+			return abort(exchange, http.StatusBadRequest, fmt.Errorf("too many query parameters, the maximum is %d", config.Max))
+		}
+		return true
+	})
+}
+
+// countQueryParams 统计原始查询字符串中的参数数量，不解析参数，忽略空的参数段
+// 计数超过limit后立即返回
+func countQueryParams(rawQuery string, limit int) int {
+	var count int
+	for rawQuery != "" && count <= limit {
+		var segment string
+		if i := strings.IndexByte(rawQuery, '&'); i >= 0 {
+			segment, rawQuery = rawQuery[:i], rawQuery[i+1:]
+		} else {
+			segment, rawQuery = rawQuery, ""
+		}
+		if segment != "" {
+			count++
+		}
+	}
+	return count
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/json"
+	"net/http"
+	"strings"
+)
+
+// OutputMapping 把规则链约定字段映射成客户端版本字段的处理器名称
+// 与fieldMapping相反，在responseToBody之前处理响应消息体
+const OutputMapping = "outputMapping"
+
+// OutputMappingConfig outputMapping 处理器配置
+type OutputMappingConfig struct {
+	//Mappings 客户端版本对应的字段映射，key:客户端版本 value:字段映射(key:规则链约定字段名 value:客户端字段名)
+	//只处理JSON对象的第一层字段
+	Mappings map[string]map[string]string
+	//VersionHeader 获取客户端版本的请求头，默认X-Client-Version
+	VersionHeader string
+	//VersionVar 请求头没有客户端版本时，从目标规则链该vars变量获取客户端版本，为空则不使用
+	VersionVar string
+	//DropUnmapped 是否删除没有映射的字段，默认false：原样输出
+	DropUnmapped bool
+}
+
+func init() {
+	//把规则链约定字段映射成不同版本客户端期望的字段名，没有客户端版本或者没有对应版本的映射则不处理
+	Builtins.Register(OutputMapping, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := OutputMappingConfig{VersionHeader: "X-Client-Version"}
+		if err := getConfig(router, OutputMapping, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.Out.GetMsg()
+		if msg == nil || exchange.Out.GetError() != nil || strings.TrimSpace(msg.Data) == "" {
+			return true
+		}
+		version := exchange.In.Headers().Get(config.VersionHeader)
+		if version == "" && config.VersionVar != "" {
+			version = chainVars(router, exchange)[config.VersionVar]
+		}
+		mapping, ok := config.Mappings[version]
+		if version == "" || !ok {
+			return true
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal([]byte(msg.Data), &body); err != nil {
+			//不是JSON对象，原样输出
+			return true
+		}
+		var result = make(map[string]interface{}, len(body))
+		for k, v := range body {
+			if target, ok := mapping[k]; ok {
+				result[target] = v
+			} else if !config.DropUnmapped {
+				if _, exists := result[k]; !exists {
+					result[k] = v
+				}
+			}
+		}
+		if b, err := json.Marshal(result); err != nil {
+			return abort(exchange, http.StatusInternalServerError, fmt.Errorf("output mapping error: %w", err))
+		} else {
+			msg.Data = string(b)
+			msg.DataType = types.JSON
+		}
+		return true
+	})
+}
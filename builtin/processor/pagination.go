@@ -0,0 +1,123 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"fmt"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"strconv"
+)
+
+// Pagination 解析并校验分页参数的处理器名称
+const Pagination = "pagination"
+
+const (
+	// PaginationPageKey 页码元数据key
+	PaginationPageKey = "page"
+	// PaginationSizeKey 每页数量元数据key
+	PaginationSizeKey = "size"
+	// PaginationOffsetKey 偏移量元数据key，等于(page-1)*size
+	PaginationOffsetKey = "offset"
+	// PaginationCursorKey 游标元数据key
+	PaginationCursorKey = "cursor"
+)
+
+// PaginationConfig pagination 处理器配置
+type PaginationConfig struct {
+	//PageParam 页码请求参数名，默认page
+	PageParam string
+	//SizeParam 每页数量请求参数名，默认size
+	SizeParam string
+	//CursorParam 游标请求参数名，默认cursor。存在游标时不再输出page和offset
+	CursorParam string
+	//DefaultSize 默认每页数量，默认20
+	DefaultSize int
+	//MaxSize 最大每页数量，默认100
+	MaxSize int
+	//Strict 是否严格模式，true:非数字或者超出范围的参数响应400；false:使用默认值或者截断到有效范围
+	Strict bool
+}
+
+func init() {
+	//把分页参数规范化后写入消息元数据，供后续查询节点使用
+	Builtins.Register(Pagination, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := PaginationConfig{
+			PageParam:   PaginationPageKey,
+			SizeParam:   PaginationSizeKey,
+			CursorParam: PaginationCursorKey,
+			DefaultSize: 20,
+			MaxSize:     100,
+		}
+		if err := getConfig(router, Pagination, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		if config.MaxSize <= 0 {
+			config.MaxSize = 100
+		}
+		if config.DefaultSize <= 0 || config.DefaultSize > config.MaxSize {
+			config.DefaultSize = config.MaxSize
+		}
+		msg := exchange.In.GetMsg()
+		if msg == nil {
+			return true
+		}
+		size, err := pageParam(exchange.In.GetParam(config.SizeParam), config.DefaultSize, 1, config.MaxSize, config.Strict)
+		if err != nil {
+			return abort(exchange, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", config.SizeParam, err))
+		}
+		msg.Metadata.PutValue(PaginationSizeKey, strconv.Itoa(size))
+		if cursor := exchange.In.GetParam(config.CursorParam); cursor != "" {
+			msg.Metadata.PutValue(PaginationCursorKey, cursor)
+			return true
+		}
+		page, err := pageParam(exchange.In.GetParam(config.PageParam), 1, 1, 0, config.Strict)
+		if err != nil {
+			return abort(exchange, http.StatusBadRequest, fmt.Errorf("invalid %s: %w", config.PageParam, err))
+		}
+		msg.Metadata.PutValue(PaginationPageKey, strconv.Itoa(page))
+		msg.Metadata.PutValue(PaginationOffsetKey, strconv.Itoa((page-1)*size))
+		return true
+	})
+}
+
+// pageParam 解析分页参数，max<=0表示不限制上限
+func pageParam(value string, defaultValue, min, max int, strict bool) (int, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		if strict {
+			return 0, fmt.Errorf("%s is not a number", value)
+		}
+		return defaultValue, nil
+	}
+	if v < min {
+		if strict {
+			return 0, fmt.Errorf("%d is less than %d", v, min)
+		}
+		v = min
+	}
+	if max > 0 && v > max {
+		if strict {
+			return 0, fmt.Errorf("%d is greater than %d", v, max)
+		}
+		v = max
+	}
+	return v, nil
+}
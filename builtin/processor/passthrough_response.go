@@ -0,0 +1,106 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PassthroughResponse 根据规则链保存的后端响应信息，还原HTTP响应的处理器名称
+//
+// 元数据约定(与restApiCall节点输出一致)：
+//   - statusCode：后端响应状态码，例如：200
+//   - errorBody：后端响应失败时的响应体，规则链执行失败时优先使用
+//   - Headers配置的元数据key：原样作为响应头输出
+//   - HeaderPrefix前缀的元数据key：去掉前缀后作为响应头输出，例如：header.X-Request-Id
+const PassthroughResponse = "passthroughResponse"
+
+const (
+	// defaultStatusCodeKey 默认状态码元数据key
+	defaultStatusCodeKey = "statusCode"
+	// defaultErrorBodyKey 默认失败响应体元数据key
+	defaultErrorBodyKey = "errorBody"
+)
+
+// PassthroughResponseConfig passthroughResponse 处理器配置
+type PassthroughResponseConfig struct {
+	//StatusCodeKey 状态码元数据key，默认statusCode
+	StatusCodeKey string
+	//ErrorBodyKey 失败响应体元数据key，默认errorBody
+	ErrorBodyKey string
+	//Headers 需要输出的响应头，从同名元数据key获取
+	Headers []string
+	//HeaderPrefix 以该前缀开头的元数据key，去掉前缀后作为响应头输出，为空则不处理
+	HeaderPrefix string
+}
+
+func init() {
+	//把规则链处理结果按照后端原始响应的状态码、响应头和响应体输出到exchange.Out
+	Builtins.Register(PassthroughResponse, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := PassthroughResponseConfig{StatusCodeKey: defaultStatusCodeKey, ErrorBodyKey: defaultErrorBodyKey}
+		if err := getConfig(router, PassthroughResponse, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		msg := exchange.Out.GetMsg()
+		err := exchange.Out.GetError()
+		if msg == nil {
+			if err != nil {
+				return abort(exchange, http.StatusInternalServerError, err)
+			}
+			return true
+		}
+		if headers := exchange.Out.Headers(); headers != nil {
+			for _, key := range config.Headers {
+				if v := msg.Metadata.GetValue(key); v != "" {
+					headers.Set(key, v)
+				}
+			}
+			if config.HeaderPrefix != "" {
+				for k, v := range msg.Metadata.Values() {
+					if strings.HasPrefix(k, config.HeaderPrefix) && len(k) > len(config.HeaderPrefix) {
+						headers.Set(k[len(config.HeaderPrefix):], v)
+					}
+				}
+			}
+			if headers.Get("Content-Type") == "" && msg.DataType == types.JSON {
+				headers.Set("Content-Type", "application/json")
+			}
+		}
+		statusCode := http.StatusOK
+		if err != nil {
+			statusCode = http.StatusInternalServerError
+		}
+		if v, convErr := strconv.Atoi(msg.Metadata.GetValue(config.StatusCodeKey)); convErr == nil && v > 0 {
+			statusCode = v
+		}
+		exchange.Out.SetStatusCode(statusCode)
+		if err != nil {
+			if errorBody := msg.Metadata.GetValue(config.ErrorBodyKey); errorBody != "" {
+				exchange.Out.SetBody([]byte(errorBody))
+			} else {
+				exchange.Out.SetBody([]byte(err.Error()))
+			}
+		} else {
+			exchange.Out.SetBody([]byte(msg.Data))
+		}
+		return true
+	})
+}
@@ -19,6 +19,8 @@ package processor
 import (
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/maps"
+	"github.com/rulego/rulego/utils/str"
 	"sync"
 )
 
@@ -94,3 +96,50 @@ func (b *builtins) Get(name string) (endpoint.Process, bool) {
 	p, ok := b.processors[name]
 	return p, ok
 }
+
+// getConfig 获取处理器配置，处理器配置位于路由from端配置中，以处理器名称作为key，例如：
+//
+//	"from": {
+//	  "path": "/api/v1/msg",
+//	  "configuration": {
+//	    "detectDataType": {"override": true}
+//	  },
+//	  "processors": ["detectDataType"]
+//	}
+//
+// 如果路由没有定义DSL或者没有对应配置，则不修改output，使用其默认值
+func getConfig(router endpoint.Router, name string, output interface{}) error {
+	if router == nil || router.Definition() == nil {
+		return nil
+	}
+	if v, ok := router.Definition().From.Configuration[name]; ok && v != nil {
+		return maps.Map2Struct(v, output)
+	}
+	return nil
+}
+
+// abort 中断处理，并把错误响应给客户端
+func abort(exchange *endpoint.Exchange, statusCode int, err error) bool {
+	exchange.Out.SetError(err)
+	exchange.Out.SetStatusCode(statusCode)
+	exchange.Out.SetBody([]byte(err.Error()))
+	return false
+}
+
+// chainVars 获取路由目标规则链的vars变量，如果目标不是规则链或者找不到规则链返回nil
+func chainVars(router endpoint.Router, exchange *endpoint.Exchange) map[string]string {
+	if router == nil || router.GetFrom() == nil || router.GetFrom().GetTo() == nil {
+		return nil
+	}
+	var dict map[string]string
+	if msg := exchange.In.GetMsg(); msg != nil {
+		dict = msg.Metadata.Values()
+	}
+	chainId := router.GetFrom().GetTo().ToStringByDict(dict)
+	if ruleEngine, ok := router.GetRuleGo(exchange).Get(chainId); ok {
+		if configuration := ruleEngine.Definition().RuleChain.Configuration; configuration != nil {
+			return str.ToStringMapString(configuration[types.Vars])
+		}
+	}
+	return nil
+}
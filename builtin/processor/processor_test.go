@@ -0,0 +1,1068 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/endpoint/impl"
+	"github.com/rulego/rulego/test/assert"
+	"github.com/rulego/rulego/utils/json"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testMessage 测试消息
+type testMessage struct {
+	body       []byte
+	headers    textproto.MIMEHeader
+	params     map[string]string
+	msg        *types.RuleMsg
+	statusCode int
+	err        error
+}
+
+func newTestMessage(body string, headers map[string]string) *testMessage {
+	m := &testMessage{body: []byte(body), headers: make(textproto.MIMEHeader), params: make(map[string]string)}
+	for k, v := range headers {
+		m.headers.Set(k, v)
+	}
+	return m
+}
+
+func (m *testMessage) Body() []byte {
+	return m.body
+}
+func (m *testMessage) Headers() textproto.MIMEHeader {
+	return m.headers
+}
+func (m *testMessage) From() string {
+	return "/api/v1/test"
+}
+func (m *testMessage) GetParam(key string) string {
+	return m.params[key]
+}
+func (m *testMessage) SetMsg(msg *types.RuleMsg) {
+	m.msg = msg
+}
+func (m *testMessage) GetMsg() *types.RuleMsg {
+	if m.msg == nil {
+		msg := types.NewMsg(0, m.From(), types.TEXT, types.NewMetadata(), string(m.body))
+		m.msg = &msg
+	}
+	return m.msg
+}
+func (m *testMessage) SetStatusCode(statusCode int) {
+	m.statusCode = statusCode
+}
+func (m *testMessage) SetBody(body []byte) {
+	m.body = body
+}
+func (m *testMessage) SetError(err error) {
+	m.err = err
+}
+func (m *testMessage) GetError() error {
+	return m.err
+}
+
+// newTestRouter 创建带有处理器配置的路由
+func newTestRouter(configuration types.Configuration) endpoint.Router {
+	def := &types.RouterDsl{From: types.FromDsl{Path: "/api/v1/test", Configuration: configuration}}
+	return impl.NewRouter(endpoint.RouterOptions.WithDefinition(def))
+}
+
+func newTestExchange(body string, headers map[string]string) *endpoint.Exchange {
+	return &endpoint.Exchange{In: newTestMessage(body, headers), Out: newTestMessage("", nil)}
+}
+
+func TestDetectDataType(t *testing.T) {
+	p, ok := Builtins.Get(DetectDataType)
+	assert.True(t, ok)
+
+	exchange := newTestExchange(`{"name":"lala"}`, nil)
+	assert.True(t, p(newTestRouter(nil), exchange))
+	assert.Equal(t, types.JSON, exchange.In.GetMsg().DataType)
+
+	exchange = newTestExchange("hello", nil)
+	assert.True(t, p(newTestRouter(nil), exchange))
+	assert.Equal(t, types.TEXT, exchange.In.GetMsg().DataType)
+
+	exchange = newTestExchange(string([]byte{0x00, 0x01, 0xff}), nil)
+	assert.True(t, p(newTestRouter(nil), exchange))
+	assert.Equal(t, types.BINARY, exchange.In.GetMsg().DataType)
+
+	//尊重已声明的Content-Type
+	exchange = newTestExchange(`{"name":"lala"}`, map[string]string{"Content-Type": "text/plain"})
+	assert.True(t, p(newTestRouter(nil), exchange))
+	assert.Equal(t, types.TEXT, exchange.In.GetMsg().DataType)
+
+	//覆盖已声明的Content-Type
+	router := newTestRouter(types.Configuration{DetectDataType: map[string]interface{}{"override": true}})
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, types.JSON, exchange.In.GetMsg().DataType)
+
+	//超过嗅探长度，只检查前缀
+	router = newTestRouter(types.Configuration{DetectDataType: map[string]interface{}{"sniffLen": 4}})
+	exchange = newTestExchange(`{"name":"lala"`, nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, types.JSON, exchange.In.GetMsg().DataType)
+}
+
+func TestJsonMergePatch(t *testing.T) {
+	p, ok := Builtins.Get(JsonMergePatch)
+	assert.True(t, ok)
+
+	router := newTestRouter(types.Configuration{JsonMergePatch: map[string]interface{}{
+		"patch": `{"receivedAt":"${metadata.ts}","status":"new","remove":null,"nested":{"b":2}}`,
+	}})
+	exchange := newTestExchange(`{"name":"lala","remove":1,"nested":{"a":1}}`, nil)
+	exchange.In.GetMsg().Metadata.PutValue("ts", "100")
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"name":"lala","nested":{"a":1,"b":2},"receivedAt":"100","status":"new"}`, exchange.In.GetMsg().Data)
+	assert.Equal(t, types.JSON, exchange.In.GetMsg().DataType)
+
+	//元数据只替换字符串值，不能注入补丁字段
+	exchange = newTestExchange(`{"name":"lala"}`, nil)
+	exchange.In.GetMsg().Metadata.PutValue("ts", `1","admin":true,"x":"`)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"name":"lala","nested":{"b":2},"receivedAt":"1\",\"admin\":true,\"x\":\"","status":"new"}`, exchange.In.GetMsg().Data)
+
+	//补丁是对象
+	router = newTestRouter(types.Configuration{JsonMergePatch: map[string]interface{}{
+		"patch": map[string]interface{}{"status": "new"},
+	}})
+	exchange = newTestExchange(`{"status":"old"}`, nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"status":"new"}`, exchange.In.GetMsg().Data)
+
+	//非JSON消息体
+	exchange = newTestExchange(`hello`, nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 400, exchange.Out.(*testMessage).statusCode)
+	assert.NotNil(t, exchange.Out.GetError())
+}
+
+func TestAllowContentTypes(t *testing.T) {
+	p, ok := Builtins.Get(AllowContentTypes)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{AllowContentTypes: map[string]interface{}{
+		"contentTypes": []string{"application/json", "text/*"},
+	}})
+
+	exchange := newTestExchange(`{}`, map[string]string{"Content-Type": "application/json; charset=utf-8"})
+	assert.True(t, p(router, exchange))
+
+	exchange = newTestExchange(`aa`, map[string]string{"Content-Type": "text/plain"})
+	assert.True(t, p(router, exchange))
+
+	exchange = newTestExchange(`<a/>`, map[string]string{"Content-Type": "application/xml"})
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 415, exchange.Out.(*testMessage).statusCode)
+
+	exchange = newTestExchange(`{}`, nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 415, exchange.Out.(*testMessage).statusCode)
+}
+
+func TestRateLimit(t *testing.T) {
+	p, ok := Builtins.Get(RateLimit)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{RateLimit: map[string]interface{}{
+		"rate":            1,
+		"burst":           2,
+		"key":             "${metadata.clientId}",
+		"remainingHeader": "RateLimit-Remaining",
+	}})
+	router.SetId("testRateLimit")
+
+	newExchange := func(clientId string) *endpoint.Exchange {
+		exchange := newTestExchange("", nil)
+		exchange.In.GetMsg().Metadata.PutValue("clientId", clientId)
+		return exchange
+	}
+	exchange := newExchange("c1")
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "2", exchange.Out.Headers().Get(defaultLimitHeader))
+	assert.Equal(t, "1", exchange.Out.Headers().Get("RateLimit-Remaining"))
+
+	exchange = newExchange("c1")
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "0", exchange.Out.Headers().Get("RateLimit-Remaining"))
+
+	exchange = newExchange("c1")
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 429, exchange.Out.(*testMessage).statusCode)
+	assert.Equal(t, "1", exchange.Out.Headers().Get("Retry-After"))
+
+	//不同key使用不同的令牌桶
+	exchange = newExchange("c2")
+	assert.True(t, p(router, exchange))
+
+	//令牌桶数量有上限，淘汰最近最少使用的令牌桶，包括没有恢复满额的令牌桶
+	limiter, err := getRateLimiter(router)
+	assert.Nil(t, err)
+	now := time.Now()
+	for i := 0; i < maxTokenBuckets; i++ {
+		limiter.take("k"+strconv.Itoa(i), now)
+	}
+	assert.Equal(t, maxTokenBuckets, len(limiter.buckets))
+	_, ok = limiter.buckets["c1"]
+	assert.False(t, ok)
+	_, ok = limiter.buckets["k0"]
+	assert.True(t, ok)
+}
+
+func TestFieldMapping(t *testing.T) {
+	p, ok := Builtins.Get(FieldMapping)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{FieldMapping: map[string]interface{}{
+		"mapping":  map[string]interface{}{"temp": "temperature", "dev": "deviceId"},
+		"required": []string{"temperature", "deviceId"},
+	}})
+
+	exchange := newTestExchange(`{"temp":41,"other":1}`, nil)
+	exchange.In.(*testMessage).params["dev"] = "d1"
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"deviceId":"d1","other":1,"temperature":41}`, exchange.In.GetMsg().Data)
+
+	//已经是约定字段
+	exchange = newTestExchange(`{"temperature":41,"deviceId":"d1"}`, nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"deviceId":"d1","temperature":41}`, exchange.In.GetMsg().Data)
+
+	//缺少必填字段
+	exchange = newTestExchange(`{"temp":41}`, nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 400, exchange.Out.(*testMessage).statusCode)
+	assert.Equal(t, "missing required fields: deviceId", exchange.Out.GetError().Error())
+}
+
+func TestJwtClaims(t *testing.T) {
+	p, ok := Builtins.Get(JwtClaims)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{JwtClaims: map[string]interface{}{
+		"claims":    map[string]interface{}{"tenant": "tenantId", "role": "role"},
+		"statusKey": "jwtStatus",
+	}})
+	//{"alg":"HS256","typ":"JWT"}.{"tenant":"t1","role":"admin","exp":1700000000}
+	token := "eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9.eyJ0ZW5hbnQiOiJ0MSIsInJvbGUiOiJhZG1pbiIsImV4cCI6MTcwMDAwMDAwMH0.sig"
+	exchange := newTestExchange("", map[string]string{"Authorization": "Bearer " + token})
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "t1", exchange.In.GetMsg().Metadata.GetValue("tenantId"))
+	assert.Equal(t, "admin", exchange.In.GetMsg().Metadata.GetValue("role"))
+	assert.False(t, exchange.In.GetMsg().Metadata.Has("exp"))
+	assert.Equal(t, JwtClaimsStatusOk, exchange.In.GetMsg().Metadata.GetValue("jwtStatus"))
+
+	exchange = newTestExchange("", map[string]string{"Authorization": "Bearer xx"})
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, JwtClaimsStatusNone, exchange.In.GetMsg().Metadata.GetValue("jwtStatus"))
+
+	router = newTestRouter(types.Configuration{JwtClaims: map[string]interface{}{"onMalformed": "reject"}})
+	exchange = newTestExchange("", nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 401, exchange.Out.(*testMessage).statusCode)
+}
+
+func TestPassthroughResponse(t *testing.T) {
+	p, ok := Builtins.Get(PassthroughResponse)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{PassthroughResponse: map[string]interface{}{
+		"headers":      []string{"X-Trace-Id"},
+		"headerPrefix": "header.",
+	}})
+	exchange := newTestExchange("", nil)
+	metadata := types.NewMetadata()
+	metadata.PutValue("statusCode", "201")
+	metadata.PutValue("X-Trace-Id", "t1")
+	metadata.PutValue("header.X-Request-Id", "r1")
+	msg := types.NewMsg(0, "TEST", types.JSON, metadata, `{"id":1}`)
+	exchange.Out.SetMsg(&msg)
+	assert.True(t, p(router, exchange))
+	out := exchange.Out.(*testMessage)
+	assert.Equal(t, 201, out.statusCode)
+	assert.Equal(t, `{"id":1}`, string(out.Body()))
+	assert.Equal(t, "t1", out.Headers().Get("X-Trace-Id"))
+	assert.Equal(t, "r1", out.Headers().Get("X-Request-Id"))
+	assert.Equal(t, "application/json", out.Headers().Get("Content-Type"))
+}
+
+// tlsTestMessage 带TLS连接状态的测试消息
+type tlsTestMessage struct {
+	*testMessage
+	state *tls.ConnectionState
+}
+
+func (m *tlsTestMessage) TLSConnectionState() *tls.ConnectionState {
+	return m.state
+}
+
+func TestClientCertAuth(t *testing.T) {
+	p, ok := Builtins.Get(ClientCertAuth)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{ClientCertAuth: map[string]interface{}{
+		"allowedNames": []string{"svc-a", "svc-b.mesh.local"},
+		"identityKey":  "clientId",
+	}})
+	newExchange := func(cert *x509.Certificate) *endpoint.Exchange {
+		in := &tlsTestMessage{testMessage: newTestMessage("", nil), state: &tls.ConnectionState{}}
+		if cert != nil {
+			in.state.VerifiedChains = [][]*x509.Certificate{{cert}}
+		}
+		return &endpoint.Exchange{In: in, Out: newTestMessage("", nil)}
+	}
+
+	exchange := newExchange(&x509.Certificate{Subject: pkix.Name{CommonName: "svc-a"}})
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "svc-a", exchange.In.GetMsg().Metadata.GetValue("clientId"))
+
+	exchange = newExchange(&x509.Certificate{Subject: pkix.Name{CommonName: "x"}, DNSNames: []string{"svc-b.mesh.local"}})
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "svc-b.mesh.local", exchange.In.GetMsg().Metadata.GetValue("clientId"))
+
+	exchange = newExchange(&x509.Certificate{Subject: pkix.Name{CommonName: "svc-c"}})
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 403, exchange.Out.(*testMessage).statusCode)
+
+	//没有客户端证书
+	exchange = newExchange(nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 403, exchange.Out.(*testMessage).statusCode)
+
+	//非TLS消息
+	exchange = newTestExchange("", nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 403, exchange.Out.(*testMessage).statusCode)
+}
+
+func TestPagination(t *testing.T) {
+	p, ok := Builtins.Get(Pagination)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{Pagination: map[string]interface{}{
+		"defaultSize": 10,
+		"maxSize":     50,
+	}})
+	//默认值
+	exchange := newTestExchange("", nil)
+	assert.True(t, p(router, exchange))
+	metadata := exchange.In.GetMsg().Metadata
+	assert.Equal(t, "1", metadata.GetValue(PaginationPageKey))
+	assert.Equal(t, "10", metadata.GetValue(PaginationSizeKey))
+	assert.Equal(t, "0", metadata.GetValue(PaginationOffsetKey))
+
+	//截断到有效范围
+	exchange = newTestExchange("", nil)
+	exchange.In.(*testMessage).params["page"] = "3"
+	exchange.In.(*testMessage).params["size"] = "500"
+	assert.True(t, p(router, exchange))
+	metadata = exchange.In.GetMsg().Metadata
+	assert.Equal(t, "3", metadata.GetValue(PaginationPageKey))
+	assert.Equal(t, "50", metadata.GetValue(PaginationSizeKey))
+	assert.Equal(t, "100", metadata.GetValue(PaginationOffsetKey))
+
+	//游标分页
+	exchange = newTestExchange("", nil)
+	exchange.In.(*testMessage).params["cursor"] = "abc"
+	assert.True(t, p(router, exchange))
+	metadata = exchange.In.GetMsg().Metadata
+	assert.Equal(t, "abc", metadata.GetValue(PaginationCursorKey))
+	assert.False(t, metadata.Has(PaginationPageKey))
+
+	//严格模式
+	router = newTestRouter(types.Configuration{Pagination: map[string]interface{}{"strict": true}})
+	exchange = newTestExchange("", nil)
+	exchange.In.(*testMessage).params["page"] = "x"
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 400, exchange.Out.(*testMessage).statusCode)
+	assert.Equal(t, "invalid page: x is not a number", exchange.Out.GetError().Error())
+}
+
+func TestSingleflight(t *testing.T) {
+	p, ok := Builtins.Get(Singleflight)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{Singleflight: map[string]interface{}{
+		"headers": []string{"X-Tenant"},
+	}})
+	leader := newTestExchange("", map[string]string{"X-Tenant": "t1"})
+	assert.True(t, p(router, leader))
+
+	//不同指纹的请求不合并
+	other := newTestExchange("", map[string]string{"X-Tenant": "t2"})
+	assert.True(t, p(router, other))
+
+	var wg sync.WaitGroup
+	var results = make([]bool, 3)
+	var waiters = make([]*endpoint.Exchange, 3)
+	for i := 0; i < 3; i++ {
+		waiters[i] = newTestExchange("", map[string]string{"X-Tenant": "t1"})
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			results[index] = p(router, waiters[index])
+		}(i)
+	}
+	time.Sleep(time.Millisecond * 100)
+	//leader规则链执行结束
+	msg := types.NewMsg(0, "TEST", types.JSON, types.NewMetadata(), `{"result":1}`)
+	leader.Out.SetMsg(&msg)
+	assert.True(t, p(router, leader))
+	wg.Wait()
+	for i := 0; i < 3; i++ {
+		assert.False(t, results[i])
+		assert.Equal(t, `{"result":1}`, waiters[i].Out.GetMsg().Data)
+	}
+	//leader结束后新的请求重新执行
+	assert.True(t, p(router, newTestExchange("", map[string]string{"X-Tenant": "t1"})))
+}
+
+func TestReplayGuard(t *testing.T) {
+	p, ok := Builtins.Get(ReplayGuard)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{ReplayGuard: map[string]interface{}{"skew": 60}})
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	exchange := newTestExchange("", map[string]string{"X-Timestamp": now, "X-Nonce": "n1"})
+	assert.True(t, p(router, exchange))
+
+	//重放
+	exchange = newTestExchange("", map[string]string{"X-Timestamp": now, "X-Nonce": "n1"})
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 401, exchange.Out.(*testMessage).statusCode)
+	assert.Equal(t, "nonce has already been used", exchange.Out.GetError().Error())
+
+	//毫秒时间戳
+	exchange = newTestExchange("", map[string]string{"X-Timestamp": strconv.FormatInt(time.Now().UnixMilli(), 10), "X-Nonce": "n2"})
+	assert.True(t, p(router, exchange))
+
+	//过期
+	expired := strconv.FormatInt(time.Now().Add(-time.Minute*2).Unix(), 10)
+	exchange = newTestExchange("", map[string]string{"X-Timestamp": expired, "X-Nonce": "n3"})
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, "request timestamp expired", exchange.Out.GetError().Error())
+
+	//缺少请求头
+	exchange = newTestExchange("", nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 401, exchange.Out.(*testMessage).statusCode)
+
+	//随机数数量达到上限时淘汰最早过期的随机数，不拒绝新的请求
+	store := &memoryNonceStore{nonces: make(map[string]*nonceEntry), maxNonces: 2}
+	ok, err := store.Add("a", time.Minute)
+	assert.True(t, ok)
+	assert.Nil(t, err)
+	ok, _ = store.Add("b", time.Minute*2)
+	assert.True(t, ok)
+	ok, _ = store.Add("c", time.Minute*3)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(store.nonces))
+	_, ok = store.nonces["a"]
+	assert.False(t, ok)
+	ok, _ = store.Add("b", time.Minute)
+	assert.False(t, ok)
+	//过期的随机数可以再次使用
+	ok, _ = store.Add("d", -time.Second)
+	assert.True(t, ok)
+	ok, _ = store.Add("d", time.Minute)
+	assert.True(t, ok)
+
+	//随机数存储故障响应500
+	RegisterNonceStore("testFailedStore", failedNonceStore{})
+	router = newTestRouter(types.Configuration{ReplayGuard: map[string]interface{}{"store": "testFailedStore"}})
+	exchange = newTestExchange("", map[string]string{"X-Timestamp": now, "X-Nonce": "n1"})
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 500, exchange.Out.(*testMessage).statusCode)
+}
+
+// failedNonceStore 总是返回错误的随机数存储
+type failedNonceStore struct {
+}
+
+func (s failedNonceStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func TestAggregateResponse(t *testing.T) {
+	p, ok := Builtins.Get(AggregateResponse)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{AggregateResponse: map[string]interface{}{
+		"branches": []string{"user", "orders"},
+	}})
+	exchange := newTestExchange("", nil)
+	userMsg := types.NewMsg(0, "TEST", types.JSON, types.BuildMetadata(map[string]string{"branch": "user"}), `{"name":"lala"}`)
+	exchange.Out.SetMsg(&userMsg)
+	assert.False(t, p(router, exchange))
+
+	ordersMsg := types.NewMsg(0, "TEST", types.JSON, types.BuildMetadata(map[string]string{"branch": "orders"}), `{}`)
+	exchange.Out.SetMsg(&ordersMsg)
+	exchange.Out.SetError(errors.New("not found"))
+	assert.True(t, p(router, exchange))
+	assert.Nil(t, exchange.Out.GetError())
+	assert.Equal(t, `{"orders":{"error":"not found"},"user":{"name":"lala"}}`, exchange.Out.GetMsg().Data)
+	assert.Equal(t, types.JSON, exchange.Out.GetMsg().DataType)
+
+	//已经输出聚合结果，忽略之后结束的分支
+	assert.False(t, p(router, exchange))
+
+	//等待超时，超时后结束的分支输出聚合结果，忽略该分支的结果
+	router = newTestRouter(types.Configuration{AggregateResponse: map[string]interface{}{
+		"branches": []string{"user", "orders"},
+		"timeout":  1,
+	}})
+	exchange = newTestExchange("", nil)
+	exchange.Out.SetMsg(&userMsg)
+	assert.False(t, p(router, exchange))
+	time.Sleep(time.Millisecond * 1100)
+	ordersMsg = types.NewMsg(0, "TEST", types.JSON, types.BuildMetadata(map[string]string{"branch": "orders"}), `{"id":1}`)
+	exchange.Out.SetMsg(&ordersMsg)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"orders":{"error":"timeout"},"user":{"name":"lala"}}`, exchange.Out.GetMsg().Data)
+
+	//同步执行时，规则链执行完成后输出已经收集的结果，并执行后续的处理器
+	router = newTestRouter(types.Configuration{AggregateResponse: map[string]interface{}{
+		"branches": []string{"user", "orders"},
+	}})
+	var responses []string
+	router.From("/api/v1/test").To("chain:test").Wait().Process(p).Process(func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		responses = append(responses, exchange.Out.GetMsg().Data)
+		return true
+	})
+	exchange = newTestExchange("", nil)
+	exchange.Out.SetMsg(&userMsg)
+	assert.False(t, p(router, exchange))
+	exchange.Done()
+	assert.Equal(t, []string{`{"orders":{"error":"timeout"},"user":{"name":"lala"}}`}, responses)
+	//已经输出聚合结果，不再输出
+	assert.False(t, p(router, exchange))
+
+	//异步执行时，分支没有全部结束也会在超时后清理
+	router = newTestRouter(types.Configuration{AggregateResponse: map[string]interface{}{
+		"branches": []string{"user", "orders"},
+		"timeout":  1,
+	}})
+	exchange = newTestExchange("", nil)
+	exchange.Out.SetMsg(&userMsg)
+	assert.False(t, p(router, exchange))
+	time.Sleep(time.Millisecond * 2100)
+	aggregations.lock.Lock()
+	_, ok = aggregations.items[exchange]
+	aggregations.lock.Unlock()
+	assert.False(t, ok)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	p, ok := Builtins.Get(ConcurrencyLimit)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{ConcurrencyLimit: map[string]interface{}{
+		"max":     2,
+		"timeout": 100,
+	}})
+	exchange1 := newTestExchange("", nil)
+	exchange2 := newTestExchange("", nil)
+	assert.True(t, p(router, exchange1))
+	assert.True(t, p(router, exchange2))
+
+	//并发已满，等待超时
+	exchange3 := newTestExchange("", nil)
+	assert.False(t, p(router, exchange3))
+	assert.Equal(t, 429, exchange3.Out.(*testMessage).statusCode)
+
+	//排队等待释放的槽位
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		exchange1.Done()
+	}()
+	exchange3 = newTestExchange("", nil)
+	assert.True(t, p(router, exchange3))
+	exchange2.Done()
+	exchange3.Done()
+
+	//槽位全部释放
+	router = newTestRouter(types.Configuration{ConcurrencyLimit: map[string]interface{}{"max": 1, "statusCode": 503}})
+	exchange1 = newTestExchange("", nil)
+	assert.True(t, p(router, exchange1))
+	exchange2 = newTestExchange("", nil)
+	assert.False(t, p(router, exchange2))
+	assert.Equal(t, 503, exchange2.Out.(*testMessage).statusCode)
+	exchange1.Done()
+	assert.True(t, p(router, exchange2))
+}
+
+func TestRelationToStatus(t *testing.T) {
+	p, ok := Builtins.Get(RelationToStatus)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{RelationToStatus: map[string]interface{}{
+		"mapping":              map[string]interface{}{"NotFound": 404, "Conflict": 409},
+		"defaultFailureStatus": 503,
+	}})
+	newOutExchange := func(relationType string, err error) *endpoint.Exchange {
+		exchange := newTestExchange("", nil)
+		msg := types.NewMsg(0, "TEST", types.JSON, types.BuildMetadata(map[string]string{endpoint.RelationTypeKey: relationType}), "{}")
+		exchange.Out.SetMsg(&msg)
+		exchange.Out.SetError(err)
+		return exchange
+	}
+	exchange := newOutExchange("NotFound", nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, 404, exchange.Out.(*testMessage).statusCode)
+
+	exchange = newOutExchange(types.Success, nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, 200, exchange.Out.(*testMessage).statusCode)
+
+	exchange = newOutExchange(types.Failure, errors.New("error"))
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, 503, exchange.Out.(*testMessage).statusCode)
+}
+
+func TestJsonLimits(t *testing.T) {
+	p, ok := Builtins.Get(JsonLimits)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{JsonLimits: map[string]interface{}{
+		"maxDepth":    3,
+		"maxElements": 6,
+	}})
+	exchange := newTestExchange(`{"a":{"b":[1,2]},"c":"x"}`, nil)
+	assert.True(t, p(router, exchange))
+	//空请求体不校验
+	assert.True(t, p(router, newTestExchange("", nil)))
+
+	exchange = newTestExchange(`{"a":{"b":[{"c":1}]}}`, nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 400, exchange.Out.(*testMessage).statusCode)
+	assert.Equal(t, "JSON body exceeds the maximum depth 3", exchange.Out.GetError().Error())
+
+	exchange = newTestExchange(`[1,2,3,{"a":1,"b":2,"c":3}]`, nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, "JSON body exceeds the maximum number of elements 6", exchange.Out.GetError().Error())
+
+	exchange = newTestExchange(`{"a":[1,2`, nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 400, exchange.Out.(*testMessage).statusCode)
+}
+
+func TestAccessLog(t *testing.T) {
+	p, ok := Builtins.Get(AccessLog)
+	assert.True(t, ok)
+	var buf bytes.Buffer
+	accessLogWriter = &buf
+	defer func() {
+		accessLogWriter = os.Stdout
+	}()
+
+	router := newTestRouter(types.Configuration{AccessLog: map[string]interface{}{
+		"fields": []string{AccessLogFieldPath, AccessLogFieldStatus, AccessLogFieldBytes, AccessLogFieldCorrelationId, AccessLogFieldError},
+	}})
+	exchange := newTestExchange("", map[string]string{"X-Request-Id": "req-1"})
+	assert.True(t, p(router, exchange))
+	//处理结束前不输出
+	assert.Equal(t, 0, buf.Len())
+	exchange.Out.SetBody([]byte("hello"))
+	exchange.Done()
+	assert.Equal(t, `{"bytes":5,"correlationId":"req-1","path":"/api/v1/test","status":200}`+"\n", buf.String())
+	//不修改响应
+	assert.Equal(t, 0, exchange.Out.(*testMessage).statusCode)
+
+	//没有关联ID请求头使用消息ID
+	buf.Reset()
+	exchange = newTestExchange("", nil)
+	assert.True(t, p(router, exchange))
+	exchange.Out.SetError(errors.New("error"))
+	exchange.Done()
+	var entry map[string]interface{}
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, exchange.In.GetMsg().Id, entry[AccessLogFieldCorrelationId])
+	assert.Equal(t, float64(500), entry[AccessLogFieldStatus])
+	assert.Equal(t, "error", entry[AccessLogFieldError])
+
+	//默认输出全部字段
+	buf.Reset()
+	exchange = newTestExchange("", nil)
+	assert.True(t, p(newTestRouter(types.Configuration{AccessLog: map[string]interface{}{}}), exchange))
+	exchange.Done()
+	entry = nil
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &entry))
+	for _, field := range []string{AccessLogFieldTime, AccessLogFieldPath, AccessLogFieldStatus, AccessLogFieldLatency, AccessLogFieldBytes} {
+		_, ok = entry[field]
+		assert.True(t, ok)
+	}
+
+	exchange = newTestExchange("", nil)
+	assert.False(t, p(newTestRouter(types.Configuration{AccessLog: map[string]interface{}{"sink": "file"}}), exchange))
+	assert.Equal(t, 500, exchange.Out.(*testMessage).statusCode)
+}
+
+func TestContentNegotiation(t *testing.T) {
+	p, ok := Builtins.Get(ContentNegotiation)
+	assert.True(t, ok)
+	newOutExchange := func(accept string, dataType types.DataType, data string) *endpoint.Exchange {
+		exchange := newTestExchange("", map[string]string{"Accept": accept})
+		msg := types.NewMsg(0, "TEST", dataType, types.NewMetadata(), data)
+		exchange.Out.SetMsg(&msg)
+		return exchange
+	}
+	router := newTestRouter(nil)
+	for _, accept := range []string{"", "*/*", "application/json", "application/*;q=0.5, text/html", "text/html, application/xml;q=0.9, */*;q=0.1"} {
+		exchange := newOutExchange(accept, types.JSON, `{"name":"lala"}`)
+		assert.True(t, p(router, exchange))
+		assert.Equal(t, `{"name":"lala"}`, exchange.Out.GetMsg().Data)
+	}
+
+	//转换成XML
+	exchange := newOutExchange("application/xml, application/json;q=0", types.JSON, `{"name":"lala","tags":["a","b"],"age":18,"info":{"x":null}}`)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `<root><age>18</age><info><x></x></info><name>lala</name><tags>a</tags><tags>b</tags></root>`, exchange.Out.GetMsg().Data)
+	assert.Equal(t, MimeTypeXml, exchange.Out.Headers().Get("Content-Type"))
+	assert.Equal(t, types.TEXT, exchange.Out.GetMsg().DataType)
+
+	exchange = newOutExchange("text/html, application/xml;q=0.5", types.JSON, `[1,2]`)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `<root><item>1</item><item>2</item></root>`, exchange.Out.GetMsg().Data)
+
+	//没有可用的转换器
+	exchange = newOutExchange("application/xml", types.TEXT, "hello")
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 406, exchange.Out.(*testMessage).statusCode)
+
+	//不转换
+	exchange = newOutExchange("application/xml", types.JSON, `{"name":"lala"}`)
+	assert.False(t, p(newTestRouter(types.Configuration{ContentNegotiation: map[string]interface{}{"convert": false}}), exchange))
+	assert.Equal(t, 406, exchange.Out.(*testMessage).statusCode)
+
+	//规则链执行失败不处理
+	exchange = newOutExchange("application/xml", types.TEXT, "hello")
+	exchange.Out.SetError(errors.New("error"))
+	assert.True(t, p(router, exchange))
+}
+
+func TestResponseCache(t *testing.T) {
+	p, ok := Builtins.Get(ResponseCache)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{ResponseCache: map[string]interface{}{
+		"maxEntries": 2,
+		"headers":    []string{"X-Key"},
+	}})
+	request := func(key string, headers map[string]string, body string) *endpoint.Exchange {
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		headers["X-Key"] = key
+		exchange := newTestExchange("", headers)
+		if p(router, exchange) {
+			//规则链输出响应
+			exchange.Out.Headers().Set("Content-Type", "text/plain")
+			exchange.Out.SetBody([]byte(body))
+		}
+		exchange.Done()
+		return exchange
+	}
+	exchange := request("a", nil, "a1")
+	assert.Equal(t, 0, exchange.Out.(*testMessage).statusCode)
+	//命中缓存
+	exchange = request("a", nil, "a2")
+	assert.Equal(t, "a1", string(exchange.Out.Body()))
+	assert.Equal(t, 200, exchange.Out.(*testMessage).statusCode)
+	assert.Equal(t, "text/plain", exchange.Out.Headers().Get("Content-Type"))
+
+	//no-cache 重新执行并更新缓存，no-store 不读取也不保存
+	exchange = request("a", map[string]string{"Cache-Control": "no-cache"}, "a3")
+	assert.Equal(t, "a3", string(exchange.Out.Body()))
+	exchange = request("a", map[string]string{"Cache-Control": "no-store"}, "a4")
+	assert.Equal(t, "a4", string(exchange.Out.Body()))
+	assert.Equal(t, "a3", string(request("a", nil, "a5").Out.Body()))
+
+	//错误不缓存
+	exchange = newTestExchange("", map[string]string{"X-Key": "b"})
+	assert.True(t, p(router, exchange))
+	exchange.Out.SetError(errors.New("error"))
+	exchange.Out.SetBody([]byte("error"))
+	exchange.Done()
+	assert.Equal(t, "b1", string(request("b", nil, "b1").Out.Body()))
+
+	//淘汰最近最少使用的缓存
+	assert.Equal(t, "a3", string(request("a", nil, "a6").Out.Body()))
+	request("c", nil, "c1")
+	assert.Equal(t, "b2", string(request("b", nil, "b2").Out.Body()))
+	assert.Equal(t, "c1", string(request("c", nil, "c2").Out.Body()))
+
+	//过期
+	cache, err := getResponseCache(router)
+	assert.Nil(t, err)
+	_, ok = cache.get(responseCacheKey(router, newTestExchange("", map[string]string{"X-Key": "c"}), []string{"X-Key"}), time.Now().Add(time.Minute*2))
+	assert.False(t, ok)
+	assert.Equal(t, "c3", string(request("c", nil, "c3").Out.Body()))
+}
+
+func TestSequenceGuard(t *testing.T) {
+	p, ok := Builtins.Get(SequenceGuard)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{SequenceGuard: map[string]interface{}{
+		"keyHeader": "X-Device-Id",
+		"maxKeys":   2,
+	}})
+	send := func(key string, seq int) *endpoint.Exchange {
+		return newTestExchange("", map[string]string{"X-Device-Id": key, "X-Sequence": strconv.Itoa(seq)})
+	}
+	for i, seq := range []int{1, 2, 5} {
+		exchange := send("d1", seq)
+		assert.True(t, p(router, exchange))
+		expected := SequenceInOrder
+		if i == 2 {
+			expected = SequenceGap
+		}
+		assert.Equal(t, expected, exchange.In.GetMsg().Metadata.GetValue(SequenceStatusKey))
+	}
+	exchange := send("d1", 5)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 409, exchange.Out.(*testMessage).statusCode)
+	assert.Equal(t, "Duplicate sequence 5 for key d1", exchange.Out.GetError().Error())
+	exchange = send("d1", 3)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, "OutOfOrder sequence 3 for key d1", exchange.Out.GetError().Error())
+	exchange = newTestExchange("", map[string]string{"X-Device-Id": "d1", "X-Sequence": "a"})
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 400, exchange.Out.(*testMessage).statusCode)
+
+	//淘汰最近最少使用的key
+	assert.True(t, p(router, send("d2", 1)))
+	assert.True(t, p(router, send("d3", 1)))
+	exchange = send("d1", 1)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, SequenceInOrder, exchange.In.GetMsg().Metadata.GetValue(SequenceStatusKey))
+
+	//从请求体获取，交给规则链处理
+	router = newTestRouter(types.Configuration{SequenceGuard: map[string]interface{}{
+		"keyField":      "device.id",
+		"sequenceField": "seq",
+		"drop":          false,
+	}})
+	assert.True(t, p(router, newTestExchange(`{"device":{"id":"d1"},"seq":9007199254740993}`, nil)))
+	exchange = newTestExchange(`{"device":{"id":"d1"},"seq":9007199254740992}`, nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, SequenceOutOfOrder, exchange.In.GetMsg().Metadata.GetValue(SequenceStatusKey))
+	exchange = newTestExchange(`{"seq":1}`, nil)
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, "missing sequence key", exchange.Out.GetError().Error())
+}
+
+func TestSlidingWindowLimit(t *testing.T) {
+	p, ok := Builtins.Get(SlidingWindowLimit)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{SlidingWindowLimit: map[string]interface{}{
+		"limit":  2,
+		"window": 60,
+		"key":    "${metadata.clientId}",
+	}})
+	newExchange := func(clientId string) *endpoint.Exchange {
+		exchange := newTestExchange("", nil)
+		exchange.In.GetMsg().Metadata.PutValue("clientId", clientId)
+		return exchange
+	}
+	exchange := newExchange("c1")
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "2", exchange.Out.Headers().Get(defaultLimitHeader))
+	assert.Equal(t, "1", exchange.Out.Headers().Get(defaultRemainingHeader))
+	assert.True(t, p(router, newExchange("c1")))
+	exchange = newExchange("c1")
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 429, exchange.Out.(*testMessage).statusCode)
+	assert.True(t, exchange.Out.Headers().Get("Retry-After") != "")
+	//不同key使用不同的窗口
+	assert.True(t, p(router, newExchange("c2")))
+
+	//不允许突发：任意60秒窗口内最多2个请求
+	limiter, err := getWindowLimiter(router)
+	assert.Nil(t, err)
+	start := time.Unix(0, 0).Add(time.Second * 600)
+	allowed, _, _ := limiter.take("k", start)
+	assert.True(t, allowed)
+	allowed, _, _ = limiter.take("k", start.Add(time.Second*30))
+	assert.True(t, allowed)
+	allowed, remaining, reset := limiter.take("k", start.Add(time.Second*59))
+	assert.False(t, allowed)
+	assert.Equal(t, 0, remaining)
+	assert.Equal(t, time.Second*7, reset)
+	//第一个请求所在的计数桶移出窗口后恢复
+	allowed, _, _ = limiter.take("k", start.Add(time.Second*66))
+	assert.True(t, allowed)
+	allowed, _, _ = limiter.take("k", start.Add(time.Second*80))
+	assert.False(t, allowed)
+	allowed, _, _ = limiter.take("k", start.Add(time.Second*96))
+	assert.True(t, allowed)
+
+	//窗口计数数量有上限，淘汰最近最少使用的窗口计数，包括仍然有请求的窗口计数
+	for i := 0; i < maxWindowCounters; i++ {
+		limiter.take("k"+strconv.Itoa(i), start)
+	}
+	assert.Equal(t, maxWindowCounters, len(limiter.counters))
+	assert.Equal(t, maxWindowCounters, limiter.order.Len())
+	_, ok = limiter.counters["c1"]
+	assert.False(t, ok)
+	_, ok = limiter.counters["k0"]
+	assert.True(t, ok)
+}
+
+func TestDefaultHeaders(t *testing.T) {
+	p, ok := Builtins.Get(DefaultHeaders)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{DefaultHeaders: map[string]interface{}{
+		"headers": map[string]string{"content-type": "application/json", "Accept": "application/json", "X-Version": "v1"},
+	}})
+	exchange := newTestExchange("", map[string]string{"Content-Type": "text/plain"})
+	exchange.In.Headers()["X-Version"] = []string{""}
+	assert.True(t, p(router, exchange))
+	//不覆盖客户端提供的请求头
+	assert.Equal(t, "text/plain", exchange.In.Headers().Get("Content-Type"))
+	assert.Equal(t, "", exchange.In.Headers().Get("X-Version"))
+	assert.Equal(t, "application/json", exchange.In.Headers().Get("Accept"))
+
+	exchange = newTestExchange("", nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "application/json", exchange.In.Headers().Get("Content-Type"))
+	assert.True(t, p(newTestRouter(nil), newTestExchange("", nil)))
+}
+
+func TestCharsetConvert(t *testing.T) {
+	p, ok := Builtins.Get(CharsetConvert)
+	assert.True(t, ok)
+	gbk := string([]byte{0xc4, 0xe3, 0xba, 0xc3})
+	//根据Content-Type的charset参数转换
+	exchange := newTestExchange(gbk, map[string]string{"Content-Type": "text/plain; charset=GBK"})
+	assert.True(t, p(newTestRouter(nil), exchange))
+	assert.Equal(t, "你好", string(exchange.In.Body()))
+	assert.Equal(t, "你好", exchange.In.GetMsg().Data)
+	assert.Equal(t, "text/plain; charset=utf-8", exchange.In.Headers().Get("Content-Type"))
+
+	//没有charset参数，不是合法UTF-8时使用配置的字符集
+	router := newTestRouter(types.Configuration{CharsetConvert: map[string]interface{}{"charset": "ISO-8859-1"}})
+	exchange = newTestExchange(string([]byte{'c', 'a', 'f', 0xe9}), nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "café", string(exchange.In.Body()))
+	exchange = newTestExchange("café", nil)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, "café", string(exchange.In.Body()))
+
+	exchange = newTestExchange("a", map[string]string{"Content-Type": "text/plain; charset=unknown"})
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, 415, exchange.Out.(*testMessage).statusCode)
+
+	//响应转换
+	p, ok = Builtins.Get(CharsetConvertOut)
+	assert.True(t, ok)
+	newOutExchange := func(headers map[string]string) *endpoint.Exchange {
+		exchange := newTestExchange("", headers)
+		msg := types.NewMsg(0, "TEST", types.JSON, types.NewMetadata(), `{"name":"你好"}`)
+		exchange.Out.SetMsg(&msg)
+		return exchange
+	}
+	exchange = newOutExchange(map[string]string{"Accept-Charset": "utf-8;q=0.5, gbk"})
+	assert.True(t, p(newTestRouter(nil), exchange))
+	assert.Equal(t, `{"name":"`+gbk+`"}`, exchange.Out.GetMsg().Data)
+	assert.Equal(t, "application/json; charset=gbk", exchange.Out.Headers().Get("Content-Type"))
+
+	exchange = newOutExchange(map[string]string{"Accept-Charset": "utf-8"})
+	assert.True(t, p(newTestRouter(nil), exchange))
+	assert.Equal(t, `{"name":"你好"}`, exchange.Out.GetMsg().Data)
+
+	exchange = newOutExchange(nil)
+	assert.True(t, p(newTestRouter(types.Configuration{CharsetConvertOut: map[string]interface{}{"charset": "ISO-8859-1"}}), exchange))
+	//无法表示的字符替换为SUB替换字符
+	assert.Equal(t, "{\"name\":\"\x1a\x1a\"}", exchange.Out.GetMsg().Data)
+	assert.Equal(t, "application/json; charset=windows-1252", exchange.Out.Headers().Get("Content-Type"))
+}
+
+// httpTestMessage 带HTTP请求的测试消息
+type httpTestMessage struct {
+	*testMessage
+	request *http.Request
+}
+
+func (m *httpTestMessage) Request() *http.Request {
+	return m.request
+}
+
+func TestMaxQueryParams(t *testing.T) {
+	p, ok := Builtins.Get(MaxQueryParams)
+	assert.True(t, ok)
+	router := newTestRouter(types.Configuration{MaxQueryParams: map[string]interface{}{"max": 3}})
+	newExchange := func(target string) *endpoint.Exchange {
+		in := &httpTestMessage{testMessage: newTestMessage("", nil), request: httptest.NewRequest(http.MethodGet, target, nil)}
+		return &endpoint.Exchange{In: in, Out: newTestMessage("", nil)}
+	}
+
+	exchange := newExchange("/api?a=1&b=2&c=3")
+	assert.True(t, p(router, exchange))
+	//空参数段不计数
+	exchange = newExchange("/api?a=1&&b=2&c=3&")
+	assert.True(t, p(router, exchange))
+	//重复的key分别计数
+	exchange = newExchange("/api?a=1&a=2&a=3&a=4")
+	assert.False(t, p(router, exchange))
+	assert.Equal(t, http.StatusBadRequest, exchange.Out.(*testMessage).statusCode)
+	//默认最大100
+	exchange = newExchange("/api?" + strings.Repeat("a=1&", 101))
+	assert.False(t, p(newTestRouter(nil), exchange))
+	//非HTTP请求不校验
+	assert.True(t, p(router, newTestExchange("", nil)))
+}
+
+func TestOutputMapping(t *testing.T) {
+	p, ok := Builtins.Get(OutputMapping)
+	assert.True(t, ok)
+	config := map[string]interface{}{
+		"mappings": map[string]interface{}{
+			"v1": map[string]interface{}{"temperature": "temp", "deviceId": "dev"},
+		},
+	}
+	router := newTestRouter(types.Configuration{OutputMapping: config})
+	newExchange := func(version, data string) *endpoint.Exchange {
+		exchange := newTestExchange("", map[string]string{"X-Client-Version": version})
+		msg := types.NewMsg(0, "test", types.JSON, types.NewMetadata(), data)
+		exchange.Out.SetMsg(&msg)
+		return exchange
+	}
+
+	exchange := newExchange("v1", `{"temperature":41,"deviceId":"d1","other":1}`)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"dev":"d1","other":1,"temp":41}`, exchange.Out.GetMsg().Data)
+
+	//没有对应版本的映射不处理
+	exchange = newExchange("v2", `{"temperature":41}`)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `{"temperature":41}`, exchange.Out.GetMsg().Data)
+
+	//不是JSON对象原样输出
+	exchange = newExchange("v1", `[1,2]`)
+	assert.True(t, p(router, exchange))
+	assert.Equal(t, `[1,2]`, exchange.Out.GetMsg().Data)
+
+	//删除没有映射的字段
+	config["dropUnmapped"] = true
+	exchange = newExchange("v1", `{"temperature":41,"other":1}`)
+	assert.True(t, p(newTestRouter(types.Configuration{OutputMapping: config}), exchange))
+	assert.Equal(t, `{"temp":41}`, exchange.Out.GetMsg().Data)
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"container/list"
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/str"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit 限流处理器名称
+const RateLimit = "rateLimit"
+
+const (
+	// defaultLimitHeader 默认限流总数响应头
+	defaultLimitHeader = "X-RateLimit-Limit"
+	// defaultRemainingHeader 默认剩余请求数响应头
+	defaultRemainingHeader = "X-RateLimit-Remaining"
+	// defaultResetHeader 默认恢复到满额所需秒数响应头
+	defaultResetHeader = "X-RateLimit-Reset"
+	// maxTokenBuckets 令牌桶最大数量，超过后淘汰最近最少使用的令牌桶
+	maxTokenBuckets = 10000
+)
+
+// RateLimitConfig rateLimit 处理器配置
+type RateLimitConfig struct {
+	//Rate 每秒产生的令牌数
+	Rate float64
+	//Burst 令牌桶容量，默认等于Rate
+	Burst int
+	//Key 限流维度，支持${metadata.key}替换消息元数据，例如：${metadata.clientId}
+	//为空则该路由所有请求共用一个令牌桶
+	Key string
+	//DisableHeaders 是否不输出限流响应头
+	DisableHeaders bool
+	//LimitHeader 限流总数响应头名称，默认X-RateLimit-Limit
+	LimitHeader string
+	//RemainingHeader 剩余请求数响应头名称，默认X-RateLimit-Remaining
+	RemainingHeader string
+	//ResetHeader 令牌桶恢复到满额所需秒数响应头名称，默认X-RateLimit-Reset
+	ResetHeader string
+}
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter 路由限流器，每个路由一个实例
+type rateLimiter struct {
+	//def 创建限流器时的路由定义，路由定义变化后重新创建限流器
+	def    *types.RouterDsl
+	config RateLimitConfig
+	//buckets key->*list.Element(*tokenBucket)
+	buckets map[string]*list.Element
+	//order 按照最近访问时间排序的令牌桶，最近访问的在前
+	order *list.List
+	lock  sync.Mutex
+}
+
+// rateLimiters 路由限流器，key:路由ID
+var rateLimiters sync.Map
+
+func init() {
+	//令牌桶限流，允许通过的请求在exchange.Out 输出X-RateLimit-*响应头，超过限制响应429
+	Builtins.Register(RateLimit, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		limiter, err := getRateLimiter(router)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		var key = limiter.config.Key
+		if msg := exchange.In.GetMsg(); msg != nil && key != "" {
+			key = str.SprintfVar(key, types.MetadataKey+".", msg.Metadata.Values())
+		}
+		allowed, remaining, reset := limiter.take(key, time.Now())
+		limiter.writeHeaders(exchange, remaining, reset)
+		if !allowed {
+			if headers := exchange.Out.Headers(); headers != nil {
+				headers.Set("Retry-After", strconv.Itoa(int(math.Ceil(limiter.retryAfter().Seconds()))))
+			}
+			return abort(exchange, http.StatusTooManyRequests, errors.New("too many requests"))
+		}
+		return true
+	})
+}
+
+// getRateLimiter 获取路由对应的限流器，不存在则根据路由配置创建
+func getRateLimiter(router endpoint.Router) (*rateLimiter, error) {
+	if v, ok := rateLimiters.Load(router.GetId()); ok && v.(*rateLimiter).def == router.Definition() {
+		return v.(*rateLimiter), nil
+	}
+	var config RateLimitConfig
+	if err := getConfig(router, RateLimit, &config); err != nil {
+		return nil, err
+	}
+	if config.Rate <= 0 {
+		return nil, errors.New("rateLimit rate must be greater than 0")
+	}
+	if config.Burst <= 0 {
+		config.Burst = int(math.Ceil(config.Rate))
+	}
+	if config.LimitHeader == "" {
+		config.LimitHeader = defaultLimitHeader
+	}
+	if config.RemainingHeader == "" {
+		config.RemainingHeader = defaultRemainingHeader
+	}
+	if config.ResetHeader == "" {
+		config.ResetHeader = defaultResetHeader
+	}
+	limiter := &rateLimiter{def: router.Definition(), config: config, buckets: make(map[string]*list.Element), order: list.New()}
+	rateLimiters.Store(router.GetId(), limiter)
+	return limiter, nil
+}
+
+// take 从指定key的令牌桶获取一个令牌
+// 返回是否允许通过、剩余令牌数、恢复到满额所需时间
+func (l *rateLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	burst := float64(l.config.Burst)
+	var bucket *tokenBucket
+	if element, ok := l.buckets[key]; ok {
+		l.order.MoveToFront(element)
+		bucket = element.Value.(*tokenBucket)
+		bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.config.Rate)
+		bucket.last = now
+	} else {
+		bucket = &tokenBucket{key: key, tokens: burst, last: now}
+		l.buckets[key] = l.order.PushFront(bucket)
+		l.evict()
+	}
+	allowed := bucket.tokens >= 1
+	if allowed {
+		bucket.tokens--
+	}
+	reset := time.Duration((burst - bucket.tokens) / l.config.Rate * float64(time.Second))
+	return allowed, int(bucket.tokens), reset
+}
+
+// evict 令牌桶数量超过上限时，淘汰最近最少使用的令牌桶
+func (l *rateLimiter) evict() {
+	for l.order.Len() > maxTokenBuckets {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.buckets, oldest.Value.(*tokenBucket).key)
+	}
+}
+
+// retryAfter 获取下一个令牌产生所需时间
+func (l *rateLimiter) retryAfter() time.Duration {
+	return time.Duration(float64(time.Second) / l.config.Rate)
+}
+
+// writeHeaders 输出限流响应头
+func (l *rateLimiter) writeHeaders(exchange *endpoint.Exchange, remaining int, reset time.Duration) {
+	if l.config.DisableHeaders {
+		return
+	}
+	headers := exchange.Out.Headers()
+	if headers == nil {
+		return
+	}
+	headers.Set(l.config.LimitHeader, strconv.Itoa(l.config.Burst))
+	headers.Set(l.config.RemainingHeader, strconv.Itoa(remaining))
+	headers.Set(l.config.ResetHeader, strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+}
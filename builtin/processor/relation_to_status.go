@@ -0,0 +1,68 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+)
+
+// RelationToStatus 根据规则链结束的关系类型设置HTTP响应码的处理器名称
+const RelationToStatus = "relationToStatus"
+
+// RelationToStatusConfig relationToStatus 处理器配置
+// 该处理器配置在to端处理器列表，并放在responseToBody之前，例如：
+//
+//	"relationToStatus": {
+//	  "mapping": {"NotFound": 404, "Conflict": 409, "Success": 200}
+//	}
+type RelationToStatusConfig struct {
+	//Mapping 关系类型和响应码映射
+	Mapping map[string]int
+	//RelationKey 关系类型所在的消息元数据key，默认使用执行器设置的endRelationType
+	RelationKey string
+	//DefaultSuccessStatus 没有映射的关系类型，处理成功时的响应码，默认200
+	DefaultSuccessStatus int
+	//DefaultFailureStatus 没有映射的关系类型，处理失败时的响应码，默认500
+	DefaultFailureStatus int
+}
+
+func init() {
+	//把规则链结束的关系类型转换成HTTP响应码
+	Builtins.Register(RelationToStatus, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := RelationToStatusConfig{
+			RelationKey:          endpoint.RelationTypeKey,
+			DefaultSuccessStatus: http.StatusOK,
+			DefaultFailureStatus: http.StatusInternalServerError,
+		}
+		if err := getConfig(router, RelationToStatus, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		var relationType string
+		if msg := exchange.Out.GetMsg(); msg != nil {
+			relationType = msg.Metadata.GetValue(config.RelationKey)
+		}
+		if statusCode, ok := config.Mapping[relationType]; ok && relationType != "" {
+			exchange.Out.SetStatusCode(statusCode)
+		} else if exchange.Out.GetError() != nil {
+			exchange.Out.SetStatusCode(config.DefaultFailureStatus)
+		} else {
+			exchange.Out.SetStatusCode(config.DefaultSuccessStatus)
+		}
+		return true
+	})
+}
@@ -0,0 +1,211 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ReplayGuard 基于时间戳+随机数防重放的处理器名称
+const ReplayGuard = "replayGuard"
+
+// ReplayGuardConfig replayGuard 处理器配置
+type ReplayGuardConfig struct {
+	//TimestampHeader 时间戳请求头，值为unix时间戳，支持秒或者毫秒，默认X-Timestamp
+	TimestampHeader string
+	//NonceHeader 随机数请求头，默认X-Nonce
+	NonceHeader string
+	//Skew 允许的客户端与服务器时间偏差，单位秒，默认300
+	Skew int
+	//NonceTTL 随机数保留时间，单位秒，默认等于2倍Skew，应不小于2倍Skew，否则过期随机数可以在时间窗口内被重放
+	NonceTTL int
+	//MaxNonces 内置随机数存储最多保存的随机数数量，默认100000，超过后淘汰最早过期的随机数
+	MaxNonces int
+	//Store 随机数存储名称，通过RegisterNonceStore注册，例如：基于redis的分布式存储
+	//为空则使用内置的内存存储，每个路由独立
+	Store string
+}
+
+// NonceStore 随机数存储
+type NonceStore interface {
+	//Add 保存随机数，保留ttl时间。如果随机数已经存在返回false
+	Add(nonce string, ttl time.Duration) (bool, error)
+}
+
+// nonceStores 注册的随机数存储，key:存储名称
+var nonceStores sync.Map
+
+// memoryNonceStores 内置的内存随机数存储，key:路由ID
+var memoryNonceStores sync.Map
+
+// RegisterNonceStore 注册replayGuard 随机数存储，用于多实例部署共享随机数
+func RegisterNonceStore(name string, store NonceStore) {
+	nonceStores.Store(name, store)
+}
+
+func init() {
+	//校验时间戳和随机数，拒绝过期或者重放的请求，响应401
+	Builtins.Register(ReplayGuard, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := ReplayGuardConfig{TimestampHeader: "X-Timestamp", NonceHeader: "X-Nonce", Skew: 300, MaxNonces: 100000}
+		if err := getConfig(router, ReplayGuard, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		if config.NonceTTL <= 0 {
+			config.NonceTTL = config.Skew * 2
+		}
+		store, err := getNonceStore(router, config)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		headers := exchange.In.Headers()
+		if headers == nil {
+			return abort(exchange, http.StatusUnauthorized, errors.New("missing timestamp or nonce"))
+		}
+		timestamp, nonce := headers.Get(config.TimestampHeader), headers.Get(config.NonceHeader)
+		if timestamp == "" || nonce == "" {
+			return abort(exchange, http.StatusUnauthorized, errors.New("missing timestamp or nonce"))
+		}
+		ts, err := parseTimestamp(timestamp)
+		if err != nil {
+			return abort(exchange, http.StatusUnauthorized, err)
+		}
+		if skew := time.Since(ts); skew > time.Duration(config.Skew)*time.Second || -skew > time.Duration(config.Skew)*time.Second {
+			return abort(exchange, http.StatusUnauthorized, errors.New("request timestamp expired"))
+		}
+		if ok, err := store.Add(nonce, time.Duration(config.NonceTTL)*time.Second); err != nil {
+			//存储故障不是请求的问题
+			return abort(exchange, http.StatusInternalServerError, err)
+		} else if !ok {
+			return abort(exchange, http.StatusUnauthorized, errors.New("nonce has already been used"))
+		}
+		return true
+	})
+}
+
+// getNonceStore 获取路由使用的随机数存储
+func getNonceStore(router endpoint.Router, config ReplayGuardConfig) (NonceStore, error) {
+	if config.Store != "" {
+		if v, ok := nonceStores.Load(config.Store); ok {
+			return v.(NonceStore), nil
+		}
+		return nil, fmt.Errorf("nonce store not found: %s", config.Store)
+	}
+	v, _ := memoryNonceStores.LoadOrStore(router.GetId(), &memoryNonceStore{nonces: make(map[string]*nonceEntry)})
+	store := v.(*memoryNonceStore)
+	store.setMaxNonces(config.MaxNonces)
+	return store, nil
+}
+
+// parseTimestamp 解析unix时间戳，大于1e12认为是毫秒
+func parseTimestamp(value string) (time.Time, error) {
+	v, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid timestamp: %s", value)
+	}
+	if v > 1e12 {
+		return time.UnixMilli(v), nil
+	}
+	return time.Unix(v, 0), nil
+}
+
+// nonceEntry 随机数及其过期时间
+type nonceEntry struct {
+	nonce     string
+	expiredAt time.Time
+	//index 在过期时间堆中的位置
+	index int
+}
+
+// nonceHeap 按照过期时间排序的随机数最小堆
+type nonceHeap []*nonceEntry
+
+func (h nonceHeap) Len() int {
+	return len(h)
+}
+
+func (h nonceHeap) Less(i, j int) bool {
+	return h[i].expiredAt.Before(h[j].expiredAt)
+}
+
+func (h nonceHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *nonceHeap) Push(x interface{}) {
+	entry := x.(*nonceEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *nonceHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return entry
+}
+
+// memoryNonceStore 内存随机数存储
+type memoryNonceStore struct {
+	//nonces key:随机数
+	nonces map[string]*nonceEntry
+	//expiry 按照过期时间排序，最早过期的在堆顶
+	expiry    nonceHeap
+	maxNonces int
+	lock      sync.Mutex
+}
+
+func (s *memoryNonceStore) setMaxNonces(maxNonces int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.maxNonces = maxNonces
+}
+
+// Add 保存随机数，先清理已经过期的随机数，数量达到上限时淘汰最早过期的随机数
+func (s *memoryNonceStore) Add(nonce string, ttl time.Duration) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	for len(s.expiry) > 0 && !now.Before(s.expiry[0].expiredAt) {
+		s.remove(heap.Pop(&s.expiry).(*nonceEntry))
+	}
+	if _, ok := s.nonces[nonce]; ok {
+		return false, nil
+	}
+	for s.maxNonces > 0 && len(s.expiry) >= s.maxNonces {
+		s.remove(heap.Pop(&s.expiry).(*nonceEntry))
+	}
+	entry := &nonceEntry{nonce: nonce, expiredAt: now.Add(ttl)}
+	heap.Push(&s.expiry, entry)
+	s.nonces[nonce] = entry
+	return true, nil
+}
+
+// remove 删除已经从堆中移除的随机数
+func (s *memoryNonceStore) remove(entry *nonceEntry) {
+	delete(s.nonces, entry.nonce)
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"container/list"
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache 缓存响应的处理器名称
+//
+// 该处理器配置在from端处理器列表：
+//   - 命中缓存：把缓存的响应码、响应头和响应体写入exchange.Out，不再执行规则链
+//   - 没有命中：继续执行规则链，exchange处理结束时保存to端处理器(例如：responseToBody)输出的响应
+//
+// 只缓存响应码为200并且没有错误的响应，to端需要配置为同步等待(wait)模式
+const ResponseCache = "responseCache"
+
+// ResponseCacheConfig responseCache 处理器配置
+type ResponseCacheConfig struct {
+	//TTL 缓存有效时间，单位秒，默认60
+	TTL int
+	//MaxEntries 最大缓存数量，默认1000，超过后淘汰最近最少使用的缓存
+	MaxEntries int
+	//Headers 参与计算缓存key的请求头，请求方法、路径和参数总是参与计算
+	Headers []string
+	//Methods 允许缓存的请求方法，默认GET、HEAD。非HTTP请求不检查请求方法
+	Methods []string
+}
+
+// cachedResponse 缓存的响应
+type cachedResponse struct {
+	key        string
+	statusCode int
+	headers    textproto.MIMEHeader
+	body       []byte
+	expireAt   time.Time
+}
+
+// responseLRU 路由响应缓存，每个路由一个实例
+type responseLRU struct {
+	//def 创建缓存时的路由定义，路由定义变化后重新创建缓存
+	def     *types.RouterDsl
+	config  ResponseCacheConfig
+	entries map[string]*list.Element
+	order   *list.List
+	lock    sync.Mutex
+}
+
+// responseCaches 路由响应缓存，key:路由ID
+var responseCaches sync.Map
+
+func init() {
+	//命中缓存直接输出缓存的响应，没有命中则在exchange处理结束时保存响应
+	//请求头Cache-Control: no-cache 跳过缓存读取，no-store 跳过缓存读取和保存
+	Builtins.Register(ResponseCache, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		cache, err := getResponseCache(router)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		if r, ok := exchange.In.(interface{ Request() *http.Request }); ok && r.Request() != nil {
+			if !containsFold(cache.config.Methods, r.Request().Method) {
+				return true
+			}
+		}
+		var noCache, noStore bool
+		if headers := exchange.In.Headers(); headers != nil {
+			noCache, noStore = cacheControl(headers.Get("Cache-Control"))
+		}
+		if noStore {
+			return true
+		}
+		key := responseCacheKey(router, exchange, cache.config.Headers)
+		if !noCache {
+			if item, ok := cache.get(key, time.Now()); ok {
+				if headers := exchange.Out.Headers(); headers != nil {
+					for k, v := range item.headers {
+						headers[k] = append([]string(nil), v...)
+					}
+				}
+				exchange.Out.SetStatusCode(item.statusCode)
+				exchange.Out.SetBody(item.body)
+				return false
+			}
+		}
+		exchange.OnDone(func() {
+			cache.capture(key, exchange)
+		})
+		return true
+	})
+}
+
+// getResponseCache 获取路由对应的响应缓存，不存在则根据路由配置创建
+func getResponseCache(router endpoint.Router) (*responseLRU, error) {
+	if v, ok := responseCaches.Load(router.GetId()); ok && v.(*responseLRU).def == router.Definition() {
+		return v.(*responseLRU), nil
+	}
+	config := ResponseCacheConfig{TTL: 60, MaxEntries: 1000, Methods: []string{http.MethodGet, http.MethodHead}}
+	if err := getConfig(router, ResponseCache, &config); err != nil {
+		return nil, err
+	}
+	if config.TTL <= 0 {
+		return nil, errors.New("responseCache ttl must be greater than 0")
+	}
+	if config.MaxEntries <= 0 {
+		return nil, errors.New("responseCache maxEntries must be greater than 0")
+	}
+	cache := &responseLRU{def: router.Definition(), config: config, entries: make(map[string]*list.Element), order: list.New()}
+	responseCaches.Store(router.GetId(), cache)
+	return cache, nil
+}
+
+// get 获取没有过期的缓存，并标记为最近使用
+func (c *responseLRU) get(key string, now time.Time) (*cachedResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*cachedResponse)
+	if now.After(item.expireAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return item, true
+}
+
+// put 保存缓存，超过最大数量淘汰最近最少使用的缓存
+func (c *responseLRU) put(item *cachedResponse) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if element, ok := c.entries[item.key]; ok {
+		element.Value = item
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[item.key] = c.order.PushFront(item)
+	for c.order.Len() > c.config.MaxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedResponse).key)
+	}
+}
+
+// capture 保存exchange输出的响应
+func (c *responseLRU) capture(key string, exchange *endpoint.Exchange) {
+	if exchange.Out == nil || exchange.Out.GetError() != nil || exchange.Out.Body() == nil {
+		return
+	}
+	if out, ok := exchange.Out.(endpoint.StatusCodeMessage); ok && out.StatusCode() != http.StatusOK {
+		return
+	}
+	var headers = make(textproto.MIMEHeader)
+	if outHeaders := exchange.Out.Headers(); outHeaders != nil {
+		if _, noStore := cacheControl(outHeaders.Get("Cache-Control")); noStore || strings.Contains(strings.ToLower(outHeaders.Get("Cache-Control")), "private") {
+			return
+		}
+		for k, v := range outHeaders {
+			headers[k] = append([]string(nil), v...)
+		}
+	}
+	c.put(&cachedResponse{
+		key:        key,
+		statusCode: http.StatusOK,
+		headers:    headers,
+		body:       append([]byte(nil), exchange.Out.Body()...),
+		expireAt:   time.Now().Add(time.Duration(c.config.TTL) * time.Second),
+	})
+}
+
+// responseCacheKey 计算缓存key：路由ID+请求方法+请求地址+指定请求头
+func responseCacheKey(router endpoint.Router, exchange *endpoint.Exchange, headers []string) string {
+	var sb strings.Builder
+	sb.WriteString(router.GetId())
+	sb.WriteByte(0)
+	if r, ok := exchange.In.(interface{ Request() *http.Request }); ok && r.Request() != nil {
+		sb.WriteString(r.Request().Method)
+	}
+	sb.WriteByte(0)
+	sb.WriteString(exchange.In.From())
+	for _, item := range headers {
+		sb.WriteByte(0)
+		if inHeaders := exchange.In.Headers(); inHeaders != nil {
+			sb.WriteString(inHeaders.Get(item))
+		}
+	}
+	return sb.String()
+}
+
+// cacheControl 解析Cache-Control头的no-cache和no-store指令
+func cacheControl(value string) (noCache bool, noStore bool) {
+	for _, item := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(item)) {
+		case "no-cache":
+			noCache = true
+		case "no-store":
+			noStore = true
+		}
+	}
+	return
+}
+
+// containsFold 判断列表是否包含指定字符串，忽略大小写
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/maps"
+	"github.com/rulego/rulego/utils/str"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// SequenceGuard 按照key检查消息序列号顺序的处理器名称
+const SequenceGuard = "sequenceGuard"
+
+// SequenceStatusKey 序列号检查结果元数据key，规则链可以根据该元数据路由消息，例如：使用switch节点处理Gap
+const SequenceStatusKey = "sequenceStatus"
+
+// 序列号检查结果
+const (
+	// SequenceInOrder 序列号等于上一个序列号+1，或者是该key的第一个消息
+	SequenceInOrder = "InOrder"
+	// SequenceGap 序列号大于上一个序列号+1，中间有消息丢失或者还没有到达
+	SequenceGap = "Gap"
+	// SequenceOutOfOrder 序列号小于上一个序列号
+	SequenceOutOfOrder = "OutOfOrder"
+	// SequenceDuplicate 序列号等于上一个序列号
+	SequenceDuplicate = "Duplicate"
+)
+
+// SequenceGuardConfig sequenceGuard 处理器配置
+// key和序列号可以从请求头或者JSON请求体字段获取，同时配置时优先使用请求头
+type SequenceGuardConfig struct {
+	//KeyHeader key请求头，例如：X-Device-Id
+	KeyHeader string
+	//KeyField key JSON请求体字段，支持嵌套字段，例如：device.id
+	KeyField string
+	//SequenceHeader 序列号请求头，默认X-Sequence
+	SequenceHeader string
+	//SequenceField 序列号JSON请求体字段，支持嵌套字段，例如：header.seq
+	SequenceField string
+	//Drop 是否拒绝乱序和重复的消息，默认true。false则继续执行规则链，由规则链根据sequenceStatus元数据处理
+	Drop bool
+	//StatusCode 拒绝消息的响应码，默认409
+	StatusCode int
+	//MaxKeys 最多跟踪的key数量，默认10000，超过后淘汰最近最少使用的key
+	MaxKeys int
+}
+
+// sequenceTracker 路由序列号跟踪器，每个路由一个实例
+type sequenceTracker struct {
+	//def 创建跟踪器时的路由定义，路由定义变化后重新创建跟踪器
+	def    *types.RouterDsl
+	config SequenceGuardConfig
+	//entries key->*list.Element(*sequenceEntry)
+	entries map[string]*list.Element
+	order   *list.List
+	lock    sync.Mutex
+}
+
+type sequenceEntry struct {
+	key  string
+	last int64
+}
+
+// sequenceTrackers 路由序列号跟踪器，key:路由ID
+var sequenceTrackers sync.Map
+
+func init() {
+	//检查每个key的序列号是否单调递增，把检查结果写入消息元数据sequenceStatus
+	//乱序和重复的消息根据配置拒绝或者交给规则链处理，Gap消息总是交给规则链处理
+	Builtins.Register(SequenceGuard, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		tracker, err := getSequenceTracker(router)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		config := tracker.config
+		var body interface{}
+		if (config.KeyHeader == "" && config.KeyField != "") || (config.SequenceHeader == "" && config.SequenceField != "") {
+			decoder := json.NewDecoder(bytes.NewReader(exchange.In.Body()))
+			decoder.UseNumber()
+			if err := decoder.Decode(&body); err != nil {
+				return abort(exchange, http.StatusBadRequest, fmt.Errorf("invalid JSON body: %w", err))
+			}
+		}
+		key := sequenceValue(exchange, body, config.KeyHeader, config.KeyField)
+		if key == "" {
+			return abort(exchange, http.StatusBadRequest, errors.New("missing sequence key"))
+		}
+		seqValue := sequenceValue(exchange, body, config.SequenceHeader, config.SequenceField)
+		seq, err := strconv.ParseInt(seqValue, 10, 64)
+		if err != nil {
+			return abort(exchange, http.StatusBadRequest, fmt.Errorf("invalid sequence: %s", seqValue))
+		}
+		status := tracker.check(key, seq)
+		if config.Drop && (status == SequenceOutOfOrder || status == SequenceDuplicate) {
+			return abort(exchange, config.StatusCode, fmt.Errorf("%s sequence %d for key %s", status, seq, key))
+		}
+		exchange.In.GetMsg().Metadata.PutValue(SequenceStatusKey, status)
+		return true
+	})
+}
+
+// getSequenceTracker 获取路由对应的序列号跟踪器，不存在则根据路由配置创建
+func getSequenceTracker(router endpoint.Router) (*sequenceTracker, error) {
+	if v, ok := sequenceTrackers.Load(router.GetId()); ok && v.(*sequenceTracker).def == router.Definition() {
+		return v.(*sequenceTracker), nil
+	}
+	config := SequenceGuardConfig{Drop: true, StatusCode: http.StatusConflict, MaxKeys: 10000}
+	if err := getConfig(router, SequenceGuard, &config); err != nil {
+		return nil, err
+	}
+	if config.KeyHeader == "" && config.KeyField == "" {
+		return nil, errors.New("sequenceGuard keyHeader or keyField is required")
+	}
+	if config.SequenceHeader == "" && config.SequenceField == "" {
+		config.SequenceHeader = "X-Sequence"
+	}
+	if config.MaxKeys <= 0 {
+		return nil, errors.New("sequenceGuard maxKeys must be greater than 0")
+	}
+	tracker := &sequenceTracker{def: router.Definition(), config: config, entries: make(map[string]*list.Element), order: list.New()}
+	sequenceTrackers.Store(router.GetId(), tracker)
+	return tracker, nil
+}
+
+// sequenceValue 优先从请求头获取值，否则从JSON请求体字段获取
+func sequenceValue(exchange *endpoint.Exchange, body interface{}, header, field string) string {
+	if header != "" {
+		if headers := exchange.In.Headers(); headers != nil {
+			return headers.Get(header)
+		}
+		return ""
+	}
+	if v := maps.Get(body, field); v != nil {
+		return str.ToString(v)
+	}
+	return ""
+}
+
+// check 检查序列号，序列号递增时更新key最后的序列号
+func (t *sequenceTracker) check(key string, seq int64) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	element, ok := t.entries[key]
+	if !ok {
+		t.entries[key] = t.order.PushFront(&sequenceEntry{key: key, last: seq})
+		for t.order.Len() > t.config.MaxKeys {
+			oldest := t.order.Back()
+			t.order.Remove(oldest)
+			delete(t.entries, oldest.Value.(*sequenceEntry).key)
+		}
+		return SequenceInOrder
+	}
+	t.order.MoveToFront(element)
+	entry := element.Value.(*sequenceEntry)
+	switch {
+	case seq == entry.last:
+		return SequenceDuplicate
+	case seq < entry.last:
+		return SequenceOutOfOrder
+	case seq == entry.last+1:
+		entry.last = seq
+		return SequenceInOrder
+	default:
+		entry.last = seq
+		return SequenceGap
+	}
+}
@@ -0,0 +1,177 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Singleflight 合并并发相同请求的处理器名称
+const Singleflight = "singleflight"
+
+// SingleflightConfig singleflight 处理器配置
+//
+// 该处理器需要同时配置在from端和to端处理器列表：
+//   - from端：相同指纹的请求只有第一个请求(leader)执行规则链，其他请求等待leader执行结果
+//   - to端：leader把规则链执行结果共享给等待的请求，等待的请求再各自执行to端处理器输出响应
+//
+// from端建议放在处理器列表最后，避免leader在执行规则链之前被后续处理器中断，导致其他请求等待超时
+type SingleflightConfig struct {
+	//Headers 参与计算请求指纹的请求头，请求路径和参数总是参与计算
+	Headers []string
+	//IncludeBody 请求体是否参与计算请求指纹
+	IncludeBody bool
+	//Timeout 等待leader执行结果的超时时间，单位秒，默认10。超时后自行执行规则链
+	Timeout int
+	//MaxInFlight 最大同时执行的请求指纹数量，默认1000。超过后新的请求不再合并，直接执行规则链
+	MaxInFlight int
+}
+
+// flightCall 正在执行的请求
+type flightCall struct {
+	//leader 执行规则链的请求
+	leader *endpoint.Exchange
+	start  time.Time
+	done   chan struct{}
+	//msg 规则链执行结果
+	msg *types.RuleMsg
+	err error
+}
+
+// flightGroup 正在执行的请求，key:请求指纹
+type flightGroup struct {
+	calls map[string]*flightCall
+	//waiters 已经获得共享结果，正在执行to端处理器的请求
+	waiters map[*endpoint.Exchange]struct{}
+	lock    sync.Mutex
+}
+
+var flights = &flightGroup{
+	calls:   make(map[string]*flightCall),
+	waiters: make(map[*endpoint.Exchange]struct{}),
+}
+
+func init() {
+	//合并并发的相同请求，只执行一次规则链，所有请求共享执行结果
+	Builtins.Register(Singleflight, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		config := SingleflightConfig{Timeout: 10, MaxInFlight: 1000}
+		if err := getConfig(router, Singleflight, &config); err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		timeout := time.Duration(config.Timeout) * time.Second
+		key := flightKey(router, exchange, config)
+		now := time.Now()
+
+		flights.lock.Lock()
+		if _, ok := flights.waiters[exchange]; ok {
+			//等待的请求执行to端处理器
+			flights.lock.Unlock()
+			return true
+		}
+		call, ok := flights.calls[key]
+		if ok && call.leader == exchange {
+			//leader规则链执行结束，共享执行结果
+			if msg := exchange.Out.GetMsg(); msg != nil {
+				copyMsg := msg.Copy()
+				call.msg = &copyMsg
+			}
+			call.err = exchange.Out.GetError()
+			delete(flights.calls, key)
+			flights.lock.Unlock()
+			close(call.done)
+			return true
+		}
+		if !ok || now.Sub(call.start) > timeout {
+			if len(flights.calls) >= config.MaxInFlight {
+				flights.evict(now, timeout)
+			}
+			if len(flights.calls) >= config.MaxInFlight {
+				flights.lock.Unlock()
+				return true
+			}
+			flights.calls[key] = &flightCall{leader: exchange, start: now, done: make(chan struct{})}
+			flights.lock.Unlock()
+			return true
+		}
+		flights.lock.Unlock()
+
+		select {
+		case <-call.done:
+		case <-time.After(timeout - now.Sub(call.start)):
+			//等待超时，自行执行规则链
+			return true
+		}
+		if call.msg != nil {
+			msg := call.msg.Copy()
+			exchange.Out.SetMsg(&msg)
+		}
+		if call.err != nil {
+			exchange.Out.SetError(call.err)
+		}
+		flights.lock.Lock()
+		flights.waiters[exchange] = struct{}{}
+		flights.lock.Unlock()
+		defer func() {
+			flights.lock.Lock()
+			delete(flights.waiters, exchange)
+			flights.lock.Unlock()
+		}()
+		if router.GetFrom() != nil && router.GetFrom().GetTo() != nil {
+			for _, process := range router.GetFrom().GetTo().GetProcessList() {
+				if !process(router, exchange) {
+					break
+				}
+			}
+		}
+		//已经输出响应，不再执行规则链
+		return false
+	})
+}
+
+// evict 清理超时未结束的请求
+func (g *flightGroup) evict(now time.Time, timeout time.Duration) {
+	for key, call := range g.calls {
+		if now.Sub(call.start) > timeout {
+			delete(g.calls, key)
+		}
+	}
+}
+
+// flightKey 计算请求指纹：路由ID+请求地址+指定请求头+请求体(可选)
+func flightKey(router endpoint.Router, exchange *endpoint.Exchange, config SingleflightConfig) string {
+	h := sha256.New()
+	h.Write([]byte(router.GetId()))
+	h.Write([]byte{0})
+	h.Write([]byte(exchange.In.From()))
+	for _, item := range config.Headers {
+		h.Write([]byte{0})
+		if headers := exchange.In.Headers(); headers != nil {
+			h.Write([]byte(headers.Get(item)))
+		}
+	}
+	if config.IncludeBody {
+		h.Write([]byte{0})
+		h.Write(exchange.In.Body())
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,215 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package processor
+
+import (
+	"container/list"
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/api/types/endpoint"
+	"github.com/rulego/rulego/utils/str"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SlidingWindowLimit 滑动窗口限流处理器名称
+// 与rateLimit令牌桶限流不同，滑动窗口限流不允许突发流量：任意Window时长内通过的请求数不超过Limit
+const SlidingWindowLimit = "slidingWindowLimit"
+
+// maxWindowCounters 滑动窗口计数最大数量，超过后淘汰最近最少使用的窗口计数
+const maxWindowCounters = 10000
+
+// SlidingWindowLimitConfig slidingWindowLimit 处理器配置
+type SlidingWindowLimitConfig struct {
+	//Limit 窗口内允许通过的最大请求数
+	Limit int
+	//Window 窗口时长，单位秒，默认60
+	Window int
+	//Buckets 窗口划分的计数桶数量，默认10。每个key只保存Buckets+1个计数，
+	//窗口起点所在的计数桶按照全部计入，因此限流是保守的，桶越多越接近精确的滑动窗口
+	Buckets int
+	//Key 限流维度，支持${metadata.key}替换消息元数据，例如：${metadata.clientId}
+	//为空则该路由所有请求共用一个窗口
+	Key string
+	//DisableHeaders 是否不输出限流响应头
+	DisableHeaders bool
+	//LimitHeader 限流总数响应头名称，默认X-RateLimit-Limit
+	LimitHeader string
+	//RemainingHeader 剩余请求数响应头名称，默认X-RateLimit-Remaining
+	RemainingHeader string
+	//ResetHeader 窗口内最早的请求移出窗口所需秒数响应头名称，默认X-RateLimit-Reset
+	ResetHeader string
+}
+
+// windowCounter 滑动窗口计数，环形数组保存最近Buckets+1个计数桶
+type windowCounter struct {
+	key string
+	//ids 计数桶序号，序号=时间/桶时长
+	ids    []int64
+	counts []int
+}
+
+// windowLimiter 路由滑动窗口限流器，每个路由一个实例
+type windowLimiter struct {
+	//def 创建限流器时的路由定义，路由定义变化后重新创建限流器
+	def    *types.RouterDsl
+	config SlidingWindowLimitConfig
+	bucket time.Duration
+	//counters key->*list.Element(*windowCounter)
+	counters map[string]*list.Element
+	//order 按照最近访问时间排序的窗口计数，最近访问的在前
+	order *list.List
+	lock  sync.Mutex
+}
+
+// windowLimiters 路由滑动窗口限流器，key:路由ID
+var windowLimiters sync.Map
+
+func init() {
+	//滑动窗口限流，允许通过的请求在exchange.Out 输出X-RateLimit-*响应头，超过限制响应429
+	Builtins.Register(SlidingWindowLimit, func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+		limiter, err := getWindowLimiter(router)
+		if err != nil {
+			return abort(exchange, http.StatusInternalServerError, err)
+		}
+		var key = limiter.config.Key
+		if msg := exchange.In.GetMsg(); msg != nil && key != "" {
+			key = str.SprintfVar(key, types.MetadataKey+".", msg.Metadata.Values())
+		}
+		allowed, remaining, reset := limiter.take(key, time.Now())
+		limiter.writeHeaders(exchange, remaining, reset)
+		if !allowed {
+			if headers := exchange.Out.Headers(); headers != nil {
+				headers.Set("Retry-After", strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+			}
+			return abort(exchange, http.StatusTooManyRequests, errors.New("too many requests"))
+		}
+		return true
+	})
+}
+
+// getWindowLimiter 获取路由对应的滑动窗口限流器，不存在则根据路由配置创建
+func getWindowLimiter(router endpoint.Router) (*windowLimiter, error) {
+	if v, ok := windowLimiters.Load(router.GetId()); ok && v.(*windowLimiter).def == router.Definition() {
+		return v.(*windowLimiter), nil
+	}
+	config := SlidingWindowLimitConfig{Window: 60, Buckets: 10}
+	if err := getConfig(router, SlidingWindowLimit, &config); err != nil {
+		return nil, err
+	}
+	if config.Limit <= 0 {
+		return nil, errors.New("slidingWindowLimit limit must be greater than 0")
+	}
+	if config.Window <= 0 {
+		return nil, errors.New("slidingWindowLimit window must be greater than 0")
+	}
+	if config.Buckets <= 0 {
+		return nil, errors.New("slidingWindowLimit buckets must be greater than 0")
+	}
+	if config.LimitHeader == "" {
+		config.LimitHeader = defaultLimitHeader
+	}
+	if config.RemainingHeader == "" {
+		config.RemainingHeader = defaultRemainingHeader
+	}
+	if config.ResetHeader == "" {
+		config.ResetHeader = defaultResetHeader
+	}
+	limiter := &windowLimiter{
+		def:      router.Definition(),
+		config:   config,
+		bucket:   time.Duration(config.Window) * time.Second / time.Duration(config.Buckets),
+		counters: make(map[string]*list.Element),
+		order:    list.New(),
+	}
+	windowLimiters.Store(router.GetId(), limiter)
+	return limiter, nil
+}
+
+// take 在指定key的窗口内记录一个请求
+// 返回是否允许通过、剩余请求数、窗口内最早的请求移出窗口所需时间
+func (l *windowLimiter) take(key string, now time.Time) (bool, int, time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	current := now.UnixNano() / int64(l.bucket)
+	var counter *windowCounter
+	if element, ok := l.counters[key]; ok {
+		l.order.MoveToFront(element)
+		counter = element.Value.(*windowCounter)
+	} else {
+		counter = &windowCounter{key: key, ids: make([]int64, l.config.Buckets+1), counts: make([]int, l.config.Buckets+1)}
+		for i := range counter.ids {
+			counter.ids[i] = -1
+		}
+		l.counters[key] = l.order.PushFront(counter)
+		l.evict()
+	}
+	//与窗口有重叠的计数桶序号范围：[oldest, current]
+	oldest := current - int64(l.config.Buckets)
+	total := 0
+	earliest := int64(-1)
+	for i, id := range counter.ids {
+		if id >= oldest && counter.counts[i] > 0 {
+			total += counter.counts[i]
+			if earliest < 0 || id < earliest {
+				earliest = id
+			}
+		}
+	}
+	allowed := total < l.config.Limit
+	if allowed {
+		slot := int(current % int64(len(counter.ids)))
+		if counter.ids[slot] != current {
+			counter.ids[slot] = current
+			counter.counts[slot] = 0
+		}
+		counter.counts[slot]++
+		total++
+		if earliest < 0 {
+			earliest = current
+		}
+	}
+	//计数桶earliest在(earliest+1)*bucket+window时刻完全移出窗口
+	reset := time.Duration((earliest+1)*int64(l.bucket)-now.UnixNano()) + time.Duration(l.config.Window)*time.Second
+	return allowed, l.config.Limit - total, reset
+}
+
+// evict 窗口计数数量超过上限时，淘汰最近最少使用的窗口计数
+func (l *windowLimiter) evict() {
+	for l.order.Len() > maxWindowCounters {
+		oldest := l.order.Back()
+		l.order.Remove(oldest)
+		delete(l.counters, oldest.Value.(*windowCounter).key)
+	}
+}
+
+// writeHeaders 输出限流响应头
+func (l *windowLimiter) writeHeaders(exchange *endpoint.Exchange, remaining int, reset time.Duration) {
+	if l.config.DisableHeaders {
+		return
+	}
+	headers := exchange.Out.Headers()
+	if headers == nil {
+		return
+	}
+	headers.Set(l.config.LimitHeader, strconv.Itoa(l.config.Limit))
+	headers.Set(l.config.RemainingHeader, strconv.Itoa(remaining))
+	headers.Set(l.config.ResetHeader, strconv.Itoa(int(math.Ceil(reset.Seconds()))))
+}
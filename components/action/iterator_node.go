@@ -121,7 +121,7 @@ func (x *IteratorNode) Destroy() {
 // 处理每条item
 func (x *IteratorNode) executeItem(ctx types.RuleContext, msg types.RuleMsg, item interface{}, index interface{}) error {
 	if x.jsEngine != nil {
-		if out, err := x.jsEngine.Execute("ItemFilter", item, index, msg.Metadata.Values()); err != nil {
+		if out, err := js.ExecuteWithContext(ctx.GetContext(), x.jsEngine, "ItemFilter", item, index, msg.Metadata.Values()); err != nil {
 			ctx.TellFailure(msg, err)
 			//出现错误中断遍历
 			return err
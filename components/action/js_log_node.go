@@ -101,7 +101,7 @@ func (x *LogNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 			data = dataMap
 		}
 	}
-	out, err := x.jsEngine.Execute("ToString", data, msg.Metadata.Values(), msg.Type)
+	out, err := js.ExecuteWithContext(ctx.GetContext(), x.jsEngine, "ToString", data, msg.Metadata.Values(), msg.Type)
 	if err != nil {
 		ctx.TellFailure(msg, err)
 	} else {
@@ -17,6 +17,7 @@
 package external
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -146,15 +147,20 @@ func (x *DbClientNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 		params = x.Config.Params
 	}
 
+	//继承消息上下文，消息超过执行截止时间、被取消或者调用方上下文取消时中断执行
+	execCtx := ctx.GetContext()
+	if execCtx == nil {
+		execCtx = context.Background()
+	}
 	switch x.opType {
 	case SELECT:
-		data, err = x.query(sqlStr, params, x.Config.GetOne)
+		data, err = x.query(execCtx, sqlStr, params, x.Config.GetOne)
 	case UPDATE:
-		rowsAffected, err = x.update(sqlStr, params)
+		rowsAffected, err = x.update(execCtx, sqlStr, params)
 	case INSERT:
-		rowsAffected, lastInsertId, err = x.insert(sqlStr, params)
+		rowsAffected, lastInsertId, err = x.insert(execCtx, sqlStr, params)
 	case DELETE:
-		rowsAffected, err = x.delete(sqlStr, params)
+		rowsAffected, err = x.delete(execCtx, sqlStr, params)
 	default:
 		err = fmt.Errorf("unsupported sql statement: %s", sqlStr)
 	}
@@ -176,8 +182,8 @@ func (x *DbClientNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 }
 
 // query 查询数据并返回map或slice类型
-func (x *DbClientNode) query(sqlStr string, params []interface{}, getOne bool) (interface{}, error) {
-	rows, err := x.db.Query(sqlStr, params...)
+func (x *DbClientNode) query(ctx context.Context, sqlStr string, params []interface{}, getOne bool) (interface{}, error) {
+	rows, err := x.db.QueryContext(ctx, sqlStr, params...)
 	if err != nil {
 		return nil, err
 	}
@@ -243,8 +249,8 @@ func (x *DbClientNode) query(sqlStr string, params []interface{}, getOne bool) (
 }
 
 // update 修改数据并返回影响行数
-func (x *DbClientNode) update(sqlStr string, params []interface{}) (int64, error) {
-	result, err := x.db.Exec(sqlStr, params...)
+func (x *DbClientNode) update(ctx context.Context, sqlStr string, params []interface{}) (int64, error) {
+	result, err := x.db.ExecContext(ctx, sqlStr, params...)
 	if err != nil {
 		return 0, err
 	}
@@ -256,8 +262,8 @@ func (x *DbClientNode) update(sqlStr string, params []interface{}) (int64, error
 }
 
 // insert 插入数据并返回自增ID
-func (x *DbClientNode) insert(sqlStr string, params []interface{}) (int64, int64, error) {
-	result, err := x.db.Exec(sqlStr, params...)
+func (x *DbClientNode) insert(ctx context.Context, sqlStr string, params []interface{}) (int64, int64, error) {
+	result, err := x.db.ExecContext(ctx, sqlStr, params...)
 	if err != nil {
 		return 0, 0, err
 	} else {
@@ -272,8 +278,8 @@ func (x *DbClientNode) insert(sqlStr string, params []interface{}) (int64, int64
 }
 
 // delete 删除数据并返回影响行数
-func (x *DbClientNode) delete(sqlStr string, params []interface{}) (int64, error) {
-	result, err := x.db.Exec(sqlStr, params...)
+func (x *DbClientNode) delete(ctx context.Context, sqlStr string, params []interface{}) (int64, error) {
+	result, err := x.db.ExecContext(ctx, sqlStr, params...)
 	if err != nil {
 		return 0, err
 	}
@@ -284,6 +290,14 @@ func (x *DbClientNode) delete(sqlStr string, params []interface{}) (int64, error
 	return rowsAffected, nil
 }
 
+// Check 健康检查，检查数据库是否可以连接
+func (x *DbClientNode) Check(ctx context.Context) error {
+	if x.db == nil {
+		return errors.New("db client not initialized")
+	}
+	return x.db.PingContext(ctx)
+}
+
 // Destroy 销毁组件
 func (x *DbClientNode) Destroy() {
 	if x.db != nil {
@@ -19,10 +19,13 @@ package external
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/components/mqtt"
 	"github.com/rulego/rulego/utils/maps"
 	"github.com/rulego/rulego/utils/str"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -64,6 +67,13 @@ type MqttClientNodeConfiguration struct {
 	CertKeyFile          string
 }
 
+// SharedClientKey 共享客户端key，连接配置相同的节点共享同一个mqtt客户端，发布主题和QOS不影响连接
+func (x *MqttClientNodeConfiguration) SharedClientKey() string {
+	c := x.ToMqttConfig()
+	return strings.Join([]string{strings.TrimSpace(c.Server), c.Username, c.Password, c.ClientID,
+		strconv.FormatBool(c.CleanSession), c.MaxReconnectInterval.String(), c.CAFile, c.CertFile, c.CertKeyFile}, "|")
+}
+
 func (x *MqttClientNodeConfiguration) ToMqttConfig() mqtt.Config {
 	if x.MaxReconnectInterval < 0 {
 		x.MaxReconnectInterval = 60
@@ -90,6 +100,10 @@ type MqttClientNode struct {
 	locker sync.RWMutex
 	//是否正在连接mqtt 服务器
 	connecting int32
+	//共享客户端注册表，为nil则节点独占客户端
+	sharedClients types.SharedClientRegistry
+	//使用的共享客户端key，为空表示没有使用共享客户端
+	sharedKey string
 }
 
 // Type 组件类型
@@ -110,6 +124,7 @@ func (x *MqttClientNode) New() types.Node {
 func (x *MqttClientNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
 	err := maps.Map2Struct(configuration, &x.Config)
 	if err == nil {
+		x.sharedClients = ruleConfig.SharedClients
 		_ = x.tryInitClient()
 	}
 	return err
@@ -131,9 +146,24 @@ func (x *MqttClientNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 	}
 }
 
+// Check 健康检查，检查是否已经连接到mqtt服务器
+func (x *MqttClientNode) Check(ctx context.Context) error {
+	if x.mqttClient == nil {
+		return MqttClientNotInitErr
+	}
+	if !x.mqttClient.IsConnected() {
+		return fmt.Errorf("mqtt server %s is not connected", x.Config.Server)
+	}
+	return nil
+}
+
 // Destroy 销毁
 func (x *MqttClientNode) Destroy() {
-	if x.mqttClient != nil {
+	if x.sharedKey != "" {
+		//释放共享客户端，最后一个引用释放后关闭
+		x.sharedClients.ReleaseSharedClient(x.Type(), x.sharedKey)
+		x.sharedKey = ""
+	} else if x.mqttClient != nil {
 		_ = x.mqttClient.Close()
 	}
 }
@@ -150,8 +180,19 @@ func (x *MqttClientNode) tryInitClient() error {
 			cancel()
 			atomic.StoreInt32(&x.connecting, 0)
 		}()
-		x.mqttClient, err = mqtt.NewClient(ctx, x.Config.ToMqttConfig())
-		return err
+		if x.sharedClients == nil {
+			x.mqttClient, err = mqtt.NewClient(ctx, x.Config.ToMqttConfig())
+			return err
+		}
+		key := x.Config.SharedClientKey()
+		client, err := x.sharedClients.GetSharedClient(x.Type(), key, func() (interface{}, error) {
+			return mqtt.NewClient(ctx, x.Config.ToMqttConfig())
+		})
+		if err != nil {
+			return err
+		}
+		x.mqttClient, x.sharedKey = client.(*mqtt.Client), key
+		return nil
 	} else {
 		return nil
 	}
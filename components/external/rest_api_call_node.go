@@ -31,6 +31,7 @@ package external
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/rulego/rulego/api/types"
@@ -142,11 +143,16 @@ func (x *RestApiCallNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 	endpointUrl := str.SprintfDict(x.Config.RestEndpointUrlPattern, metaData)
 	var req *http.Request
 	var err error
-
+	//继承消息上下文，消息超过执行截止时间、被取消或者调用方上下文取消时中断请求
+	//异步处理的消息上下文只保留来源请求的值(参考 types.WithoutCancel)，来源请求结束等取消信号不影响异步处理，只受执行截止时间限制
+	reqCtx := ctx.GetContext()
+	if reqCtx == nil {
+		reqCtx = context.Background()
+	}
 	if x.Config.WithoutRequestBody {
-		req, err = http.NewRequest(x.Config.RequestMethod, endpointUrl, nil)
+		req, err = http.NewRequestWithContext(reqCtx, x.Config.RequestMethod, endpointUrl, nil)
 	} else {
-		req, err = http.NewRequest(x.Config.RequestMethod, endpointUrl, bytes.NewReader([]byte(msg.Data)))
+		req, err = http.NewRequestWithContext(reqCtx, x.Config.RequestMethod, endpointUrl, bytes.NewReader([]byte(msg.Data)))
 	}
 	if err != nil {
 		ctx.TellFailure(msg, err)
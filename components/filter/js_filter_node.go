@@ -92,7 +92,7 @@ func (x *JsFilterNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 		}
 	}
 
-	out, err := x.jsEngine.Execute("Filter", data, msg.Metadata.Values(), msg.Type)
+	out, err := js.ExecuteWithContext(ctx.GetContext(), x.jsEngine, "Filter", data, msg.Metadata.Values(), msg.Type)
 	if err != nil {
 		ctx.TellFailure(msg, err)
 	} else {
@@ -97,7 +97,7 @@ func (x *JsSwitchNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 		}
 	}
 
-	out, err := x.jsEngine.Execute("Switch", data, msg.Metadata.Values(), msg.Type)
+	out, err := js.ExecuteWithContext(ctx.GetContext(), x.jsEngine, "Switch", data, msg.Metadata.Values(), msg.Type)
 
 	if err != nil {
 		ctx.TellFailure(msg, err)
@@ -17,6 +17,7 @@
 package js
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dop251/goja"
@@ -155,6 +156,11 @@ func (g *GojaJsEngine) NewVm(config types.Config, fromVars map[string]interface{
 
 // Execute Execute JavaScript script
 func (g *GojaJsEngine) Execute(functionName string, argumentList ...interface{}) (out interface{}, err error) {
+	return g.ExecuteWithContext(context.Background(), functionName, argumentList...)
+}
+
+// ExecuteWithContext Execute JavaScript script, interrupt the script execution when ctx deadline exceeded
+func (g *GojaJsEngine) ExecuteWithContext(ctx context.Context, functionName string, argumentList ...interface{}) (out interface{}, err error) {
 	defer func() {
 		if caught := recover(); caught != nil {
 			err = fmt.Errorf("%s", caught)
@@ -164,9 +170,11 @@ func (g *GojaJsEngine) Execute(functionName string, argumentList ...interface{})
 	vm := g.vmPool.Get().(*goja.Runtime)
 
 	state := g.setTimeout(vm)
+	stopInterrupt := interruptOnDone(ctx, vm)
 
 	f, ok := goja.AssertFunction(vm.Get(functionName))
 	if !ok {
+		stopInterrupt()
 		return nil, errors.New(functionName + " is not a function")
 	}
 	var params []goja.Value
@@ -176,6 +184,7 @@ func (g *GojaJsEngine) Execute(functionName string, argumentList ...interface{})
 	res, err := f(goja.Undefined(), params...)
 	//If there is no timeout, state=0; otherwise, state=-2
 	closeStateChan(state)
+	stopInterrupt()
 	//Put back to the pool
 	g.vmPool.Put(vm)
 	if err != nil {
@@ -200,6 +209,41 @@ func (g *GojaJsEngine) setTimeout(vm *goja.Runtime) chan int {
 	return state
 }
 
+// interruptOnDone interrupt the js script execution when ctx deadline exceeded,
+// cancellation without deadline does not interrupt, such as the end of the source http request.
+// the returned function stops listening and clears the interrupt triggered after the script returned
+func interruptOnDone(ctx context.Context, vm *goja.Runtime) func() {
+	if ctx == nil || ctx.Done() == nil {
+		return func() {}
+	}
+	finished := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				vm.Interrupt(ctx.Err())
+			}
+		case <-finished:
+		}
+	}()
+	return func() {
+		close(finished)
+		<-exited
+		vm.ClearInterrupt()
+	}
+}
+
+// ExecuteWithContext if jsEngine implements types.ContextJsEngine, interrupt the script execution when ctx deadline exceeded,
+// otherwise execute the script by jsEngine.Execute
+func ExecuteWithContext(ctx context.Context, jsEngine types.JsEngine, functionName string, argumentList ...interface{}) (interface{}, error) {
+	if ctxEngine, ok := jsEngine.(types.ContextJsEngine); ok && ctx != nil {
+		return ctxEngine.ExecuteWithContext(ctx, functionName, argumentList...)
+	}
+	return jsEngine.Execute(functionName, argumentList...)
+}
+
 func closeStateChan(state chan int) {
 	if <-state == 0 {
 		state <- 1
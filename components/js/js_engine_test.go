@@ -17,6 +17,7 @@
 package js
 
 import (
+	"context"
 	"github.com/dop251/goja"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/test/assert"
@@ -215,3 +216,37 @@ func testExecuteJs(t *testing.T, jsEngine *GojaJsEngine, index int, group *sync.
 	jsEngine.config.Logger.Printf("index:%d,响应:%s,用时：%s", index, response, time.Since(start))
 
 }
+
+func TestExecuteWithContext(t *testing.T) {
+	var jsScript = `
+	function Loop(msg) {
+		while(true){}
+	}
+	function Echo(msg) {
+		return msg
+	}
+	`
+	config := types.NewConfig()
+	config.ScriptMaxExecutionTime = time.Second * 10
+	jsEngine, err := NewGojaJsEngine(config, jsScript, nil)
+	assert.Nil(t, err)
+
+	//超过截止时间中断脚本执行
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	start := time.Now()
+	_, err = ExecuteWithContext(ctx, jsEngine, "Loop", "aa")
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), context.DeadlineExceeded.Error()))
+	assert.True(t, time.Since(start) < time.Second*5)
+
+	//没有截止时间的取消不中断脚本执行，中断标志不影响后续执行
+	cancelCtx, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+	out, err := ExecuteWithContext(cancelCtx, jsEngine, "Echo", "aa")
+	assert.Nil(t, err)
+	assert.Equal(t, "aa", out)
+	out, err = jsEngine.Execute("Echo", "bb")
+	assert.Nil(t, err)
+	assert.Equal(t, "bb", out)
+}
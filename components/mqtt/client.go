@@ -157,6 +157,11 @@ func (b *Client) Close() error {
 }
 
 // Publish 发布数据
+// IsConnected 是否已经连接到mqtt服务器
+func (b *Client) IsConnected() bool {
+	return b.client.IsConnectionOpen()
+}
+
 func (b *Client) Publish(topic string, qos byte, data []byte) error {
 	if token := b.client.Publish(topic, qos, false, data); token.Wait() && token.Error() != nil {
 		return token.Error()
@@ -104,7 +104,7 @@ func (x *JsTransformNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 			data = make(map[string]interface{})
 		}
 	}
-	out, err := x.jsEngine.Execute("Transform", data, msg.Metadata.Values(), msg.Type)
+	out, err := js.ExecuteWithContext(ctx.GetContext(), x.jsEngine, "Transform", data, msg.Metadata.Values(), msg.Type)
 	if err != nil {
 		ctx.TellFailure(msg, err)
 	} else {
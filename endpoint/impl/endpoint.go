@@ -313,6 +313,11 @@ func (r *Router) SetConfig(config types.Config) {
 	r.Config = config
 }
 
+// RuleConfig 获取路由的规则引擎配置
+func (r *Router) RuleConfig() types.Config {
+	return r.Config
+}
+
 func (r *Router) SetRuleEnginePool(pool types.RuleEnginePool) {
 	r.RuleGo = pool
 }
@@ -421,6 +426,8 @@ func (e *BaseEndpoint) AddInterceptors(interceptors ...endpoint.Process) {
 }
 
 func (e *BaseEndpoint) DoProcess(baseCtx context.Context, router endpoint.Router, exchange *endpoint.Exchange) {
+	//处理结束，释放exchange占用的资源
+	defer exchange.Done()
 	//创建上下文
 	ctx := e.createContext(baseCtx, router, exchange)
 	for _, item := range e.interceptors {
@@ -519,13 +526,21 @@ func (ce *ChainExecutor) Execute(ctx context.Context, router endpoint.Router, ex
 		//查找规则链，并执行
 		if ruleEngine, ok := router.GetRuleGo(exchange).Get(toChainId); ok {
 			opts := toFlow.GetOpts()
+			//规则链有多个分支时，结束回调会并发执行，保证同一个exchange的响应处理器串行执行
+			var endLock sync.Mutex
 			//监听结束回调函数
 			endFunc := types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+				endLock.Lock()
+				defer endLock.Unlock()
 				if err != nil {
 					exchange.Out.SetError(err)
-				} else {
-					exchange.Out.SetMsg(&msg)
 				}
+				//记录分支结束的关系类型
+				if msg.Metadata != nil {
+					msg.Metadata.PutValue(endpoint.RelationTypeKey, relationType)
+				}
+				//失败时也设置消息，响应处理器可以通过消息元数据获取分支信息
+				exchange.Out.SetMsg(&msg)
 
 				for _, process := range toFlow.GetProcessList() {
 					if !process(router, exchange) {
@@ -533,13 +548,14 @@ func (ce *ChainExecutor) Execute(ctx context.Context, router endpoint.Router, ex
 					}
 				}
 			})
-			opts = append(opts, types.WithContext(ctx))
 			opts = append(opts, endFunc)
 			if toFlow.IsWait() {
 				//同步
+				opts = append(opts, types.WithContext(ctx))
 				ruleEngine.OnMsgAndWait(*inMsg, opts...)
 			} else {
-				//异步
+				//异步，只传递上下文的值，来源请求结束等取消信号不影响异步处理
+				opts = append(opts, types.WithContext(types.WithoutCancel(ctx)))
 				ruleEngine.OnMsg(*inMsg, opts...)
 			}
 		} else {
@@ -601,6 +617,9 @@ func (ce *ComponentExecutor) Execute(ctx context.Context, router endpoint.Router
 				if err != nil {
 					exchange.Out.SetError(err)
 				} else {
+					if msg.Metadata != nil {
+						msg.Metadata.PutValue(endpoint.RelationTypeKey, relationType)
+					}
 					exchange.Out.SetMsg(&msg)
 				}
 				for _, process := range toFlow.GetProcessList() {
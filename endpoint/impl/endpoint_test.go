@@ -327,6 +327,34 @@ func TestEndpoint(t *testing.T) {
 		time.Sleep(time.Millisecond * 200)
 	})
 
+	//异步执行规则链，来源请求结束取消上下文不影响规则链处理
+	t.Run("ExecuteChainAsyncContextCanceled", func(t *testing.T) {
+		exchange := &endpoint.Exchange{
+			In:  &testRequestMessage{body: []byte("{\"productName\":\"lala\"}")},
+			Out: &testResponseMessage{}}
+		endCh := make(chan error, 1)
+		router2 := NewRouter()
+		router2.From(from).Transform(transformFunc).Process(processFunc).To(toDefault).Process(func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+			select {
+			case endCh <- exchange.Out.GetError():
+			default:
+			}
+			return true
+		})
+		//来源请求已经结束
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if router2.GetFrom().ExecuteProcess(router2, exchange) {
+			router2.GetFrom().GetTo().Execute(ctx, exchange)
+		}
+		select {
+		case err := <-endCh:
+			assert.Nil(t, err)
+		case <-time.After(time.Second * 3):
+			t.Fatal("timeout")
+		}
+	})
+
 	t.Run("ExecuteChainErr", func(t *testing.T) {
 		exchange := &endpoint.Exchange{
 			In:  &testRequestMessage{body: []byte("{\"productName\":\"lala\"}")},
@@ -424,6 +452,31 @@ func TestEndpoint(t *testing.T) {
 		executeRouterTest(router2, exchange)
 	})
 
+	t.Run("DoProcessOnDone", func(t *testing.T) {
+		exchange := &endpoint.Exchange{
+			In:  &testRequestMessage{body: []byte("{\"productName\":\"lala\"}")},
+			Out: &testResponseMessage{}}
+		var doneList []string
+		router := NewRouter(endpoint.RouterOptions.WithRuleConfig(config), endpoint.RouterOptions.WithRuleGo(engine.DefaultPool)).From(from).
+			Process(func(router endpoint.Router, exchange *endpoint.Exchange) bool {
+				exchange.OnDone(func() {
+					doneList = append(doneList, "first")
+				})
+				exchange.OnDone(func() {
+					doneList = append(doneList, "second")
+				})
+				//中断处理，仍然执行OnDone
+				return false
+			}).
+			To("chain:${chainId}").End()
+		testEp := &testEndpoint{}
+		testEp.DoProcess(context.Background(), router, exchange)
+		assert.Equal(t, "second,first", strings.Join(doneList, ","))
+		//只执行一次
+		exchange.Done()
+		assert.Equal(t, 2, len(doneList))
+	})
+
 	t.Run("DoProcessContextIsNil", func(t *testing.T) {
 		defer func() {
 			if caught := recover(); caught != nil {
@@ -18,6 +18,8 @@ package rest
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"github.com/julienschmidt/httprouter"
@@ -30,6 +32,7 @@ import (
 	"net"
 	"net/http"
 	"net/textproto"
+	"os"
 	"strings"
 )
 
@@ -130,6 +133,14 @@ func (r *RequestMessage) Request() *http.Request {
 	return r.request
 }
 
+// TLSConnectionState 获取TLS连接状态，非TLS请求返回nil
+func (r *RequestMessage) TLSConnectionState() *tls.ConnectionState {
+	if r.request == nil {
+		return nil
+	}
+	return r.request.TLS
+}
+
 // ResponseMessage http响应消息
 type ResponseMessage struct {
 	request  *http.Request
@@ -138,6 +149,8 @@ type ResponseMessage struct {
 	to       string
 	msg      *types.RuleMsg
 	err      error
+	//statusCode 已经发送的响应码
+	statusCode int
 }
 
 func (r *ResponseMessage) Body() []byte {
@@ -173,11 +186,23 @@ func (r *ResponseMessage) GetMsg() *types.RuleMsg {
 }
 
 func (r *ResponseMessage) SetStatusCode(statusCode int) {
+	//只有第一次设置的响应码会发送给客户端
+	if r.statusCode == 0 {
+		r.statusCode = statusCode
+	}
 	if r.response != nil {
 		r.response.WriteHeader(statusCode)
 	}
 }
 
+// StatusCode 获取发送给客户端的响应码，没有设置则为默认的200
+func (r *ResponseMessage) StatusCode() int {
+	if r.statusCode == 0 {
+		return http.StatusOK
+	}
+	return r.statusCode
+}
+
 func (r *ResponseMessage) SetBody(body []byte) {
 	r.body = body
 	if r.response != nil {
@@ -202,6 +227,9 @@ type Config struct {
 	Server      string
 	CertFile    string
 	CertKeyFile string
+	//ClientCaFile 客户端证书CA文件，配置后如果客户端提供证书则校验，
+	//并通过RequestMessage.TLSConnectionState()获取已校验的客户端证书
+	ClientCaFile string
 }
 
 // Rest 接收端端点
@@ -288,11 +316,18 @@ func (rest *Rest) Start() error {
 	}
 	var err error
 	rest.Server = &http.Server{Addr: rest.Config.Server, Handler: rest.router}
+	isTls := rest.Config.CertKeyFile != "" && rest.Config.CertFile != ""
+	if isTls && rest.Config.ClientCaFile != "" {
+		if tlsConfig, err := rest.clientAuthTLSConfig(); err != nil {
+			return err
+		} else {
+			rest.Server.TLSConfig = tlsConfig
+		}
+	}
 	ln, err := rest.Listen()
 	if err != nil {
 		return err
 	}
-	isTls := rest.Config.CertKeyFile != "" && rest.Config.CertFile != ""
 	if rest.OnEvent != nil {
 		rest.OnEvent(endpoint.EventInitServer, rest)
 	}
@@ -318,6 +353,22 @@ func (rest *Rest) Start() error {
 	return err
 }
 
+// clientAuthTLSConfig 创建校验客户端证书的TLS配置
+func (rest *Rest) clientAuthTLSConfig() (*tls.Config, error) {
+	caCert, err := os.ReadFile(rest.Config.ClientCaFile)
+	if err != nil {
+		return nil, err
+	}
+	caPool := x509.NewCertPool()
+	if !caPool.AppendCertsFromPEM(caCert) {
+		return nil, fmt.Errorf("invalid client ca file: %s", rest.Config.ClientCaFile)
+	}
+	return &tls.Config{
+		ClientCAs:  caPool,
+		ClientAuth: tls.VerifyClientCertIfGiven,
+	}, nil
+}
+
 func (rest *Rest) Listen() (net.Listener, error) {
 	addr := rest.Server.Addr
 	if addr == "" {
@@ -18,6 +18,7 @@ package websocket
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
@@ -124,6 +125,14 @@ func (r *RequestMessage) Request() *http.Request {
 	return r.request
 }
 
+// TLSConnectionState 获取TLS连接状态，非TLS请求返回nil
+func (r *RequestMessage) TLSConnectionState() *tls.ConnectionState {
+	if r.request == nil {
+		return nil
+	}
+	return r.request.TLS
+}
+
 // ResponseMessage websocket响应消息
 type ResponseMessage struct {
 	headers textproto.MIMEHeader
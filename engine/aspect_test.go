@@ -17,10 +17,14 @@
 package engine
 
 import (
+	"errors"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/builtin/aspect"
 	"github.com/rulego/rulego/test/assert"
 	"github.com/rulego/rulego/utils/str"
+	"strconv"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -124,6 +128,48 @@ func TestAspectOrder(t *testing.T) {
 	assert.Equal(t, 3, before[0].Order())
 }
 
+func TestAspectComposition(t *testing.T) {
+	//排序不修改原列表，Order相同保持注册顺序
+	var events []string
+	var lock sync.Mutex
+	record := func(event string) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}
+	newAspect := func(name string, order int, stop bool) *OrderTestAspect {
+		return &OrderTestAspect{Name: name, OrderValue: order, Stop: stop, Record: record}
+	}
+	aspects := types.AspectList{newAspect("a", 2, false), newAspect("b", 1, false), newAspect("c", 1, false)}
+	around, _, _ := aspects.GetNodeAspects()
+	assert.Equal(t, "b", around[0].(*OrderTestAspect).Name)
+	assert.Equal(t, "c", around[1].(*OrderTestAspect).Name)
+	assert.Equal(t, "a", around[2].(*OrderTestAspect).Name)
+	assert.Equal(t, "a", aspects[0].(*OrderTestAspect).Name)
+	assert.Equal(t, "b", aspects.Sorted()[0].(*OrderTestAspect).Name)
+
+	def := []byte(`{"ruleChain":{"id":"testAspectComposition"},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, types.WithAspects(aspects...))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	run := func() string {
+		events = nil
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"))
+		lock.Lock()
+		defer lock.Unlock()
+		return strings.Join(events, ",")
+	}
+	//Order值最小的在最外层：环绕和拦截前置从外到内，拦截后置从内到外
+	assert.Equal(t, "around:b,around:c,around:a,before:b,before:c,before:a,after:a,after:c,after:b", run())
+
+	//运行时替换切面列表后同样生效，外层环绕切面短路后不再执行内层环绕切面和节点，节点输出仍然经过拦截后置
+	chainCtx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	chainCtx.SetAspects(types.AspectList{newAspect("x", 5, false), newAspect("y", 3, true), newAspect("z", 3, false)})
+	assert.Equal(t, "around:y,after:x,after:z,after:y", run())
+	chainCtx.SetAspects(types.AspectList{newAspect("x", 5, false), newAspect("z", 3, false), newAspect("y", 3, false)})
+	assert.Equal(t, "around:z,around:y,around:x,before:z,before:y,before:x,after:x,after:y,after:z", run())
+}
+
 func TestEngineAspect(t *testing.T) {
 	chainId := "test01"
 	var count int32
@@ -219,6 +265,172 @@ func TestChainAspect(t *testing.T) {
 	time.Sleep(time.Millisecond * 200)
 }
 
+func TestAddRemoveAspect(t *testing.T) {
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	aspectCount := len(ruleEngine.RootRuleChainCtx().(*RuleChainCtx).GetAspects())
+
+	newMsg := func() types.RuleMsg {
+		return types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "{\"temperature\":41}")
+	}
+	var values []string
+	onEnd := types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		values = append(values, msg.Metadata.GetValue("key1")+","+msg.Metadata.GetValue("key2"))
+	})
+	ruleEngine.OnMsgAndWait(newMsg(), onEnd)
+
+	ruleEngine.(*RuleEngine).AddAspect(&ChainAspect{}, &NodeAspect1{})
+	assert.Equal(t, aspectCount+2, len(ruleEngine.RootRuleChainCtx().(*RuleChainCtx).GetAspects()))
+	ruleEngine.OnMsgAndWait(newMsg(), onEnd)
+
+	removed := ruleEngine.(*RuleEngine).RemoveAspect(func(aspect types.Aspect) bool {
+		_, ok := aspect.(*ChainAspect)
+		return ok
+	})
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, aspectCount+1, len(ruleEngine.RootRuleChainCtx().(*RuleChainCtx).GetAspects()))
+	ruleEngine.OnMsgAndWait(newMsg(), onEnd)
+
+	assert.Equal(t, ",|addValueOnStart,addValueOnEnd|,", strings.Join(values, "|"))
+
+	//重新加载后保留动态增加的切面
+	err = ruleEngine.Reload()
+	assert.Nil(t, err)
+	assert.Equal(t, aspectCount+1, len(ruleEngine.RootRuleChainCtx().(*RuleChainCtx).GetAspects()))
+
+	//重新加载后动态增加和删除的节点切面对新的消息生效
+	chainCtx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	counter := &beforeCountAspect{}
+	chainCtx.AddAspect(counter)
+	ruleEngine.OnMsgAndWait(newMsg())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&counter.count))
+	chainCtx.RemoveAspect(func(aspect types.Aspect) bool {
+		return aspect == counter
+	})
+	ruleEngine.OnMsgAndWait(newMsg())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&counter.count))
+}
+
+// beforeCountAspect 记录节点执行次数的切面
+type beforeCountAspect struct {
+	count int32
+}
+
+func (aspect *beforeCountAspect) Order() int {
+	return 10
+}
+
+func (aspect *beforeCountAspect) New() types.Aspect {
+	return aspect
+}
+
+func (aspect *beforeCountAspect) PointCut(ctx types.RuleContext, msg types.RuleMsg, relationType string) bool {
+	return true
+}
+
+func (aspect *beforeCountAspect) Before(ctx types.RuleContext, msg types.RuleMsg, relationType string) types.RuleMsg {
+	atomic.AddInt32(&aspect.count, 1)
+	return msg
+}
+
+func TestInterceptAspect(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testInterceptAspect"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata.s2='1';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s3","type":"jsTransform","configuration":{"jsScript":"metadata.s3='1';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"},{"fromId":"s1","toId":"s3","type":"False"}]}}`)
+	run := func(interceptAspect types.Aspect) (types.RuleMsg, error, string) {
+		ruleEngine, err := New(str.RandomStr(10), def, types.WithAspects(interceptAspect))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		var endMsg types.RuleMsg
+		var endErr error
+		var endRelation string
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			endMsg, endErr, endRelation = msg, err, relationType
+		}))
+		return endMsg, endErr, endRelation
+	}
+
+	//跳过节点，默认通过Success关系路由，消息可以被修改
+	msg, err, relationType := run(&InterceptTestAspect{Skip: "s2"})
+	assert.Nil(t, err)
+	assert.Equal(t, types.Success, relationType)
+	assert.Equal(t, "", msg.Metadata.GetValue("s2"))
+	assert.Equal(t, "s2", msg.Metadata.GetValue("skipped"))
+
+	//跳过节点，通过指定的关系和错误路由
+	injectErr := errors.New("injected error")
+	msg, err, relationType = run(&InterceptSkipTestAspect{InterceptTestAspect{Skip: "s2"}, injectErr})
+	assert.Equal(t, injectErr, err)
+	assert.Equal(t, types.Failure, relationType)
+	assert.Equal(t, "", msg.Metadata.GetValue("s2"))
+
+	//改写节点输出的关系
+	msg, err, relationType = run(&InterceptTestAspect{RewriteNodeId: "s1", RewriteRelation: types.False})
+	assert.Nil(t, err)
+	assert.Equal(t, types.Success, relationType)
+	assert.Equal(t, "", msg.Metadata.GetValue("s2"))
+	assert.Equal(t, "1", msg.Metadata.GetValue("s3"))
+
+	//不拦截
+	msg, _, _ = run(&InterceptTestAspect{})
+	assert.Equal(t, "1", msg.Metadata.GetValue("s2"))
+}
+
+func TestMetricsAspect(t *testing.T) {
+	metrics := &aspect.Metrics{}
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile), types.WithAspects(metrics))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	newMsg := func(temperature int) types.RuleMsg {
+		return types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "{\"temperature\":"+strconv.Itoa(temperature)+"}")
+	}
+	ruleEngine.OnMsgAndWait(newMsg(41))
+	a := metrics.MetricsSnapshot()
+	assert.Equal(t, int64(1), a.Chains[ruleEngine.Id()].Messages)
+	assert.Equal(t, int64(1), a.Chains[ruleEngine.Id()].Nodes["s2"].Messages)
+
+	ruleEngine.OnMsgAndWait(newMsg(41))
+	ruleEngine.OnMsgAndWait(newMsg(5))
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "null"))
+	b := metrics.MetricsSnapshot()
+	//快照是副本，不受后续统计影响
+	assert.Equal(t, int64(1), a.Chains[ruleEngine.Id()].Messages)
+
+	diff := aspect.DiffMetrics(a, b)
+	assert.Equal(t, a.Time, diff.From)
+	chainDiff := diff.Chains[ruleEngine.Id()]
+	assert.Equal(t, int64(3), chainDiff.Messages)
+	assert.Equal(t, int64(3), chainDiff.Completed)
+	assert.Equal(t, int64(1), chainDiff.Errors)
+	assert.Equal(t, int64(3), chainDiff.Nodes["s1"].Messages)
+	assert.Equal(t, int64(3), chainDiff.Nodes["s1"].Completed)
+	assert.Equal(t, int64(1), chainDiff.Nodes["s1"].Errors)
+	assert.Equal(t, int64(1), chainDiff.Nodes["s2"].Messages)
+	assert.True(t, chainDiff.Nodes["s1"].AvgLatency > 0)
+
+	//同一消息通过多个分支同时进入同一节点，分别统计耗时
+	_ = Registry.Register(&slowNode{})
+	def := []byte(`{"ruleChain":{"id":"testMetricsAspectJoin"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s3","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s4","type":"test/slow"}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s1","toId":"s3","type":"Success"},` +
+		`{"fromId":"s2","toId":"s4","type":"Success"},{"fromId":"s3","toId":"s4","type":"Success"}]}}`)
+	joinMetrics := &aspect.Metrics{}
+	joinEngine, err := New(str.RandomStr(10), def, types.WithAspects(joinMetrics))
+	assert.Nil(t, err)
+	defer Del(joinEngine.Id())
+	joinEngine.OnMsgAndWait(newMsg(41))
+	s4 := joinMetrics.MetricsSnapshot().Chains[joinEngine.Id()].Nodes["s4"]
+	assert.Equal(t, int64(2), s4.Messages)
+	assert.Equal(t, int64(2), s4.Completed)
+}
+
 type CallbackTest struct {
 	OnCreated   func(ctx types.NodeCtx)
 	OnReload    func(parentCtx types.NodeCtx, ctx types.NodeCtx, err error)
@@ -342,3 +554,91 @@ func (aspect *NodeAspect2) Before(ctx types.RuleContext, msg types.RuleMsg, rela
 func (aspect *NodeAspect2) Around(ctx types.RuleContext, msg types.RuleMsg, relationType string) (types.RuleMsg, bool) {
 	return msg, true
 }
+
+// InterceptTestAspect 跳过指定节点或者改写指定节点输出关系的拦截切面
+type InterceptTestAspect struct {
+	Skip            string
+	RewriteNodeId   string
+	RewriteRelation string
+}
+
+func (aspect *InterceptTestAspect) Order() int {
+	return 5
+}
+
+func (aspect *InterceptTestAspect) New() types.Aspect {
+	return &InterceptTestAspect{Skip: aspect.Skip, RewriteNodeId: aspect.RewriteNodeId, RewriteRelation: aspect.RewriteRelation}
+}
+
+func (aspect *InterceptTestAspect) PointCut(ctx types.RuleContext, msg types.RuleMsg, relationType string) bool {
+	return true
+}
+
+func (aspect *InterceptTestAspect) InterceptBefore(nodeCtx types.NodeCtx, ctx types.RuleContext, msg types.RuleMsg, relationType string) (types.RuleMsg, bool) {
+	if nodeCtx.GetNodeId().Id != aspect.Skip {
+		return msg, false
+	}
+	msg.Metadata.PutValue("skipped", nodeCtx.GetNodeId().Id)
+	return msg, true
+}
+
+func (aspect *InterceptTestAspect) InterceptAfter(nodeCtx types.NodeCtx, ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) (types.RuleMsg, string) {
+	if nodeCtx.GetNodeId().Id == aspect.RewriteNodeId {
+		return msg, aspect.RewriteRelation
+	}
+	return msg, relationType
+}
+
+// InterceptSkipTestAspect 跳过节点后通过Failure关系路由的拦截切面
+type InterceptSkipTestAspect struct {
+	InterceptTestAspect
+	Err error
+}
+
+func (aspect *InterceptSkipTestAspect) New() types.Aspect {
+	return &InterceptSkipTestAspect{InterceptTestAspect: aspect.InterceptTestAspect, Err: aspect.Err}
+}
+
+func (aspect *InterceptSkipTestAspect) SkipRelation(nodeCtx types.NodeCtx, ctx types.RuleContext, msg types.RuleMsg) (string, error) {
+	return types.Failure, aspect.Err
+}
+
+// OrderTestAspect 记录环绕和拦截增强点执行顺序的切面
+type OrderTestAspect struct {
+	Name       string
+	OrderValue int
+	//Stop 环绕增强点是否直接通知下一个节点，不再执行节点和内层切面
+	Stop   bool
+	Record func(event string)
+}
+
+func (aspect *OrderTestAspect) Order() int {
+	return aspect.OrderValue
+}
+
+func (aspect *OrderTestAspect) New() types.Aspect {
+	return &OrderTestAspect{Name: aspect.Name, OrderValue: aspect.OrderValue, Stop: aspect.Stop, Record: aspect.Record}
+}
+
+func (aspect *OrderTestAspect) PointCut(ctx types.RuleContext, msg types.RuleMsg, relationType string) bool {
+	return true
+}
+
+func (aspect *OrderTestAspect) Around(ctx types.RuleContext, msg types.RuleMsg, relationType string) (types.RuleMsg, bool) {
+	aspect.Record("around:" + aspect.Name)
+	if aspect.Stop {
+		ctx.TellSuccess(msg)
+		return msg, false
+	}
+	return msg, true
+}
+
+func (aspect *OrderTestAspect) InterceptBefore(nodeCtx types.NodeCtx, ctx types.RuleContext, msg types.RuleMsg, relationType string) (types.RuleMsg, bool) {
+	aspect.Record("before:" + aspect.Name)
+	return msg, false
+}
+
+func (aspect *OrderTestAspect) InterceptAfter(nodeCtx types.NodeCtx, ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) (types.RuleMsg, string) {
+	aspect.Record("after:" + aspect.Name)
+	return msg, relationType
+}
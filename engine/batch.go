@@ -0,0 +1,200 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// BatchMsgResult 批量处理中一条消息的执行结果
+type BatchMsgResult struct {
+	//MsgId 消息ID
+	MsgId string
+	//Msg 最后一个结束分支的消息
+	Msg types.RuleMsg
+	//Err 第一个出错分支的错误，nil表示所有分支都执行成功
+	Err error
+	//Ends 结束的分支数
+	Ends int
+}
+
+// BatchResult 批量处理的执行结果
+type BatchResult struct {
+	//Results 每条消息的执行结果，与提交的消息顺序一致
+	Results []BatchMsgResult
+	//Succeeded 所有分支都执行成功的消息数
+	Succeeded int
+	//Failed 至少一个分支出错的消息数
+	Failed int
+}
+
+// WithOnBatchEnd 批量处理的所有消息执行完成后回调一次，参考 RuleEngine.OnMsgBatch
+// 单条消息使用该选项没有任何效果
+func WithOnBatchEnd(onBatchEnd func(result BatchResult)) types.RuleContextOption {
+	return func(rc types.RuleContext) {
+		if ctx, ok := rc.(*DefaultRuleContext); ok {
+			ctx.onBatchEnd = onBatchEnd
+		}
+	}
+}
+
+// withInlineFirst 在当前协程执行第一个节点，批量处理已经在协程池任务中执行，不需要再提交任务
+func withInlineFirst() types.RuleContextOption {
+	return func(rc types.RuleContext) {
+		if ctx, ok := rc.(*DefaultRuleContext); ok {
+			ctx.inlineFirst = true
+		}
+	}
+}
+
+// msgBatch 批量处理的消息，收集每条消息的执行结果
+type msgBatch struct {
+	results    []BatchMsgResult
+	remaining  int32
+	onBatchEnd func(result BatchResult)
+	done       chan struct{}
+	lock       sync.Mutex
+}
+
+func newMsgBatch(msgs []types.RuleMsg, onBatchEnd func(result BatchResult)) *msgBatch {
+	b := &msgBatch{
+		results:    make([]BatchMsgResult, len(msgs)),
+		remaining:  int32(len(msgs)),
+		onBatchEnd: onBatchEnd,
+		done:       make(chan struct{}),
+	}
+	for i, msg := range msgs {
+		b.results[i].MsgId = msg.Id
+	}
+	return b
+}
+
+// track 记录第index条消息每个分支的结束和所有节点执行完成
+func (b *msgBatch) track(index int) types.RuleContextOption {
+	return func(rc types.RuleContext) {
+		ctx, ok := rc.(*DefaultRuleContext)
+		if !ok {
+			return
+		}
+		onEnd := ctx.onEnd
+		ctx.onEnd = func(c types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			b.end(index, msg, err)
+			if onEnd != nil {
+				onEnd(c, msg, err, relationType)
+			}
+		}
+		onAllNodeCompleted := ctx.onAllNodeCompleted
+		ctx.onAllNodeCompleted = func() {
+			if onAllNodeCompleted != nil {
+				onAllNodeCompleted()
+			}
+			b.complete()
+		}
+	}
+}
+
+func (b *msgBatch) end(index int, msg types.RuleMsg, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	result := &b.results[index]
+	result.Msg = msg
+	result.Ends++
+	if err != nil && result.Err == nil {
+		result.Err = err
+	}
+}
+
+// complete 一条消息所有节点执行完成，所有消息都完成后回调批量处理结果
+func (b *msgBatch) complete() {
+	if atomic.AddInt32(&b.remaining, -1) != 0 {
+		return
+	}
+	if b.onBatchEnd != nil {
+		b.onBatchEnd(b.result())
+	}
+	close(b.done)
+}
+
+func (b *msgBatch) result() BatchResult {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	result := BatchResult{Results: b.results}
+	for _, item := range b.results {
+		if item.Err == nil {
+			result.Succeeded++
+		} else {
+			result.Failed++
+		}
+	}
+	return result
+}
+
+// OnMsgBatch 批量把消息交给规则引擎处理，异步执行
+// 消息按照CPU核数分成多组，每组只提交一个协程池任务，按顺序在该任务中执行每条消息的第一个节点，后续节点和单条消息一样并发执行
+// 每条消息相互独立，使用各自的上下文，一条消息出错不影响其他消息。opts 对每条消息生效，
+// 可以通过 WithOnBatchEnd 获取所有消息执行完成后的每条消息结果和汇总结果
+func (e *RuleEngine) OnMsgBatch(msgs []types.RuleMsg, opts ...types.RuleContextOption) {
+	e.onMsgBatch(msgs, opts...)
+}
+
+// OnMsgBatchAndWait 批量把消息交给规则引擎处理，同步执行，所有消息的所有节点执行完成后返回执行结果，参考 OnMsgBatch
+func (e *RuleEngine) OnMsgBatchAndWait(msgs []types.RuleMsg, opts ...types.RuleContextOption) BatchResult {
+	batch := e.onMsgBatch(msgs, opts...)
+	<-batch.done
+	return batch.result()
+}
+
+func (e *RuleEngine) onMsgBatch(msgs []types.RuleMsg, opts ...types.RuleContextOption) *msgBatch {
+	//获取批量处理完成回调
+	probe := &DefaultRuleContext{}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	batch := newMsgBatch(msgs, probe.onBatchEnd)
+	if len(msgs) == 0 {
+		batch.remaining = 1
+		batch.complete()
+		return batch
+	}
+	size := (len(msgs) + runtime.GOMAXPROCS(0) - 1) / runtime.GOMAXPROCS(0)
+	for start := 0; start < len(msgs); start += size {
+		end := start + size
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		offset, chunk := start, msgs[start:end]
+		task := func() {
+			for i, msg := range chunk {
+				itemOpts := append(opts[:len(opts):len(opts)], batch.track(offset+i), withInlineFirst())
+				e.onMsgAndWait(msg, false, itemOpts...)
+			}
+		}
+		if pool := e.Config.Pool; pool != nil {
+			if err := pool.Submit(task); err != nil {
+				//协程池已满，在当前协程执行
+				task()
+			}
+		} else {
+			go task()
+		}
+	}
+	return batch
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// MsgCanceledError 消息被取消，停止执行后续节点，参考 RuleEngine.CancelMsg
+type MsgCanceledError struct {
+	//NodeId 停止执行的节点ID，该节点没有执行
+	NodeId string
+}
+
+func (e *MsgCanceledError) Error() string {
+	return fmt.Sprintf("message canceled, stopped before node %s: %s", e.NodeId, context.Canceled)
+}
+
+// Unwrap 可以通过 errors.Is(err, context.Canceled) 判断
+func (e *MsgCanceledError) Unwrap() error {
+	return context.Canceled
+}
+
+// WithCancelFunc 获取取消当前消息的函数，消息开始执行前回调，与 RuleEngine.CancelMsg 取消该消息效果相同
+// 消息执行完成后调用取消函数没有任何效果
+func WithCancelFunc(f func(cancel context.CancelFunc)) types.RuleContextOption {
+	return func(rc types.RuleContext) {
+		if ctx, ok := rc.(*DefaultRuleContext); ok {
+			ctx.onCancelFunc = f
+		}
+	}
+}
+
+// activeMsg 正在执行的消息
+type activeMsg struct {
+	msgId  string
+	cancel context.CancelFunc
+}
+
+// activeMsgs 正在执行的消息，key:消息ID，相同ID的消息可能同时执行
+type activeMsgs struct {
+	lock  sync.Mutex
+	items map[string][]*activeMsg
+}
+
+// add 为消息上下文创建可以取消的上下文，并记录为正在执行的消息，返回的函数在消息执行完成后调用
+func (a *activeMsgs) add(ctx *DefaultRuleContext, msgId string) func() {
+	parent := ctx.GetContext()
+	if parent == nil {
+		parent = context.Background()
+	}
+	c, cancel := context.WithCancel(parent)
+	ctx.context = c
+	item := &activeMsg{msgId: msgId, cancel: cancel}
+	a.lock.Lock()
+	if a.items == nil {
+		a.items = make(map[string][]*activeMsg)
+	}
+	a.items[msgId] = append(a.items[msgId], item)
+	a.lock.Unlock()
+	if ctx.onCancelFunc != nil {
+		ctx.onCancelFunc(cancel)
+	}
+	return func() {
+		a.remove(item)
+		cancel()
+	}
+}
+
+func (a *activeMsgs) remove(item *activeMsg) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	items := a.items[item.msgId]
+	for i, v := range items {
+		if v == item {
+			items = append(items[:i], items[i+1:]...)
+			break
+		}
+	}
+	if len(items) == 0 {
+		delete(a.items, item.msgId)
+	} else {
+		a.items[item.msgId] = items
+	}
+}
+
+// cancel 取消指定ID所有正在执行的消息，返回取消的消息数量
+func (a *activeMsgs) cancel(msgId string) int {
+	a.lock.Lock()
+	items := append([]*activeMsg(nil), a.items[msgId]...)
+	a.lock.Unlock()
+	for _, item := range items {
+		item.cancel()
+	}
+	return len(items)
+}
+
+// count 正在执行的消息数量
+func (a *activeMsgs) count() int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	var n int
+	for _, items := range a.items {
+		n += len(items)
+	}
+	return n
+}
+
+// CancelMsg 取消正在执行的消息，正在执行的节点通过 RuleContext.GetContext 感知取消，
+// 后续节点不再执行，通过OnEnd回调 MsgCanceledError，包含停止执行的节点ID
+// 返回是否找到正在执行的消息，相同ID的消息同时执行时全部取消
+func (e *RuleEngine) CancelMsg(msgId string) bool {
+	return e.activeMsgs.cancel(msgId) > 0
+}
@@ -18,13 +18,37 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/utils/aes"
+	"github.com/rulego/rulego/utils/dsl"
 	"github.com/rulego/rulego/utils/str"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// ErrEmptyRuleChain 规则链没有任何节点
+var ErrEmptyRuleChain = errors.New("the rule chain has no nodes")
+
+// ErrRuleChainStopped 规则链正在优雅关闭或者已经关闭，不再接收新的消息
+var ErrRuleChainStopped = errors.New("the rule chain is stopped")
+
+// ErrRuleChainCycle 规则链节点连接存在环路
+var ErrRuleChainCycle = errors.New("the rule chain connections contain a cycle")
+
+// TagsKey 规则链标签key，可以通过规则链定义的additionalInfo配置，多个标签使用逗号分隔，例如：
+//
+//	"additionalInfo": {"tags": "env:prod,team:iot"}
+//
+// 也可以通过SetAttribute(TagsKey, []string{"team:iot"})附加标签，附加的标签在重新加载后保留
+const TagsKey = "tags"
+
 type RelationCache struct {
 	//入接点
 	inNodeId types.RuleNodeId
@@ -32,6 +56,24 @@ type RelationCache struct {
 	relationType string
 }
 
+// dslCache 规则链定义编码结果缓存
+type dslCache struct {
+	//缓存对应的定义版本
+	version uint64
+	dsl     []byte
+}
+
+// routeEntry 路由表项
+type routeEntry struct {
+	//出节点列表
+	nodes []types.NodeCtx
+	//是否包含子规则链，子规则链需要通过规则链池查找，使用 relationCache 延迟解析
+	hasSubChain bool
+}
+
+// routingTable 通过入节点查询指定关系出节点列表的路由表，初始化时一次性构建，只读，路由变化时整体替换
+type routingTable map[RelationCache]routeEntry
+
 // RuleChainCtx 规则链实例定义
 // 初始化所有节点
 // 记录规则链，所有节点路由关系
@@ -50,17 +92,28 @@ type RuleChainCtx struct {
 	nodeIds []types.RuleNodeId
 	//组件列表
 	nodes map[types.RuleNodeId]types.NodeCtx
+	//组件列表只读快照，类型：map[types.RuleNodeId]types.NodeCtx
+	//只在初始化和Copy时整体替换，GetNodeById读取节点不需要加锁
+	nodesSnapshot atomic.Value
 	//组件路由关系
-	nodeRoutes    map[types.RuleNodeId][]types.RuleNodeRelation
+	nodeRoutes map[types.RuleNodeId][]types.RuleNodeRelation
+	//反向路由关系，key:下一个节点或者子规则链ID，value:连接到该节点的关系，随nodeRoutes一起重建
+	parentRoutes  map[types.RuleNodeId][]types.RuleNodeRelation
 	nodeCtxRoutes map[types.RuleNodeId][]types.NodeCtx
-	//通过入节点查询指定关系出节点列表缓存
+	//路由表，类型：routingTable，读取不需要加锁
+	routingTable atomic.Value
+	//包含子规则链的路由缓存，子规则链通过规则链池延迟解析
 	relationCache map[RelationCache][]types.NodeCtx
 	//根上下文
 	rootRuleContext types.RuleContext
+	//命名入口的根上下文，key:入口名称
+	entryRuleContexts map[string]types.RuleContext
 	//子规则链池
 	ruleChainPool types.RuleEnginePool
 	//切面
 	aspects types.AspectList
+	//切面读写锁，保证并发处理的消息获取到一致的切面列表
+	aspectsLock sync.RWMutex
 	//重新加载增强点切面
 	reloadAspects []types.OnReloadAspect
 	//销毁增强点切面
@@ -71,11 +124,53 @@ type RuleChainCtx struct {
 	decryptSecrets map[string]string
 	//是否没有任何节点
 	isEmpty bool
+	//是否正在重新加载 1:是 0:否
+	reloading int32
+	//是否正在优雅关闭 1:是 0:否，优雅关闭开始后不再接收新的消息
+	stopping int32
+	//是否已经启动 1:是 0:否，参考 Start
+	started int32
+	//宿主程序附加的属性，与规则链定义和消息元数据无关，重新加载后保留，销毁时清空
+	attributes sync.Map
+	//规则链定义配置的标签
+	tags []string
+	//销毁时执行的清理回调函数，属于当前的节点实例，重新加载时随节点实例一起替换
+	cleanups *cleanupList
+	//当前节点实例上正在执行的消息计数器，类型：*inflightCounter，重新加载时替换
+	inflight atomic.Value
+	//执行事件订阅者
+	subscribers map[*Subscription]struct{}
+	//订阅者数量，没有订阅者时不创建事件
+	subscriberCount int32
+	subscribersLock sync.RWMutex
+	//重新加载前的历史定义，重新加载后保留
+	versions versionHistory
+	//规则链定义版本，定义变化时递增，用于判断DSL缓存是否有效
+	dslVersion uint64
+	//DSL缓存，类型：dslCache
+	dslCache atomic.Value
+	//只读快照缓存，类型：snapshotCache，与DSL缓存使用相同的定义版本
+	snapshotCache atomic.Value
+	//消息计数器，重新加载时共享给新的规则链实例，ReloadChild保留，ReloadSelf重置
+	stats *chainStats
+	//运行时设置的调试模式，重新加载时共享给新的规则链实例，ReloadChild保留，ReloadSelf重置
+	debug *debugSwitch
+	//调试回调采样器，类型：*debugSampler，读取不需要加锁，重新加载规则链时替换
+	debugSampler atomic.Value
+	//协程池溢出策略，类型：*poolOverflow，读取不需要加锁，重新加载规则链时替换
+	poolOverflow atomic.Value
+	//优先级调度器，类型：*priorityDispatcher，读取不需要加锁，重新加载规则链时替换
+	priorityDispatcher atomic.Value
 	sync.RWMutex
 }
 
 // InitRuleChainCtx 初始化RuleChainCtx
 func InitRuleChainCtx(config types.Config, aspects types.AspectList, ruleChainDef *types.RuleChain) (*RuleChainCtx, error) {
+	return initRuleChainCtx(config, aspects, ruleChainDef, nil)
+}
+
+// initRuleChainCtx 初始化RuleChainCtx，reuse 返回可以复用的节点实例，复用的节点不重新初始化
+func initRuleChainCtx(config types.Config, aspects types.AspectList, ruleChainDef *types.RuleChain, reuse map[types.RuleNodeId]types.NodeCtx) (*RuleChainCtx, error) {
 	var ruleChainCtx = &RuleChainCtx{
 		config:             config,
 		SelfDefinition:     ruleChainDef,
@@ -85,10 +180,22 @@ func InitRuleChainCtx(config types.Config, aspects types.AspectList, ruleChainDe
 		componentsRegistry: config.ComponentsRegistry,
 		initialized:        true,
 		aspects:            aspects,
+		cleanups:           &cleanupList{},
+		stats:              &chainStats{},
+		debug:              &debugSwitch{},
 	}
+	ruleChainCtx.inflight.Store(&inflightCounter{})
+	ruleChainCtx.stats.reset()
+	ruleChainCtx.debugSampler.Store(newDebugSampler(config, ruleChainDef))
+	overflow := newPoolOverflow(config)
+	ruleChainCtx.poolOverflow.Store(overflow)
+	ruleChainCtx.priorityDispatcher.Store(newPriorityDispatcher(config, overflow))
 	if ruleChainDef.RuleChain.ID != "" {
 		ruleChainCtx.Id = types.RuleNodeId{Id: ruleChainDef.RuleChain.ID, Type: types.CHAIN}
 	}
+	if v, ok := ruleChainDef.RuleChain.GetAdditionalInfo(TagsKey); ok {
+		ruleChainCtx.tags = splitTags(v)
+	}
 	//处理规则链配置的vars和secrets
 	if ruleChainDef != nil && ruleChainDef.RuleChain.Configuration != nil {
 		varsConfig := ruleChainDef.RuleChain.Configuration[types.Vars]
@@ -96,21 +203,27 @@ func InitRuleChainCtx(config types.Config, aspects types.AspectList, ruleChainDe
 		envConfig := ruleChainDef.RuleChain.Configuration[types.Secrets]
 		secrets := str.ToStringMapString(envConfig)
 		ruleChainCtx.decryptSecrets = decryptSecret(secrets, []byte(config.SecretKey))
+		if missing := missingSecrets(ruleChainDef.RuleChain.Configuration[types.RequiredSecrets], secrets); len(missing) > 0 {
+			return nil, fmt.Errorf("required secrets not found: %s", strings.Join(missing, ","))
+		}
 	}
 	nodeLen := len(ruleChainDef.Metadata.Nodes)
 	ruleChainCtx.nodeIds = make([]types.RuleNodeId, nodeLen)
-	//加载所有节点信息
+	//分配节点ID，并检查节点ID是否重复，包括与自动分配的节点ID重复
+	var nodeTypes = make(map[string]string, nodeLen)
 	for index, item := range ruleChainDef.Metadata.Nodes {
 		if item.Id == "" {
 			item.Id = fmt.Sprintf(defaultNodeIdPrefix+"%d", index)
 		}
-		ruleNodeId := types.RuleNodeId{Id: item.Id, Type: types.NODE}
-		ruleChainCtx.nodeIds[index] = ruleNodeId
-		ruleNodeCtx, err := InitRuleNodeCtx(config, ruleChainCtx, item)
-		if err != nil {
-			return nil, err
+		if nodeType, ok := nodeTypes[item.Id]; ok {
+			return nil, fmt.Errorf("duplicate node id %s: node types %s and %s", item.Id, nodeType, item.Type)
 		}
-		ruleChainCtx.nodes[ruleNodeId] = ruleNodeCtx
+		nodeTypes[item.Id] = item.Type
+		ruleChainCtx.nodeIds[index] = types.RuleNodeId{Id: item.Id, Type: types.NODE}
+	}
+	//校验连接引用的节点都已经定义
+	if err := dsl.ValidateConnections(*ruleChainDef); err != nil {
+		return nil, err
 	}
 	//加载节点关系信息
 	for _, item := range ruleChainDef.Metadata.Connections {
@@ -120,6 +233,7 @@ func InitRuleChainCtx(config types.Config, aspects types.AspectList, ruleChainDe
 			InId:         inNodeId,
 			OutId:        outNodeId,
 			RelationType: item.Type,
+			Priority:     item.Priority,
 		}
 		nodeRelations, ok := ruleChainCtx.nodeRoutes[inNodeId]
 
@@ -138,6 +252,7 @@ func InitRuleChainCtx(config types.Config, aspects types.AspectList, ruleChainDe
 			InId:         inNodeId,
 			OutId:        outNodeId,
 			RelationType: item.Type,
+			Priority:     item.Priority,
 		}
 
 		nodeRelations, ok := ruleChainCtx.nodeRoutes[inNodeId]
@@ -148,58 +263,170 @@ func InitRuleChainCtx(config types.Config, aspects types.AspectList, ruleChainDe
 		}
 		ruleChainCtx.nodeRoutes[inNodeId] = nodeRelations
 	}
+	//同一节点的出组件按照优先级排序，优先级相同保持定义顺序：先节点连接，再子规则链连接
+	for _, relations := range ruleChainCtx.nodeRoutes {
+		sortRelations(relations)
+	}
+	//节点初始化前检测环路，避免初始化后又被拒绝的组件资源泄露
+	if !config.AllowCycle {
+		if cycle := ruleChainCtx.findCycle(); len(cycle) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrRuleChainCycle, strings.Join(cycle, "->"))
+		}
+	}
+
+	//初始化失败时销毁新创建的节点，复用的节点由调用方管理
+	var success bool
+	defer func() {
+		if !success {
+			ruleChainCtx.destroyCreatedNodes(reuse)
+		}
+	}()
+	//加载所有节点信息
+	for _, item := range ruleChainDef.Metadata.Nodes {
+		ruleNodeId := types.RuleNodeId{Id: item.Id, Type: types.NODE}
+		if nodeCtx, ok := reuse[ruleNodeId]; ok {
+			ruleChainCtx.nodes[ruleNodeId] = nodeCtx
+			continue
+		}
+		ruleNodeCtx, err := InitRuleNodeCtx(config, ruleChainCtx, item)
+		if err != nil {
+			err = &NodeInitError{ChainId: ruleChainDef.RuleChain.ID, NodeId: item.Id, NodeType: item.Type, Err: err}
+		}
+		if err != nil && config.AllowNodeInitFailure {
+			//降级模式：使用占位节点代替初始化失败的节点
+			if config.Logger != nil {
+				config.Logger.Printf("%s, running in degraded mode", err.Error())
+			}
+			ruleNodeCtx, err = newDegradedNodeCtx(config, ruleChainCtx, item, err), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ruleChainCtx.nodes[ruleNodeId] = ruleNodeCtx
+	}
+	ruleChainCtx.nodesSnapshot.Store(ruleChainCtx.nodes)
+	ruleChainCtx.buildRoutingTable()
+	ruleChainCtx.buildParentRoutes()
 
+	baseCtx := config.BaseContext
+	if baseCtx == nil {
+		baseCtx = context.Background()
+	}
 	if firstNode, ok := ruleChainCtx.GetFirstNode(); ok {
-		ruleChainCtx.rootRuleContext = NewRuleContext(context.TODO(), ruleChainCtx.config, ruleChainCtx, nil,
+		ruleChainCtx.rootRuleContext = NewRuleContext(baseCtx, ruleChainCtx.config, ruleChainCtx, nil,
 			firstNode, config.Pool, nil, nil)
+	} else if firstNodeId := ruleChainDef.Metadata.FirstNodeId; firstNodeId != "" {
+		return nil, fmt.Errorf("firstNodeId %s is not a declared node", firstNodeId)
+	} else if nodeLen > 0 {
+		//有节点但是第一个节点索引越界，不能作为空规则链处理，否则所有消息都会被丢弃
+		return nil, fmt.Errorf("firstNodeIndex %d is out of range, the rule chain has %d nodes", ruleChainDef.Metadata.FirstNodeIndex, nodeLen)
+	} else if config.DisallowEmptyChain {
+		return nil, ErrEmptyRuleChain
 	} else {
 		//没有节点，则初始化一个空节点
 		ruleNodeCtx, _ := InitRuleNodeCtx(config, ruleChainCtx, &types.RuleNode{})
-		ruleChainCtx.rootRuleContext = NewRuleContext(context.TODO(), ruleChainCtx.config, ruleChainCtx, nil,
+		ruleChainCtx.rootRuleContext = NewRuleContext(baseCtx, ruleChainCtx.config, ruleChainCtx, nil,
 			ruleNodeCtx, config.Pool, nil, nil)
 		ruleChainCtx.isEmpty = true
 	}
 
+	if nodeId := ruleChainDef.RuleChain.DeadLetterNodeId; nodeId != "" {
+		if _, ok := ruleChainCtx.GetNodeById(types.RuleNodeId{Id: nodeId, Type: types.NODE}); !ok {
+			return nil, fmt.Errorf("deadLetterNodeId %s is not a declared node", nodeId)
+		}
+	}
+
+	//每个命名入口创建一个根上下文
+	for name, nodeId := range ruleChainDef.Metadata.EntryPoints {
+		entryNode, ok := ruleChainCtx.GetNodeById(types.RuleNodeId{Id: nodeId, Type: types.NODE})
+		if !ok {
+			return nil, fmt.Errorf("entry point %s: node %s is not a declared node", name, nodeId)
+		}
+		if ruleChainCtx.entryRuleContexts == nil {
+			ruleChainCtx.entryRuleContexts = make(map[string]types.RuleContext)
+		}
+		ruleChainCtx.entryRuleContexts[name] = NewRuleContext(baseCtx, ruleChainCtx.config, ruleChainCtx, nil,
+			entryNode, config.Pool, nil, nil)
+	}
+
 	//get aspects
 	_, reloadAspects, destroyAspects := aspects.GetEngineAspects()
 	ruleChainCtx.reloadAspects = reloadAspects
 	ruleChainCtx.destroyAspects = destroyAspects
 
+	success = true
 	return ruleChainCtx, nil
 }
 
+// destroyCreatedNodes 销毁初始化过程中新创建的节点实例，不销毁复用的节点实例
+func (rc *RuleChainCtx) destroyCreatedNodes(reuse map[types.RuleNodeId]types.NodeCtx) {
+	created := make(map[types.RuleNodeId]types.NodeCtx, len(rc.nodes))
+	for id, nodeCtx := range rc.nodes {
+		if _, ok := reuse[id]; !ok {
+			created[id] = nodeCtx
+		}
+	}
+	destroyNodes(created, rc.cleanups)
+}
+
 func (rc *RuleChainCtx) Config() types.Config {
 	return rc.config
 }
 
+// setConfig 更新规则引擎配置，重新加载前调用
+func (rc *RuleChainCtx) setConfig(config types.Config) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.config = config
+}
+
+// GetNodeById 获取节点，节点从只读快照获取，不需要加锁；子规则链通过规则链池查找
 func (rc *RuleChainCtx) GetNodeById(id types.RuleNodeId) (types.NodeCtx, bool) {
-	rc.RLock()
-	defer rc.RUnlock()
 	if id.Type == types.CHAIN {
+		rc.RLock()
+		defer rc.RUnlock()
 		//子规则链通过规则链池查找
 		if subRuleEngine, ok := rc.GetRuleChainPool().Get(id.Id); ok && subRuleEngine.RootRuleChainCtx() != nil {
 			return subRuleEngine.RootRuleChainCtx(), true
 		} else {
 			return nil, false
 		}
-	} else {
-		ruleNodeCtx, ok := rc.nodes[id]
-		return ruleNodeCtx, ok
 	}
-
+	nodes, _ := rc.nodesSnapshot.Load().(map[types.RuleNodeId]types.NodeCtx)
+	ruleNodeCtx, ok := nodes[id]
+	return ruleNodeCtx, ok
 }
 
+// GetNodeByIndex 获取指定索引的节点，索引越界返回nil和false
 func (rc *RuleChainCtx) GetNodeByIndex(index int) (types.NodeCtx, bool) {
-	if index >= len(rc.nodeIds) {
-		return &RuleNodeCtx{}, false
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.getNodeByIndex(index)
+}
+
+// getNodeByIndex 获取指定索引的节点，调用方需要持有锁
+func (rc *RuleChainCtx) getNodeByIndex(index int) (types.NodeCtx, bool) {
+	if index < 0 || index >= len(rc.nodeIds) {
+		return nil, false
 	}
-	return rc.GetNodeById(rc.nodeIds[index])
+	nodeCtx, ok := rc.nodes[rc.nodeIds[index]]
+	return nodeCtx, ok
 }
 
-// GetFirstNode 获取第一个节点，消息从该节点开始流转。默认是index=0的节点
+// GetFirstNode 获取第一个节点，消息从该节点开始流转。优先使用firstNodeId指定的节点，否则使用firstNodeIndex，默认是index=0的节点
 func (rc *RuleChainCtx) GetFirstNode() (types.NodeCtx, bool) {
-	var firstNodeIndex = rc.SelfDefinition.Metadata.FirstNodeIndex
-	return rc.GetNodeByIndex(firstNodeIndex)
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.getFirstNode()
+}
+
+// getFirstNode 获取第一个节点，调用方需要持有锁，保证规则链定义和节点列表属于同一个版本
+func (rc *RuleChainCtx) getFirstNode() (types.NodeCtx, bool) {
+	if firstNodeId := rc.SelfDefinition.Metadata.FirstNodeId; firstNodeId != "" {
+		nodeCtx, ok := rc.nodes[types.RuleNodeId{Id: firstNodeId, Type: types.NODE}]
+		return nodeCtx, ok
+	}
+	return rc.getNodeByIndex(rc.SelfDefinition.Metadata.FirstNodeIndex)
 }
 
 func (rc *RuleChainCtx) GetNodeRoutes(id types.RuleNodeId) ([]types.RuleNodeRelation, bool) {
@@ -209,13 +436,227 @@ func (rc *RuleChainCtx) GetNodeRoutes(id types.RuleNodeId) ([]types.RuleNodeRela
 	return relations, ok
 }
 
+// GetParentNodes 获取连接到指定节点的关系列表，包括节点连接和子规则链连接(id类型为 types.CHAIN)
+// 按照上一个节点的定义顺序返回，没有上一个节点返回false
+func (rc *RuleChainCtx) GetParentNodes(id types.RuleNodeId) ([]types.RuleNodeRelation, bool) {
+	rc.RLock()
+	defer rc.RUnlock()
+	relations, ok := rc.parentRoutes[id]
+	return relations, ok
+}
+
+// RelationFanout 获取指定节点每种关系连接的下游节点数量，key:关系类型 value:下游节点数量
+func (rc *RuleChainCtx) RelationFanout(id types.RuleNodeId) map[string]int {
+	rc.RLock()
+	defer rc.RUnlock()
+	fanout := make(map[string]int)
+	for _, item := range rc.nodeRoutes[id] {
+		fanout[item.RelationType]++
+	}
+	return fanout
+}
+
+// GraphStats 规则链拓扑结构统计
+type GraphStats struct {
+	//NodeCount 节点数量
+	NodeCount int
+	//EdgeCount 连接数量，包括连接到子规则链的连接
+	EdgeCount int
+	//LongestPath 从第一个节点出发的最长路径跳数，环路上的回边不计入
+	LongestPath int
+	//MaxBranching 单个节点最大出度
+	MaxBranching int
+	//AvgBranching 平均出度，EdgeCount/NodeCount
+	AvgBranching float64
+	//HasCycle 从第一个节点出发是否存在环路
+	HasCycle bool
+}
+
+// GraphStats 计算规则链拓扑结构统计，用于评估规则链延迟和扇出风险
+func (rc *RuleChainCtx) GraphStats() GraphStats {
+	rc.RLock()
+	defer rc.RUnlock()
+	stats := GraphStats{NodeCount: len(rc.nodeIds)}
+	for _, relations := range rc.nodeRoutes {
+		stats.EdgeCount += len(relations)
+		if len(relations) > stats.MaxBranching {
+			stats.MaxBranching = len(relations)
+		}
+	}
+	if stats.NodeCount > 0 {
+		stats.AvgBranching = float64(stats.EdgeCount) / float64(stats.NodeCount)
+	}
+	if firstNode, ok := rc.getFirstNode(); ok {
+		//记忆化深度优先遍历，每个节点只计算一次，跳过指向当前路径上节点的回边
+		memo := make(map[types.RuleNodeId]int)
+		onPath := make(map[types.RuleNodeId]bool)
+		var longest func(id types.RuleNodeId) int
+		longest = func(id types.RuleNodeId) int {
+			if v, ok := memo[id]; ok {
+				return v
+			}
+			onPath[id] = true
+			maxHops := 0
+			for _, item := range rc.nodeRoutes[id] {
+				hops := 1
+				if onPath[item.OutId] {
+					stats.HasCycle = true
+					continue
+				}
+				if item.OutId.Type == types.NODE {
+					hops += longest(item.OutId)
+				}
+				if hops > maxHops {
+					maxHops = hops
+				}
+			}
+			onPath[id] = false
+			memo[id] = maxHops
+			return maxHops
+		}
+		stats.LongestPath = longest(firstNode.GetNodeId())
+	}
+	return stats
+}
+
+// findCycle 深度优先遍历节点连接，查找环路，返回环路上的节点ID，首尾节点相同，例如：[s1 s2 s3 s1]
+// 子规则链是独立的规则链，不会与当前规则链节点形成环路。没有环路返回nil
+func (rc *RuleChainCtx) findCycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	//按照节点定义顺序遍历，再遍历连接中出现但是没有定义的节点，保证结果确定
+	startIds := append([]types.RuleNodeId{}, rc.nodeIds...)
+	defined := make(map[types.RuleNodeId]struct{}, len(rc.nodeIds))
+	for _, id := range rc.nodeIds {
+		defined[id] = struct{}{}
+	}
+	var undefinedIds []types.RuleNodeId
+	for id := range rc.nodeRoutes {
+		if _, ok := defined[id]; !ok {
+			undefinedIds = append(undefinedIds, id)
+		}
+	}
+	sort.Slice(undefinedIds, func(i, j int) bool {
+		return undefinedIds[i].Id < undefinedIds[j].Id
+	})
+	startIds = append(startIds, undefinedIds...)
+
+	state := make(map[types.RuleNodeId]int)
+	var path []types.RuleNodeId
+	var visit func(id types.RuleNodeId) []string
+	visit = func(id types.RuleNodeId) []string {
+		state[id] = visiting
+		path = append(path, id)
+		for _, item := range rc.nodeRoutes[id] {
+			if item.OutId.Type == types.CHAIN {
+				continue
+			}
+			switch state[item.OutId] {
+			case visiting:
+				var cycle []string
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == item.OutId {
+						for _, nodeId := range path[i:] {
+							cycle = append(cycle, nodeId.Id)
+						}
+						break
+					}
+				}
+				return append(cycle, item.OutId.Id)
+			case unvisited:
+				if cycle := visit(item.OutId); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+		return nil
+	}
+	for _, id := range startIds {
+		if state[id] == unvisited {
+			if cycle := visit(id); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+// ExportEffectiveConfig 把所有节点实际使用的配置展开成扁平的key/value列表，用于比较不同环境的配置差异
+// key格式：节点ID.字段，嵌套字段使用"."连接，数组元素使用[索引]，例如：s1.headers.Content-Type、s2.topics[0]
+// 配置值是替换全局配置和vars变量占位符后的值，secret明文替换为 SecretMask
+func (rc *RuleChainCtx) ExportEffectiveConfig() map[string]string {
+	rc.RLock()
+	defer rc.RUnlock()
+	var result = make(map[string]string)
+	for _, id := range rc.nodeIds {
+		nodeCtx, ok := rc.nodes[id].(*RuleNodeCtx)
+		if !ok {
+			continue
+		}
+		for k, v := range nodeCtx.EffectiveConfig() {
+			flattenConfig(id.Id+"."+k, v, result)
+		}
+	}
+	return result
+}
+
+// flattenConfig 递归展开配置值
+func flattenConfig(key string, value interface{}, result map[string]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			flattenConfig(key+"."+k, item, result)
+		}
+	case types.Configuration:
+		for k, item := range v {
+			flattenConfig(key+"."+k, item, result)
+		}
+	case map[string]string:
+		for k, item := range v {
+			result[key+"."+k] = item
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenConfig(key+"["+strconv.Itoa(i)+"]", item, result)
+		}
+	case []string:
+		for i, item := range v {
+			result[key+"["+strconv.Itoa(i)+"]"] = item
+		}
+	default:
+		result[key] = str.ToString(v)
+	}
+}
+
 // GetNextNodes 获取当前节点指定关系的子节点
+// 从预先构建的路由表读取，不需要加锁；包含子规则链的路由通过规则链池延迟解析并缓存
 func (rc *RuleChainCtx) GetNextNodes(id types.RuleNodeId, relationType string) ([]types.NodeCtx, bool) {
-	var nodeCtxList []types.NodeCtx
 	cacheKey := RelationCache{inNodeId: id, relationType: relationType}
+	if table, ok := rc.routingTable.Load().(routingTable); ok {
+		entry, ok := table[cacheKey]
+		if !ok {
+			return nil, false
+		}
+		if !entry.hasSubChain {
+			return entry.nodes, len(entry.nodes) > 0
+		}
+	}
+	return rc.resolveNextNodes(cacheKey)
+}
+
+// resolveNextNodes 从路由关系解析子节点，并写入 relationCache 缓存
+func (rc *RuleChainCtx) resolveNextNodes(cacheKey RelationCache) ([]types.NodeCtx, bool) {
+	var nodeCtxList []types.NodeCtx
+	id, relationType := cacheKey.inNodeId, cacheKey.relationType
 	rc.RLock()
 	//get from cache
-	nodeCtxList, ok := rc.relationCache[cacheKey]
+	relationCache := rc.relationCache
+	nodeCtxList, ok := relationCache[cacheKey]
 	rc.RUnlock()
 	if ok {
 		return nodeCtxList, nodeCtxList != nil
@@ -236,11 +677,26 @@ func (rc *RuleChainCtx) GetNextNodes(id types.RuleNodeId, relationType string) (
 	}
 	rc.Lock()
 	//add to the cache
-	rc.relationCache[cacheKey] = nodeCtxList
+	//如果期间重新加载了规则链，写入的是已经废弃的缓存，避免把旧的节点实例写入新的缓存
+	relationCache[cacheKey] = nodeCtxList
 	rc.Unlock()
 	return nodeCtxList, hasNextComponents
 }
 
+// GetNextNodeIds 获取当前节点指定关系的子节点ID，只读取路由关系，不解析节点实例，
+// 子规则链节点不会查询规则链池，适用于图导出、静态分析等只需要节点ID的场景
+func (rc *RuleChainCtx) GetNextNodeIds(id types.RuleNodeId, relationType string) ([]types.RuleNodeId, bool) {
+	var nodeIds []types.RuleNodeId
+	if relations, ok := rc.GetNodeRoutes(id); ok {
+		for _, item := range relations {
+			if item.RelationType == relationType {
+				nodeIds = append(nodeIds, item.OutId)
+			}
+		}
+	}
+	return nodeIds, len(nodeIds) > 0
+}
+
 // Type 组件类型
 func (rc *RuleChainCtx) Type() string {
 	return "ruleChain"
@@ -254,7 +710,7 @@ func (rc *RuleChainCtx) New() types.Node {
 func (rc *RuleChainCtx) Init(_ types.Config, configuration types.Configuration) error {
 	if rootRuleChainDef, ok := configuration["selfDefinition"]; ok {
 		if v, ok := rootRuleChainDef.(*types.RuleChain); ok {
-			if ruleChainCtx, err := InitRuleChainCtx(rc.config, rc.aspects, v); err == nil {
+			if ruleChainCtx, err := InitRuleChainCtx(rc.config, rc.GetAspects(), v); err == nil {
 				rc.Copy(ruleChainCtx)
 			} else {
 				return err
@@ -269,36 +725,555 @@ func (rc *RuleChainCtx) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
 	ctx.TellFlow(msg, rc.Id.Id, nil, nil)
 }
 
+// SegmentResult 分段执行的分支结果
+type SegmentResult struct {
+	//Stopped 分支是否因为即将进入停止节点而停止，false表示分支在到达停止节点之前已经结束
+	Stopped bool
+	//NodeId 分支停止或者结束时所在的节点ID，停止时为停止节点的上一个节点
+	NodeId string
+	//RelationType 分支停止或者结束时的关系类型
+	RelationType string
+	//Msg 分支停止或者结束时的消息，即进入停止节点之前的消息
+	Msg types.RuleMsg
+	//Err 分支结束时的错误
+	Err error
+}
+
+// OnMsgSegment 从startId节点开始执行消息，消息即将进入stopId节点时停止该分支，不执行stopId节点及其后续节点
+// 同步等待所有分支停止或者结束，返回每个分支的结果。多个分支扇出时，每个分支分别在stopId停止
+// stopId为空则执行到分支结束。用于调试和重放规则链的中间片段，避免执行有副作用的后续节点
+// ctx 取消时停止等待，返回已经收集的结果和ctx.Err()
+func (rc *RuleChainCtx) OnMsgSegment(ctx context.Context, msg types.RuleMsg, startId, stopId string) ([]SegmentResult, error) {
+	startNode, ok := rc.GetNodeById(types.RuleNodeId{Id: startId})
+	if !ok {
+		return nil, fmt.Errorf("start node not found nodeId=%s", startId)
+	}
+	if stopId != "" {
+		if stopId == startId {
+			return nil, errors.New("start node and stop node must be different")
+		}
+		if _, ok := rc.GetNodeById(types.RuleNodeId{Id: stopId}); !ok {
+			return nil, fmt.Errorf("stop node not found nodeId=%s", stopId)
+		}
+	}
+	var pool types.Pool
+	if rootCtx, ok := rc.rootRuleContext.(*DefaultRuleContext); ok {
+		pool = rootCtx.pool
+	}
+	var results []SegmentResult
+	var lock sync.Mutex
+	addResult := func(result SegmentResult) {
+		lock.Lock()
+		defer lock.Unlock()
+		results = append(results, result)
+	}
+	done := make(chan struct{})
+	inflight, ok := rc.acquireInflight()
+	if !ok {
+		return nil, ErrRuleChainStopped
+	}
+	segmentCtx := NewRuleContext(ctx, rc.config, rc, nil, startNode, pool, func(ruleCtx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		addResult(SegmentResult{NodeId: ruleCtx.GetSelfId(), RelationType: relationType, Msg: msg, Err: err})
+	}, rc.GetRuleChainPool())
+	segmentCtx.stopNodeId = stopId
+	segmentCtx.onStop = func(ruleCtx types.RuleContext, msg types.RuleMsg, relationType string) {
+		addResult(SegmentResult{Stopped: true, NodeId: ruleCtx.GetSelfId(), RelationType: relationType, Msg: msg})
+	}
+	segmentCtx.onAllNodeCompleted = func() {
+		inflight.release()
+		close(done)
+	}
+	segmentCtx.TellNext(msg)
+	select {
+	case <-done:
+	case <-ctx.Done():
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]SegmentResult(nil), results...), ctx.Err()
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	return results, nil
+}
+
+// Destroy 立即销毁规则链，不等待正在执行的消息，与 ForceDestroy 相同
 func (rc *RuleChainCtx) Destroy() {
+	rc.shutdown(false)
+}
+
+// ForceDestroy 立即销毁规则链，不等待正在执行的消息，正在执行的消息可能因为节点资源已经释放而失败
+// 销毁切面得到的是强制关闭
+func (rc *RuleChainCtx) ForceDestroy() {
+	rc.Destroy()
+}
+
+// GracefulDestroy 优雅关闭规则链：不再接收新的消息(返回 ErrRuleChainStopped)，等待正在执行的消息完成后再销毁所有节点
+// 如果ctx在消息完成之前结束，则强制销毁并返回ctx.Err()。销毁切面可以通过 types.OnDestroyModeAspect 得到是否优雅关闭
+func (rc *RuleChainCtx) GracefulDestroy(ctx context.Context) error {
+	atomic.StoreInt32(&rc.stopping, 1)
+	if !rc.loadInflight().wait(ctx) {
+		rc.shutdown(false)
+		return ctx.Err()
+	}
+	rc.shutdown(true)
+	return nil
+}
+
+// shutdown 销毁所有节点，清空宿主程序附加的属性，取消所有订阅
+func (rc *RuleChainCtx) shutdown(graceful bool) {
+	rc.destroy(graceful)
+	rc.attributes.Range(func(key, value interface{}) bool {
+		rc.attributes.Delete(key)
+		return true
+	})
+	rc.subscribersLock.RLock()
+	var subscriptions = make([]*Subscription, 0, len(rc.subscribers))
+	for item := range rc.subscribers {
+		subscriptions = append(subscriptions, item)
+	}
+	rc.subscribersLock.RUnlock()
+	for _, item := range subscriptions {
+		item.Unsubscribe()
+	}
+}
+
+// destroy 销毁所有节点，保留宿主程序附加的属性
+func (rc *RuleChainCtx) destroy(graceful bool) {
+	atomic.StoreInt32(&rc.started, 0)
 	rc.RLock()
-	defer rc.RUnlock()
-	for _, v := range rc.nodes {
+	nodes, cleanups := rc.nodes, rc.cleanups
+	rc.RUnlock()
+	stopNodes(nodes)
+	destroyNodes(nodes, cleanups)
+	rc.onDestroy(graceful)
+}
+
+// onDestroy 执行销毁切面逻辑，graceful 是否优雅关闭
+func (rc *RuleChainCtx) onDestroy(graceful bool) {
+	_, destroyAspects := rc.engineAspects()
+	for _, aop := range destroyAspects {
+		if modeAspect, ok := aop.(types.OnDestroyModeAspect); ok {
+			modeAspect.OnDestroyWithMode(rc, graceful)
+		} else {
+			aop.OnDestroy(rc)
+		}
+	}
+}
+
+// Start 启动规则链，规则链可以处理消息后由规则引擎调用，调用实现了 types.ChainStartListener 的节点的OnChainStart，
+// 之后重新加载或者运行时新增的节点实例也会启动。只解析规则链定义不会启动节点
+func (rc *RuleChainCtx) Start() {
+	atomic.StoreInt32(&rc.started, 1)
+	rc.startNodes()
+}
+
+// isStarted 规则链是否已经启动
+func (rc *RuleChainCtx) isStarted() bool {
+	return atomic.LoadInt32(&rc.started) == 1
+}
+
+// startNodes 按照节点定义顺序启动所有未启动的节点
+func (rc *RuleChainCtx) startNodes() {
+	rc.RLock()
+	nodeIds, nodes := rc.nodeIds, rc.nodes
+	rc.RUnlock()
+	for _, id := range nodeIds {
+		if ruleNodeCtx, ok := nodes[id].(*RuleNodeCtx); ok {
+			ruleNodeCtx.start()
+		}
+	}
+}
+
+// stopNodes 停止节点实例
+func stopNodes(nodes map[types.RuleNodeId]types.NodeCtx) {
+	for _, v := range nodes {
+		if ruleNodeCtx, ok := v.(*RuleNodeCtx); ok {
+			ruleNodeCtx.stop()
+		}
+	}
+}
+
+// destroyNodes 销毁节点实例，所有节点销毁后，按照注册顺序的逆序执行清理回调函数
+func destroyNodes(nodes map[types.RuleNodeId]types.NodeCtx, cleanups *cleanupList) {
+	for _, v := range nodes {
 		temp := v
 		temp.Destroy()
 	}
-	//执行销毁切面逻辑
-	for _, aop := range rc.destroyAspects {
-		aop.OnDestroy(rc)
+	cleanups.run()
+}
+
+// replace 使用新的规则链实例替换当前的节点实例，新的消息立即使用新的节点实例，
+// 旧的节点实例在替换前已经开始执行的消息完成后销毁，最多等待 Config.ReloadDrainTimeout，
+// 没有正在执行的消息则立即销毁，否则在后台等待后销毁。
+// 首次加载的消息通过当前规则链实例查找下一个节点，可能使用任意一次重新加载后的节点实例，
+// 因此需要等待替换前所有未完成的消息，而不仅是最近一次重新加载之后开始的消息
+func (rc *RuleChainCtx) replace(newCtx *RuleChainCtx) {
+	rc.RLock()
+	oldNodes, oldCleanups := rc.nodes, rc.cleanups
+	rc.RUnlock()
+	rc.replaceNodes(newCtx, oldNodes, oldCleanups)
+}
+
+// replaceNodes 使用新的规则链实例替换当前的节点实例，只销毁oldNodes中的节点实例，并执行oldCleanups清理回调函数
+func (rc *RuleChainCtx) replaceNodes(newCtx *RuleChainCtx, oldNodes map[types.RuleNodeId]types.NodeCtx, oldCleanups *cleanupList) {
+	rc.onDestroy(false)
+	//先停止旧的节点实例，再启动新的节点实例
+	stopNodes(oldNodes)
+	rc.Copy(newCtx)
+	if rc.isStarted() {
+		rc.startNodes()
+	}
+	//节点实例替换后再替换计数器，获取到新计数器的消息一定使用新的节点实例
+	rc.drainAndDestroy(oldNodes, oldCleanups)
+}
+
+// drainAndDestroy 替换正在执行的消息计数器，等待替换前已经开始执行的消息完成后销毁节点实例，最多等待 Config.ReloadDrainTimeout
+// 调用前节点实例必须已经从当前规则链移除，新的消息不会再使用这些节点实例
+func (rc *RuleChainCtx) drainAndDestroy(oldNodes map[types.RuleNodeId]types.NodeCtx, oldCleanups *cleanupList) {
+	oldInflight := rc.loadInflight()
+	rc.inflight.Store(&inflightCounter{previous: oldInflight})
+	timeout, logger, chainId := rc.config.ReloadDrainTimeout, rc.config.Logger, rc.Id.Id
+	if oldInflight.isZero() || timeout <= 0 {
+		destroyNodes(oldNodes, oldCleanups)
+		return
 	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if !oldInflight.wait(ctx) && logger != nil {
+			logger.Printf("rule chain %s: destroy previous nodes with %d messages still in flight after %s", chainId, oldInflight.count(), timeout)
+		}
+		destroyNodes(oldNodes, oldCleanups)
+	}()
+}
+
+// getRootRuleContext 获取根上下文，重新加载规则链时会被替换
+func (rc *RuleChainCtx) getRootRuleContext() types.RuleContext {
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.rootRuleContext
+}
+
+// getEntryRuleContext 获取命名入口的根上下文
+func (rc *RuleChainCtx) getEntryRuleContext(name string) (types.RuleContext, bool) {
+	rc.RLock()
+	defer rc.RUnlock()
+	entryCtx, ok := rc.entryRuleContexts[name]
+	return entryCtx, ok
+}
+
+// EntryPoints 获取规则链命名入口，key:入口名称 value:节点ID
+func (rc *RuleChainCtx) EntryPoints() map[string]string {
+	rc.RLock()
+	defer rc.RUnlock()
+	entryPoints := make(map[string]string, len(rc.entryRuleContexts))
+	for name, entryCtx := range rc.entryRuleContexts {
+		entryPoints[name] = entryCtx.Self().GetNodeId().Id
+	}
+	return entryPoints
+}
+
+// acquireInflight 当前节点实例上正在执行的消息数量加1，返回对应的计数器，消息执行完成后调用计数器的release
+// 如果增加计数时计数器已经被替换，则重新获取，保证返回的计数器在节点实例被替换前已经计数
+// 规则链正在优雅关闭则不计数，返回false
+func (rc *RuleChainCtx) acquireInflight() (*inflightCounter, bool) {
+	for {
+		counter := rc.loadInflight()
+		if counter == nil {
+			return nil, atomic.LoadInt32(&rc.stopping) == 0
+		}
+		atomic.AddInt64(&counter.value, 1)
+		//先计数再检查是否正在关闭，保证关闭开始之后等待的计数包含所有已经接收的消息
+		if atomic.LoadInt32(&rc.stopping) == 1 {
+			counter.release()
+			return nil, false
+		}
+		if rc.loadInflight() == counter {
+			return counter, true
+		}
+		counter.release()
+	}
+}
+
+func (rc *RuleChainCtx) loadInflight() *inflightCounter {
+	counter, _ := rc.inflight.Load().(*inflightCounter)
+	return counter
+}
+
+// inflightCounter 一组节点实例上正在执行的消息计数器
+type inflightCounter struct {
+	value int64
+	//previous 被该计数器替换的上一个计数器，被替换的计数器不会再增加计数，归零后从链中移除
+	previous *inflightCounter
+	lock     sync.Mutex
+}
+
+func (c *inflightCounter) release() {
+	if c != nil {
+		atomic.AddInt64(&c.value, -1)
+	}
+}
+
+// count 正在执行的消息数量，包括之前的计数器
+func (c *inflightCounter) count() int64 {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	previous := c.previous
+	c.lock.Unlock()
+	previousCount := previous.count()
+	if previous != nil && previousCount == 0 {
+		c.lock.Lock()
+		c.previous = nil
+		c.lock.Unlock()
+	}
+	return atomic.LoadInt64(&c.value) + previousCount
+}
+
+func (c *inflightCounter) isZero() bool {
+	return c.count() <= 0
+}
+
+// wait 等待计数归零，ctx结束之前没有归零返回false
+func (c *inflightCounter) wait(ctx context.Context) bool {
+	ticker := time.NewTicker(5 * time.Millisecond)
+	defer ticker.Stop()
+	for !c.isZero() {
+		select {
+		case <-ctx.Done():
+			return c.isZero()
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// cleanupList 清理回调函数列表
+type cleanupList struct {
+	funcs []func()
+	sync.Mutex
+}
+
+func (l *cleanupList) add(f func()) {
+	l.Lock()
+	defer l.Unlock()
+	l.funcs = append(l.funcs, f)
+}
+
+// run 按照注册顺序的逆序执行清理回调函数，每个回调函数只执行一次
+func (l *cleanupList) run() {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	funcs := l.funcs
+	l.funcs = nil
+	l.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
+
+// AddCleanup 注册规则链销毁时执行的清理回调函数，用于组件在自身Destroy之外，需要依赖规则链其他节点的有序清理逻辑，
+// 例如：关闭连接之前把缓冲区的数据发送给其他节点
+// 回调函数在所有节点Destroy之后、销毁切面之前，按照注册顺序的逆序执行，每个回调函数只执行一次。
+// 重新加载规则链时旧的节点实例被销毁，同样会执行已注册的回调函数
+func (rc *RuleChainCtx) AddCleanup(f func()) {
+	rc.RLock()
+	cleanups := rc.cleanups
+	rc.RUnlock()
+	cleanups.add(f)
 }
 
+// IsDebugMode 规则链是否调试模式，优先使用 SetDebugMode 设置的调试模式
 func (rc *RuleChainCtx) IsDebugMode() bool {
+	if enable, ok := rc.debug.chainMode(); ok {
+		return enable
+	}
+	rc.RLock()
+	defer rc.RUnlock()
 	return rc.SelfDefinition.RuleChain.DebugMode
 }
 
+// SetDebugMode 运行时设置规则链调试模式，不需要重新加载，对新的消息立即生效
+// 重新加载子节点(ReloadChild)后保留，重新加载规则链(ReloadSelf)后恢复为DSL定义的调试模式
+func (rc *RuleChainCtx) SetDebugMode(enable bool) {
+	rc.debug.setChainMode(enable)
+}
+
+// SetNodeDebugMode 运行时设置节点调试模式，不需要重新加载，对新的消息立即生效
+// 重新加载子节点(ReloadChild)后保留，重新加载规则链(ReloadSelf)后恢复为DSL定义的调试模式
+func (rc *RuleChainCtx) SetNodeDebugMode(nodeId string, enable bool) error {
+	if _, ok := rc.GetNodeById(types.RuleNodeId{Id: nodeId, Type: types.NODE}); !ok {
+		return fmt.Errorf("node %s not found", nodeId)
+	}
+	rc.debug.setNodeMode(nodeId, enable)
+	return nil
+}
+
+// sampleDebug 调试事件是否通过采样，没有通过采样的调试事件计入统计的DebugDropped
+func (rc *RuleChainCtx) sampleDebug(msgId string) bool {
+	sampler, _ := rc.debugSampler.Load().(*debugSampler)
+	if sampler.allow(msgId) {
+		return true
+	}
+	rc.stats.incDebugDropped()
+	return false
+}
+
+// debugSwitch 运行时设置的调试模式，没有设置时使用DSL定义的调试模式
+type debugSwitch struct {
+	//规则链调试模式 0:没有设置 1:开启 2:关闭
+	chain int32
+	//节点ID->是否开启调试模式
+	nodes sync.Map
+}
+
+func (d *debugSwitch) chainMode() (bool, bool) {
+	if d == nil {
+		return false, false
+	}
+	switch atomic.LoadInt32(&d.chain) {
+	case 1:
+		return true, true
+	case 2:
+		return false, true
+	default:
+		return false, false
+	}
+}
+
+func (d *debugSwitch) setChainMode(enable bool) {
+	if enable {
+		atomic.StoreInt32(&d.chain, 1)
+	} else {
+		atomic.StoreInt32(&d.chain, 2)
+	}
+}
+
+func (d *debugSwitch) nodeMode(nodeId string) (bool, bool) {
+	if d == nil {
+		return false, false
+	}
+	if v, ok := d.nodes.Load(nodeId); ok {
+		return v.(bool), true
+	}
+	return false, false
+}
+
+func (d *debugSwitch) setNodeMode(nodeId string, enable bool) {
+	d.nodes.Store(nodeId, enable)
+}
+
+func (d *debugSwitch) removeNode(nodeId string) {
+	d.nodes.Delete(nodeId)
+}
+
+func (d *debugSwitch) reset() {
+	atomic.StoreInt32(&d.chain, 0)
+	d.nodes.Range(func(key, value interface{}) bool {
+		d.nodes.Delete(key)
+		return true
+	})
+}
+
 func (rc *RuleChainCtx) GetNodeId() types.RuleNodeId {
+	rc.RLock()
+	defer rc.RUnlock()
 	return rc.Id
 }
 
+// isEmptyChain 规则链是否没有任何节点
+func (rc *RuleChainCtx) isEmptyChain() bool {
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.isEmpty
+}
+
+// IsInitialized 规则链是否已经初始化
+func (rc *RuleChainCtx) IsInitialized() bool {
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.initialized
+}
+
+// IsReady 规则链是否可以处理消息：已经初始化、有节点、不在重新加载中并且没有正在优雅关闭
+func (rc *RuleChainCtx) IsReady() bool {
+	if atomic.LoadInt32(&rc.reloading) == 1 || atomic.LoadInt32(&rc.stopping) == 1 {
+		return false
+	}
+	rc.RLock()
+	defer rc.RUnlock()
+	return rc.initialized && !rc.isEmpty
+}
+
+// SetAttribute 设置宿主程序附加的属性，例如：负责人、团队、SLA等级，用于关联宿主程序自身的记录
+// 属性不会写入规则链定义，重新加载规则链后保留，销毁规则链时清空
+func (rc *RuleChainCtx) SetAttribute(key string, value interface{}) {
+	rc.attributes.Store(key, value)
+}
+
+// GetAttribute 获取宿主程序附加的属性
+func (rc *RuleChainCtx) GetAttribute(key string) (interface{}, bool) {
+	return rc.attributes.Load(key)
+}
+
+// Tags 获取规则链标签，包括规则链定义additionalInfo配置的标签和通过SetAttribute附加的标签
+// 标签在初始化时解析，获取标签不需要编码规则链定义
+func (rc *RuleChainCtx) Tags() []string {
+	rc.RLock()
+	tags := append([]string{}, rc.tags...)
+	rc.RUnlock()
+	if v, ok := rc.attributes.Load(TagsKey); ok {
+		var attributeTags []string
+		switch t := v.(type) {
+		case []string:
+			attributeTags = t
+		case string:
+			attributeTags = splitTags(t)
+		}
+		for _, tag := range attributeTags {
+			if tag = strings.TrimSpace(tag); tag != "" && !containsTag(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// HasTag 规则链是否包含指定标签
+func (rc *RuleChainCtx) HasTag(tag string) bool {
+	return containsTag(rc.Tags(), tag)
+}
+
 func (rc *RuleChainCtx) ReloadSelf(def []byte) error {
+	atomic.StoreInt32(&rc.reloading, 1)
+	defer atomic.StoreInt32(&rc.reloading, 0)
+	rc.recordVersion()
 	var err error
 	var ctx types.Node
-	if ctx, err = rc.config.Parser.DecodeRuleChain(rc.config, rc.aspects, def); err == nil {
-		rc.Destroy()
-		rc.Copy(ctx.(*RuleChainCtx))
+	if ctx, err = rc.config.Parser.DecodeRuleChain(rc.config, rc.GetAspects(), def); err != nil {
+		err = wrapReloadError(rc.Id.Id, err)
+	} else {
+		//保留重新加载前的规则链定义，用于校验失败回滚
+		var previousDef []byte
+		if rc.config.OnReloadVerify != nil && rc.initialized {
+			previousDef = rc.DSL()
+		}
+		rc.replace(ctx.(*RuleChainCtx))
+		//全量重新加载后重置统计和运行时设置的调试模式，以DSL为准
+		rc.stats.reset()
+		rc.debug.reset()
+		if rc.config.OnReloadVerify != nil {
+			if verifyErr := rc.config.OnReloadVerify(rc); verifyErr != nil {
+				err = rc.rollback(previousDef, verifyErr)
+			}
+		}
 	}
 	//执行reload切面
-	for _, aop := range rc.reloadAspects {
+	reloadAspects, _ := rc.engineAspects()
+	for _, aop := range reloadAspects {
 		if err := aop.OnReload(rc, rc, err); err != nil {
 			return err
 		}
@@ -306,12 +1281,175 @@ func (rc *RuleChainCtx) ReloadSelf(def []byte) error {
 	return err
 }
 
+// decodeDefinition 使用配置的解析器解析规则链定义
+// 解析器没有实现 types.RuleChainDefParser 时，解析并初始化规则链后获取定义，再销毁初始化的节点
+func (rc *RuleChainCtx) decodeDefinition(def []byte) (types.RuleChain, error) {
+	if parser, ok := rc.config.Parser.(types.RuleChainDefParser); ok {
+		return parser.DecodeRuleChainDef(def)
+	}
+	node, err := rc.config.Parser.DecodeRuleChain(rc.config, nil, def)
+	if err != nil {
+		return types.RuleChain{}, err
+	}
+	defer node.Destroy()
+	if chainCtx, ok := node.(*RuleChainCtx); ok && chainCtx.SelfDefinition != nil {
+		return *chainCtx.SelfDefinition, nil
+	}
+	return types.RuleChain{}, errors.New("parser does not return a rule chain definition")
+}
+
+// ReloadDiff 增量重新加载规则链，逐个节点与当前规则链定义比较，只初始化新增和类型或者配置变化的节点，
+// 保留未变化的节点实例，销毁删除和变化前的节点实例，并重建节点路由和关系缓存。
+// 适用于节点持有MQTT、数据库等连接的规则链，避免修改一个节点导致所有节点重新连接。
+// 节点的类型、配置、调试模式或者终止标志不同视为变化；规则链的配置(vars、secrets)变化时所有节点都重新初始化。
+// 实现 types.OnReloadDiffAspect 的切面通过OnReloadDiff获取变化的节点，其他切面调用OnReload
+func (rc *RuleChainCtx) ReloadDiff(def []byte) (types.ReloadDiff, error) {
+	atomic.StoreInt32(&rc.reloading, 1)
+	defer atomic.StoreInt32(&rc.reloading, 0)
+	rc.recordVersion()
+	var diff types.ReloadDiff
+	newDef, err := rc.decodeDefinition(def)
+	if err != nil {
+		err = wrapReloadError(rc.Id.Id, err)
+	} else {
+		var reuse map[types.RuleNodeId]types.NodeCtx
+		diff, reuse = rc.diffNodes(&newDef)
+		var newCtx *RuleChainCtx
+		if newCtx, err = initRuleChainCtx(rc.config, rc.GetAspects(), &newDef, reuse); err != nil {
+			err = wrapReloadError(rc.Id.Id, err)
+		} else {
+			var previousDef []byte
+			if rc.config.OnReloadVerify != nil && rc.initialized {
+				previousDef = rc.DSL()
+			}
+			rc.RLock()
+			oldNodes, cleanups := rc.nodes, rc.cleanups
+			rc.RUnlock()
+			//复用的节点注册的清理回调函数保留到规则链销毁时执行
+			newCtx.cleanups = cleanups
+			destroyNodes := make(map[types.RuleNodeId]types.NodeCtx)
+			for id, nodeCtx := range oldNodes {
+				if _, ok := reuse[id]; !ok {
+					destroyNodes[id] = nodeCtx
+				}
+			}
+			rc.replaceNodes(newCtx, destroyNodes, nil)
+			if rc.config.OnReloadVerify != nil {
+				if verifyErr := rc.config.OnReloadVerify(rc); verifyErr != nil {
+					err = rc.rollback(previousDef, verifyErr)
+				}
+			}
+		}
+	}
+	//执行reload切面
+	reloadAspects, _ := rc.engineAspects()
+	for _, aop := range reloadAspects {
+		var aopErr error
+		if diffAop, ok := aop.(types.OnReloadDiffAspect); ok {
+			aopErr = diffAop.OnReloadDiff(rc, diff, err)
+		} else {
+			aopErr = aop.OnReload(rc, rc, err)
+		}
+		if aopErr != nil {
+			return diff, aopErr
+		}
+	}
+	return diff, err
+}
+
+// diffNodes 比较新的规则链定义与当前规则链定义的节点，返回节点变化和可以复用的节点实例
+func (rc *RuleChainCtx) diffNodes(newDef *types.RuleChain) (types.ReloadDiff, map[types.RuleNodeId]types.NodeCtx) {
+	rc.RLock()
+	defer rc.RUnlock()
+	var diff types.ReloadDiff
+	reuse := make(map[types.RuleNodeId]types.NodeCtx)
+	//规则链配置影响所有节点的变量替换，变化时不复用节点
+	reusable := rc.SelfDefinition != nil && configurationEqual(rc.SelfDefinition.RuleChain.Configuration, newDef.RuleChain.Configuration)
+	newIds := make(map[string]bool)
+	for index, item := range newDef.Metadata.Nodes {
+		if item.Id == "" {
+			item.Id = fmt.Sprintf(defaultNodeIdPrefix+"%d", index)
+		}
+		newIds[item.Id] = true
+		ruleNodeId := types.RuleNodeId{Id: item.Id, Type: types.NODE}
+		nodeCtx, ok := rc.nodes[ruleNodeId]
+		if !ok {
+			diff.Added = append(diff.Added, item.Id)
+			continue
+		}
+		//禁用的节点根据规则链连接透传消息，降级的节点需要重新初始化，都不复用
+		if ruleNodeCtx, isRuleNodeCtx := nodeCtx.(*RuleNodeCtx); reusable && isRuleNodeCtx && !isPlaceholder(ruleNodeCtx) && nodeEqual(ruleNodeCtx.SelfDefinition, item) {
+			reuse[ruleNodeId] = nodeCtx
+		} else {
+			diff.Changed = append(diff.Changed, item.Id)
+		}
+	}
+	for _, id := range rc.nodeIds {
+		if !newIds[id.Id] {
+			diff.Removed = append(diff.Removed, id.Id)
+		}
+	}
+	return diff, reuse
+}
+
+// isPlaceholder 节点是否是代替禁用节点的透传节点或者代替初始化失败节点的降级节点
+func isPlaceholder(ruleNodeCtx *RuleNodeCtx) bool {
+	switch ruleNodeCtx.Node.(type) {
+	case *passThroughNode, *degradedNode:
+		return true
+	}
+	return false
+}
+
+// nodeEqual 节点类型、配置、调试模式和终止标志是否相同
+func nodeEqual(oldNode, newNode *types.RuleNode) bool {
+	return oldNode != nil && newNode != nil &&
+		oldNode.Type == newNode.Type &&
+		oldNode.DebugMode == newNode.DebugMode &&
+		oldNode.Terminal == newNode.Terminal &&
+		configurationEqual(oldNode.Configuration, newNode.Configuration)
+}
+
+// configurationEqual 配置是否相同，nil和空配置视为相同
+func configurationEqual(a, b types.Configuration) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// rollback 校验失败，回滚到重新加载前的规则链定义
+// 回滚后的规则链实例通过previousDef重新初始化
+func (rc *RuleChainCtx) rollback(previousDef []byte, verifyErr error) error {
+	if len(previousDef) == 0 {
+		return fmt.Errorf("reload verify error: %w", verifyErr)
+	}
+	ctx, err := rc.config.Parser.DecodeRuleChain(rc.config, rc.GetAspects(), previousDef)
+	if err != nil {
+		return fmt.Errorf("reload verify error: %w, rollback error: %s", verifyErr, err.Error())
+	}
+	rc.replace(ctx.(*RuleChainCtx))
+	return fmt.Errorf("reload verify error: %w, rolled back to previous definition", verifyErr)
+}
+
 func (rc *RuleChainCtx) ReloadChild(ruleNodeId types.RuleNodeId, def []byte) error {
 	if node, ok := rc.GetNodeById(ruleNodeId); ok {
 		//更新子节点
 		err := node.ReloadSelf(def)
+		if err != nil {
+			var nodeType string
+			if ruleNodeCtx, ok := node.(*RuleNodeCtx); ok && ruleNodeCtx.SelfDefinition != nil {
+				nodeType = ruleNodeCtx.SelfDefinition.Type
+			}
+			err = &NodeInitError{ChainId: rc.Id.Id, NodeId: ruleNodeId.Id, NodeType: nodeType, Err: err}
+		} else {
+			//清除引用该节点的关系缓存，后续消息重新解析到新的节点实例
+			rc.invalidateRelationCache(ruleNodeId)
+			rc.invalidateDSL()
+		}
 		//执行reload切面
-		for _, aop := range rc.reloadAspects {
+		reloadAspects, _ := rc.engineAspects()
+		for _, aop := range reloadAspects {
 			if err := aop.OnReload(rc, node, err); err != nil {
 				return err
 			}
@@ -321,12 +1459,190 @@ func (rc *RuleChainCtx) ReloadChild(ruleNodeId types.RuleNodeId, def []byte) err
 	return nil
 }
 
+// NodeInitError 节点初始化错误，包含所在的规则链ID、节点ID和节点类型，用于从日志定位出错的节点
+// 通过errors.Is/As可以获取组件返回的原始错误
+type NodeInitError struct {
+	ChainId  string
+	NodeId   string
+	NodeType string
+	Err      error
+}
+
+func (e *NodeInitError) Error() string {
+	return fmt.Sprintf("rule chain %s: node %s(%s) init error: %s", e.ChainId, e.NodeId, e.NodeType, e.Err.Error())
+}
+
+func (e *NodeInitError) Unwrap() error {
+	return e.Err
+}
+
+// wrapReloadError 给重新加载规则链的错误加上规则链ID，已经包含规则链ID的节点初始化错误不重复包装
+func wrapReloadError(chainId string, err error) error {
+	var nodeInitErr *NodeInitError
+	if errors.As(err, &nodeInitErr) {
+		return err
+	}
+	return fmt.Errorf("reload rule chain %s: %w", chainId, err)
+}
+
+// Stats 获取规则链消息统计快照，包括进入规则链、结束的消息数和每个节点按关系类型输出的消息数
+func (rc *RuleChainCtx) Stats() ChainStats {
+	return rc.stats.snapshot()
+}
+
+// PoolStats 获取协程池溢出统计，重新加载规则链(ReloadSelf)后重置
+func (rc *RuleChainCtx) PoolStats() PoolStats {
+	if overflow, ok := rc.poolOverflow.Load().(*poolOverflow); ok {
+		return overflow.stats()
+	}
+	return PoolStats{}
+}
+
+// ResetStats 重置规则链消息统计
+func (rc *RuleChainCtx) ResetStats() {
+	rc.stats.reset()
+}
+
+// DegradedNodes 获取初始化失败、以降级模式运行的节点，按节点定义顺序排列
+// 参考 types.Config.AllowNodeInitFailure
+func (rc *RuleChainCtx) DegradedNodes() []DegradedNode {
+	rc.RLock()
+	nodeIds := rc.nodeIds
+	rc.RUnlock()
+	var result []DegradedNode
+	for _, id := range nodeIds {
+		nodeCtx, ok := rc.GetNodeById(id)
+		if !ok {
+			continue
+		}
+		if ruleNodeCtx, ok := nodeCtx.(*RuleNodeCtx); ok {
+			if node, ok := ruleNodeCtx.Node.(*degradedNode); ok {
+				result = append(result, DegradedNode{NodeId: id.Id, Err: node.err})
+			}
+		}
+	}
+	return result
+}
+
+// sortRelations 按照优先级从高到低稳定排序，优先级相同保持原来的顺序
+func sortRelations(relations []types.RuleNodeRelation) {
+	sort.SliceStable(relations, func(i, j int) bool {
+		return relations[i].Priority > relations[j].Priority
+	})
+}
+
+// buildParentRoutes 根据当前的路由关系构建反向路由关系，调用方需要持有写锁或者在初始化阶段调用
+func (rc *RuleChainCtx) buildParentRoutes() {
+	parentRoutes := make(map[types.RuleNodeId][]types.RuleNodeRelation)
+	for _, inNodeId := range rc.nodeIds {
+		for _, item := range rc.nodeRoutes[inNodeId] {
+			parentRoutes[item.OutId] = append(parentRoutes[item.OutId], item)
+		}
+	}
+	rc.parentRoutes = parentRoutes
+}
+
+// buildRoutingTable 根据当前的节点和路由关系构建路由表，调用方需要持有写锁或者在初始化阶段调用
+func (rc *RuleChainCtx) buildRoutingTable() {
+	table := make(routingTable)
+	for inNodeId, relations := range rc.nodeRoutes {
+		for _, item := range relations {
+			key := RelationCache{inNodeId: inNodeId, relationType: item.RelationType}
+			entry := table[key]
+			if entry.hasSubChain {
+				continue
+			}
+			if item.OutId.Type == types.CHAIN {
+				table[key] = routeEntry{hasSubChain: true}
+				continue
+			}
+			if nodeCtx, ok := rc.nodes[item.OutId]; ok {
+				entry.nodes = append(entry.nodes, nodeCtx)
+			}
+			table[key] = entry
+		}
+	}
+	rc.routingTable.Store(table)
+}
+
+// invalidateRelationCache 重新构建路由表，并清除以指定节点为起点或者子节点列表包含指定节点的关系缓存
+func (rc *RuleChainCtx) invalidateRelationCache(ruleNodeIds ...types.RuleNodeId) {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.removeRelationCache(ruleNodeIds...)
+}
+
+// removeRelationCache 重新构建路由表，并使用不包含指定节点的关系缓存副本替换当前缓存，调用方需要持有写锁
+// 替换而不是原地删除，避免并发的 GetNextNodes 把按照旧路由解析的节点写回新的缓存
+func (rc *RuleChainCtx) removeRelationCache(ruleNodeIds ...types.RuleNodeId) {
+	rc.buildRoutingTable()
+	rc.buildParentRoutes()
+	affected := func(id types.RuleNodeId) bool {
+		for _, item := range ruleNodeIds {
+			if item.Id == id.Id {
+				return true
+			}
+		}
+		return false
+	}
+	relationCache := make(map[RelationCache][]types.NodeCtx, len(rc.relationCache))
+	for key, nodeCtxList := range rc.relationCache {
+		retain := !affected(key.inNodeId)
+		for _, nodeCtx := range nodeCtxList {
+			if !retain {
+				break
+			}
+			//规则链引用自身作为子规则链时，持有写锁不能再获取自身的ID
+			retain = nodeCtx == types.NodeCtx(rc) || !affected(nodeCtx.GetNodeId())
+		}
+		if retain {
+			relationCache[key] = nodeCtxList
+		}
+	}
+	rc.relationCache = relationCache
+}
+
+// DSL 获取规则链定义，编码结果会被缓存，规则链定义变化后重新编码。返回的是缓存的副本
+// 编码失败返回nil并打印日志，需要获取错误使用 EncodeDSL
 func (rc *RuleChainCtx) DSL() []byte {
-	v, _ := rc.config.Parser.EncodeRuleChain(rc.SelfDefinition)
+	v, err := rc.EncodeDSL()
+	if err != nil {
+		rc.RLock()
+		logger, chainId := rc.config.Logger, rc.Id.Id
+		rc.RUnlock()
+		if logger != nil {
+			logger.Printf("rule chain %s encode dsl error:%s", chainId, err)
+		}
+	}
 	return v
 }
 
+// EncodeDSL 获取规则链定义，返回编码错误。编码结果会被缓存，返回的是缓存的副本
+func (rc *RuleChainCtx) EncodeDSL() ([]byte, error) {
+	version := atomic.LoadUint64(&rc.dslVersion)
+	if cache, ok := rc.dslCache.Load().(dslCache); ok && cache.version == version {
+		return append([]byte(nil), cache.dsl...), nil
+	}
+	rc.RLock()
+	def, parser := rc.SelfDefinition, rc.config.Parser
+	rc.RUnlock()
+	v, err := parser.EncodeRuleChain(def)
+	if err != nil {
+		return nil, err
+	}
+	rc.dslCache.Store(dslCache{version: version, dsl: v})
+	return append([]byte(nil), v...), nil
+}
+
+// invalidateDSL 规则链定义变化后调用，清除DSL缓存
+func (rc *RuleChainCtx) invalidateDSL() {
+	atomic.AddUint64(&rc.dslVersion, 1)
+}
+
+// Definition 获取规则链定义，重新加载和运行时编辑会整体替换定义，不会修改返回的定义
 func (rc *RuleChainCtx) Definition() *types.RuleChain {
+	rc.RLock()
+	defer rc.RUnlock()
 	return rc.SelfDefinition
 }
 
@@ -341,16 +1657,56 @@ func (rc *RuleChainCtx) Copy(newCtx *RuleChainCtx) {
 	rc.SelfDefinition = newCtx.SelfDefinition
 	rc.nodeIds = newCtx.nodeIds
 	rc.nodes = newCtx.nodes
+	rc.nodesSnapshot.Store(newCtx.nodes)
 	rc.nodeRoutes = newCtx.nodeRoutes
-	rc.rootRuleContext = newCtx.rootRuleContext
+	rc.parentRoutes = newCtx.parentRoutes
 	rc.ruleChainPool = newCtx.ruleChainPool
+	rc.aspectsLock.Lock()
 	rc.aspects = newCtx.aspects
 	rc.reloadAspects = newCtx.reloadAspects
 	rc.destroyAspects = newCtx.destroyAspects
+	rc.aspectsLock.Unlock()
+	//新实例的根上下文和节点引用临时的规则链实例，重新绑定到当前规则链，
+	//否则运行时编辑、路由表替换、切面和事件订阅对重新加载后的消息不生效
+	rc.rootRuleContext = rc.rebindRuleContext(newCtx, newCtx.rootRuleContext)
+	rc.entryRuleContexts = nil
+	for name, entryCtx := range newCtx.entryRuleContexts {
+		if rc.entryRuleContexts == nil {
+			rc.entryRuleContexts = make(map[string]types.RuleContext, len(newCtx.entryRuleContexts))
+		}
+		rc.entryRuleContexts[name] = rc.rebindRuleContext(newCtx, entryCtx)
+	}
+	for _, nodeCtx := range newCtx.nodes {
+		//复用的节点实例可能正在执行消息，只重新绑定新创建的节点实例
+		if ruleNodeCtx, ok := nodeCtx.(*RuleNodeCtx); ok && ruleNodeCtx.ChainCtx == newCtx {
+			ruleNodeCtx.ChainCtx = rc
+		}
+	}
 	rc.vars = newCtx.vars
 	rc.decryptSecrets = newCtx.decryptSecrets
-	//清除缓存
+	rc.isEmpty = newCtx.isEmpty
+	rc.cleanups = newCtx.cleanups
+	rc.tags = newCtx.tags
+	//新实例的上下文引用新实例，共享计数器使统计记录到当前规则链
+	newCtx.stats = rc.stats
+	newCtx.debug = rc.debug
+	rc.debugSampler.Store(newCtx.debugSampler.Load())
+	rc.poolOverflow.Store(newCtx.poolOverflow.Load())
+	rc.priorityDispatcher.Store(newCtx.priorityDispatcher.Load())
+	//替换路由表，清除缓存
+	if table, ok := newCtx.routingTable.Load().(routingTable); ok {
+		rc.routingTable.Store(table)
+	}
 	rc.relationCache = make(map[RelationCache][]types.NodeCtx)
+	rc.invalidateDSL()
+}
+
+// rebindRuleContext 使用当前规则链重新创建引用newCtx的根上下文，调用方需要持有写锁
+func (rc *RuleChainCtx) rebindRuleContext(newCtx *RuleChainCtx, ruleCtx types.RuleContext) types.RuleContext {
+	if rootCtx, ok := ruleCtx.(*DefaultRuleContext); ok && rootCtx.ruleChainCtx == newCtx {
+		return NewRuleContext(rootCtx.GetContext(), rc.config, rc, nil, rootCtx.self, rootCtx.pool, nil, nil)
+	}
+	return ruleCtx
 }
 
 // SetRuleChainPool 设置子规则链池
@@ -361,19 +1717,92 @@ func (rc *RuleChainCtx) SetRuleChainPool(ruleChainPool types.RuleEnginePool) {
 // GetRuleChainPool 获取子规则链池
 func (rc *RuleChainCtx) GetRuleChainPool() types.RuleEnginePool {
 	if rc.ruleChainPool == nil {
-		return DefaultPool
+		return fallbackPool(rc.config)
 	} else {
 		return rc.ruleChainPool
 	}
 }
 
 func (rc *RuleChainCtx) SetAspects(aspects types.AspectList) {
+	rc.aspectsLock.Lock()
+	defer rc.aspectsLock.Unlock()
+	rc.setAspects(aspects)
+}
+
+// GetAspects 获取切面列表
+func (rc *RuleChainCtx) GetAspects() types.AspectList {
+	rc.aspectsLock.RLock()
+	defer rc.aspectsLock.RUnlock()
+	return rc.aspects
+}
+
+// AddAspect 增加切面，不需要重新加载规则链，新的消息开始生效，例如：临时开启某个规则链的跟踪
+func (rc *RuleChainCtx) AddAspect(aspects ...types.Aspect) {
+	rc.aspectsLock.Lock()
+	defer rc.aspectsLock.Unlock()
+	//复制一份，不修改正在处理的消息使用的切面列表
+	var newAspects = make(types.AspectList, 0, len(rc.aspects)+len(aspects))
+	newAspects = append(newAspects, rc.aspects...)
+	newAspects = append(newAspects, aspects...)
+	rc.setAspects(newAspects)
+}
+
+// RemoveAspect 删除满足条件的切面，返回删除的数量，新的消息开始生效
+func (rc *RuleChainCtx) RemoveAspect(predicate func(aspect types.Aspect) bool) int {
+	rc.aspectsLock.Lock()
+	defer rc.aspectsLock.Unlock()
+	var newAspects = make(types.AspectList, 0, len(rc.aspects))
+	for _, item := range rc.aspects {
+		if !predicate(item) {
+			newAspects = append(newAspects, item)
+		}
+	}
+	removed := len(rc.aspects) - len(newAspects)
+	if removed > 0 {
+		rc.setAspects(newAspects)
+	}
+	return removed
+}
+
+// engineAspects 获取重新加载和销毁切面列表
+func (rc *RuleChainCtx) engineAspects() ([]types.OnReloadAspect, []types.OnDestroyAspect) {
+	rc.aspectsLock.RLock()
+	defer rc.aspectsLock.RUnlock()
+	return rc.reloadAspects, rc.destroyAspects
+}
+
+// setAspects 设置切面列表，并重新分组重新加载和销毁切面，调用方需要持有aspectsLock写锁
+func (rc *RuleChainCtx) setAspects(aspects types.AspectList) {
 	rc.aspects = aspects
 	_, reloadAspects, destroyAspects := aspects.GetEngineAspects()
 	rc.reloadAspects = reloadAspects
 	rc.destroyAspects = destroyAspects
 }
 
+// missingSecrets 返回secrets中缺失的必须secrets key
+func missingSecrets(required interface{}, secrets map[string]string) []string {
+	var missing []string
+	var keys []string
+	switch v := required.(type) {
+	case []string:
+		keys = v
+	case []interface{}:
+		for _, item := range v {
+			keys = append(keys, str.ToString(item))
+		}
+	case string:
+		if v != "" {
+			keys = strings.Split(v, ",")
+		}
+	}
+	for _, key := range keys {
+		if _, ok := secrets[strings.TrimSpace(key)]; !ok {
+			missing = append(missing, strings.TrimSpace(key))
+		}
+	}
+	return missing
+}
+
 func decryptSecret(inputMap map[string]string, secretKey []byte) map[string]string {
 	result := make(map[string]string)
 	for key, value := range inputMap {
@@ -385,3 +1814,137 @@ func decryptSecret(inputMap map[string]string, secretKey []byte) map[string]stri
 	}
 	return result
 }
+
+// SubscribePolicy 订阅者处理事件太慢，缓冲区满时的处理策略
+type SubscribePolicy int
+
+const (
+	// SubscribeDrop 丢弃新的事件，不影响规则链执行
+	SubscribeDrop SubscribePolicy = iota
+	// SubscribeBlock 阻塞规则链执行，直到订阅者接收事件或者取消订阅
+	SubscribeBlock
+)
+
+// Event 规则链执行事件
+type Event struct {
+	//ChainId 规则链ID
+	ChainId string
+	//MsgId 消息ID
+	MsgId string
+	//NodeId 节点ID
+	NodeId string
+	//FlowType 流向 IN:进入节点 OUT:离开节点
+	FlowType string
+	//RelationType 节点输出的关系类型，FlowType=IN时为上一个节点的关系类型
+	RelationType string
+	//Err 节点执行错误
+	Err error
+	//Msg 消息副本
+	Msg types.RuleMsg
+	//Ts 事件时间，毫秒
+	Ts int64
+}
+
+// Subscription 规则链执行事件订阅
+type Subscription struct {
+	chainCtx *RuleChainCtx
+	events   chan Event
+	policy   SubscribePolicy
+	//done 取消订阅后关闭，用于唤醒阻塞的发送者
+	done    chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// Events 事件通道，取消订阅后关闭
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped 因为缓冲区满丢弃的事件数量
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Unsubscribe 取消订阅，停止发送事件并关闭事件通道，可以重复调用
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.done)
+		rc := s.chainCtx
+		//等待正在发送的事件结束，再关闭事件通道
+		rc.subscribersLock.Lock()
+		delete(rc.subscribers, s)
+		atomic.StoreInt32(&rc.subscriberCount, int32(len(rc.subscribers)))
+		rc.subscribersLock.Unlock()
+		close(s.events)
+	})
+}
+
+// Subscribe 订阅规则链实时执行事件：消息进入节点、节点输出关系和错误，所有节点都会产生事件，与节点是否开启调试模式无关
+// bufferSize 事件缓冲区大小，policy 缓冲区满时的处理策略
+// 订阅在规则链重新加载后保留，规则链销毁时自动取消
+func (rc *RuleChainCtx) Subscribe(bufferSize int, policy SubscribePolicy) *Subscription {
+	if bufferSize < 0 {
+		bufferSize = 0
+	}
+	s := &Subscription{
+		chainCtx: rc,
+		events:   make(chan Event, bufferSize),
+		policy:   policy,
+		done:     make(chan struct{}),
+	}
+	rc.subscribersLock.Lock()
+	defer rc.subscribersLock.Unlock()
+	if rc.subscribers == nil {
+		rc.subscribers = make(map[*Subscription]struct{})
+	}
+	rc.subscribers[s] = struct{}{}
+	atomic.StoreInt32(&rc.subscriberCount, int32(len(rc.subscribers)))
+	return s
+}
+
+// hasSubscribers 是否有执行事件订阅者
+func (rc *RuleChainCtx) hasSubscribers() bool {
+	return atomic.LoadInt32(&rc.subscriberCount) > 0
+}
+
+// publish 发送执行事件给所有订阅者
+func (rc *RuleChainCtx) publish(event Event) {
+	rc.subscribersLock.RLock()
+	defer rc.subscribersLock.RUnlock()
+	for s := range rc.subscribers {
+		if s.policy == SubscribeBlock {
+			select {
+			case s.events <- event:
+			case <-s.done:
+			}
+		} else {
+			select {
+			case s.events <- event:
+			default:
+				atomic.AddUint64(&s.dropped, 1)
+			}
+		}
+	}
+}
+
+// splitTags 解析逗号分隔的标签，忽略空标签和重复标签
+func splitTags(v string) []string {
+	var tags []string
+	for _, tag := range strings.Split(v, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" && !containsTag(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// containsTag 标签列表是否包含指定标签
+func containsTag(tags []string, tag string) bool {
+	for _, item := range tags {
+		if item == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,439 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"strings"
+)
+
+// ErrNodeInUse 删除的节点被其他连接引用
+var ErrNodeInUse = errors.New("node is referenced by connections")
+
+// AddNode 在规则链运行时新增一个节点，不重新加载其他节点。新增的节点没有任何连接，需要通过 AddConnection 连接到其他节点
+// 规则链定义同步更新，DSL()返回编辑后的定义
+func (rc *RuleChainCtx) AddNode(def types.RuleNode) error {
+	nodeCtx, err := rc.addNode(&def)
+	return rc.onMutation(nodeCtx, types.ChainMutation{Op: types.MutationAddNode, NodeId: def.Id}, err)
+}
+
+func (rc *RuleChainCtx) addNode(def *types.RuleNode) (types.NodeCtx, error) {
+	if def.Id == "" {
+		return nil, errors.New("node id can not be empty")
+	}
+	ruleNodeId := types.RuleNodeId{Id: def.Id, Type: types.NODE}
+	if _, ok := rc.GetNodeById(ruleNodeId); ok {
+		return nil, fmt.Errorf("node %s already exists", def.Id)
+	}
+	ruleNodeCtx, err := InitRuleNodeCtx(rc.config, rc, def)
+	if err != nil {
+		return nil, err
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	if _, ok := rc.nodes[ruleNodeId]; ok {
+		ruleNodeCtx.Destroy()
+		return nil, fmt.Errorf("node %s already exists", def.Id)
+	}
+	newDef := rc.copyDefinition()
+	newDef.Metadata.Nodes = append(newDef.Metadata.Nodes, def)
+	nodes := copyNodes(rc.nodes)
+	nodes[ruleNodeId] = ruleNodeCtx
+	rc.SelfDefinition = newDef
+	rc.nodeIds = append(append([]types.RuleNodeId{}, rc.nodeIds...), ruleNodeId)
+	rc.nodes = nodes
+	rc.nodesSnapshot.Store(nodes)
+	//空规则链新增第一个节点后，消息从该节点开始流转
+	if rc.isEmpty {
+		if firstNode, ok := rc.getFirstNode(); ok {
+			baseCtx := rc.config.BaseContext
+			if baseCtx == nil {
+				baseCtx = context.Background()
+			}
+			rc.rootRuleContext = NewRuleContext(baseCtx, rc.config, rc, nil, firstNode, rc.config.Pool, nil, nil)
+			rc.isEmpty = false
+		}
+	}
+	return ruleNodeCtx, nil
+}
+
+// UpdateNode 在规则链运行时更新一个已经存在的节点，只重新初始化该节点，节点的连接保持不变
+// 新的消息立即使用新的节点实例，旧的节点实例在已经开始执行的消息完成后销毁
+func (rc *RuleChainCtx) UpdateNode(def types.RuleNode) error {
+	nodeCtx, oldNodeCtx, err := rc.updateNode(&def)
+	if err == nil {
+		//先停止旧的节点实例，新的节点实例在执行切面前启动
+		oldNodes := map[types.RuleNodeId]types.NodeCtx{oldNodeCtx.GetNodeId(): oldNodeCtx}
+		stopNodes(oldNodes)
+		rc.drainAndDestroy(oldNodes, nil)
+	}
+	return rc.onMutation(nodeCtx, types.ChainMutation{Op: types.MutationUpdateNode, NodeId: def.Id}, err)
+}
+
+// updateNode 初始化新的节点实例，并替换节点实例映射和规则链定义的副本，返回新的节点实例和被替换的节点实例
+func (rc *RuleChainCtx) updateNode(def *types.RuleNode) (types.NodeCtx, types.NodeCtx, error) {
+	ruleNodeId := types.RuleNodeId{Id: def.Id, Type: types.NODE}
+	nodeCtx, ok := rc.GetNodeById(ruleNodeId)
+	if !ok {
+		return nil, nil, fmt.Errorf("node %s not found", def.Id)
+	}
+	ruleNodeCtx, err := InitRuleNodeCtx(rc.config, rc, def)
+	if err != nil {
+		return nodeCtx, nil, err
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	oldNodeCtx, ok := rc.nodes[ruleNodeId]
+	if !ok {
+		ruleNodeCtx.Destroy()
+		return nodeCtx, nil, fmt.Errorf("node %s not found", def.Id)
+	}
+	newDef := rc.copyDefinition()
+	for i, item := range newDef.Metadata.Nodes {
+		if item.Id == def.Id {
+			newDef.Metadata.Nodes[i] = def
+		}
+	}
+	nodes := copyNodes(rc.nodes)
+	nodes[ruleNodeId] = ruleNodeCtx
+	rc.SelfDefinition = newDef
+	rc.nodes = nodes
+	rc.nodesSnapshot.Store(nodes)
+	rc.replaceRootContexts(oldNodeCtx, ruleNodeCtx)
+	rc.removeRelationCache(ruleNodeId)
+	return ruleNodeCtx, oldNodeCtx, nil
+}
+
+// replaceRootContexts 根上下文或者命名入口从被替换的节点开始执行时，使用新的节点实例重新创建，调用方需要持有写锁
+func (rc *RuleChainCtx) replaceRootContexts(oldNodeCtx, nodeCtx types.NodeCtx) {
+	baseCtx := rc.config.BaseContext
+	if baseCtx == nil {
+		baseCtx = context.Background()
+	}
+	if rc.rootRuleContext != nil && rc.rootRuleContext.Self() == oldNodeCtx {
+		rc.rootRuleContext = NewRuleContext(baseCtx, rc.config, rc, nil, nodeCtx, rc.config.Pool, nil, nil)
+	}
+	var entryRuleContexts map[string]types.RuleContext
+	for name, entryCtx := range rc.entryRuleContexts {
+		if entryCtx.Self() == oldNodeCtx {
+			if entryRuleContexts == nil {
+				entryRuleContexts = make(map[string]types.RuleContext, len(rc.entryRuleContexts))
+				for k, v := range rc.entryRuleContexts {
+					entryRuleContexts[k] = v
+				}
+			}
+			entryRuleContexts[name] = NewRuleContext(baseCtx, rc.config, rc, nil, nodeCtx, rc.config.Pool, nil, nil)
+		}
+	}
+	if entryRuleContexts != nil {
+		rc.entryRuleContexts = entryRuleContexts
+	}
+}
+
+// RemoveNode 在规则链运行时删除一个节点，节点实例在已经开始执行的消息完成后销毁
+// 如果节点被连接引用，cascade=true 同时删除这些连接，否则返回 ErrNodeInUse。
+// 第一个节点、命名入口节点和死信节点不能删除
+func (rc *RuleChainCtx) RemoveNode(id string, cascade bool) error {
+	nodeCtx, removed, err := rc.removeNode(id, cascade)
+	if err == nil {
+		rc.debug.removeNode(id)
+		rc.drainAndDestroy(map[types.RuleNodeId]types.NodeCtx{nodeCtx.GetNodeId(): nodeCtx}, nil)
+	}
+	return rc.onMutation(nodeCtx, types.ChainMutation{Op: types.MutationRemoveNode, NodeId: id, Connections: removed}, err)
+}
+
+func (rc *RuleChainCtx) removeNode(id string, cascade bool) (types.NodeCtx, []types.NodeConnection, error) {
+	ruleNodeId := types.RuleNodeId{Id: id, Type: types.NODE}
+	rc.Lock()
+	defer rc.Unlock()
+	nodeCtx, ok := rc.nodes[ruleNodeId]
+	if !ok {
+		return nil, nil, fmt.Errorf("node %s not found", id)
+	}
+	metadata := rc.SelfDefinition.Metadata
+	index := -1
+	for i, item := range rc.nodeIds {
+		if item == ruleNodeId {
+			index = i
+			break
+		}
+	}
+	if metadata.FirstNodeId == id || (metadata.FirstNodeId == "" && metadata.FirstNodeIndex == index) {
+		return nodeCtx, nil, fmt.Errorf("node %s is the first node of the rule chain", id)
+	}
+	for name, nodeId := range metadata.EntryPoints {
+		if nodeId == id {
+			return nodeCtx, nil, fmt.Errorf("node %s is the entry point %s", id, name)
+		}
+	}
+	if rc.SelfDefinition.RuleChain.DeadLetterNodeId == id {
+		return nodeCtx, nil, fmt.Errorf("node %s is the dead letter node of the rule chain", id)
+	}
+	newDef := rc.copyDefinition()
+	var removed []types.NodeConnection
+	var connections []types.NodeConnection
+	for _, item := range newDef.Metadata.Connections {
+		if item.FromId == id || item.ToId == id {
+			removed = append(removed, item)
+		} else {
+			connections = append(connections, item)
+		}
+	}
+	var ruleChainConnections []types.RuleChainConnection
+	for _, item := range newDef.Metadata.RuleChainConnections {
+		if item.FromId == id {
+			removed = append(removed, types.NodeConnection{FromId: item.FromId, ToId: item.ToId, Type: item.Type, Priority: item.Priority})
+		} else {
+			ruleChainConnections = append(ruleChainConnections, item)
+		}
+	}
+	if len(removed) > 0 && !cascade {
+		return nodeCtx, nil, fmt.Errorf("%w: %s has %d connections", ErrNodeInUse, id, len(removed))
+	}
+	newDef.Metadata.Connections = connections
+	newDef.Metadata.RuleChainConnections = ruleChainConnections
+	newDef.Metadata.Nodes = append(newDef.Metadata.Nodes[:index:index], newDef.Metadata.Nodes[index+1:]...)
+	//按照索引指定的第一个节点位置前移
+	if metadata.FirstNodeId == "" && index < metadata.FirstNodeIndex {
+		newDef.Metadata.FirstNodeIndex--
+	}
+	nodeIds := append(rc.nodeIds[:index:index], rc.nodeIds[index+1:]...)
+	nodes := copyNodes(rc.nodes)
+	delete(nodes, ruleNodeId)
+	nodeRoutes := make(map[types.RuleNodeId][]types.RuleNodeRelation, len(rc.nodeRoutes))
+	for inNodeId, relations := range rc.nodeRoutes {
+		if inNodeId == ruleNodeId {
+			continue
+		}
+		nodeRoutes[inNodeId] = filterRelations(relations, func(item types.RuleNodeRelation) bool {
+			return item.OutId != ruleNodeId
+		})
+	}
+	rc.SelfDefinition = newDef
+	rc.nodeIds = nodeIds
+	rc.nodes = nodes
+	rc.nodesSnapshot.Store(nodes)
+	rc.nodeRoutes = nodeRoutes
+	rc.removeRelationCache(ruleNodeId)
+	return nodeCtx, removed, nil
+}
+
+// AddConnection 在规则链运行时新增fromId节点到toId节点的relationType关系连接
+// 两个节点都必须已经存在，不允许环路时，形成环路的连接返回 ErrRuleChainCycle
+func (rc *RuleChainCtx) AddConnection(fromId, toId, relationType string) error {
+	connection := types.NodeConnection{FromId: fromId, ToId: toId, Type: relationType}
+	err := rc.addConnection(connection)
+	return rc.onMutation(rc, types.ChainMutation{Op: types.MutationAddConnection, Connections: []types.NodeConnection{connection}}, err)
+}
+
+func (rc *RuleChainCtx) addConnection(connection types.NodeConnection) error {
+	inNodeId := types.RuleNodeId{Id: connection.FromId, Type: types.NODE}
+	outNodeId := types.RuleNodeId{Id: connection.ToId, Type: types.NODE}
+	rc.Lock()
+	defer rc.Unlock()
+	for _, id := range []types.RuleNodeId{inNodeId, outNodeId} {
+		if _, ok := rc.nodes[id]; !ok {
+			return fmt.Errorf("node %s not found", id.Id)
+		}
+	}
+	for _, item := range rc.SelfDefinition.Metadata.Connections {
+		if sameConnection(item, connection) {
+			return fmt.Errorf("connection %s -%s-> %s already exists", connection.FromId, connection.Type, connection.ToId)
+		}
+	}
+	newDef := rc.copyDefinition()
+	newDef.Metadata.Connections = append(newDef.Metadata.Connections, connection)
+	nodeRoutes := copyNodeRoutes(rc.nodeRoutes)
+	nodeRoutes[inNodeId] = relationsOf(newDef, connection.FromId)
+	if !rc.config.AllowCycle {
+		graph := &RuleChainCtx{nodeIds: rc.nodeIds, nodeRoutes: nodeRoutes}
+		if cycle := graph.findCycle(); len(cycle) > 0 {
+			return fmt.Errorf("%w: %s", ErrRuleChainCycle, strings.Join(cycle, "->"))
+		}
+	}
+	rc.SelfDefinition = newDef
+	rc.nodeRoutes = nodeRoutes
+	rc.removeRelationCache(inNodeId)
+	return nil
+}
+
+// RemoveConnection 在规则链运行时删除fromId节点到toId节点的relationType关系连接
+func (rc *RuleChainCtx) RemoveConnection(fromId, toId, relationType string) error {
+	connection := types.NodeConnection{FromId: fromId, ToId: toId, Type: relationType}
+	err := rc.removeConnection(connection)
+	return rc.onMutation(rc, types.ChainMutation{Op: types.MutationRemoveConnection, Connections: []types.NodeConnection{connection}}, err)
+}
+
+func (rc *RuleChainCtx) removeConnection(connection types.NodeConnection) error {
+	inNodeId := types.RuleNodeId{Id: connection.FromId, Type: types.NODE}
+	outNodeId := types.RuleNodeId{Id: connection.ToId, Type: types.NODE}
+	rc.Lock()
+	defer rc.Unlock()
+	newDef := rc.copyDefinition()
+	var connections []types.NodeConnection
+	for _, item := range newDef.Metadata.Connections {
+		if !sameConnection(item, connection) {
+			connections = append(connections, item)
+		}
+	}
+	if len(connections) == len(newDef.Metadata.Connections) {
+		return fmt.Errorf("connection %s -%s-> %s not found", connection.FromId, connection.Type, connection.ToId)
+	}
+	newDef.Metadata.Connections = connections
+	nodeRoutes := copyNodeRoutes(rc.nodeRoutes)
+	nodeRoutes[inNodeId] = filterRelations(nodeRoutes[inNodeId], func(item types.RuleNodeRelation) bool {
+		return item.OutId != outNodeId || item.RelationType != connection.Type
+	})
+	rc.SelfDefinition = newDef
+	rc.nodeRoutes = nodeRoutes
+	rc.removeRelationCache(inNodeId)
+	return nil
+}
+
+// onMutation 执行reload切面，实现 types.OnMutationAspect 的切面调用OnMutation，其他切面调用OnReload
+func (rc *RuleChainCtx) onMutation(nodeCtx types.NodeCtx, mutation types.ChainMutation, err error) error {
+	if nodeCtx == nil {
+		nodeCtx = rc
+	}
+	if err == nil {
+		rc.invalidateDSL()
+		//启动运行时新增的节点
+		if rc.isStarted() {
+			rc.startNodes()
+		}
+	}
+	reloadAspects, _ := rc.engineAspects()
+	for _, aop := range reloadAspects {
+		var aopErr error
+		if mutationAop, ok := aop.(types.OnMutationAspect); ok {
+			aopErr = mutationAop.OnMutation(rc, mutation, err)
+		} else {
+			aopErr = aop.OnReload(rc, nodeCtx, err)
+		}
+		if aopErr != nil {
+			return aopErr
+		}
+	}
+	return err
+}
+
+// copyDefinition 复制规则链定义，节点和连接列表使用新的切片，调用方需要持有锁
+// 编辑不会修改正在被读取的规则链定义
+func (rc *RuleChainCtx) copyDefinition() *types.RuleChain {
+	def := *rc.SelfDefinition
+	def.Metadata.Nodes = append([]*types.RuleNode{}, def.Metadata.Nodes...)
+	def.Metadata.Connections = append([]types.NodeConnection{}, def.Metadata.Connections...)
+	def.Metadata.RuleChainConnections = append([]types.RuleChainConnection(nil), def.Metadata.RuleChainConnections...)
+	return &def
+}
+
+// copyNodes 复制节点实例映射
+func copyNodes(nodes map[types.RuleNodeId]types.NodeCtx) map[types.RuleNodeId]types.NodeCtx {
+	result := make(map[types.RuleNodeId]types.NodeCtx, len(nodes)+1)
+	for k, v := range nodes {
+		result[k] = v
+	}
+	return result
+}
+
+// copyNodeRoutes 复制节点路由映射，路由列表与原映射共享，修改前需要复制
+func copyNodeRoutes(nodeRoutes map[types.RuleNodeId][]types.RuleNodeRelation) map[types.RuleNodeId][]types.RuleNodeRelation {
+	result := make(map[types.RuleNodeId][]types.RuleNodeRelation, len(nodeRoutes)+1)
+	for k, v := range nodeRoutes {
+		result[k] = v
+	}
+	return result
+}
+
+// filterRelations 返回满足条件的路由关系新列表
+func filterRelations(relations []types.RuleNodeRelation, keep func(item types.RuleNodeRelation) bool) []types.RuleNodeRelation {
+	var result []types.RuleNodeRelation
+	for _, item := range relations {
+		if keep(item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// relationsOf 按照规则链定义获取指定节点的路由关系，顺序与初始化规则链时一致
+func relationsOf(def *types.RuleChain, fromId string) []types.RuleNodeRelation {
+	inNodeId := types.RuleNodeId{Id: fromId, Type: types.NODE}
+	var relations []types.RuleNodeRelation
+	for _, item := range def.Metadata.Connections {
+		if item.FromId == fromId {
+			relations = append(relations, types.RuleNodeRelation{InId: inNodeId, OutId: types.RuleNodeId{Id: item.ToId, Type: types.NODE},
+				RelationType: item.Type, Priority: item.Priority})
+		}
+	}
+	for _, item := range def.Metadata.RuleChainConnections {
+		if item.FromId == fromId {
+			relations = append(relations, types.RuleNodeRelation{InId: inNodeId, OutId: types.RuleNodeId{Id: item.ToId, Type: types.CHAIN},
+				RelationType: item.Type, Priority: item.Priority})
+		}
+	}
+	sortRelations(relations)
+	return relations
+}
+
+// sameConnection 是否是同一个连接，不比较优先级
+func sameConnection(a, b types.NodeConnection) bool {
+	return a.FromId == b.FromId && a.ToId == b.ToId && a.Type == b.Type
+}
+
+// AddNode 在根规则链运行时新增一个节点，参考 RuleChainCtx.AddNode
+func (e *RuleEngine) AddNode(def types.RuleNode) error {
+	if !e.Initialized() {
+		return errors.New("AddNode error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.AddNode(def)
+}
+
+// UpdateNode 在根规则链运行时更新一个节点，参考 RuleChainCtx.UpdateNode
+func (e *RuleEngine) UpdateNode(def types.RuleNode) error {
+	if !e.Initialized() {
+		return errors.New("UpdateNode error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.UpdateNode(def)
+}
+
+// RemoveNode 在根规则链运行时删除一个节点，参考 RuleChainCtx.RemoveNode
+func (e *RuleEngine) RemoveNode(id string, cascade bool) error {
+	if !e.Initialized() {
+		return errors.New("RemoveNode error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.RemoveNode(id, cascade)
+}
+
+// AddConnection 在根规则链运行时新增一个连接，参考 RuleChainCtx.AddConnection
+func (e *RuleEngine) AddConnection(fromId, toId, relationType string) error {
+	if !e.Initialized() {
+		return errors.New("AddConnection error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.AddConnection(fromId, toId, relationType)
+}
+
+// RemoveConnection 在根规则链运行时删除一个连接，参考 RuleChainCtx.RemoveConnection
+func (e *RuleEngine) RemoveConnection(fromId, toId, relationType string) error {
+	if !e.Initialized() {
+		return errors.New("RemoveConnection error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.RemoveConnection(fromId, toId, relationType)
+}
@@ -17,18 +17,38 @@
 package engine
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/test/assert"
+	"github.com/rulego/rulego/utils/dsl"
 	"github.com/rulego/rulego/utils/maps"
 	"github.com/rulego/rulego/utils/str"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// testNodes 创建指定ID的节点列表，用于只关心节点连接的测试
+func testNodes(ids ...string) []*types.RuleNode {
+	var nodes []*types.RuleNode
+	for _, id := range ids {
+		nodes = append(nodes, &types.RuleNode{Id: id, Type: "jsFilter", Configuration: types.Configuration{"jsScript": "return true;"}})
+	}
+	return nodes
+}
+
 func TestChainCtx(t *testing.T) {
 
 	ruleChainDef := types.RuleChain{}
+	ruleChainDef.Metadata.Nodes = testNodes("s1")
 
 	t.Run("New", func(t *testing.T) {
 		defer func() {
@@ -58,7 +78,10 @@ func TestChainCtx(t *testing.T) {
 		ruleNode := types.RuleNode{Type: "notFound"}
 		newRuleChainDef.Metadata.Nodes = append(newRuleChainDef.Metadata.Nodes, &ruleNode)
 		err = ctx.Init(NewConfig(), types.Configuration{"selfDefinition": &newRuleChainDef})
-		assert.Equal(t, "component not found. componentType=notFound", err.Error())
+		assert.Equal(t, "rule chain : node node0(notFound) init error: component not found. componentType=notFound", err.Error())
+		var nodeInitErr *NodeInitError
+		assert.True(t, errors.As(err, &nodeInitErr))
+		assert.Equal(t, "node0", nodeInitErr.NodeId)
 	})
 
 	t.Run("ReloadChildNotFound", func(t *testing.T) {
@@ -147,3 +170,1749 @@ func TestChainCtx(t *testing.T) {
 	})
 
 }
+
+func TestRelationFanout(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ruleChainDef.Metadata.Nodes = testNodes("s1", "s2", "s3", "s4")
+	ruleChainDef.Metadata.Connections = []types.NodeConnection{
+		{FromId: "s1", ToId: "s2", Type: types.Success},
+		{FromId: "s1", ToId: "s3", Type: types.Success},
+		{FromId: "s1", ToId: "s4", Type: types.Failure},
+	}
+	ctx, _ := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	fanout := ctx.RelationFanout(types.RuleNodeId{Id: "s1"})
+	assert.Equal(t, 2, fanout[types.Success])
+	assert.Equal(t, 1, fanout[types.Failure])
+	assert.Equal(t, 0, len(ctx.RelationFanout(types.RuleNodeId{Id: "s2"})))
+}
+
+func TestGetNextNodeIds(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ruleChainDef.Metadata.Nodes = testNodes("s1", "s2", "s3", "s4")
+	ruleChainDef.Metadata.Connections = []types.NodeConnection{
+		{FromId: "s1", ToId: "s2", Type: types.Success},
+		{FromId: "s1", ToId: "s3", Type: types.Success},
+		{FromId: "s1", ToId: "s4", Type: types.Failure},
+	}
+	ruleChainDef.Metadata.RuleChainConnections = []types.RuleChainConnection{
+		{FromId: "s1", ToId: "notExistChain", Type: types.Success},
+	}
+	ctx, _ := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	nodeIds, ok := ctx.GetNextNodeIds(types.RuleNodeId{Id: "s1"}, types.Success)
+	assert.True(t, ok)
+	assert.Equal(t, []types.RuleNodeId{{Id: "s2", Type: types.NODE}, {Id: "s3", Type: types.NODE}, {Id: "notExistChain", Type: types.CHAIN}}, nodeIds)
+	//不解析节点实例，不存在的子规则链同样返回
+	nodes, _ := ctx.GetNextNodes(types.RuleNodeId{Id: "s1"}, types.Success)
+	assert.Equal(t, 2, len(nodes))
+	_, ok = ctx.GetNextNodeIds(types.RuleNodeId{Id: "s1"}, types.True)
+	assert.False(t, ok)
+	_, ok = ctx.GetNextNodeIds(types.RuleNodeId{Id: "s2"}, types.Success)
+	assert.False(t, ok)
+}
+
+func TestDisallowEmptyChain(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ctx, err := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+	assert.True(t, ctx.isEmpty)
+
+	_, err = InitRuleChainCtx(NewConfig(types.WithDisallowEmptyChain(true)), nil, &ruleChainDef)
+	assert.Equal(t, ErrEmptyRuleChain, err)
+}
+
+func TestGraphStats(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ruleChainDef.Metadata.Nodes = testNodes("s1", "s2", "s3", "s4")
+	ruleChainDef.Metadata.Connections = []types.NodeConnection{
+		{FromId: "s1", ToId: "s2", Type: types.Success},
+		{FromId: "s1", ToId: "s3", Type: types.Success},
+		{FromId: "s2", ToId: "s4", Type: types.Success},
+		{FromId: "s4", ToId: "s1", Type: types.Failure},
+	}
+	ctx, _ := InitRuleChainCtx(NewConfig(types.WithAllowCycle(true)), nil, &ruleChainDef)
+	ctx.nodeIds = []types.RuleNodeId{{Id: "s1"}, {Id: "s2"}, {Id: "s3"}, {Id: "s4"}}
+	stats := ctx.GraphStats()
+	assert.Equal(t, 4, stats.NodeCount)
+	assert.Equal(t, 4, stats.EdgeCount)
+	assert.Equal(t, 2, stats.MaxBranching)
+	assert.Equal(t, 1.0, stats.AvgBranching)
+	assert.Equal(t, 2, stats.LongestPath)
+	assert.True(t, stats.HasCycle)
+}
+
+func TestCycleDetection(t *testing.T) {
+	newChainDef := func(connections ...types.NodeConnection) *types.RuleChain {
+		ruleChainDef := &types.RuleChain{}
+		ruleChainDef.Metadata.Nodes = testNodes("s1", "s2", "s3", "s4")
+		ruleChainDef.Metadata.Connections = connections
+		ruleChainDef.Metadata.RuleChainConnections = []types.RuleChainConnection{{FromId: "s4", ToId: "subChain", Type: types.True}}
+		return ruleChainDef
+	}
+	//菱形，无环路
+	_, err := InitRuleChainCtx(NewConfig(), nil, newChainDef(
+		types.NodeConnection{FromId: "s1", ToId: "s2", Type: types.True},
+		types.NodeConnection{FromId: "s1", ToId: "s3", Type: types.False},
+		types.NodeConnection{FromId: "s2", ToId: "s4", Type: types.True},
+		types.NodeConnection{FromId: "s3", ToId: "s4", Type: types.True},
+	))
+	assert.Nil(t, err)
+
+	//三个节点组成环路
+	cycleDef := newChainDef(
+		types.NodeConnection{FromId: "s1", ToId: "s2", Type: types.True},
+		types.NodeConnection{FromId: "s2", ToId: "s3", Type: types.True},
+		types.NodeConnection{FromId: "s3", ToId: "s4", Type: types.False},
+		types.NodeConnection{FromId: "s3", ToId: "s2", Type: types.True},
+	)
+	_, err = InitRuleChainCtx(NewConfig(), nil, cycleDef)
+	assert.True(t, errors.Is(err, ErrRuleChainCycle))
+	assert.Equal(t, "the rule chain connections contain a cycle: s2->s3->s2", err.Error())
+	cycleDef.Metadata.Connections[3] = types.NodeConnection{FromId: "s4", ToId: "s2", Type: types.True}
+	_, err = InitRuleChainCtx(NewConfig(), nil, cycleDef)
+	assert.Equal(t, "the rule chain connections contain a cycle: s2->s3->s4->s2", err.Error())
+	//允许环路
+	_, err = InitRuleChainCtx(NewConfig(types.WithAllowCycle(true)), nil, cycleDef)
+	assert.Nil(t, err)
+
+	//节点连接自身
+	_, err = InitRuleChainCtx(NewConfig(), nil, newChainDef(types.NodeConnection{FromId: "s1", ToId: "s1", Type: types.Failure}))
+	assert.Equal(t, "the rule chain connections contain a cycle: s1->s1", err.Error())
+}
+
+func TestValidateConnections(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ruleChainDef.Metadata.Nodes = testNodes("s1", "s2")
+	ruleChainDef.Metadata.Connections = []types.NodeConnection{
+		{FromId: "s1", ToId: "s2", Type: types.True},
+		{FromId: "s1", ToId: "s3", Type: types.False},
+		{FromId: "s4", ToId: "s2", Type: types.True},
+	}
+	ruleChainDef.Metadata.RuleChainConnections = []types.RuleChainConnection{
+		{FromId: "s2", ToId: "subChain", Type: types.Success},
+		{FromId: "s5", ToId: "subChain", Type: types.Success},
+	}
+	_, err := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	var connectionsErr *dsl.InvalidConnectionsError
+	assert.True(t, errors.As(err, &connectionsErr))
+	assert.Equal(t, []string{
+		"connection s1->s3(False): toId s3 is not a declared node",
+		"connection s4->s2(True): fromId s4 is not a declared node",
+		"rule chain connection s5->subChain(Success): fromId s5 is not a declared node",
+	}, connectionsErr.Problems)
+
+	ruleChainDef.Metadata.Nodes = testNodes("s1", "s2", "s3", "s4", "s5")
+	_, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+}
+
+func TestDuplicateNodeId(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ruleChainDef.Metadata.Nodes = testNodes("node_1", "node_1")
+	ruleChainDef.Metadata.Nodes[1].Type = "jsTransform"
+	_, err := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Equal(t, "duplicate node id node_1: node types jsFilter and jsTransform", err.Error())
+
+	//与自动分配的节点ID重复
+	ruleChainDef.Metadata.Nodes = testNodes("", defaultNodeIdPrefix+"0")
+	_, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Equal(t, "duplicate node id node0: node types jsFilter and jsFilter", err.Error())
+
+	ruleChainDef.Metadata.Nodes = testNodes("", defaultNodeIdPrefix+"0a")
+	_, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+}
+
+func TestGetNodeByIndex(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ctx, err := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+	//没有节点
+	nodeCtx, ok := ctx.GetNodeByIndex(0)
+	assert.False(t, ok)
+	assert.Nil(t, nodeCtx)
+	_, ok = ctx.GetFirstNode()
+	assert.False(t, ok)
+
+	ruleChainDef.Metadata.Nodes = testNodes("s1", "s2")
+	ctx, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+	nodeCtx, ok = ctx.GetNodeByIndex(1)
+	assert.True(t, ok)
+	assert.Equal(t, "s2", nodeCtx.GetNodeId().Id)
+	nodeCtx, ok = ctx.GetNodeByIndex(-1)
+	assert.False(t, ok)
+	assert.Nil(t, nodeCtx)
+	nodeCtx, ok = ctx.GetNodeByIndex(2)
+	assert.False(t, ok)
+	assert.Nil(t, nodeCtx)
+
+	//第一个节点索引越界
+	ruleChainDef.Metadata.FirstNodeIndex = 2
+	_, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Equal(t, "firstNodeIndex 2 is out of range, the rule chain has 2 nodes", err.Error())
+	ruleChainDef.Metadata.FirstNodeIndex = -1
+	_, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Equal(t, "firstNodeIndex -1 is out of range, the rule chain has 2 nodes", err.Error())
+}
+
+func TestFirstNodeId(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ruleChainDef.Metadata.Nodes = testNodes("s1", "s2", "s3")
+	//没有firstNodeId，使用firstNodeIndex
+	ctx, err := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+	firstNode, ok := ctx.GetFirstNode()
+	assert.True(t, ok)
+	assert.Equal(t, "s1", firstNode.GetNodeId().Id)
+
+	//firstNodeId优先于firstNodeIndex
+	ruleChainDef.Metadata.FirstNodeId = "s3"
+	ctx, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+	firstNode, ok = ctx.GetFirstNode()
+	assert.True(t, ok)
+	assert.Equal(t, "s3", firstNode.GetNodeId().Id)
+
+	//同时输出firstNodeIndex，不修改原规则链定义
+	def, err := ParserRuleChain(ctx.DSL())
+	assert.Nil(t, err)
+	assert.Equal(t, "s3", def.Metadata.FirstNodeId)
+	assert.Equal(t, 2, def.Metadata.FirstNodeIndex)
+	assert.Equal(t, 0, ruleChainDef.Metadata.FirstNodeIndex)
+
+	//调整节点顺序，入口节点不变
+	def.Metadata.Nodes[0], def.Metadata.Nodes[2] = def.Metadata.Nodes[2], def.Metadata.Nodes[0]
+	ctx, err = InitRuleChainCtx(NewConfig(), nil, &def)
+	assert.Nil(t, err)
+	firstNode, _ = ctx.GetFirstNode()
+	assert.Equal(t, "s3", firstNode.GetNodeId().Id)
+
+	ruleChainDef.Metadata.FirstNodeId = "s4"
+	_, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Equal(t, "firstNodeId s4 is not a declared node", err.Error())
+}
+
+func TestChainReady(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ctx, _ := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.True(t, ctx.IsInitialized())
+	//没有节点
+	assert.False(t, ctx.IsReady())
+
+	jsonParser := JsonParser{}
+	chainNode, err := jsonParser.DecodeRuleChain(NewConfig(), nil, []byte(`{"ruleChain":{"id":"test01"},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`))
+	assert.Nil(t, err)
+	ctx = chainNode.(*RuleChainCtx)
+	assert.True(t, ctx.IsReady())
+}
+
+func TestRequiredSecrets(t *testing.T) {
+	ruleChainDef := types.RuleChain{}
+	ruleChainDef.RuleChain.Configuration = types.Configuration{
+		types.Secrets:         map[string]interface{}{"api_key": "xx"},
+		types.RequiredSecrets: []interface{}{"api_key"},
+	}
+	_, err := InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Nil(t, err)
+
+	ruleChainDef.RuleChain.Configuration[types.RequiredSecrets] = []interface{}{"api_key", "token", "password"}
+	_, err = InitRuleChainCtx(NewConfig(), nil, &ruleChainDef)
+	assert.Equal(t, "required secrets not found: token,password", err.Error())
+}
+
+func TestExportEffectiveConfig(t *testing.T) {
+	config := NewConfig()
+	config.Properties.PutValue("region", "cn")
+	jsonParser := JsonParser{}
+	chainNode, err := jsonParser.DecodeRuleChain(config, nil, []byte(`{
+		"ruleChain":{"id":"test01","configuration":{"vars":{"host":"192.168.1.1"},"secrets":{"apiKey":"sk-123"}}},
+		"metadata":{"nodes":[
+			{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},
+			{"id":"s2","type":"restApiCall","configuration":{"restEndpointUrlPattern":"http://${vars.host}/${global.region}?key=sk-123","headers":{"Authorization":"Bearer sk-123"},"readTimeoutMs":2000}}
+		]}}`))
+	assert.Nil(t, err)
+	result := chainNode.(*RuleChainCtx).ExportEffectiveConfig()
+	assert.Equal(t, "return true;", result["s1.jsScript"])
+	assert.Equal(t, "http://192.168.1.1/cn?key="+SecretMask, result["s2.restEndpointUrlPattern"])
+	assert.Equal(t, "Bearer "+SecretMask, result["s2.headers.Authorization"])
+	assert.Equal(t, "2000", result["s2.readTimeoutMs"])
+	for k, v := range result {
+		assert.False(t, strings.HasPrefix(k, "s2.vars") || strings.HasPrefix(k, "s2.secrets"))
+		assert.False(t, strings.Contains(v, "sk-123"))
+	}
+}
+
+func TestChainCleanup(t *testing.T) {
+	var order []string
+	engineAspect := &EngineAspect{Callback: &CallbackTest{OnDestroy: func(ctx types.NodeCtx) {
+		order = append(order, "aspect")
+	}}}
+	jsonParser := JsonParser{}
+	chainNode, err := jsonParser.DecodeRuleChain(NewConfig(), types.AspectList{engineAspect}, []byte(`{"ruleChain":{"id":"test01"},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`))
+	assert.Nil(t, err)
+	ctx := chainNode.(*RuleChainCtx)
+	ctx.AddCleanup(func() {
+		order = append(order, "first")
+	})
+	ctx.AddCleanup(func() {
+		order = append(order, "second")
+	})
+	ctx.Destroy()
+	assert.Equal(t, "second,first,aspect", strings.Join(order, ","))
+	//只执行一次
+	ctx.Destroy()
+	assert.Equal(t, "second,first,aspect,aspect", strings.Join(order, ","))
+}
+
+// slowNode 处理消息前等待一段时间，并检查节点是否已经被销毁
+type slowNode struct {
+	destroyed int32
+}
+
+var (
+	slowNodeUsedAfterDestroy int32
+	slowNodeDestroyed        int32
+)
+
+func (n *slowNode) Type() string {
+	return "test/slow"
+}
+
+func (n *slowNode) New() types.Node {
+	return &slowNode{}
+}
+
+func (n *slowNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	return nil
+}
+
+func (n *slowNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	time.Sleep(time.Millisecond * 20)
+	if atomic.LoadInt32(&n.destroyed) == 1 {
+		atomic.AddInt32(&slowNodeUsedAfterDestroy, 1)
+	}
+	ctx.TellSuccess(msg)
+}
+
+func (n *slowNode) Destroy() {
+	atomic.StoreInt32(&n.destroyed, 1)
+	atomic.AddInt32(&slowNodeDestroyed, 1)
+}
+
+func TestReloadDrainInflight(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	atomic.StoreInt32(&slowNodeUsedAfterDestroy, 0)
+	atomic.StoreInt32(&slowNodeDestroyed, 0)
+	def := []byte(`{"ruleChain":{"id":"testReloadDrain"},"metadata":{"nodes":[{"id":"s1","type":"test/slow"},{"id":"s2","type":"test/slow"}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"))
+				}
+			}
+		}()
+	}
+	reloads := 10
+	for i := 0; i < reloads; i++ {
+		time.Sleep(time.Millisecond * 15)
+		assert.Nil(t, ruleEngine.ReloadSelf(def))
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&slowNodeUsedAfterDestroy))
+	//旧的节点实例在消息执行完成后销毁
+	for i := 0; i < 100 && atomic.LoadInt32(&slowNodeDestroyed) < int32(reloads*2); i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	assert.Equal(t, int32(reloads*2), atomic.LoadInt32(&slowNodeDestroyed))
+}
+
+func TestReloadChildRelationCache(t *testing.T) {
+	nodeDef := func(id, version string) string {
+		return `{"id":"` + id + `","type":"jsTransform","configuration":{"jsScript":"metadata['version']='` + version + `';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}`
+	}
+	def := []byte(`{"ruleChain":{"id":"testReloadChildCache"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` + nodeDef("s2", "v1") + `,` +
+		`{"id":"s3","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"},{"fromId":"s2","toId":"s3","type":"Success"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	version := func() string {
+		var result string
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = msg.Metadata.GetValue("version")
+		}))
+		return result
+	}
+	//填充关系缓存
+	assert.Equal(t, "v1", version())
+
+	assert.Nil(t, ruleEngine.ReloadChild("s2", []byte(nodeDef("s2", "v2"))))
+	assert.Equal(t, "v2", version())
+
+	//节点之间的路由从路由表读取，不写入关系缓存
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	s1Key := RelationCache{inNodeId: types.RuleNodeId{Id: "s1", Type: types.NODE}, relationType: types.True}
+	s3Key := RelationCache{inNodeId: types.RuleNodeId{Id: "s3", Type: types.NODE}, relationType: types.True}
+	ctx.invalidateRelationCache(types.RuleNodeId{Id: "s2", Type: types.NODE})
+	table := ctx.routingTable.Load().(routingTable)
+	assert.Equal(t, 1, len(table[s1Key].nodes))
+	assert.Equal(t, "s2", table[s1Key].nodes[0].GetNodeId().Id)
+	_, s3Ok := table[s3Key]
+	assert.False(t, s3Ok)
+	ctx.RLock()
+	assert.Equal(t, 0, len(ctx.relationCache))
+	ctx.RUnlock()
+	nodes, ok := ctx.GetNextNodes(s1Key.inNodeId, types.True)
+	assert.True(t, ok)
+	assert.Equal(t, "s2", nodes[0].GetNodeId().Id)
+	_, ok = ctx.GetNextNodes(s3Key.inNodeId, types.True)
+	assert.False(t, ok)
+}
+
+// TestRoutingTableSubChain 包含子规则链的路由通过规则链池延迟解析
+func TestRoutingTableSubChain(t *testing.T) {
+	subChainId := str.RandomStr(10)
+	subEngine, err := New(subChainId, []byte(`{"ruleChain":{"id":"`+subChainId+`"},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`), WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(subEngine.Id())
+	def := []byte(`{"ruleChain":{"id":"testRoutingTableSubChain"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}],` +
+		`"ruleChainConnections":[{"fromId":"s1","toId":"` + subChainId + `","type":"True"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	s1Key := RelationCache{inNodeId: types.RuleNodeId{Id: "s1", Type: types.NODE}, relationType: types.True}
+	assert.True(t, ctx.routingTable.Load().(routingTable)[s1Key].hasSubChain)
+	nodes, ok := ctx.GetNextNodes(s1Key.inNodeId, types.True)
+	assert.True(t, ok)
+	assert.Equal(t, 2, len(nodes))
+	assert.Equal(t, "s2", nodes[0].GetNodeId().Id)
+	assert.Equal(t, subChainId, nodes[1].GetNodeId().Id)
+	ctx.RLock()
+	_, cached := ctx.relationCache[s1Key]
+	ctx.RUnlock()
+	assert.True(t, cached)
+}
+
+// TestRoutingTableAfterReload 重新加载后替换的路由表对新的消息生效
+func TestRoutingTableAfterReload(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testRoutingTableAfterReload"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata['s2']='v1';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`)
+	re, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+	route := func() string {
+		var result string
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = ctx.GetSelfId()
+		}))
+		return result
+	}
+	assert.Equal(t, "s2", route())
+
+	//全量重新加载后编辑连接
+	assert.Nil(t, ruleEngine.ReloadSelf(def))
+	assert.Nil(t, ruleEngine.RemoveConnection("s1", "s2", types.True))
+	assert.Equal(t, "s1", route())
+	assert.Nil(t, ruleEngine.AddConnection("s1", "s2", types.True))
+	assert.Equal(t, "s2", route())
+
+	//增量重新加载后编辑连接
+	_, err = ruleEngine.ReloadDiff(def)
+	assert.Nil(t, err)
+	assert.Nil(t, ruleEngine.RemoveConnection("s1", "s2", types.True))
+	assert.Equal(t, "s1", route())
+	_, err = ruleEngine.ReloadDiff(def)
+	assert.Nil(t, err)
+	assert.Equal(t, "s2", route())
+}
+
+// reloadDiffAspect 记录增量重新加载变化的节点
+type reloadDiffAspect struct {
+	diffs chan types.ReloadDiff
+}
+
+func (aspect *reloadDiffAspect) Order() int {
+	return 1
+}
+
+func (aspect *reloadDiffAspect) New() types.Aspect {
+	return aspect
+}
+
+func (aspect *reloadDiffAspect) OnReload(parentCtx types.NodeCtx, ctx types.NodeCtx, err error) error {
+	return nil
+}
+
+func (aspect *reloadDiffAspect) OnReloadDiff(chainCtx types.NodeCtx, diff types.ReloadDiff, err error) error {
+	aspect.diffs <- diff
+	return err
+}
+
+func TestReloadDiff(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	buildDef := func(ids []string, changed string) []byte {
+		var nodes, connections []string
+		for i, id := range ids {
+			version := "v1"
+			if id == changed {
+				version = "v2"
+			}
+			nodes = append(nodes, `{"id":"`+id+`","type":"test/slow","configuration":{"version":"`+version+`"}}`)
+			if i > 0 {
+				connections = append(connections, `{"fromId":"`+ids[i-1]+`","toId":"`+id+`","type":"Success"}`)
+			}
+		}
+		return []byte(`{"ruleChain":{"id":"testReloadDiff"},"metadata":{"nodes":[` + strings.Join(nodes, ",") +
+			`],"connections":[` + strings.Join(connections, ",") + `]}}`)
+	}
+	var ids []string
+	for i := 0; i < 10; i++ {
+		ids = append(ids, fmt.Sprintf("n%d", i))
+	}
+	diffAspect := &reloadDiffAspect{diffs: make(chan types.ReloadDiff, 10)}
+	ruleEngine, err := New(str.RandomStr(10), buildDef(ids, ""), WithConfig(NewConfig()), types.WithAspects(diffAspect))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	chainCtx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	nodeInstances := func() map[string]types.Node {
+		instances := make(map[string]types.Node)
+		for _, id := range chainCtx.nodeIds {
+			nodeCtx, _ := chainCtx.GetNodeById(id)
+			instances[id.Id] = nodeCtx.(*RuleNodeCtx).Node
+		}
+		return instances
+	}
+	before := nodeInstances()
+	atomic.StoreInt32(&slowNodeDestroyed, 0)
+
+	//只修改n5的配置
+	diff, err := ruleEngine.(*RuleEngine).ReloadDiff(buildDef(ids, "n5"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"n5"}, diff.Changed)
+	assert.Equal(t, 0, len(diff.Added))
+	assert.Equal(t, 0, len(diff.Removed))
+	assert.Equal(t, diff, <-diffAspect.diffs)
+	after := nodeInstances()
+	for _, id := range ids {
+		if id == "n5" {
+			assert.True(t, before[id] != after[id])
+		} else {
+			assert.True(t, before[id] == after[id])
+		}
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&slowNodeDestroyed))
+	var count int
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnNodeCompleted(func(ctx types.RuleContext, nodeRunLog types.RuleNodeRunLog) {
+		count++
+	}))
+	assert.Equal(t, 10, count)
+
+	//删除n9，新增n10
+	newIds := append(append([]string{}, ids[:9]...), "n10")
+	diff, err = ruleEngine.(*RuleEngine).ReloadDiff(buildDef(newIds, "n5"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"n10"}, diff.Added)
+	assert.Equal(t, 0, len(diff.Changed))
+	assert.Equal(t, []string{"n9"}, diff.Removed)
+	<-diffAspect.diffs
+	assert.Equal(t, int32(2), atomic.LoadInt32(&slowNodeDestroyed))
+	assert.True(t, after["n0"] == nodeInstances()["n0"])
+	_, ok := chainCtx.GetNodeById(types.RuleNodeId{Id: "n9", Type: types.NODE})
+	assert.False(t, ok)
+
+	//规则链配置变化，所有节点重新初始化
+	changedChainDef := strings.Replace(string(buildDef(newIds, "n5")), `"id":"testReloadDiff"`, `"id":"testReloadDiff","configuration":{"vars":{"ip":"127.0.0.1"}}`, 1)
+	diff, err = ruleEngine.(*RuleEngine).ReloadDiff([]byte(changedChainDef))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(diff.Changed))
+	<-diffAspect.diffs
+
+	//DSL错误
+	_, err = ruleEngine.(*RuleEngine).ReloadDiff([]byte(`{`))
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len((<-diffAspect.diffs).Changed))
+}
+
+// prefixParser DSL带有"json:"前缀的解析器，没有实现 types.RuleChainDefParser
+type prefixParser struct {
+	parser JsonParser
+}
+
+func (p *prefixParser) DecodeRuleChain(config types.Config, aspects types.AspectList, dsl []byte) (types.Node, error) {
+	return p.parser.DecodeRuleChain(config, aspects, bytes.TrimPrefix(dsl, []byte("json:")))
+}
+
+func (p *prefixParser) DecodeRuleNode(config types.Config, dsl []byte, chainCtx types.Node) (types.Node, error) {
+	return p.parser.DecodeRuleNode(config, bytes.TrimPrefix(dsl, []byte("json:")), chainCtx)
+}
+
+func (p *prefixParser) EncodeRuleChain(def interface{}) ([]byte, error) {
+	return p.parser.EncodeRuleChain(def)
+}
+
+func (p *prefixParser) EncodeRuleNode(def interface{}) ([]byte, error) {
+	return p.parser.EncodeRuleNode(def)
+}
+
+func TestReloadDiffCustomParser(t *testing.T) {
+	buildDef := func(value string) []byte {
+		return []byte(`json:{"ruleChain":{"id":"testReloadDiffParser"},"metadata":{"nodes":[` +
+			`{"id":"s1","type":"jsTransform","configuration":{"jsScript":"metadata.s1='v1';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+			`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata.s2='` + value + `';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+			`"connections":[{"fromId":"s1","toId":"s2","type":"Success"}]}}`)
+	}
+	ruleEngine, err := New(str.RandomStr(10), buildDef("v1"), WithConfig(NewConfig(types.WithParser(&prefixParser{}))))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	s1, _ := ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "s1", Type: types.NODE})
+
+	//通过配置的解析器解析规则链定义
+	diff, err := ruleEngine.(*RuleEngine).ReloadDiff(buildDef("v2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"s2"}, diff.Changed)
+	s1After, _ := ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "s1", Type: types.NODE})
+	assert.True(t, s1.(*RuleNodeCtx).Node == s1After.(*RuleNodeCtx).Node)
+	var s2Value string
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		s2Value = msg.Metadata.GetValue("s2")
+	}))
+	assert.Equal(t, "v2", s2Value)
+}
+
+// mutationAspect 记录规则链运行时编辑
+type mutationAspect struct {
+	mutations []types.ChainMutation
+}
+
+func (aspect *mutationAspect) Order() int {
+	return 1
+}
+
+func (aspect *mutationAspect) New() types.Aspect {
+	return aspect
+}
+
+func (aspect *mutationAspect) OnReload(parentCtx types.NodeCtx, ctx types.NodeCtx, err error) error {
+	return nil
+}
+
+func (aspect *mutationAspect) OnMutation(chainCtx types.NodeCtx, mutation types.ChainMutation, err error) error {
+	if err == nil {
+		aspect.mutations = append(aspect.mutations, mutation)
+	}
+	return err
+}
+
+func TestChainMutation(t *testing.T) {
+	transformNode := func(id, value string) types.RuleNode {
+		return types.RuleNode{Id: id, Type: "jsTransform", Configuration: types.Configuration{
+			"jsScript": "metadata['" + id + "']='" + value + "';return {'msg':msg,'metadata':metadata,'msgType':msgType};",
+		}}
+	}
+	def := []byte(`{"ruleChain":{"id":"testChainMutation"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata['s2']='v1';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`)
+	aspect := &mutationAspect{}
+	re, err := New(str.RandomStr(10), def, WithConfig(NewConfig()), types.WithAspects(aspect))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+	metadata := func() map[string]string {
+		var result map[string]string
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = msg.Metadata.Values()
+		}))
+		return result
+	}
+	//填充关系缓存
+	assert.Equal(t, "v1", metadata()["s2"])
+	s1, _ := ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "s1", Type: types.NODE})
+
+	//新增节点和连接
+	assert.NotNil(t, ruleEngine.AddNode(transformNode("s1", "v1")))
+	assert.Nil(t, ruleEngine.AddNode(transformNode("s3", "v1")))
+	assert.Nil(t, ruleEngine.AddConnection("s2", "s3", types.Success))
+	assert.NotNil(t, ruleEngine.AddConnection("s2", "s3", types.Success))
+	assert.NotNil(t, ruleEngine.AddConnection("s2", "s4", types.Success))
+	assert.True(t, errors.Is(ruleEngine.AddConnection("s3", "s1", types.Success), ErrRuleChainCycle))
+	assert.Equal(t, "v1", metadata()["s3"])
+
+	//更新节点
+	assert.NotNil(t, ruleEngine.UpdateNode(transformNode("s4", "v1")))
+	assert.Nil(t, ruleEngine.UpdateNode(transformNode("s3", "v2")))
+	assert.Equal(t, "v2", metadata()["s3"])
+
+	//DSL与编辑后的规则链保持一致
+	var chainDef types.RuleChain
+	assert.Nil(t, json.Unmarshal(ruleEngine.DSL(), &chainDef))
+	assert.Equal(t, 3, len(chainDef.Metadata.Nodes))
+	assert.Equal(t, "s3", chainDef.Metadata.Nodes[2].Id)
+	assert.True(t, strings.Contains(chainDef.Metadata.Nodes[2].Configuration["jsScript"].(string), "v2"))
+	assert.Equal(t, []types.NodeConnection{{FromId: "s1", ToId: "s2", Type: types.True}, {FromId: "s2", ToId: "s3", Type: types.Success}}, chainDef.Metadata.Connections)
+
+	//删除被引用的节点
+	assert.True(t, errors.Is(ruleEngine.RemoveNode("s3", false), ErrNodeInUse))
+	assert.NotNil(t, ruleEngine.RemoveNode("s1", true))
+	assert.Nil(t, ruleEngine.RemoveNode("s3", true))
+	values := metadata()
+	assert.Equal(t, "v1", values["s2"])
+	assert.Equal(t, "", values["s3"])
+	assert.Nil(t, json.Unmarshal(ruleEngine.DSL(), &chainDef))
+	assert.Equal(t, 2, len(chainDef.Metadata.Nodes))
+	assert.Equal(t, 1, len(chainDef.Metadata.Connections))
+
+	//删除连接
+	assert.NotNil(t, ruleEngine.RemoveConnection("s1", "s2", types.False))
+	assert.Nil(t, ruleEngine.RemoveConnection("s1", "s2", types.True))
+	assert.Equal(t, "", metadata()["s2"])
+
+	//未修改的节点实例保留
+	s1After, _ := ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "s1", Type: types.NODE})
+	assert.True(t, s1 == s1After)
+
+	var ops []string
+	for _, item := range aspect.mutations {
+		ops = append(ops, item.Op)
+	}
+	assert.Equal(t, []string{types.MutationAddNode, types.MutationAddConnection, types.MutationUpdateNode,
+		types.MutationRemoveNode, types.MutationRemoveConnection}, ops)
+	assert.Equal(t, []types.NodeConnection{{FromId: "s2", ToId: "s3", Type: types.Success}}, aspect.mutations[3].Connections)
+
+	//更新第一个节点，新的消息从新的节点实例开始执行，已经获取的规则链定义不变
+	oldDef := ruleEngine.RootRuleChainCtx().Definition()
+	assert.Nil(t, ruleEngine.UpdateNode(transformNode("s1", "v3")))
+	assert.Equal(t, "v3", metadata()["s1"])
+	assert.Equal(t, "jsFilter", oldDef.Metadata.Nodes[0].Type)
+	s1After, _ = ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "s1", Type: types.NODE})
+	assert.False(t, s1 == s1After)
+}
+
+// TestChainMutationAfterReload 重新加载后运行时编辑对新的消息生效
+func TestChainMutationAfterReload(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testChainMutationAfterReload"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata['s2']='v1';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"entryPoints":{"http":"s1"}}}`)
+	re, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+	metadata := func(opts ...types.RuleContextOption) map[string]string {
+		var result map[string]string
+		opts = append(opts, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = msg.Metadata.Values()
+		}))
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), opts...)
+		return result
+	}
+	assert.Nil(t, ruleEngine.ReloadSelf(def))
+	assert.Equal(t, "", metadata()["s2"])
+
+	assert.Nil(t, ruleEngine.AddConnection("s1", "s2", types.True))
+	assert.Equal(t, "v1", metadata()["s2"])
+	assert.Equal(t, "v1", metadata(types.WithEntryPoint("http"))["s2"])
+
+	assert.Nil(t, ruleEngine.UpdateNode(types.RuleNode{Id: "s2", Type: "jsTransform", Configuration: types.Configuration{
+		"jsScript": "metadata['s2']='v2';return {'msg':msg,'metadata':metadata,'msgType':msgType};",
+	}}))
+	assert.Equal(t, "v2", metadata()["s2"])
+
+	assert.Nil(t, ruleEngine.RemoveNode("s2", true))
+	assert.Equal(t, "", metadata()["s2"])
+
+	//重新加载后新创建的节点实例引用当前规则链
+	s1, _ := ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "s1", Type: types.NODE})
+	assert.True(t, s1.(*RuleNodeCtx).ChainCtx == ruleEngine.rootRuleChainCtx)
+}
+
+func TestGetParentNodes(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testGetParentNodes"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s3","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s3","type":"True"},{"fromId":"s2","toId":"s3","type":"Success"},{"fromId":"s1","toId":"s2","type":"False"}],` +
+		`"ruleChainConnections":[{"fromId":"s2","toId":"subChain","type":"Failure"}]}}`)
+	re, err := New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+	chainCtx := ruleEngine.rootRuleChainCtx
+	nodeId := func(id string) types.RuleNodeId {
+		return types.RuleNodeId{Id: id, Type: types.NODE}
+	}
+
+	parents, ok := chainCtx.GetParentNodes(nodeId("s3"))
+	assert.True(t, ok)
+	assert.Equal(t, []types.RuleNodeRelation{
+		{InId: nodeId("s1"), OutId: nodeId("s3"), RelationType: types.True},
+		{InId: nodeId("s2"), OutId: nodeId("s3"), RelationType: types.Success},
+	}, parents)
+	_, ok = chainCtx.GetParentNodes(nodeId("s1"))
+	assert.False(t, ok)
+
+	//子规则链连接
+	parents, ok = chainCtx.GetParentNodes(types.RuleNodeId{Id: "subChain", Type: types.CHAIN})
+	assert.True(t, ok)
+	assert.Equal(t, 1, len(parents))
+	assert.Equal(t, "s2", parents[0].InId.Id)
+
+	//运行时修改连接后更新
+	assert.Nil(t, ruleEngine.RemoveConnection("s1", "s3", types.True))
+	parents, _ = chainCtx.GetParentNodes(nodeId("s3"))
+	assert.Equal(t, 1, len(parents))
+	assert.Equal(t, "s2", parents[0].InId.Id)
+	assert.Nil(t, ruleEngine.AddConnection("s1", "s3", types.Success))
+	parents, _ = chainCtx.GetParentNodes(nodeId("s3"))
+	assert.Equal(t, 2, len(parents))
+	assert.Nil(t, ruleEngine.RemoveNode("s2", true))
+	parents, _ = chainCtx.GetParentNodes(nodeId("s3"))
+	assert.Equal(t, []types.RuleNodeRelation{{InId: nodeId("s1"), OutId: nodeId("s3"), RelationType: types.Success}}, parents)
+	_, ok = chainCtx.GetParentNodes(types.RuleNodeId{Id: "subChain", Type: types.CHAIN})
+	assert.False(t, ok)
+
+	//重新加载规则链后更新
+	assert.Nil(t, ruleEngine.ReloadSelf(def))
+	parents, _ = ruleEngine.rootRuleChainCtx.GetParentNodes(nodeId("s3"))
+	assert.Equal(t, 2, len(parents))
+}
+
+func TestVersionedReload(t *testing.T) {
+	chainDef := func(version string) []byte {
+		return []byte(`{"ruleChain":{"id":"testVersionedReload"},"metadata":{"nodes":[` +
+			`{"id":"s1","type":"jsTransform","configuration":{"jsScript":"metadata['version']='` + version + `';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}]}}`)
+	}
+	var reloads int32
+	callback := &CallbackTest{OnReload: func(parentCtx types.NodeCtx, ctx types.NodeCtx, err error) {
+		atomic.AddInt32(&reloads, 1)
+	}}
+	config := NewConfig(types.WithMaxVersions(2))
+	re, err := New(str.RandomStr(10), chainDef("v1"), WithConfig(config), types.WithAspects(&EngineAspect{Callback: callback}))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+	version := func() string {
+		var result string
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = msg.Metadata.GetValue("version")
+		}))
+		return result
+	}
+	assert.Equal(t, 0, len(ruleEngine.ListVersions()))
+	assert.Nil(t, ruleEngine.ReloadSelf(chainDef("v2")))
+	assert.Nil(t, ruleEngine.ReloadSelf(chainDef("v3")))
+	//解析失败也记录重新加载前的定义
+	assert.NotNil(t, ruleEngine.ReloadSelf([]byte(`{"ruleChain":`)))
+	assert.Equal(t, "v3", version())
+
+	//最多保留2个版本
+	versions := ruleEngine.ListVersions()
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, 2, versions[0].Version)
+	assert.Equal(t, 3, versions[1].Version)
+	assert.True(t, strings.Contains(string(versions[0].Def), "v2"))
+	assert.True(t, versions[0].Ts > 0)
+
+	atomic.StoreInt32(&reloads, 0)
+	assert.Nil(t, ruleEngine.Rollback(2))
+	assert.Equal(t, "v2", version())
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+	versions = ruleEngine.ListVersions()
+	assert.Equal(t, 4, versions[1].Version)
+	assert.True(t, strings.Contains(string(versions[1].Def), "v3"))
+
+	assert.True(t, errors.Is(ruleEngine.Rollback(1), ErrVersionNotFound))
+
+	//不保留历史定义
+	re2, err := New(str.RandomStr(10), chainDef("v1"), WithConfig(NewConfig(types.WithMaxVersions(0))))
+	assert.Nil(t, err)
+	defer Del(re2.Id())
+	assert.Nil(t, re2.ReloadSelf(chainDef("v2")))
+	assert.Equal(t, 0, len(re2.(*RuleEngine).ListVersions()))
+}
+
+func TestDegradedNodes(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testDegradedNodes"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return (;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`)
+	//默认初始化失败
+	_, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.NotNil(t, err)
+
+	re, err := New(str.RandomStr(10), def, WithConfig(NewConfig(types.WithAllowNodeInitFailure(true))))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+	degradedNodes := ruleEngine.DegradedNodes()
+	assert.Equal(t, 1, len(degradedNodes))
+	assert.Equal(t, "s2", degradedNodes[0].NodeId)
+	assert.NotNil(t, degradedNodes[0].Err)
+
+	var relation, initErr string
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		relation = relationType
+		initErr = msg.Metadata.GetValue(NodeInitErrorKey)
+	}))
+	assert.Equal(t, types.Failure, relation)
+	assert.Equal(t, degradedNodes[0].Err.Error(), initErr)
+
+	//重新加载失败仍然是降级状态
+	assert.NotNil(t, ruleEngine.ReloadChild("s2", []byte(`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return (;"}}`)))
+	assert.Equal(t, 1, len(ruleEngine.DegradedNodes()))
+	//重新加载成功后恢复正常
+	assert.Nil(t, ruleEngine.ReloadChild("s2", []byte(`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}`)))
+	assert.Equal(t, 0, len(ruleEngine.DegradedNodes()))
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		relation = relationType
+	}))
+	assert.Equal(t, types.True, relation)
+}
+
+func TestNodeInitError(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testNodeInitError"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`)
+	re, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+
+	badDef := strings.Replace(string(def), `"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}`, `"id":"s2","type":"jsFilter","configuration":{"jsScript":"return (;"}`, 1)
+	err = re.ReloadSelf([]byte(badDef))
+	var nodeInitErr *NodeInitError
+	assert.True(t, errors.As(err, &nodeInitErr))
+	assert.Equal(t, "testNodeInitError", nodeInitErr.ChainId)
+	assert.Equal(t, "s2", nodeInitErr.NodeId)
+	assert.Equal(t, "jsFilter", nodeInitErr.NodeType)
+	assert.True(t, strings.HasPrefix(err.Error(), "rule chain testNodeInitError: node s2(jsFilter) init error: "))
+
+	err = re.ReloadChild("s2", []byte(`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return (;"}}`))
+	assert.True(t, errors.As(err, &nodeInitErr))
+	assert.Equal(t, "s2", nodeInitErr.NodeId)
+
+	//解析错误包含规则链ID
+	err = re.ReloadSelf([]byte(`{"ruleChain":`))
+	assert.True(t, strings.HasPrefix(err.Error(), "reload rule chain "+re.Id()+": "))
+	cycleDef := strings.Replace(string(def), `"connections":[`, `"connections":[{"fromId":"s2","toId":"s1","type":"True"},`, 1)
+	assert.True(t, errors.Is(re.ReloadSelf([]byte(cycleDef)), ErrRuleChainCycle))
+}
+
+// destroyModeAspect 记录销毁时是否优雅关闭
+type destroyModeAspect struct {
+	modes chan bool
+}
+
+func (aspect *destroyModeAspect) Order() int {
+	return 1
+}
+
+func (aspect *destroyModeAspect) New() types.Aspect {
+	return aspect
+}
+
+func (aspect *destroyModeAspect) OnDestroy(chainCtx types.NodeCtx) {
+}
+
+func (aspect *destroyModeAspect) OnDestroyWithMode(chainCtx types.NodeCtx, graceful bool) {
+	aspect.modes <- graceful
+}
+
+func TestGracefulDestroy(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	atomic.StoreInt32(&slowNodeUsedAfterDestroy, 0)
+	def := []byte(`{"ruleChain":{"id":"testGracefulDestroy"},"metadata":{"nodes":[{"id":"s1","type":"test/slow"},{"id":"s2","type":"test/slow"}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"}]}}`)
+	modeAspect := &destroyModeAspect{modes: make(chan bool, 10)}
+	newEngine := func() types.RuleEngine {
+		ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig()), types.WithAspects(modeAspect))
+		assert.Nil(t, err)
+		return ruleEngine
+	}
+	ruleEngine := newEngine()
+	defer Del(ruleEngine.Id())
+	var completed int32
+	for i := 0; i < 5; i++ {
+		ruleEngine.OnMsg(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnAllNodeCompleted(func() {
+			atomic.AddInt32(&completed, 1)
+		}))
+	}
+	chainCtx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	assert.True(t, chainCtx.IsReady())
+	stopErr := make(chan error, 1)
+	go func() {
+		stopErr <- ruleEngine.(*RuleEngine).GracefulStop(time.Second)
+	}()
+	for atomic.LoadInt32(&chainCtx.stopping) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	//等待正在执行的消息完成期间不再就绪
+	assert.False(t, chainCtx.IsReady())
+	assert.True(t, atomic.LoadInt32(&completed) < 5)
+	assert.Nil(t, <-stopErr)
+	assert.Equal(t, int32(5), atomic.LoadInt32(&completed))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&slowNodeUsedAfterDestroy))
+	assert.True(t, <-modeAspect.modes)
+	//不再接收新的消息
+	var stoppedErr error
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		stoppedErr = err
+	}))
+	assert.Equal(t, ErrRuleChainStopped, stoppedErr)
+
+	//超时后强制销毁
+	ruleEngine = newEngine()
+	defer Del(ruleEngine.Id())
+	done := make(chan struct{})
+	ruleEngine.OnMsg(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnAllNodeCompleted(func() {
+		close(done)
+	}))
+	assert.Equal(t, context.DeadlineExceeded, ruleEngine.(*RuleEngine).GracefulStop(time.Millisecond))
+	assert.False(t, <-modeAspect.modes)
+	//正在执行的消息使用了已经销毁的节点
+	<-done
+	assert.True(t, atomic.LoadInt32(&slowNodeUsedAfterDestroy) > 0)
+
+	//强制销毁
+	ruleEngine = newEngine()
+	defer Del(ruleEngine.Id())
+	ruleEngine.RootRuleChainCtx().(*RuleChainCtx).ForceDestroy()
+	assert.False(t, <-modeAspect.modes)
+}
+
+func TestOnMsgSegment(t *testing.T) {
+	jsonParser := JsonParser{}
+	transform := func(id string) string {
+		return `{"id":"` + id + `","type":"jsTransform","configuration":{"jsScript":"metadata['` + id + `']='true';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}`
+	}
+	chainNode, err := jsonParser.DecodeRuleChain(NewConfig(), nil, []byte(`{"ruleChain":{"id":"test01"},"metadata":{"nodes":[`+
+		transform("s1")+`,`+transform("s2")+`,`+transform("s3")+`,`+transform("s4")+`],
+		"connections":[
+			{"fromId":"s1","toId":"s2","type":"Success"},
+			{"fromId":"s1","toId":"s3","type":"Success"},
+			{"fromId":"s2","toId":"s4","type":"Success"},
+			{"fromId":"s3","toId":"s4","type":"Success"}
+		]}}`))
+	assert.Nil(t, err)
+	ruleChainCtx := chainNode.(*RuleChainCtx)
+	msg := types.NewMsg(0, "TEST", types.JSON, types.NewMetadata(), "{}")
+
+	//每个分支在s4停止
+	results, err := ruleChainCtx.OnMsgSegment(context.Background(), msg, "s1", "s4")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(results))
+	var nodeIds []string
+	for _, item := range results {
+		assert.True(t, item.Stopped)
+		assert.Equal(t, types.Success, item.RelationType)
+		assert.Equal(t, "true", item.Msg.Metadata.GetValue("s1"))
+		assert.Equal(t, "", item.Msg.Metadata.GetValue("s4"))
+		nodeIds = append(nodeIds, item.NodeId)
+	}
+	sort.Strings(nodeIds)
+	assert.Equal(t, "s2,s3", strings.Join(nodeIds, ","))
+
+	//执行到分支结束
+	results, err = ruleChainCtx.OnMsgSegment(context.Background(), msg, "s2", "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.False(t, results[0].Stopped)
+	assert.Equal(t, "s4", results[0].NodeId)
+	assert.Equal(t, "", results[0].Msg.Metadata.GetValue("s1"))
+	assert.Equal(t, "true", results[0].Msg.Metadata.GetValue("s4"))
+
+	_, err = ruleChainCtx.OnMsgSegment(context.Background(), msg, "s5", "s4")
+	assert.Equal(t, "start node not found nodeId=s5", err.Error())
+	_, err = ruleChainCtx.OnMsgSegment(context.Background(), msg, "s1", "s5")
+	assert.Equal(t, "stop node not found nodeId=s5", err.Error())
+	_, err = ruleChainCtx.OnMsgSegment(context.Background(), msg, "s1", "s1")
+	assert.NotNil(t, err)
+}
+
+func TestChainAttribute(t *testing.T) {
+	jsonParser := JsonParser{}
+	chainNode, err := jsonParser.DecodeRuleChain(NewConfig(), nil, []byte(`{"ruleChain":{"id":"test01"},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`))
+	assert.Nil(t, err)
+	ctx := chainNode.(*RuleChainCtx)
+	_, ok := ctx.GetAttribute("owner")
+	assert.False(t, ok)
+
+	ctx.SetAttribute("owner", "lala")
+	ctx.SetAttribute("tier", 1)
+	v, ok := ctx.GetAttribute("owner")
+	assert.True(t, ok)
+	assert.Equal(t, "lala", v)
+
+	//重新加载后保留
+	err = ctx.ReloadSelf([]byte(`{"ruleChain":{"id":"test01","name":"new"},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return false;"}}]}}`))
+	assert.Nil(t, err)
+	v, ok = ctx.GetAttribute("tier")
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+
+	//销毁后清空
+	ctx.Destroy()
+	_, ok = ctx.GetAttribute("owner")
+	assert.False(t, ok)
+}
+
+// TestNextNodesOrder 子节点按照连接定义顺序返回，优先级高的排在前面
+func TestNextNodesOrder(t *testing.T) {
+	subChainId := str.RandomStr(10)
+	subEngine, err := New(subChainId, []byte(`{"ruleChain":{"id":"`+subChainId+`"},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`), WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(subEngine.Id())
+	chainDef := func(connections, ruleChainConnections string) []byte {
+		var nodes []string
+		for _, id := range []string{"s1", "s2", "s3", "s4", "s5"} {
+			nodes = append(nodes, `{"id":"`+id+`","type":"jsFilter","configuration":{"jsScript":"return true;"}}`)
+		}
+		return []byte(`{"ruleChain":{"id":"testNextNodesOrder"},"metadata":{"nodes":[` + strings.Join(nodes, ",") + `],` +
+			`"connections":[` + connections + `],"ruleChainConnections":[` + ruleChainConnections + `]}}`)
+	}
+	connection := func(toId string, priority int) string {
+		return `{"fromId":"s1","toId":"` + toId + `","type":"True","priority":` + strconv.Itoa(priority) + `}`
+	}
+	ruleEngine, err := New(str.RandomStr(10), chainDef(connection("s4", 0)+","+connection("s2", 0)+","+connection("s3", 0),
+		`{"fromId":"s1","toId":"`+subChainId+`","type":"True"}`), WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	nextNodeIds := func() []string {
+		nodes, _ := ctx.GetNextNodes(types.RuleNodeId{Id: "s1", Type: types.NODE}, types.True)
+		var ids []string
+		for _, item := range nodes {
+			ids = append(ids, item.GetNodeId().Id)
+		}
+		return ids
+	}
+	//按照定义顺序，子规则链连接排在节点连接之后
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, []string{"s4", "s2", "s3", subChainId}, nextNodeIds())
+	}
+
+	//优先级高的排在前面，优先级相同保持定义顺序
+	assert.Nil(t, ruleEngine.ReloadSelf(chainDef(connection("s4", 0)+","+connection("s2", 0)+","+connection("s3", 10),
+		`{"fromId":"s1","toId":"`+subChainId+`","type":"True","priority":5}`)))
+	assert.Equal(t, []string{"s3", subChainId, "s4", "s2"}, nextNodeIds())
+	ids, _ := ctx.GetNextNodeIds(types.RuleNodeId{Id: "s1", Type: types.NODE}, types.True)
+	assert.Equal(t, 4, len(ids))
+	assert.Equal(t, "s3", ids[0].Id)
+
+	//运行时新增的连接与重新加载后的顺序一致
+	assert.Nil(t, ctx.AddConnection("s1", "s5", types.True))
+	assert.Equal(t, []string{"s3", subChainId, "s4", "s2", "s5"}, nextNodeIds())
+	assert.Nil(t, ruleEngine.ReloadSelf(ruleEngine.DSL()))
+	assert.Equal(t, []string{"s3", subChainId, "s4", "s2", "s5"}, nextNodeIds())
+	assert.Nil(t, ctx.RemoveConnection("s1", "s3", types.True))
+	assert.Equal(t, []string{subChainId, "s4", "s2", "s5"}, nextNodeIds())
+}
+
+// dslErrorParser 编码规则链定义失败的解析器
+type dslErrorParser struct {
+	JsonParser
+	fail bool
+}
+
+func (p *dslErrorParser) EncodeRuleChain(def interface{}) ([]byte, error) {
+	if p.fail {
+		return nil, errors.New("encode error")
+	}
+	return p.JsonParser.EncodeRuleChain(def)
+}
+
+func TestDSLCache(t *testing.T) {
+	parser := &dslErrorParser{}
+	def := []byte(`{"ruleChain":{"id":"testDSLCache"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig(types.WithParser(parser))))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+
+	dsl := ruleEngine.DSL()
+	assert.True(t, len(dsl) > 0)
+	//命中缓存，不重新编码
+	parser.fail = true
+	assert.Equal(t, string(dsl), string(ruleEngine.DSL()))
+	//返回的是副本，修改不影响缓存
+	dsl[0] = 'x'
+	assert.NotEqual(t, string(dsl), string(ruleEngine.DSL()))
+
+	//运行时编辑后缓存失效，返回编码错误
+	assert.Nil(t, ctx.AddConnection("s1", "s2", types.True))
+	_, err = ruleEngine.(*RuleEngine).EncodeDSL()
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, len(ruleEngine.DSL()))
+	parser.fail = false
+	assert.True(t, strings.Contains(string(ruleEngine.DSL()), `"toId": "s2"`))
+
+	//更新子节点后缓存失效
+	assert.Nil(t, ruleEngine.ReloadChild("s2", []byte(`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return false;"}}`)))
+	assert.True(t, strings.Contains(string(ruleEngine.DSL()), "return false;"))
+
+	//重新加载后缓存失效
+	assert.Nil(t, ruleEngine.ReloadSelf([]byte(strings.Replace(string(def), "testDSLCache", "testDSLCacheV2", 1))))
+	assert.True(t, strings.Contains(string(ruleEngine.DSL()), "testDSLCacheV2"))
+}
+
+// TestConcurrentReloadRead 并发处理消息和读取规则链的同时重新加载，读取到的规则链定义和节点列表属于同一个版本
+// 使用 go test -race 运行
+func TestConcurrentReloadRead(t *testing.T) {
+	//v1: 2个节点，第一个节点是索引1的节点；v2: 1个节点，第一个节点是索引0的节点
+	v1 := []byte(`{"ruleChain":{"id":"testConcurrentReloadRead"},"metadata":{"firstNodeIndex":1,"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s2","toId":"s1","type":"True"}]}}`)
+	v2 := []byte(`{"ruleChain":{"id":"testConcurrentReloadRead","debugMode":true},"metadata":{"nodes":[` +
+		`{"id":"s3","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`)
+	config := NewConfig()
+	config.OnDebug = func(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
+	}
+	ruleEngine, err := New(str.RandomStr(10), v1, WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+
+	var wg sync.WaitGroup
+	var firstNodeNotFound int32
+	stop := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"))
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if _, ok := ctx.GetFirstNode(); !ok {
+						atomic.AddInt32(&firstNodeNotFound, 1)
+					}
+					_ = ctx.IsDebugMode()
+					_ = ctx.GetNodeId()
+					_ = ruleEngine.Definition()
+					_ = ruleEngine.DSL()
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		time.Sleep(time.Millisecond * 5)
+		if i%2 == 0 {
+			assert.Nil(t, ruleEngine.ReloadSelf(v2))
+		} else {
+			assert.Nil(t, ruleEngine.ReloadSelf(v1))
+		}
+	}
+	close(stop)
+	wg.Wait()
+	assert.Equal(t, int32(0), atomic.LoadInt32(&firstNodeNotFound))
+}
+
+// lifecycleNode 记录生命周期回调的测试组件
+type lifecycleNode struct {
+	name string
+}
+
+var lifecycleEvents struct {
+	sync.Mutex
+	items []string
+}
+
+func recordLifecycle(event string) {
+	lifecycleEvents.Lock()
+	defer lifecycleEvents.Unlock()
+	lifecycleEvents.items = append(lifecycleEvents.items, event)
+}
+
+// takeLifecycleEvents 获取并清空记录的生命周期回调
+func takeLifecycleEvents() []string {
+	lifecycleEvents.Lock()
+	defer lifecycleEvents.Unlock()
+	items := lifecycleEvents.items
+	lifecycleEvents.items = nil
+	return items
+}
+
+func (n *lifecycleNode) Type() string {
+	return "test/lifecycle"
+}
+
+func (n *lifecycleNode) New() types.Node {
+	return &lifecycleNode{}
+}
+
+func (n *lifecycleNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	n.name = str.ToString(configuration["name"])
+	recordLifecycle("init:" + n.name)
+	return nil
+}
+
+func (n *lifecycleNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	ctx.TellSuccess(msg)
+}
+
+func (n *lifecycleNode) OnChainStart(ctx types.NodeCtx) {
+	recordLifecycle("start:" + n.name + "@" + ctx.GetNodeId().Id)
+}
+
+func (n *lifecycleNode) OnChainStop() {
+	recordLifecycle("stop:" + n.name)
+}
+
+func (n *lifecycleNode) Destroy() {
+	recordLifecycle("destroy:" + n.name)
+}
+
+func TestNodeLifecycle(t *testing.T) {
+	_ = Registry.Register(&lifecycleNode{})
+	defer Registry.Unregister("test/lifecycle")
+	takeLifecycleEvents()
+	chainDef := func(name string) []byte {
+		return []byte(`{"ruleChain":{"id":"testNodeLifecycle"},"metadata":{"nodes":[` +
+			`{"id":"s1","type":"test/lifecycle","configuration":{"name":"` + name + `"}}]}}`)
+	}
+	//只解析规则链定义，不启动节点
+	ctx, err := NewConfig().Parser.DecodeRuleChain(NewConfig(), nil, chainDef("parse"))
+	assert.Nil(t, err)
+	ctx.Destroy()
+	assert.Equal(t, []string{"init:parse", "destroy:parse"}, takeLifecycleEvents())
+	//环路在节点初始化前拒绝
+	_, err = NewConfig().Parser.DecodeRuleChain(NewConfig(), nil, []byte(`{"ruleChain":{"id":"testNodeLifecycle"},"metadata":{"nodes":[`+
+		`{"id":"s1","type":"test/lifecycle","configuration":{"name":"cycle"}}],"connections":[{"fromId":"s1","toId":"s1","type":"Success"}]}}`))
+	assert.True(t, errors.Is(err, ErrRuleChainCycle))
+	assert.Equal(t, 0, len(takeLifecycleEvents()))
+	//节点初始化后校验不通过，销毁已经初始化的节点
+	_, err = NewConfig().Parser.DecodeRuleChain(NewConfig(), nil, []byte(`{"ruleChain":{"id":"testNodeLifecycle"},"metadata":{"nodes":[`+
+		`{"id":"s1","type":"test/lifecycle","configuration":{"name":"entry"}}],"entryPoints":{"api":"s2"}}}`))
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{"init:entry", "destroy:entry"}, takeLifecycleEvents())
+
+	ruleEngine, err := New(str.RandomStr(10), chainDef("v1"), WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"init:v1", "start:v1@s1"}, takeLifecycleEvents())
+
+	//重新加载：先停止旧的节点实例，再启动新的节点实例
+	assert.Nil(t, ruleEngine.ReloadSelf(chainDef("v2")))
+	assert.Equal(t, []string{"init:v2", "stop:v1", "start:v2@s1", "destroy:v1"}, takeLifecycleEvents())
+
+	//更新子节点
+	assert.Nil(t, ruleEngine.ReloadChild("s1", []byte(`{"id":"s1","type":"test/lifecycle","configuration":{"name":"v3"}}`)))
+	assert.Equal(t, []string{"init:v3", "stop:v2", "destroy:v2", "start:v3@s1"}, takeLifecycleEvents())
+
+	//运行时更新节点：先停止旧的节点实例，没有正在执行的消息则立即销毁，再启动新的节点实例
+	assert.Nil(t, ruleEngine.RootRuleChainCtx().(*RuleChainCtx).UpdateNode(types.RuleNode{Id: "s1", Type: "test/lifecycle", Configuration: types.Configuration{"name": "v4"}}))
+	assert.Equal(t, []string{"init:v4", "stop:v3", "destroy:v3", "start:v4@s1"}, takeLifecycleEvents())
+
+	//运行时新增节点
+	assert.Nil(t, ruleEngine.RootRuleChainCtx().(*RuleChainCtx).AddNode(types.RuleNode{Id: "s2", Type: "test/lifecycle", Configuration: types.Configuration{"name": "added"}}))
+	assert.Equal(t, []string{"init:added", "start:added@s2"}, takeLifecycleEvents())
+
+	ruleEngine.Stop()
+	events := takeLifecycleEvents()
+	assert.Equal(t, 4, len(events))
+	//停止在销毁之前
+	for _, name := range []string{"v4", "added"} {
+		stopIndex, destroyIndex := -1, -1
+		for i, item := range events {
+			if item == "stop:"+name {
+				stopIndex = i
+			} else if item == "destroy:"+name {
+				destroyIndex = i
+			}
+		}
+		assert.True(t, stopIndex >= 0 && stopIndex < destroyIndex)
+	}
+	Del(ruleEngine.Id())
+}
+
+// panicNode 处理消息时panic的测试组件，afterTell=true 先通知下一个节点再panic
+type panicNode struct {
+	afterTell bool
+}
+
+func (n *panicNode) Type() string {
+	return "test/panic"
+}
+
+func (n *panicNode) New() types.Node {
+	return &panicNode{}
+}
+
+func (n *panicNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	n.afterTell = str.ToString(configuration["afterTell"]) == "true"
+	return nil
+}
+
+func (n *panicNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	if n.afterTell {
+		ctx.TellSuccess(msg)
+	}
+	panic("boom")
+}
+
+func (n *panicNode) Destroy() {
+}
+
+// TestNodePanic 节点panic恢复后转换成错误，通过Failure关系路由到下一个节点
+func TestNodePanic(t *testing.T) {
+	_ = Registry.Register(&panicNode{})
+	defer Registry.Unregister("test/panic")
+	def := []byte(`{"ruleChain":{"id":"testNodePanic"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"test/panic","debugMode":true},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata['handled']='true';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"Failure"}]}}`)
+	debugErr := make(chan error, 10)
+	config := NewConfig()
+	config.OnDebug = func(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
+		if flowType == types.Out && nodeId == "s1" {
+			debugErr <- err
+		}
+	}
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var endMsg types.RuleMsg
+	var endErr error
+	var endCount int32
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		atomic.AddInt32(&endCount, 1)
+		endMsg = msg
+		endErr = err
+	}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&endCount))
+	assert.Nil(t, endErr)
+	assert.Equal(t, "true", endMsg.Metadata.GetValue("handled"))
+	assert.Equal(t, "s1", endMsg.Metadata.GetValue(PanicNodeIdKey))
+	assert.Equal(t, "boom", endMsg.Metadata.GetValue(PanicErrorKey))
+	assert.True(t, strings.Contains(endMsg.Metadata.GetValue(PanicStackKey), "panicNode"))
+	assert.True(t, len(endMsg.Metadata.GetValue(PanicStackKey)) <= maxPanicStackSize)
+
+	select {
+	case err := <-debugErr:
+		var panicErr *NodePanicError
+		assert.True(t, errors.As(err, &panicErr))
+		assert.Equal(t, "s1", panicErr.NodeId)
+	case <-time.After(time.Second * 3):
+		t.Fatal("debug callback not called")
+	}
+}
+
+// TestNodePanicAfterTell 节点通知下一个节点后panic，消息不再通过Failure关系重复路由
+func TestNodePanicAfterTell(t *testing.T) {
+	_ = Registry.Register(&panicNode{})
+	defer Registry.Unregister("test/panic")
+	def := []byte(`{"ruleChain":{"id":"testNodePanicAfterTell"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"test/panic","configuration":{"afterTell":true}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata['relation']='success';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s3","type":"jsTransform","configuration":{"jsScript":"metadata['relation']='failure';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s1","toId":"s3","type":"Failure"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var relations []string
+	var lock sync.Mutex
+	var completedCount int32
+	ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		lock.Lock()
+		defer lock.Unlock()
+		relations = append(relations, msg.Metadata.GetValue("relation"))
+	}), types.WithOnAllNodeCompleted(func() {
+		atomic.AddInt32(&completedCount, 1)
+	}))
+	time.Sleep(time.Millisecond * 100)
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, []string{"success"}, relations)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&completedCount))
+}
+
+// TestChainStats 规则链按节点和关系类型统计消息数
+func TestChainStats(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testChainStats"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return msg.temperature > 10;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"if (msg.temperature > 50) {throw 'too high';} return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	createdTime := ctx.Stats().LastResetTime
+	assert.False(t, createdTime.IsZero())
+
+	send := func(temperatures ...int) {
+		for _, item := range temperatures {
+			ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), `{"temperature":`+strconv.Itoa(item)+`}`))
+		}
+	}
+	send(20, 30, 5, 60)
+	stats := ctx.Stats()
+	assert.Equal(t, int64(4), stats.Received)
+	assert.Equal(t, int64(3), stats.Completed)
+	assert.Equal(t, int64(1), stats.Failed)
+	assert.Equal(t, map[string]int64{types.True: 3, types.False: 1}, stats.Nodes["s1"])
+	assert.Equal(t, map[string]int64{types.Success: 2, types.Failure: 1}, stats.Nodes["s2"])
+
+	//更新子节点保留统计
+	assert.Nil(t, ruleEngine.ReloadChild("s2", []byte(`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}`)))
+	send(60)
+	stats = ctx.Stats()
+	assert.Equal(t, int64(5), stats.Received)
+	assert.Equal(t, int64(3), stats.Nodes["s2"][types.Success])
+	assert.Equal(t, createdTime, stats.LastResetTime)
+
+	//全量重新加载重置统计
+	time.Sleep(time.Millisecond * 10)
+	assert.Nil(t, ruleEngine.ReloadSelf(def))
+	stats = ctx.Stats()
+	assert.Equal(t, int64(0), stats.Received)
+	assert.Equal(t, 0, len(stats.Nodes))
+	assert.True(t, stats.LastResetTime.After(createdTime))
+	send(20)
+	stats = ctx.Stats()
+	assert.Equal(t, int64(1), stats.Received)
+	assert.Equal(t, int64(1), stats.Nodes["s2"][types.Success])
+
+	ruleEngine.(*RuleEngine).ResetStats()
+	assert.Equal(t, int64(0), ruleEngine.(*RuleEngine).Stats().Received)
+}
+
+// asyncNode 在其他协程通知下一个节点的测试组件
+type asyncNode struct {
+}
+
+func (n *asyncNode) Type() string {
+	return "test/async"
+}
+
+func (n *asyncNode) New() types.Node {
+	return &asyncNode{}
+}
+
+func (n *asyncNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	return nil
+}
+
+func (n *asyncNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	go func() {
+		time.Sleep(time.Millisecond * 30)
+		ctx.TellSuccess(msg)
+	}()
+}
+
+func (n *asyncNode) Destroy() {
+}
+
+// TestNodeLatency 统计节点执行耗时，异步组件统计到通知下一个节点为止
+func TestNodeLatency(t *testing.T) {
+	_ = Registry.Register(&asyncNode{})
+	defer Registry.Unregister("test/async")
+	def := []byte(`{"ruleChain":{"id":"testNodeLatency"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"test/async"},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"Success"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig()))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	for i := 0; i < 5; i++ {
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"))
+	}
+	latency := ruleEngine.(*RuleEngine).Stats().Latency
+	assert.Equal(t, int64(5), latency["s1"].Count)
+	assert.True(t, latency["s1"].P50 >= time.Millisecond*30)
+	assert.True(t, latency["s1"].Max >= latency["s1"].P99)
+	assert.Equal(t, int64(5), latency["s2"].Count)
+	assert.True(t, latency["s2"].Max < time.Millisecond*30)
+
+	var histogram latencyHistogram
+	for i := 0; i < 90; i++ {
+		histogram.observe(time.Microsecond * 80)
+	}
+	for i := 0; i < 10; i++ {
+		histogram.observe(time.Millisecond * 20)
+	}
+	stats := histogram.snapshot()
+	assert.Equal(t, int64(100), stats.Count)
+	assert.Equal(t, time.Microsecond*100, stats.P50)
+	assert.Equal(t, time.Millisecond*20, stats.P95)
+	assert.Equal(t, time.Millisecond*20, stats.P99)
+	assert.Equal(t, time.Millisecond*20, stats.Max)
+}
+
+// TestSetDebugMode 运行时设置规则链和节点调试模式
+func TestSetDebugMode(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testSetDebugMode"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`)
+	var lock sync.Mutex
+	debugNodes := make(map[string]int)
+	config := NewConfig()
+	config.OnDebug = func(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		debugNodes[nodeId]++
+	}
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	engine := ruleEngine.(*RuleEngine)
+	//发送一条消息，返回每个节点的调试回调次数
+	send := func() map[string]int {
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"))
+		time.Sleep(time.Millisecond * 100)
+		lock.Lock()
+		defer lock.Unlock()
+		result := debugNodes
+		debugNodes = make(map[string]int)
+		return result
+	}
+	assert.Equal(t, 0, len(send()))
+
+	assert.Nil(t, engine.SetNodeDebugMode("s1", true))
+	assert.Equal(t, map[string]int{"s1": 2}, send())
+	assert.NotNil(t, engine.SetNodeDebugMode("notFound", true))
+
+	//更新子节点保留
+	assert.Nil(t, ruleEngine.ReloadChild("s1", []byte(`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}`)))
+	assert.Equal(t, map[string]int{"s1": 2}, send())
+
+	assert.Nil(t, engine.SetDebugMode(true))
+	assert.True(t, ruleEngine.RootRuleChainCtx().(*RuleChainCtx).IsDebugMode())
+	assert.Equal(t, map[string]int{"s1": 2, "s2": 2}, send())
+
+	//重新加载规则链恢复为DSL定义的调试模式
+	assert.Nil(t, ruleEngine.ReloadSelf(def))
+	assert.False(t, ruleEngine.RootRuleChainCtx().(*RuleChainCtx).IsDebugMode())
+	assert.Equal(t, 0, len(send()))
+}
+
+// TestDebugSampling 调试回调采样，被丢弃的调试事件计入统计
+func TestDebugSampling(t *testing.T) {
+	def := `{"ruleChain":{"id":"testDebugSampling","debugMode":true%s},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`
+	var count int32
+	config := NewConfig(types.WithDebugSampling(types.DebugSampling{MaxPerSecond: 3}))
+	config.OnDebug = func(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
+		atomic.AddInt32(&count, 1)
+	}
+	ruleEngine, err := New(str.RandomStr(10), []byte(fmt.Sprintf(def, "")), WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"))
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	//每条消息4个调试事件，令牌桶最多保留3个
+	send(5)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&count))
+	assert.Equal(t, int64(17), ruleEngine.(*RuleEngine).Stats().DebugDropped)
+
+	//规则链定义覆盖全局采样策略，几乎所有消息都不采样
+	atomic.StoreInt32(&count, 0)
+	assert.Nil(t, ruleEngine.ReloadSelf([]byte(fmt.Sprintf(def, `,"debugSampling":{"rate":1000000000}`))))
+	assert.Equal(t, int64(0), ruleEngine.(*RuleEngine).Stats().DebugDropped)
+	send(5)
+	assert.True(t, atomic.LoadInt32(&count) <= 4)
+	assert.True(t, ruleEngine.(*RuleEngine).Stats().DebugDropped >= 16)
+
+	//关闭采样后所有调试事件都回调
+	atomic.StoreInt32(&count, 0)
+	assert.Nil(t, ruleEngine.ReloadSelf([]byte(fmt.Sprintf(def, `,"debugSampling":{}`))))
+	send(5)
+	assert.Equal(t, int32(20), atomic.LoadInt32(&count))
+	assert.Equal(t, int64(0), ruleEngine.(*RuleEngine).Stats().DebugDropped)
+}
+
+func TestChainSnapshot(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testChainSnapshot","name":"snapshot","configuration":{"vars":{"host":"192.168.1.1"},"secrets":{"apiKey":"sk-123"}}},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","name":"filter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"restApiCall","configuration":{"restEndpointUrlPattern":"http://${vars.host}/api","headers":{"Authorization":"Bearer sk-123"}}},` +
+		`{"id":"s3","type":"jsFilter","configuration":{"jsScript":"return (;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True","priority":1},{"fromId":"s1","toId":"s3","type":"False"}]}}`)
+	re, err := New(str.RandomStr(10), def, WithConfig(NewConfig(types.WithAllowNodeInitFailure(true))))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+
+	snapshot := ruleEngine.Snapshot()
+	assert.Equal(t, re.Id(), snapshot.Id)
+	assert.Equal(t, "snapshot", snapshot.Name)
+	assert.False(t, snapshot.DebugMode)
+	assert.False(t, snapshot.Empty)
+	assert.True(t, snapshot.Degraded)
+	assert.Equal(t, []string{"host"}, snapshot.VarKeys)
+	assert.Equal(t, []string{"apiKey"}, snapshot.SecretKeys)
+	assert.Equal(t, 3, len(snapshot.Nodes))
+	assert.Equal(t, "filter", snapshot.Nodes[0].Name)
+	assert.Equal(t, "restApiCall", snapshot.Nodes[1].Type)
+	assert.Equal(t, "http://192.168.1.1/api", snapshot.Nodes[1].Configuration["restEndpointUrlPattern"])
+	assert.Equal(t, "Bearer "+SecretMask, snapshot.Nodes[1].Configuration["headers"].(map[string]interface{})["Authorization"])
+	assert.Nil(t, snapshot.Nodes[1].Configuration[types.Secrets])
+	assert.True(t, snapshot.Nodes[2].Degraded)
+	assert.True(t, snapshot.Nodes[2].Err != "")
+	assert.Equal(t, []ConnectionSnapshot{
+		{FromId: "s1", ToId: "s2", Type: types.True, Priority: 1},
+		{FromId: "s1", ToId: "s3", Type: types.False},
+	}, snapshot.Connections)
+
+	//定义不变时使用缓存，调试模式实时读取
+	ruleEngine.rootRuleChainCtx.SetDebugMode(true)
+	cached := ruleEngine.Snapshot()
+	assert.True(t, cached.DebugMode)
+	assert.True(t, &cached.Nodes[0] == &snapshot.Nodes[0])
+
+	//重新加载后重新构建
+	assert.Nil(t, ruleEngine.ReloadChild("s3", []byte(`{"id":"s3","type":"jsFilter","configuration":{"jsScript":"return true;"}}`)))
+	snapshot = ruleEngine.Snapshot()
+	assert.False(t, snapshot.Degraded)
+	assert.False(t, snapshot.Nodes[2].Degraded)
+	assert.Nil(t, ruleEngine.RemoveConnection("s1", "s3", types.False))
+	assert.Equal(t, 1, len(ruleEngine.Snapshot().Connections))
+}
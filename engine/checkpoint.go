@@ -0,0 +1,235 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/utils/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var _ types.CheckpointStore = (*MemoryCheckpointStore)(nil)
+var _ types.CheckpointStore = (*FileCheckpointStore)(nil)
+
+// ErrCheckpointStoreNotConfigured 没有配置检查点存储
+var ErrCheckpointStoreNotConfigured = errors.New("checkpoint store is not configured")
+
+// checkpointId 检查点ID，同一条消息在同一个节点只记录一个检查点
+func checkpointId(msgId, nodeId string) string {
+	return msgId + "/" + nodeId
+}
+
+// MemoryCheckpointStore 基于内存的检查点存储，进程重启后丢失，适用于测试或者只需要在进程内恢复的场景
+type MemoryCheckpointStore struct {
+	checkpoints map[string]types.Checkpoint
+	lock        sync.RWMutex
+}
+
+// NewMemoryCheckpointStore 创建基于内存的检查点存储
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{checkpoints: make(map[string]types.Checkpoint)}
+}
+
+func (s *MemoryCheckpointStore) Save(checkpoint types.Checkpoint) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	checkpoint.Msg = checkpoint.Msg.Copy()
+	s.checkpoints[checkpoint.Id] = checkpoint
+	return nil
+}
+
+func (s *MemoryCheckpointStore) Delete(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.checkpoints, id)
+	return nil
+}
+
+// List 获取所有检查点，按照记录时间排序
+func (s *MemoryCheckpointStore) List() ([]types.Checkpoint, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	var checkpoints []types.Checkpoint
+	for _, item := range s.checkpoints {
+		item.Msg = item.Msg.Copy()
+		checkpoints = append(checkpoints, item)
+	}
+	sortCheckpoints(checkpoints)
+	return checkpoints, nil
+}
+
+// FileCheckpointStore 基于文件的检查点存储，每个检查点保存为目录下的一个json文件，进程重启后可以恢复
+type FileCheckpointStore struct {
+	dir string
+}
+
+// NewFileCheckpointStore 创建基于文件的检查点存储，目录不存在则创建
+func NewFileCheckpointStore(dir string) (*FileCheckpointStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileCheckpointStore{dir: dir}, nil
+}
+
+// Save 先写入临时文件再重命名，避免进程崩溃时留下不完整的检查点文件
+func (s *FileCheckpointStore) Save(checkpoint types.Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	file := s.file(checkpoint.Id)
+	tmpFile := file + ".tmp"
+	if err := os.WriteFile(tmpFile, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile, file)
+}
+
+func (s *FileCheckpointStore) Delete(id string) error {
+	if err := os.Remove(s.file(id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List 获取所有检查点，按照记录时间排序
+func (s *FileCheckpointStore) List() ([]types.Checkpoint, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var checkpoints []types.Checkpoint
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			//读取期间已经被删除
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		var checkpoint types.Checkpoint
+		if err := json.Unmarshal(data, &checkpoint); err != nil {
+			return nil, fmt.Errorf("checkpoint file %s: %w", entry.Name(), err)
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+	sortCheckpoints(checkpoints)
+	return checkpoints, nil
+}
+
+// file 检查点ID可能包含路径分隔符，使用十六进制编码作为文件名
+func (s *FileCheckpointStore) file(id string) string {
+	return filepath.Join(s.dir, hex.EncodeToString([]byte(id))+".json")
+}
+
+func sortCheckpoints(checkpoints []types.Checkpoint) {
+	sort.Slice(checkpoints, func(i, j int) bool {
+		if checkpoints[i].Ts != checkpoints[j].Ts {
+			return checkpoints[i].Ts < checkpoints[j].Ts
+		}
+		return checkpoints[i].Id < checkpoints[j].Id
+	})
+}
+
+// saveCheckpoint 节点执行前记录检查点
+func (ctx *DefaultRuleContext) saveCheckpoint(msg types.RuleMsg, node types.NodeCtx, relationType string) {
+	store := ctx.config.CheckpointStore
+	if store == nil || ctx.ruleChainCtx == nil || node == nil {
+		return
+	}
+	nodeId := node.GetNodeId().Id
+	checkpoint := types.Checkpoint{
+		Id:           checkpointId(ctx.checkpointMsgId(msg), nodeId),
+		ChainId:      ctx.ruleChainCtx.Id.Id,
+		NodeId:       nodeId,
+		RelationType: relationType,
+		Msg:          msg,
+		Ts:           time.Now().UnixMilli(),
+	}
+	if err := store.Save(checkpoint); err != nil {
+		ctx.config.Logger.Printf("save checkpoint %s error:%s", checkpoint.Id, err)
+	}
+}
+
+// deleteCheckpoint 当前节点执行完成，删除当前节点的检查点
+func (ctx *DefaultRuleContext) deleteCheckpoint(msg types.RuleMsg) {
+	store := ctx.config.CheckpointStore
+	if store == nil || ctx.self == nil {
+		return
+	}
+	id := checkpointId(ctx.checkpointMsgId(msg), ctx.self.GetNodeId().Id)
+	if err := store.Delete(id); err != nil {
+		ctx.config.Logger.Printf("delete checkpoint %s error:%s", id, err)
+	}
+}
+
+// checkpointMsgId 使用进入规则链时的消息ID，节点修改消息ID不影响检查点ID
+func (ctx *DefaultRuleContext) checkpointMsgId(msg types.RuleMsg) string {
+	if ctx.runSnapshot != nil && ctx.runSnapshot.msgId != "" {
+		return ctx.runSnapshot.msgId
+	}
+	return msg.Id
+}
+
+// withResumeNode 从检查点恢复执行时，指定消息开始执行的节点
+func withResumeNode(nodeId string) types.RuleContextOption {
+	return func(rc types.RuleContext) {
+		if ctx, ok := rc.(*DefaultRuleContext); ok {
+			ctx.resumeNodeId = nodeId
+		}
+	}
+}
+
+// ResumePending 从检查点恢复执行当前规则链未完成的消息，消息从记录的节点开始执行，而不是第一个节点，返回恢复的消息数量
+// 记录的节点在重新加载后已经不存在，则通过OnEnd回调错误，并删除该检查点
+// 消息在节点执行前记录，恢复后该节点会重新执行，节点需要能够处理重复的消息
+func (e *RuleEngine) ResumePending(opts ...types.RuleContextOption) (int, error) {
+	store := e.Config.CheckpointStore
+	if store == nil {
+		return 0, ErrCheckpointStoreNotConfigured
+	}
+	checkpoints, err := store.List()
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, item := range checkpoints {
+		if item.ChainId != e.id {
+			continue
+		}
+		itemOpts := append(opts[:len(opts):len(opts)], withResumeNode(item.NodeId))
+		e.onMsgAndWait(item.Msg, false, itemOpts...)
+		//节点修改过消息ID，恢复执行使用新的检查点ID，删除旧的检查点
+		if item.Id != checkpointId(item.Msg.Id, item.NodeId) {
+			_ = store.Delete(item.Id)
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,149 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrConcurrencyLimitExceeded 规则链并发数达到上限，消息被拒绝
+var ErrConcurrencyLimitExceeded = errors.New("the rule chain concurrency limit is exceeded")
+
+// ConcurrencyStats 规则链并发统计
+type ConcurrencyStats struct {
+	//InFlight 当前正在执行的消息数
+	InFlight int64
+	//Pending 当前等待并发许可的消息数
+	Pending int64
+	//Rejected 累计因为并发数达到上限被拒绝的消息数
+	Rejected int64
+}
+
+// concurrencyLimiter 规则链并发限制器
+// 没有限制时只做原子计数，达到上限后按照溢出策略阻塞、拒绝或者排队等待
+type concurrencyLimiter struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	//最大并发数，<=0 不限制
+	max        int64
+	policy     string
+	maxPending int64
+	inflight   int64
+	pending    int64
+	rejected   int64
+	//是否通过代码设置，设置后重新加载规则链不再使用DSL配置
+	override bool
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	l := &concurrencyLimiter{}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// set 更新限制配置，唤醒等待的消息重新检查
+func (l *concurrencyLimiter) set(max int, policy string, maxPending int, override bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if override {
+		l.override = true
+	} else if l.override {
+		return
+	}
+	atomic.StoreInt64(&l.max, int64(max))
+	l.policy = policy
+	l.maxPending = int64(maxPending)
+	l.cond.Broadcast()
+}
+
+// acquire 获取并发许可，被拒绝返回 ErrConcurrencyLimitExceeded
+// blockable=false 时不等待，例如：子规则链调用在父规则链的协程池任务中执行，等待会占用协程池的协程导致死锁，达到上限直接拒绝
+// 等待期间ctx取消或者超过截止时间，返回ctx的错误
+func (l *concurrencyLimiter) acquire(ctx context.Context, blockable bool) error {
+	if atomic.LoadInt64(&l.max) <= 0 {
+		atomic.AddInt64(&l.inflight, 1)
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if !l.full() {
+		atomic.AddInt64(&l.inflight, 1)
+		return nil
+	}
+	if !blockable || l.policy == types.OverflowDrop ||
+		(l.policy == types.OverflowQueue && atomic.LoadInt64(&l.pending) >= l.maxPending) {
+		atomic.AddInt64(&l.rejected, 1)
+		return ErrConcurrencyLimitExceeded
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if done := ctx.Done(); done != nil {
+		//ctx取消时唤醒等待的消息
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-done:
+				l.lock.Lock()
+				l.cond.Broadcast()
+				l.lock.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	//先增加等待数再检查，release 根据等待数决定是否唤醒
+	atomic.AddInt64(&l.pending, 1)
+	defer atomic.AddInt64(&l.pending, -1)
+	for l.full() {
+		if err := ctx.Err(); err != nil {
+			atomic.AddInt64(&l.rejected, 1)
+			return err
+		}
+		l.cond.Wait()
+	}
+	atomic.AddInt64(&l.inflight, 1)
+	return nil
+}
+
+// full 是否达到最大并发数，需要持有锁
+func (l *concurrencyLimiter) full() bool {
+	max := atomic.LoadInt64(&l.max)
+	return max > 0 && atomic.LoadInt64(&l.inflight) >= max
+}
+
+// release 释放并发许可
+func (l *concurrencyLimiter) release() {
+	atomic.AddInt64(&l.inflight, -1)
+	if atomic.LoadInt64(&l.pending) > 0 {
+		l.lock.Lock()
+		l.cond.Signal()
+		l.lock.Unlock()
+	}
+}
+
+func (l *concurrencyLimiter) stats() ConcurrencyStats {
+	return ConcurrencyStats{
+		InFlight: atomic.LoadInt64(&l.inflight),
+		Pending:  atomic.LoadInt64(&l.pending),
+		Rejected: atomic.LoadInt64(&l.rejected),
+	}
+}
@@ -0,0 +1,114 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+const (
+	//DeadLetterFromKey 发送到死信节点的消息，消息停止的节点ID在消息元数据中的key，参考 types.RuleChainBaseInfo.DeadLetterNodeId
+	DeadLetterFromKey = "deadLetterFrom"
+	//DeadLetterRelationKey 发送到死信节点的消息，没有连接的关系类型在消息元数据中的key
+	DeadLetterRelationKey = "deadLetterRelationType"
+	//DeadLetterErrorKey 发送到死信节点的消息，节点输出的错误信息在消息元数据中的key
+	DeadLetterErrorKey = "deadLetterError"
+)
+
+// ErrUnmatchedRelation 节点输出的关系没有连接，参考 types.RuleChainBaseInfo.StrictRelations
+var ErrUnmatchedRelation = errors.New("the relation has no connection")
+
+// UnmatchedRelationError 规则链开启严格关系模式后，消息停止在没有连接的关系时，通过OnEnd返回的错误
+type UnmatchedRelationError struct {
+	//NodeId 消息停止的节点ID
+	NodeId string
+	//RelationType 没有连接的关系类型
+	RelationType string
+	//Err 节点输出的错误信息
+	Err error
+}
+
+func (e *UnmatchedRelationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("node %s relation %s: %s: %s", e.NodeId, e.RelationType, ErrUnmatchedRelation, e.Err)
+	}
+	return fmt.Sprintf("node %s relation %s: %s", e.NodeId, e.RelationType, ErrUnmatchedRelation)
+}
+
+// Unwrap 可以通过 errors.Is(err, ErrUnmatchedRelation) 判断
+func (e *UnmatchedRelationError) Unwrap() error {
+	return ErrUnmatchedRelation
+}
+
+// isUnmatched relationType没有连接时，是否作为死信处理：Failure关系，或者当前节点有其他关系的连接
+// 没有任何连接的节点输出其他关系是正常结束的分支
+func (ctx *DefaultRuleContext) isUnmatched(relationType string) bool {
+	if ctx.ruleChainCtx == nil || ctx.self == nil {
+		return false
+	}
+	if relationType == types.Failure {
+		return true
+	}
+	routes, _ := ctx.ruleChainCtx.GetNodeRoutes(ctx.self.GetNodeId())
+	return len(routes) > 0
+}
+
+// deadLetter 消息停止在没有连接的关系，触发 types.Config.OnDeadLetter 回调，并发送到规则链配置的死信节点
+// 没有配置死信节点时结束该分支，开启严格关系模式则通过OnEnd返回 UnmatchedRelationError
+func (ctx *DefaultRuleContext) deadLetter(msg types.RuleMsg, err error, relationType string) {
+	chainId := ctx.ruleChainCtx.GetNodeId().Id
+	nodeId := ctx.self.GetNodeId().Id
+	if ctx.config.OnDeadLetter != nil {
+		msgCopy := msg.Copy()
+		ctx.SubmitTack(func() {
+			ctx.config.OnDeadLetter(chainId, nodeId, msgCopy, relationType, err)
+		})
+	}
+	var strict bool
+	if def := ctx.ruleChainCtx.Definition(); def != nil {
+		strict = def.RuleChain.StrictRelations
+		//死信节点自身的死信不再发送到死信节点，避免循环
+		if deadLetterNodeId := def.RuleChain.DeadLetterNodeId; deadLetterNodeId != "" && deadLetterNodeId != nodeId {
+			if node, ok := ctx.ruleChainCtx.GetNodeById(types.RuleNodeId{Id: deadLetterNodeId, Type: types.NODE}); ok {
+				msgCopy := msg.Copy()
+				if msgCopy.Metadata == nil {
+					msgCopy.Metadata = types.NewMetadata()
+				}
+				msgCopy.Metadata.PutValue(DeadLetterFromKey, nodeId)
+				msgCopy.Metadata.PutValue(DeadLetterRelationKey, relationType)
+				if err != nil {
+					msgCopy.Metadata.PutValue(DeadLetterErrorKey, err.Error())
+				}
+				ctx.childReady()
+				if submitErr := ctx.submitNodeTask(func() {
+					ctx.tellNext(msgCopy, node, relationType)
+				}, false); submitErr != nil {
+					ctx.DoOnEnd(msgCopy, submitErr, relationType)
+				}
+				return
+			}
+		}
+	}
+	if strict {
+		ctx.DoOnEnd(msg, &UnmatchedRelationError{NodeId: nodeId, RelationType: relationType, Err: err}, relationType)
+	} else {
+		ctx.DoOnEnd(msg, err, relationType)
+	}
+}
@@ -0,0 +1,91 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// debugSampler 调试回调采样器，按消息ID比例采样，同一条消息的调试事件全部保留或者全部丢弃，
+// 然后通过令牌桶限制每秒的调试事件数
+type debugSampler struct {
+	rate         uint32
+	maxPerSecond float64
+	lock         sync.Mutex
+	tokens       float64
+	last         time.Time
+}
+
+// newDebugSampler 创建调试回调采样器，规则链定义的采样策略优先，没有采样策略返回nil
+func newDebugSampler(config types.Config, ruleChainDef *types.RuleChain) *debugSampler {
+	sampling := config.DebugSampling
+	if ruleChainDef != nil && ruleChainDef.RuleChain.DebugSampling != nil {
+		sampling = *ruleChainDef.RuleChain.DebugSampling
+	}
+	if sampling.Rate <= 1 && sampling.MaxPerSecond <= 0 {
+		return nil
+	}
+	s := &debugSampler{}
+	if sampling.Rate > 1 {
+		s.rate = uint32(sampling.Rate)
+	}
+	if sampling.MaxPerSecond > 0 {
+		s.maxPerSecond = float64(sampling.MaxPerSecond)
+		s.tokens = s.maxPerSecond
+		s.last = time.Now()
+	}
+	return s
+}
+
+// allow 是否保留该消息的调试事件
+func (s *debugSampler) allow(msgId string) bool {
+	if s == nil {
+		return true
+	}
+	if s.rate > 1 && hashMsgId(msgId)%s.rate != 0 {
+		return false
+	}
+	if s.maxPerSecond <= 0 {
+		return true
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := time.Now()
+	s.tokens += now.Sub(s.last).Seconds() * s.maxPerSecond
+	if s.tokens > s.maxPerSecond {
+		s.tokens = s.maxPerSecond
+	}
+	s.last = now
+	if s.tokens < 1 {
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+// hashMsgId FNV-1a 哈希，避免分配内存
+func hashMsgId(msgId string) uint32 {
+	h := uint32(2166136261)
+	for i := 0; i < len(msgId); i++ {
+		h ^= uint32(msgId[i])
+		h *= 16777619
+	}
+	return h
+}
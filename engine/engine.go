@@ -23,6 +23,9 @@ import (
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/builtin/aspect"
 	"reflect"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -30,6 +33,8 @@ import (
 
 var _ types.RuleContext = (*DefaultRuleContext)(nil)
 var _ types.RuleEngine = (*RuleEngine)(nil)
+var _ types.ExecutionTimeoutSetter = (*DefaultRuleContext)(nil)
+var _ types.EntryPointSetter = (*DefaultRuleContext)(nil)
 
 // BuiltinsAspects 内置切面列表
 var BuiltinsAspects = []types.Aspect{&aspect.Debug{}}
@@ -71,15 +76,128 @@ type DefaultRuleContext struct {
 	beforeAspects []types.BeforeAspect
 	//后置切面列表
 	afterAspects []types.AfterAspect
+	//拦截切面列表
+	interceptAspects []types.InterceptAspect
 	//运行时快照
 	runSnapshot *RunSnapshot
+	//stopNodeId 分段执行的停止节点ID，消息即将进入该节点时停止分发
+	stopNodeId string
+	//onStop 消息到达停止节点时的回调函数
+	onStop func(ctx types.RuleContext, msg types.RuleMsg, relationType string)
+	//executionTimeout 通过 types.WithExecutionTimeout 设置的消息执行超时时间
+	executionTimeout time.Duration
+	//entryPoint 通过 types.WithEntryPoint 指定的规则链入口名称
+	entryPoint string
+	//resumeNodeId 从检查点恢复执行时，消息开始执行的节点ID
+	resumeNodeId string
+	//startTime 当前节点开始执行的时间，用于统计节点执行耗时
+	startTime time.Time
+	//latencyObserved 是否已经记录当前节点执行耗时，节点多次通知下一个节点只记录第一次
+	latencyObserved int32
+	//traceNode 当前节点在执行轨迹中的节点，参考 types.WithTrace
+	traceNode *types.TraceNode
+	//traceParent 上一个节点在执行轨迹中的节点
+	traceParent *types.TraceNode
+	//onCancelFunc 通过 WithCancelFunc 设置的获取取消函数的回调
+	onCancelFunc func(cancel context.CancelFunc)
+	//onBatchEnd 通过 WithOnBatchEnd 设置的批量处理完成回调
+	onBatchEnd func(result BatchResult)
+	//inlineFirst 是否在当前协程执行第一个节点，参考 RuleEngine.OnMsgBatch
+	inlineFirst bool
+	//priority 消息优先级，参考 types.WithPriority
+	priority string
+	//expireAt 消息过期的毫秒时间戳，0表示不过期，参考 types.ExpireAtKey
+	expireAt int64
+	//timeoutGuard 当前节点执行超时守卫，节点没有配置超时时间时为nil，参考 types.RuleNode.Timeout
+	timeoutGuard *nodeTimeoutGuard
+	//retry 当前节点的重试状态，节点没有配置重试策略时为nil，参考 types.RuleNode.Retry
+	retry *nodeRetry
+	//told 当前节点是否已经通知过下一个节点，节点通知后panic不再通过Failure关系路由
+	told int32
+}
+
+// ExecutionTimeoutError 消息执行超过截止时间，停止执行后续节点
+type ExecutionTimeoutError struct {
+	//NodeId 停止执行的节点ID，该节点没有执行
+	NodeId string
+}
+
+func (e *ExecutionTimeoutError) Error() string {
+	return fmt.Sprintf("execution timeout, stopped before node %s: %s", e.NodeId, context.DeadlineExceeded)
+}
+
+// Unwrap 可以通过 errors.Is(err, context.DeadlineExceeded) 判断
+func (e *ExecutionTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+// ErrMsgExpired 消息超过有效期，参考 types.ExpireAtKey 和 types.TTLKey
+var ErrMsgExpired = errors.New("the message is expired")
+
+// MsgExpiredError 消息超过有效期，停止执行后续节点
+type MsgExpiredError struct {
+	//NodeId 停止执行的节点ID，该节点没有执行
+	NodeId string
+	//ExpireAt 消息过期的毫秒时间戳
+	ExpireAt int64
+}
+
+func (e *MsgExpiredError) Error() string {
+	return fmt.Sprintf("message expired at %d, stopped before node %s: %s", e.ExpireAt, e.NodeId, ErrMsgExpired)
+}
+
+// Unwrap 可以通过 errors.Is(err, ErrMsgExpired) 判断
+func (e *MsgExpiredError) Unwrap() error {
+	return ErrMsgExpired
+}
+
+const (
+	//PanicNodeIdKey 节点panic时，发生panic的节点ID在消息元数据中的key
+	PanicNodeIdKey = "panicNodeId"
+	//PanicErrorKey 节点panic时，panic的值在消息元数据中的key
+	PanicErrorKey = "panicError"
+	//PanicStackKey 节点panic时，截断后的调用栈在消息元数据中的key
+	PanicStackKey = "panicStack"
+)
+
+// maxPanicStackSize panic调用栈保留的最大字节数
+const maxPanicStackSize = 4096
+
+// NodePanicError 节点执行发生panic，恢复后转换成该错误，通过Failure关系路由到下一个节点
+type NodePanicError struct {
+	//NodeId 发生panic的节点ID
+	NodeId string
+	//Value panic的值
+	Value interface{}
+	//Stack 截断后的调用栈
+	Stack string
+}
+
+func newNodePanicError(nodeId string, value interface{}) *NodePanicError {
+	stack := debug.Stack()
+	if len(stack) > maxPanicStackSize {
+		stack = stack[:maxPanicStackSize]
+	}
+	return &NodePanicError{NodeId: nodeId, Value: value, Stack: string(stack)}
+}
+
+func (e *NodePanicError) Error() string {
+	return fmt.Sprintf("node %s panic: %v", e.NodeId, e.Value)
+}
+
+// Unwrap panic的值是error时，可以通过errors.Is/As判断
+func (e *NodePanicError) Unwrap() error {
+	if err, ok := e.Value.(error); ok {
+		return err
+	}
+	return nil
 }
 
 // NewRuleContext 创建一个默认规则引擎消息处理上下文实例
 func NewRuleContext(context context.Context, config types.Config, ruleChainCtx *RuleChainCtx, from types.NodeCtx, self types.NodeCtx, pool types.Pool, onEnd types.OnEndFunc, ruleChainPool types.RuleEnginePool) *DefaultRuleContext {
 	var aspects types.AspectList
 	if ruleChainCtx != nil {
-		aspects = ruleChainCtx.aspects
+		aspects = ruleChainCtx.GetAspects()
 	}
 	if len(aspects) == 0 {
 		for _, builtinsAspect := range BuiltinsAspects {
@@ -88,19 +206,20 @@ func NewRuleContext(context context.Context, config types.Config, ruleChainCtx *
 	}
 	aroundAspects, beforeAspects, afterAspects := aspects.GetNodeAspects()
 	return &DefaultRuleContext{
-		context:       context,
-		config:        config,
-		ruleChainCtx:  ruleChainCtx,
-		from:          from,
-		self:          self,
-		isFirst:       from == nil,
-		pool:          pool,
-		onEnd:         onEnd,
-		ruleChainPool: ruleChainPool,
-		aspects:       aspects,
-		aroundAspects: aroundAspects,
-		beforeAspects: beforeAspects,
-		afterAspects:  afterAspects,
+		context:          context,
+		config:           config,
+		ruleChainCtx:     ruleChainCtx,
+		from:             from,
+		self:             self,
+		isFirst:          from == nil,
+		pool:             pool,
+		onEnd:            onEnd,
+		ruleChainPool:    ruleChainPool,
+		aspects:          aspects,
+		aroundAspects:    aroundAspects,
+		beforeAspects:    beforeAspects,
+		afterAspects:     afterAspects,
+		interceptAspects: aspects.GetInterceptAspects(),
 	}
 }
 
@@ -114,7 +233,11 @@ type RunSnapshot struct {
 	logs map[string]*types.RuleNodeRunLog
 	//onDebugCustomFunc 自定义debug回调
 	onDebugCustomFunc func(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error)
-	lock              sync.RWMutex
+	//traceItems debug模式下按发生顺序记录的执行轨迹
+	traceItems []types.DebugTraceItem
+	//tracer 通过 types.WithTrace 开启的执行轨迹树
+	tracer *messageTracer
+	lock   sync.RWMutex
 }
 
 func NewRunSnapshot(msgId string, chainCtx *RuleChainCtx, startTs int64) *RunSnapshot {
@@ -166,6 +289,56 @@ func (r *RunSnapshot) collectRunSnapshot(ctx types.RuleContext, flowType string,
 	}
 }
 
+// addTraceItem 记录执行轨迹
+func (r *RunSnapshot) addTraceItem(flowType string, nodeId string, relationType string, err error) {
+	item := types.DebugTraceItem{
+		NodeId:       nodeId,
+		FlowType:     flowType,
+		RelationType: relationType,
+		Ts:           time.Now().UnixMilli(),
+	}
+	if err != nil {
+		item.Err = err.Error()
+	}
+	r.lock.Lock()
+	r.traceItems = append(r.traceItems, item)
+	r.lock.Unlock()
+}
+
+// onDebugTrace 消息执行完成，回调执行轨迹
+func (r *RunSnapshot) onDebugTrace(onDebugTrace func(trace types.DebugTrace)) {
+	r.lock.RLock()
+	items := r.traceItems
+	r.lock.RUnlock()
+	if len(items) == 0 {
+		return
+	}
+	var chainId string
+	if r.chainCtx != nil {
+		chainId = r.chainCtx.Id.Id
+	}
+	onDebugTrace(types.DebugTrace{
+		ChainId: chainId,
+		MsgId:   r.msgId,
+		StartTs: r.startTs,
+		EndTs:   time.Now().UnixMilli(),
+		Items:   items,
+	})
+}
+
+// onTrace 消息执行完成，回调执行轨迹树
+func (r *RunSnapshot) onTrace(ctx types.RuleContext) {
+	if r.tracer == nil {
+		return
+	}
+	var chainId, definitionHash string
+	if r.chainCtx != nil {
+		chainId = r.chainCtx.Id.Id
+		definitionHash = r.chainCtx.DefinitionHash()
+	}
+	r.tracer.complete(ctx, chainId, definitionHash, r.msgId, r.startTs)
+}
+
 func (r *RunSnapshot) onDebugCustom(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
 	if r.onDebugCustomFunc != nil {
 		r.onDebugCustomFunc(ruleChainId, flowType, nodeId, msg, relationType, err)
@@ -178,7 +351,7 @@ func (r *RunSnapshot) createRuleChainRunLog(endTs int64) types.RuleChainRunSnaps
 		logs = append(logs, *item)
 	}
 	ruleChainRunLog := types.RuleChainRunSnapshot{
-		RuleChain: *r.chainCtx.SelfDefinition,
+		RuleChain: *r.chainCtx.Definition(),
 		Id:        r.msgId,
 		StartTs:   r.startTs,
 		EndTs:     endTs,
@@ -197,20 +370,26 @@ func (r *RunSnapshot) onRuleChainCompleted(ctx types.RuleContext) {
 // NewNextNodeRuleContext 创建下一个节点的规则引擎消息处理上下文实例RuleContext
 func (ctx *DefaultRuleContext) NewNextNodeRuleContext(nextNode types.NodeCtx) *DefaultRuleContext {
 	return &DefaultRuleContext{
-		config:        ctx.config,
-		ruleChainCtx:  ctx.ruleChainCtx,
-		from:          ctx.self,
-		self:          nextNode,
-		pool:          ctx.pool,
-		onEnd:         ctx.onEnd,
-		ruleChainPool: ctx.ruleChainPool,
-		context:       ctx.GetContext(),
-		parentRuleCtx: ctx,
-		skipTellNext:  ctx.skipTellNext,
-		aroundAspects: ctx.aroundAspects,
-		beforeAspects: ctx.beforeAspects,
-		afterAspects:  ctx.afterAspects,
-		runSnapshot:   ctx.runSnapshot,
+		config:           ctx.config,
+		ruleChainCtx:     ctx.ruleChainCtx,
+		from:             ctx.self,
+		self:             nextNode,
+		pool:             ctx.pool,
+		onEnd:            ctx.onEnd,
+		ruleChainPool:    ctx.ruleChainPool,
+		context:          ctx.GetContext(),
+		parentRuleCtx:    ctx,
+		skipTellNext:     ctx.skipTellNext,
+		aroundAspects:    ctx.aroundAspects,
+		beforeAspects:    ctx.beforeAspects,
+		afterAspects:     ctx.afterAspects,
+		interceptAspects: ctx.interceptAspects,
+		runSnapshot:      ctx.runSnapshot,
+		traceParent:      ctx.traceNode,
+		priority:         ctx.priority,
+		expireAt:         ctx.expireAt,
+		stopNodeId:       ctx.stopNodeId,
+		onStop:           ctx.onStop,
 	}
 }
 
@@ -265,6 +444,36 @@ func (ctx *DefaultRuleContext) SetContext(c context.Context) types.RuleContext {
 	return ctx
 }
 
+// SetExecutionTimeout 设置消息执行超时时间
+func (ctx *DefaultRuleContext) SetExecutionTimeout(timeout time.Duration) {
+	ctx.executionTimeout = timeout
+}
+
+// SetPriority 设置消息优先级
+func (ctx *DefaultRuleContext) SetPriority(priority string) {
+	ctx.priority = priority
+}
+
+// SetEntryPoint 设置消息执行的规则链入口名称
+func (ctx *DefaultRuleContext) SetEntryPoint(name string) {
+	ctx.entryPoint = name
+}
+
+// haltErr 上下文已经超过截止时间或者已经取消，返回停止在当前节点的超时或者取消错误
+func (ctx *DefaultRuleContext) haltErr() error {
+	if ctx.context == nil || ctx.context.Err() == nil {
+		return nil
+	}
+	var nodeId string
+	if ctx.self != nil {
+		nodeId = ctx.self.GetNodeId().Id
+	}
+	if ctx.context.Err() == context.DeadlineExceeded {
+		return &ExecutionTimeoutError{NodeId: nodeId}
+	}
+	return &MsgCanceledError{NodeId: nodeId}
+}
+
 func (ctx *DefaultRuleContext) GetContext() context.Context {
 	return ctx.context
 }
@@ -277,34 +486,120 @@ func (ctx *DefaultRuleContext) GetContext() context.Context {
 func (ctx *DefaultRuleContext) SubmitTack(task func()) {
 	if ctx.pool != nil {
 		if err := ctx.pool.Submit(task); err != nil {
-			ctx.config.Logger.Printf("SubmitTack error:%s", err)
+			//协程池已满，直接执行，保证回调和组件提交的任务不丢失
+			task()
 		}
 	} else {
 		go task()
 	}
 }
 
+// submitNodeTask 提交执行节点的任务，协程池已满时按照 types.Config.PoolOverflowPolicy 处理，被拒绝返回 ErrPoolExhausted
+// blockable 调用方是否可以阻塞等待，只有OnMsg调用方可以阻塞
+func (ctx *DefaultRuleContext) submitNodeTask(task func(), blockable bool) error {
+	if ctx.ruleChainCtx != nil {
+		if dispatcher, _ := ctx.ruleChainCtx.priorityDispatcher.Load().(*priorityDispatcher); dispatcher != nil {
+			return dispatcher.submit(task, ctx.priority, blockable)
+		}
+	}
+	if ctx.pool == nil {
+		go task()
+		return nil
+	}
+	if ctx.ruleChainCtx != nil {
+		if overflow, ok := ctx.ruleChainCtx.poolOverflow.Load().(*poolOverflow); ok {
+			return overflow.submit(ctx.pool, task, blockable)
+		}
+	}
+	ctx.SubmitTack(task)
+	return nil
+}
+
+// isNested 是否是子规则链调用，子规则链在父规则链的协程池任务中执行
+func (ctx *DefaultRuleContext) isNested() bool {
+	return isNestedContext(ctx.context)
+}
+
+// isNestedContext context是否是子规则链调用的context
+func isNestedContext(c context.Context) bool {
+	if c == nil {
+		return false
+	}
+	_, ok := c.Value(chainStackKey{}).([]string)
+	return ok
+}
+
+// optionsContext 获取消息选项通过 types.WithContext 设置的context，没有设置返回nil
+func optionsContext(opts []types.RuleContextOption) context.Context {
+	probe := &DefaultRuleContext{}
+	for _, opt := range opts {
+		opt(probe)
+	}
+	return probe.context
+}
+
 // TellFlow 执行子规则链，ruleChainId 规则链ID
 // onEndFunc 子规则链链分支执行完的回调，并返回该链执行结果，如果同时触发多个分支链，则会调用多次
 // onAllNodeCompleted 所以节点执行完之后的回调，无结果返回
 // 如果找不到规则链，并把消息通过`Failure`关系发送到下一个节点
 func (ctx *DefaultRuleContext) TellFlow(msg types.RuleMsg, chainId string, onEndFunc types.OnEndFunc, onAllNodeCompleted func()) {
 	if e, ok := ctx.GetRuleChainPool().Get(chainId); ok {
-		e.OnMsg(msg, types.WithOnEnd(onEndFunc), types.WithContext(ctx.context), types.WithOnAllNodeCompleted(onAllNodeCompleted))
+		subCtx, err := ctx.enterChain(chainId)
+		if err != nil {
+			ctx.TellFailure(msg, err)
+			return
+		}
+		opts := []types.RuleContextOption{types.WithOnEnd(onEndFunc), types.WithContext(subCtx), types.WithOnAllNodeCompleted(onAllNodeCompleted)}
+		if ctx.priority != "" {
+			//子规则链使用相同的优先级
+			opts = append(opts, types.WithPriority(ctx.priority))
+		}
+		e.OnMsg(msg, opts...)
 	} else {
 		ctx.TellFailure(msg, fmt.Errorf("ruleChain id=%s not found", chainId))
 	}
 }
 
+// ErrRecursiveChainInvocation 子规则链直接或者间接调用了调用栈上的规则链
+var ErrRecursiveChainInvocation = errors.New("recursive chain invocation")
+
+// chainStackKey 规则链调用栈在context中的key
+type chainStackKey struct{}
+
+// enterChain 检查是否可以进入子规则链，返回携带新调用栈的context
+// 调用栈记录从根规则链开始经过的规则链ID，通过context传递给子规则链。
+// Config.MaxChainDepth<=0时，不允许进入调用栈上已有的规则链；>0时允许递归，调用栈深度不能超过MaxChainDepth
+func (ctx *DefaultRuleContext) enterChain(chainId string) (context.Context, error) {
+	var stack []string
+	if v, ok := ctx.GetContext().Value(chainStackKey{}).([]string); ok {
+		stack = v
+	} else if ctx.ruleChainCtx != nil {
+		stack = []string{ctx.ruleChainCtx.Id.Id}
+	}
+	path := append(append([]string{}, stack...), chainId)
+	if maxDepth := ctx.config.MaxChainDepth; maxDepth > 0 {
+		if len(path) > maxDepth {
+			return nil, fmt.Errorf("%w: chain depth exceeds %d: %s", ErrRecursiveChainInvocation, maxDepth, strings.Join(path, "->"))
+		}
+	} else {
+		for _, id := range stack {
+			if id == chainId {
+				return nil, fmt.Errorf("%w: %s", ErrRecursiveChainInvocation, strings.Join(path, "->"))
+			}
+		}
+	}
+	return context.WithValue(ctx.GetContext(), chainStackKey{}, path), nil
+}
+
 // SetRuleChainPool 设置子规则链池
 func (ctx *DefaultRuleContext) SetRuleChainPool(ruleChainPool types.RuleEnginePool) {
 	ctx.ruleChainPool = ruleChainPool
 }
 
-// GetRuleChainPool 获取子规则链池
+// GetRuleChainPool 获取子规则链池，没有设置则根据 types.Config.AllowDefaultPoolFallback 使用 DefaultPool 或者空的规则链池
 func (ctx *DefaultRuleContext) GetRuleChainPool() types.RuleEnginePool {
 	if ctx.ruleChainPool == nil {
-		return DefaultPool
+		return fallbackPool(ctx.config)
 	} else {
 		return ctx.ruleChainPool
 	}
@@ -331,6 +626,11 @@ func (ctx *DefaultRuleContext) ExecuteNode(chanCtx context.Context, nodeId strin
 
 // DoOnEnd  结束规则链分支执行，触发 OnEnd 回调函数
 func (ctx *DefaultRuleContext) DoOnEnd(msg types.RuleMsg, err error, relationType string) {
+	if ctx.ruleChainCtx != nil {
+		ctx.ruleChainCtx.stats.incEnd(err)
+	}
+	//分支执行结束，删除检查点
+	ctx.deleteCheckpoint(msg)
 	//全局回调
 	//通过`Config.OnEnd`设置
 	if ctx.config.OnEnd != nil {
@@ -366,6 +666,10 @@ func (ctx *DefaultRuleContext) SetCallbackFunc(functionName string, f interface{
 			if targetFunc, ok := f.(func(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error)); ok {
 				ctx.runSnapshot.onDebugCustomFunc = targetFunc
 			}
+		case types.CallbackFuncOnTrace:
+			if targetFunc, ok := f.(func(ctx types.RuleContext, trace types.ExecutionTrace)); ok {
+				ctx.runSnapshot.tracer = newMessageTracer(targetFunc, ctx.config.TraceMaxDataSize)
+			}
 		}
 	}
 }
@@ -379,6 +683,11 @@ func (ctx *DefaultRuleContext) GetCallbackFunc(functionName string) interface{}
 			return ctx.runSnapshot.onNodeCompletedFunc
 		case types.CallbackFuncDebug:
 			return ctx.runSnapshot.onDebugCustomFunc
+		case types.CallbackFuncOnTrace:
+			if ctx.runSnapshot.tracer != nil {
+				return ctx.runSnapshot.tracer.onTrace
+			}
+			return nil
 		default:
 			return nil
 		}
@@ -386,14 +695,51 @@ func (ctx *DefaultRuleContext) GetCallbackFunc(functionName string) interface{}
 	return nil
 }
 
+// debugDetail 创建节点调试详细信息，流出时包含节点执行耗时和根据relationType解析到的下一个节点ID列表
+func (ctx *DefaultRuleContext) debugDetail(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) types.DebugDetail {
+	detail := types.DebugDetail{
+		RuleChainId:  ruleChainId,
+		FlowType:     flowType,
+		NodeId:       nodeId,
+		MsgId:        msg.Id,
+		Msg:          msg,
+		RelationType: relationType,
+		Err:          err,
+	}
+	if flowType == types.Out {
+		if !ctx.startTime.IsZero() {
+			detail.Duration = time.Since(ctx.startTime)
+		}
+		if nodes, ok := ctx.getNextNodes(relationType); ok {
+			for _, node := range nodes {
+				detail.NextNodeIds = append(detail.NextNodeIds, node.GetNodeId().Id)
+			}
+		}
+	}
+	return detail
+}
+
 func (ctx *DefaultRuleContext) OnDebug(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
 	msgCopy := msg.Copy()
-	if ctx.IsDebugMode() {
+	//先采样再创建调试信息，被丢弃的调试事件只增加计数
+	if ctx.IsDebugMode() && ctx.ruleChainCtx.sampleDebug(msg.Id) {
+		if ctx.config.OnDebugTrace != nil && ctx.runSnapshot != nil {
+			//同步记录执行轨迹，保证顺序
+			ctx.runSnapshot.addTraceItem(flowType, nodeId, relationType, err)
+		}
+		var detail types.DebugDetail
+		if ctx.config.OnDebugDetail != nil {
+			//同步解析耗时和下一个节点，异步回调时节点可能已经被重新加载
+			detail = ctx.debugDetail(ruleChainId, flowType, nodeId, msgCopy, relationType, err)
+		}
 		//异步记录日志
 		ctx.SubmitTack(func() {
 			if ctx.config.OnDebug != nil {
 				ctx.config.OnDebug(ruleChainId, flowType, nodeId, msgCopy, relationType, err)
 			}
+			if ctx.config.OnDebugDetail != nil {
+				ctx.config.OnDebugDetail(detail)
+			}
 			if ctx.runSnapshot != nil {
 				ctx.runSnapshot.onDebugCustom(ruleChainId, flowType, nodeId, msgCopy, relationType, err)
 			}
@@ -402,8 +748,29 @@ func (ctx *DefaultRuleContext) OnDebug(ruleChainId string, flowType string, node
 	if ctx.runSnapshot != nil {
 		//记录快照
 		ctx.runSnapshot.collectRunSnapshot(ctx, flowType, nodeId, msgCopy, relationType, err)
+		if tracer := ctx.runSnapshot.tracer; tracer != nil {
+			//记录执行轨迹树
+			switch flowType {
+			case types.In:
+				ctx.traceNode = tracer.enter(ctx.traceParent, nodeId, msg, relationType)
+			case types.Out:
+				tracer.exit(ctx.traceNode, msg, relationType, err)
+			}
+		}
+	}
+	if ctx.ruleChainCtx != nil && ctx.ruleChainCtx.hasSubscribers() {
+		//发送实时执行事件
+		ctx.ruleChainCtx.publish(Event{
+			ChainId:      ruleChainId,
+			MsgId:        msgCopy.Id,
+			NodeId:       nodeId,
+			FlowType:     flowType,
+			RelationType: relationType,
+			Err:          err,
+			Msg:          msgCopy,
+			Ts:           time.Now().UnixMilli(),
+		})
 	}
-
 }
 
 // IsDebugMode 是否调试模式，优先使用规则链指定的调试模式
@@ -447,13 +814,19 @@ func (ctx *DefaultRuleContext) getNextNodes(relationType string) ([]types.NodeCt
 // tellFirst 执行第一个节点
 func (ctx *DefaultRuleContext) tellFirst(msg types.RuleMsg, err error, relationTypes ...string) {
 	msgCopy := msg.Copy()
-	ctx.SubmitTack(func() {
+	ctx.saveCheckpoint(msgCopy, ctx.self, "")
+	task := func() {
 		if ctx.self != nil {
 			ctx.tellNext(msgCopy, ctx.self, "")
 		} else {
 			ctx.DoOnEnd(msgCopy, err, "")
 		}
-	})
+	}
+	if ctx.inlineFirst {
+		task()
+	} else if submitErr := ctx.submitNodeTask(task, !ctx.isNested()); submitErr != nil {
+		ctx.DoOnEnd(msgCopy, submitErr, types.Failure)
+	}
 }
 
 // tellNext 通知执行子节点，如果是当前第一个节点则执行当前节点
@@ -467,68 +840,181 @@ func (ctx *DefaultRuleContext) tellOrElse(msg types.RuleMsg, err error, defaultR
 	//msgCopy := msg.Copy()
 	if ctx.isFirst {
 		ctx.tellFirst(msg, err, relationTypes...)
+		return
+	}
+	atomic.StoreInt32(&ctx.told, 1)
+	if ctx.timeoutGuard == nil || ctx.timeoutGuard.tell() {
+		//节点执行超时后已经通过Failure关系路由，忽略节点之后的通知
+		ctx.tellOrRetry(msg, err, defaultRelationType, relationTypes...)
+	}
+}
+
+// tellChildren 通过relationTypes通知执行当前节点的子节点，找不到子节点则结束该分支
+func (ctx *DefaultRuleContext) tellChildren(msg types.RuleMsg, err error, defaultRelationType string, relationTypes ...string) {
+	ctx.observeLatency()
+	if relationTypes == nil {
+		//找不到子节点，则执行结束回调
+		ctx.DoOnEnd(msg, err, "")
+	} else if ctx.isTerminal() {
+		//终止节点，不再查找子节点，结束该分支链
+		for _, relationType := range relationTypes {
+			msg, relationType = ctx.executeInterceptAfter(msg, err, relationType)
+			ctx.countRelation(relationType)
+			msg = ctx.executeAfterAop(msg, err, relationType)
+			if err == nil {
+				relationType = types.Completed
+			}
+			ctx.DoOnEnd(msg, err, relationType)
+		}
 	} else {
-		if relationTypes == nil {
-			//找不到子节点，则执行结束回调
-			ctx.DoOnEnd(msg, err, "")
-		} else {
-			for _, relationType := range relationTypes {
-				//执行After aop
-				msg = ctx.executeAfterAop(msg, err, relationType)
-				var ok = false
-				var nodes []types.NodeCtx
-				//根据relationType查找子节点列表
-				nodes, ok = ctx.getNextNodes(relationType)
-				//根据默认关系查找节点
-				if defaultRelationType != "" && (!ok || len(nodes) == 0) && !ctx.skipTellNext {
-					nodes, ok = ctx.getNextNodes(defaultRelationType)
-				}
-				if ok && !ctx.skipTellNext {
-					for _, item := range nodes {
-						tmp := item
-						//增加一个待执行的子节点
-						ctx.childReady()
-						msgCopy := msg.Copy()
-						//通知执行子节点
+		for _, relationType := range relationTypes {
+			//执行拦截aop，可以改写消息和关系类型
+			msg, relationType = ctx.executeInterceptAfter(msg, err, relationType)
+			ctx.countRelation(relationType)
+			//执行After aop
+			msg = ctx.executeAfterAop(msg, err, relationType)
+			var ok = false
+			var nodes []types.NodeCtx
+			//根据relationType查找子节点列表
+			nodes, ok = ctx.getNextNodes(relationType)
+			//根据默认关系查找节点
+			if defaultRelationType != "" && (!ok || len(nodes) == 0) && !ctx.skipTellNext {
+				nodes, ok = ctx.getNextNodes(defaultRelationType)
+			}
+			if ok && !ctx.skipTellNext {
+				for _, item := range nodes {
+					tmp := item
+					//增加一个待执行的子节点
+					ctx.childReady()
+					msgCopy := msg.Copy()
+					if ctx.stopNodeId != "" && tmp.GetNodeId().Id == ctx.stopNodeId {
+						//分段执行到达停止节点，不再进入该节点
 						ctx.SubmitTack(func() {
-							ctx.tellNext(msgCopy, tmp, relationType)
+							ctx.onStop(ctx, msgCopy, relationType)
+							ctx.childDone()
 						})
+						continue
+					}
+					//先记录子节点的检查点，再删除当前节点的检查点，保证进程崩溃时至少有一个检查点
+					ctx.saveCheckpoint(msgCopy, tmp, relationType)
+					//通知执行子节点，被拒绝则结束该分支，子节点的检查点保留，可以通过ResumePending重新执行
+					if submitErr := ctx.submitNodeTask(func() {
+						ctx.tellNext(msgCopy, tmp, relationType)
+					}, false); submitErr != nil {
+						ctx.DoOnEnd(msgCopy, submitErr, relationType)
 					}
-				} else {
-					//找不到子节点，则执行结束回调
-					ctx.DoOnEnd(msg, err, relationType)
 				}
+			} else if !ctx.skipTellNext && ctx.isUnmatched(relationType) {
+				//关系没有连接，发送到死信节点或者结束该分支
+				ctx.deadLetter(msg, err, relationType)
+			} else {
+				//找不到子节点，则执行结束回调
+				ctx.DoOnEnd(msg, err, relationType)
 			}
 		}
+		ctx.deleteCheckpoint(msg)
+	}
+}
+
+// countRelation 统计当前节点通过relationType输出的消息数
+func (ctx *DefaultRuleContext) countRelation(relationType string) {
+	if ctx.ruleChainCtx != nil && ctx.self != nil {
+		ctx.ruleChainCtx.stats.incRelation(ctx.self.GetNodeId().Id, relationType)
+	}
+}
+
+// observeLatency 记录当前节点从开始执行到第一次通知下一个节点的耗时
+// 异步组件在其他协程通知下一个节点，同样可以统计到真实耗时
+func (ctx *DefaultRuleContext) observeLatency() {
+	if ctx.startTime.IsZero() || ctx.ruleChainCtx == nil || ctx.self == nil {
+		return
+	}
+	if atomic.CompareAndSwapInt32(&ctx.latencyObserved, 0, 1) {
+		ctx.ruleChainCtx.stats.observeLatency(ctx.self.GetNodeId().Id, time.Since(ctx.startTime))
+	}
+}
+
+// isTerminal 当前节点是否是终止节点
+func (ctx *DefaultRuleContext) isTerminal() bool {
+	if nodeCtx, ok := ctx.self.(*RuleNodeCtx); ok {
+		return nodeCtx.IsTerminal()
 	}
+	return false
 }
 
 // 执行下一个节点
 func (ctx *DefaultRuleContext) tellNext(msg types.RuleMsg, nextNode types.NodeCtx, relationType string) {
+	ctx.NewNextNodeRuleContext(nextNode).execute(msg, relationType)
+}
 
+// execute 执行当前上下文的节点，relationType 上一个节点通知该节点的关系类型
+func (ctx *DefaultRuleContext) execute(msg types.RuleMsg, relationType string) {
+	node := ctx.self
 	defer func() {
 		//捕捉异常
 		if e := recover(); e != nil {
-			//执行After aop
-			msg = ctx.executeAfterAop(msg, fmt.Errorf("%v", e), relationType)
-			ctx.childDone()
+			if ctx.config.PanicCrashFast {
+				panic(e)
+			}
+			err := newNodePanicError(node.GetNodeId().Id, e)
+			//节点已经通知过下一个节点，消息已经路由，只记录异常，避免重复路由
+			if atomic.LoadInt32(&ctx.told) == 1 {
+				if ctx.config.Logger != nil {
+					ctx.config.Logger.Printf("%s, after the message has been routed", err.Error())
+				}
+				return
+			}
+			//转换成错误，通过Failure关系路由，同时触发After aop和调试回调
+			if msg.Metadata == nil {
+				msg.Metadata = types.NewMetadata()
+			}
+			msg.Metadata.PutValue(PanicNodeIdKey, err.NodeId)
+			msg.Metadata.PutValue(PanicErrorKey, fmt.Sprintf("%v", err.Value))
+			msg.Metadata.PutValue(PanicStackKey, err.Stack)
+			ctx.TellFailure(msg, err)
 		}
 	}()
 
-	nextCtx := ctx.NewNextNodeRuleContext(nextNode)
+	//超过执行截止时间或者消息已经取消，不再执行该节点及后续节点
+	if err := ctx.haltErr(); err != nil {
+		ctx.DoOnEnd(msg, err, types.Failure)
+		return
+	}
+	//消息已经过期，不再执行该节点及后续节点
+	if ctx.expireAt > 0 && time.Now().UnixMilli() >= ctx.expireAt {
+		ctx.ruleChainCtx.stats.incExpired()
+		ctx.DoOnEnd(msg, &MsgExpiredError{NodeId: node.GetNodeId().Id, ExpireAt: ctx.expireAt}, types.Failure)
+		return
+	}
 
+	ctx.startTime = time.Now()
+	if timeout := nodeTimeout(node); timeout > 0 {
+		ctx.startNodeTimer(msg.Copy(), timeout)
+	}
+	if ctx.retry == nil {
+		if policy := nodeRetryPolicy(node); policy != nil {
+			//第一次执行，保存原始消息用于重试
+			ctx.retry = &nodeRetry{policy: policy, attempt: 1, msg: msg.Copy(), relationType: relationType}
+		}
+	}
 	//环绕aop
-	if !nextCtx.executeAroundAop(msg, relationType) {
+	msg, ok := ctx.executeAroundAop(msg, relationType)
+	if !ok {
+		// AroundAop 已经执行节点OnMsg逻辑，不在执行下面的逻辑
+		return
+	}
+	//拦截aop，跳过节点则直接通知下一个节点
+	msg, ok = ctx.executeInterceptBefore(msg, relationType)
+	if !ok {
 		return
 	}
-	// AroundAop 已经执行节点OnMsg逻辑，不在执行下面的逻辑
 
-	nextNode.OnMsg(nextCtx, msg)
+	node.OnMsg(ctx, msg)
 }
 
-// 执行环绕aop
+// 执行环绕aop，返回Before和Around aop处理后的消息
 // 返回值true: 继续执行下一个节点，否则不执行
-func (ctx *DefaultRuleContext) executeAroundAop(msg types.RuleMsg, relationType string) bool {
+func (ctx *DefaultRuleContext) executeAroundAop(msg types.RuleMsg, relationType string) (types.RuleMsg, bool) {
 	// before aop
 	for _, aop := range ctx.beforeAspects {
 		if aop.PointCut(ctx, msg, relationType) {
@@ -536,19 +1022,49 @@ func (ctx *DefaultRuleContext) executeAroundAop(msg types.RuleMsg, relationType
 		}
 	}
 
+	//Order值最小的切面在最外层最先执行
+	//如果 AroundAspect 已经执行了tellNext逻辑，则不再执行内层切面，引擎也不再执行tellNext逻辑
 	tellNext := true
-	//是否已经执行了tellNext逻辑
-	//如果 AroundAspect 已经执行了tellNext逻辑，则引擎不再执行tellNext逻辑
-	showTellNext := false
 	for _, aop := range ctx.aroundAspects {
 		if aop.PointCut(ctx, msg, relationType) {
-			msg, showTellNext = aop.Around(ctx, msg, relationType)
-			if !showTellNext {
-				tellNext = false
+			if msg, tellNext = aop.Around(ctx, msg, relationType); !tellNext {
+				return msg, false
 			}
 		}
 	}
-	return tellNext
+	return msg, true
+}
+
+// 执行拦截aop，返回拦截aop处理后的消息
+// 返回值true: 继续执行节点OnMsg逻辑；false: 节点被跳过，已经通过跳过关系通知下一个节点
+func (ctx *DefaultRuleContext) executeInterceptBefore(msg types.RuleMsg, relationType string) (types.RuleMsg, bool) {
+	for _, aop := range ctx.interceptAspects {
+		if !aop.PointCut(ctx, msg, relationType) {
+			continue
+		}
+		var skip bool
+		if msg, skip = aop.InterceptBefore(ctx.self, ctx, msg, relationType); skip {
+			skipRelationType, err := types.Success, error(nil)
+			if skipAop, ok := aop.(types.InterceptSkipAspect); ok {
+				skipRelationType, err = skipAop.SkipRelation(ctx.self, ctx, msg)
+			}
+			ctx.tell(msg, err, skipRelationType)
+			return msg, false
+		}
+	}
+	return msg, true
+}
+
+// 执行拦截aop，返回改写后的消息和关系类型
+// 按照与 executeInterceptBefore 相反的顺序执行，Order值最小的切面在最外层最后执行
+func (ctx *DefaultRuleContext) executeInterceptAfter(msg types.RuleMsg, err error, relationType string) (types.RuleMsg, string) {
+	for i := len(ctx.interceptAspects) - 1; i >= 0; i-- {
+		aop := ctx.interceptAspects[i]
+		if aop.PointCut(ctx, msg, relationType) {
+			msg, relationType = aop.InterceptAfter(ctx.self, ctx, msg, err, relationType)
+		}
+	}
+	return msg, relationType
 }
 
 // 执行After aop
@@ -567,8 +1083,10 @@ func (ctx *DefaultRuleContext) executeAfterAop(msg types.RuleMsg, err error, rel
 type RuleEngine struct {
 	//配置
 	Config types.Config
-	//子规则链池
+	//子规则链池，显式设置后优先从该池查找子规则链，参考 WithRuleChainPool
 	RuleChainPool types.RuleEnginePool
+	//创建规则引擎的规则链池，没有显式设置子规则链池时从该池查找子规则链
+	createdPool types.RuleEnginePool
 	//规则引擎实例标识
 	id string
 	//根规则链
@@ -583,20 +1101,31 @@ type RuleEngine struct {
 	initialized bool
 	//Aspects AOP切面列表
 	Aspects types.AspectList
+	//切面读写锁
+	aspectsLock sync.RWMutex
+	//规则链并发限制器
+	limiter *concurrencyLimiter
+	//合并等待中的重新加载
+	pendingReload reloadCoalescer
+	//重新加载锁，保证合并的重新加载与立即重新加载按顺序执行
+	reloadLock sync.Mutex
+	//正在执行的消息，用于取消消息
+	activeMsgs activeMsgs
 }
 
 //// RuleEngineOption is a function type that modifies the RuleEngine.
 //type RuleEngineOption func(*RuleEngine) error
 
-func newRuleEngine(id string, def []byte, opts ...types.RuleEngineOption) (*RuleEngine, error) {
+func newRuleEngine(id string, def []byte, createdPool types.RuleEnginePool, opts ...types.RuleEngineOption) (*RuleEngine, error) {
 	if len(def) == 0 {
 		return nil, errors.New("def can not nil")
 	}
 	// Create a new RuleEngine with the Id
 	ruleEngine := &RuleEngine{
-		id:            id,
-		Config:        NewConfig(),
-		RuleChainPool: DefaultPool,
+		id:          id,
+		Config:      NewConfig(),
+		createdPool: createdPool,
+		limiter:     newConcurrencyLimiter(),
 	}
 	err := ruleEngine.ReloadSelf(def, opts...)
 	if err == nil && ruleEngine.rootRuleChainCtx != nil {
@@ -608,10 +1137,7 @@ func newRuleEngine(id string, def []byte, opts ...types.RuleEngineOption) (*Rule
 		}
 	}
 	//设置切面列表
-	startAspects, endAspects, completedAspects := ruleEngine.Aspects.GetChainAspects()
-	ruleEngine.startAspects = startAspects
-	ruleEngine.endAspects = endAspects
-	ruleEngine.completedAspects = completedAspects
+	ruleEngine.setChainAspects()
 
 	return ruleEngine, err
 }
@@ -631,6 +1157,46 @@ func (e *RuleEngine) GetAspects() types.AspectList {
 	return e.Aspects
 }
 
+// AddAspect 动态增加切面，同时更新根规则链切面，不需要重新加载规则链，新的消息开始生效
+func (e *RuleEngine) AddAspect(aspects ...types.Aspect) {
+	e.aspectsLock.Lock()
+	var newAspects = make(types.AspectList, 0, len(e.Aspects)+len(aspects))
+	newAspects = append(newAspects, e.Aspects...)
+	e.Aspects = append(newAspects, aspects...)
+	e.setChainAspects()
+	e.aspectsLock.Unlock()
+	if e.rootRuleChainCtx != nil {
+		e.rootRuleChainCtx.AddAspect(aspects...)
+	}
+}
+
+// RemoveAspect 动态删除满足条件的切面，同时更新根规则链切面，返回删除的数量，新的消息开始生效
+func (e *RuleEngine) RemoveAspect(predicate func(aspect types.Aspect) bool) int {
+	e.aspectsLock.Lock()
+	var newAspects = make(types.AspectList, 0, len(e.Aspects))
+	for _, item := range e.Aspects {
+		if !predicate(item) {
+			newAspects = append(newAspects, item)
+		}
+	}
+	removed := len(e.Aspects) - len(newAspects)
+	e.Aspects = newAspects
+	e.setChainAspects()
+	e.aspectsLock.Unlock()
+	if e.rootRuleChainCtx != nil {
+		e.rootRuleChainCtx.RemoveAspect(predicate)
+	}
+	return removed
+}
+
+// setChainAspects 根据切面列表分组规则链执行开始、分支结束和执行完成切面
+func (e *RuleEngine) setChainAspects() {
+	startAspects, endAspects, completedAspects := e.Aspects.GetChainAspects()
+	e.startAspects = startAspects
+	e.endAspects = endAspects
+	e.completedAspects = completedAspects
+}
+
 func (e *RuleEngine) Reload(opts ...types.RuleEngineOption) error {
 	return e.ReloadSelf(e.DSL(), opts...)
 }
@@ -665,22 +1231,43 @@ func (e *RuleEngine) initBuiltinsAspects() {
 }
 
 // ReloadSelf 重新加载规则链
+// 如果配置了 types.Config.ReloadDebounce，已经初始化的规则引擎合并窗口内的重新加载，只应用最后一个定义，立即返回nil
 func (e *RuleEngine) ReloadSelf(def []byte, opts ...types.RuleEngineOption) error {
 	// Apply the options to the RuleEngine.
 	for _, opt := range opts {
 		_ = opt(e)
 	}
+	if window := e.Config.ReloadDebounce; window > 0 && e.Initialized() {
+		e.pendingReload.enqueue(def, window, e.applyPendingReload)
+		return nil
+	}
+	return e.reloadSelf(def)
+}
+
+// ReloadSelfNow 立即同步重新加载规则链，不经过合并窗口，合并等待中的定义被丢弃
+func (e *RuleEngine) ReloadSelfNow(def []byte, opts ...types.RuleEngineOption) error {
+	for _, opt := range opts {
+		_ = opt(e)
+	}
+	e.pendingReload.take()
+	return e.reloadSelf(def)
+}
+
+func (e *RuleEngine) reloadSelf(def []byte) error {
+	e.reloadLock.Lock()
+	defer e.reloadLock.Unlock()
 	if e.Initialized() {
 		//初始化内置切面
 		if len(e.Aspects) == 0 {
 			e.initBuiltinsAspects()
 		}
-		e.rootRuleChainCtx.config = e.Config
+		e.rootRuleChainCtx.setConfig(e.Config)
 		e.rootRuleChainCtx.SetAspects(e.Aspects)
 		//更新规则链
 		err := e.rootRuleChainCtx.ReloadSelf(def)
 		//设置子规则链池
-		e.rootRuleChainCtx.SetRuleChainPool(e.RuleChainPool)
+		e.rootRuleChainCtx.SetRuleChainPool(e.subChainPool())
+		e.applyConcurrencyLimit()
 		return err
 	} else {
 		//初始化内置切面
@@ -692,7 +1279,7 @@ func (e *RuleEngine) ReloadSelf(def []byte, opts ...types.RuleEngineOption) erro
 			}
 			e.rootRuleChainCtx = ctx.(*RuleChainCtx)
 			//设置子规则链池
-			e.rootRuleChainCtx.SetRuleChainPool(e.RuleChainPool)
+			e.rootRuleChainCtx.SetRuleChainPool(e.subChainPool())
 			//执行创建切面逻辑
 			createdAspects, _, _ := e.Aspects.GetEngineAspects()
 			for _, aop := range createdAspects {
@@ -701,6 +1288,8 @@ func (e *RuleEngine) ReloadSelf(def []byte, opts ...types.RuleEngineOption) erro
 				}
 			}
 			e.initialized = true
+			e.rootRuleChainCtx.Start()
+			e.applyConcurrencyLimit()
 			return nil
 		} else {
 			return err
@@ -709,6 +1298,95 @@ func (e *RuleEngine) ReloadSelf(def []byte, opts ...types.RuleEngineOption) erro
 
 }
 
+// ReloadDiff 增量重新加载根规则链，只重新初始化新增和配置变化的节点，保留未变化的节点实例，返回变化的节点
+// 参考 RuleChainCtx.ReloadDiff
+func (e *RuleEngine) ReloadDiff(dsl []byte) (types.ReloadDiff, error) {
+	if !e.Initialized() {
+		return types.ReloadDiff{}, errors.New("ReloadDiff error.RuleEngine not initialized")
+	}
+	e.rootRuleChainCtx.setConfig(e.Config)
+	diff, err := e.rootRuleChainCtx.ReloadDiff(dsl)
+	e.rootRuleChainCtx.SetRuleChainPool(e.subChainPool())
+	e.applyConcurrencyLimit()
+	return diff, err
+}
+
+// applyConcurrencyLimit 使用规则链DSL的并发限制配置，通过 SetConcurrencyLimit 设置过则忽略DSL配置
+func (e *RuleEngine) applyConcurrencyLimit() {
+	if e.rootRuleChainCtx == nil || e.rootRuleChainCtx.Definition() == nil {
+		return
+	}
+	info := e.rootRuleChainCtx.Definition().RuleChain
+	e.limiter.set(info.MaxConcurrency, info.OverflowPolicy, info.MaxPending, false)
+}
+
+// SetConcurrencyLimit 设置规则链最大并发数和溢出策略，覆盖规则链DSL的配置，max<=0 表示不限制
+// policy 取值：types.OverflowBlock(默认)、types.OverflowDrop、types.OverflowQueue，maxPending 为排队策略的最大等待数
+func (e *RuleEngine) SetConcurrencyLimit(max int, policy string, maxPending int) {
+	e.limiter.set(max, policy, maxPending, true)
+}
+
+// ConcurrencyStats 获取规则链当前执行中、等待中的消息数和累计拒绝数
+func (e *RuleEngine) ConcurrencyStats() ConcurrencyStats {
+	return e.limiter.stats()
+}
+
+// Stats 获取根规则链消息统计快照，参考 RuleChainCtx.Stats
+func (e *RuleEngine) Stats() ChainStats {
+	if e.rootRuleChainCtx == nil {
+		return ChainStats{}
+	}
+	return e.rootRuleChainCtx.Stats()
+}
+
+// PoolStats 获取根规则链协程池溢出统计，参考 RuleChainCtx.PoolStats
+func (e *RuleEngine) PoolStats() PoolStats {
+	if e.rootRuleChainCtx == nil {
+		return PoolStats{}
+	}
+	return e.rootRuleChainCtx.PoolStats()
+}
+
+// ResetStats 重置根规则链消息统计
+func (e *RuleEngine) ResetStats() {
+	if e.rootRuleChainCtx != nil {
+		e.rootRuleChainCtx.ResetStats()
+	}
+}
+
+// SetDebugMode 运行时设置根规则链调试模式，参考 RuleChainCtx.SetDebugMode
+func (e *RuleEngine) SetDebugMode(enable bool) error {
+	if !e.Initialized() {
+		return errors.New("SetDebugMode error.RuleEngine not initialized")
+	}
+	e.rootRuleChainCtx.SetDebugMode(enable)
+	return nil
+}
+
+// SetNodeDebugMode 运行时设置根规则链节点调试模式，参考 RuleChainCtx.SetNodeDebugMode
+func (e *RuleEngine) SetNodeDebugMode(nodeId string, enable bool) error {
+	if !e.Initialized() {
+		return errors.New("SetNodeDebugMode error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.SetNodeDebugMode(nodeId, enable)
+}
+
+// DegradedNodes 获取根规则链初始化失败、以降级模式运行的节点，参考 RuleChainCtx.DegradedNodes
+func (e *RuleEngine) DegradedNodes() []DegradedNode {
+	if e.rootRuleChainCtx == nil {
+		return nil
+	}
+	return e.rootRuleChainCtx.DegradedNodes()
+}
+
+// Snapshot 获取根规则链只读快照，参考 RuleChainCtx.Snapshot
+func (e *RuleEngine) Snapshot() ChainSnapshot {
+	if e.rootRuleChainCtx == nil {
+		return ChainSnapshot{}
+	}
+	return e.rootRuleChainCtx.Snapshot()
+}
+
 // ReloadChild 更新根规则链或者其下某个节点
 // 如果ruleNodeId为空更新根规则链，否则更新指定的子节点
 // dsl 根规则链/子节点配置
@@ -735,9 +1413,17 @@ func (e *RuleEngine) DSL() []byte {
 	}
 }
 
+// EncodeDSL 获取根规则链定义，返回编码错误，参考 RuleChainCtx.EncodeDSL
+func (e *RuleEngine) EncodeDSL() ([]byte, error) {
+	if e.rootRuleChainCtx == nil {
+		return nil, errors.New("EncodeDSL error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.EncodeDSL()
+}
+
 func (e *RuleEngine) Definition() types.RuleChain {
 	if e.rootRuleChainCtx != nil {
-		return *e.rootRuleChainCtx.SelfDefinition
+		return *e.rootRuleChainCtx.Definition()
 	} else {
 		return types.RuleChain{}
 	}
@@ -771,12 +1457,27 @@ func (e *RuleEngine) RootRuleChainCtx() types.ChainCtx {
 }
 
 func (e *RuleEngine) Stop() {
+	e.pendingReload.take()
 	if e.rootRuleChainCtx != nil {
 		e.rootRuleChainCtx.Destroy()
 	}
 	e.initialized = false
 }
 
+// GracefulStop 优雅停止规则引擎：不再接收新的消息，等待正在执行的消息完成后再销毁规则链，
+// 最多等待timeout，超时后强制销毁并返回context.DeadlineExceeded。适用于滚动重启等需要平滑下线的场景
+func (e *RuleEngine) GracefulStop(timeout time.Duration) error {
+	e.pendingReload.take()
+	var err error
+	if e.rootRuleChainCtx != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		err = e.rootRuleChainCtx.GracefulDestroy(ctx)
+	}
+	e.initialized = false
+	return err
+}
+
 // OnMsg 把消息交给规则引擎处理，异步执行
 // 提供可选参数types.RuleContextOption
 func (e *RuleEngine) OnMsg(msg types.RuleMsg, opts ...types.RuleContextOption) {
@@ -814,6 +1515,10 @@ func (e *RuleEngine) doOnAllNodeCompleted(rootCtxCopy *DefaultRuleContext, msg t
 	if rootCtxCopy.runSnapshot != nil {
 		//等待所有日志执行完
 		rootCtxCopy.runSnapshot.onRuleChainCompleted(rootCtxCopy)
+		if rootCtxCopy.config.OnDebugTrace != nil {
+			rootCtxCopy.runSnapshot.onDebugTrace(rootCtxCopy.config.OnDebugTrace)
+		}
+		rootCtxCopy.runSnapshot.onTrace(rootCtxCopy)
 	}
 	//触发自定义回调
 	if customFunc != nil {
@@ -821,8 +1526,10 @@ func (e *RuleEngine) doOnAllNodeCompleted(rootCtxCopy *DefaultRuleContext, msg t
 	}
 
 }
-func (e *RuleEngine) noNodesHandler(msg types.RuleMsg, rootCtxCopy *DefaultRuleContext, wait bool) {
-	err := errors.New("the rule chain has no nodes")
+
+// rejectMsg 规则链不处理该消息，例如：没有节点或者正在关闭，通过结束回调返回err
+func (e *RuleEngine) rejectMsg(msg types.RuleMsg, rootCtxCopy *DefaultRuleContext, err error) {
+	e.rootRuleChainCtx.stats.incEnd(err)
 	if rootCtxCopy.config.OnEnd != nil {
 		rootCtxCopy.config.OnEnd(msg, err)
 	}
@@ -835,17 +1542,71 @@ func (e *RuleEngine) noNodesHandler(msg types.RuleMsg, rootCtxCopy *DefaultRuleC
 }
 func (e *RuleEngine) onMsgAndWait(msg types.RuleMsg, wait bool, opts ...types.RuleContextOption) {
 	if e.rootRuleChainCtx != nil {
-		rootCtx := e.rootRuleChainCtx.rootRuleContext.(*DefaultRuleContext)
-		rootCtxCopy := NewRuleContext(rootCtx.GetContext(), rootCtx.config, rootCtx.ruleChainCtx, rootCtx.from, rootCtx.self, rootCtx.pool, rootCtx.onEnd, e.RuleChainPool)
+		//先获取并发许可，阻塞等待期间不计入规则链执行中的消息，避免延迟重新加载时销毁旧节点实例
+		//子规则链调用不等待，等待期间遵循消息context的截止时间
+		limitCtx := optionsContext(opts)
+		limitErr := e.limiter.acquire(limitCtx, !isNestedContext(limitCtx))
+		//先计数再获取根上下文，重新加载规则链时，等待使用旧节点实例的消息执行完成后再销毁旧节点实例
+		inflight, accepted := e.rootRuleChainCtx.acquireInflight()
+		e.rootRuleChainCtx.stats.incReceived()
+		rootCtx := e.rootRuleChainCtx.getRootRuleContext().(*DefaultRuleContext)
+		//消息上下文始终引用当前规则链，运行时编辑和重新加载替换的路由、切面对新的消息立即生效
+		rootCtxCopy := NewRuleContext(rootCtx.GetContext(), rootCtx.config, e.rootRuleChainCtx, rootCtx.from, rootCtx.self, rootCtx.pool, rootCtx.onEnd, e.subChainPool())
 		rootCtxCopy.isFirst = rootCtx.isFirst
 		rootCtxCopy.runSnapshot = NewRunSnapshot(msg.Id, rootCtxCopy.ruleChainCtx, time.Now().UnixMilli())
 		for _, opt := range opts {
 			opt(rootCtxCopy)
 		}
-		if rootCtx.ruleChainCtx.isEmpty {
-			e.noNodesHandler(msg, rootCtxCopy, wait)
+		if rootCtxCopy.priority == "" {
+			rootCtxCopy.priority = msg.Metadata.GetValue(types.PriorityKey)
+		}
+		rootCtxCopy.expireAt = msgExpireAt(msg)
+		if rootCtxCopy.entryPoint != "" {
+			//从命名入口的节点开始执行，找不到入口则从第一个节点开始执行
+			if entryCtx, ok := e.rootRuleChainCtx.getEntryRuleContext(rootCtxCopy.entryPoint); ok {
+				rootCtxCopy.self = entryCtx.Self()
+			} else if rootCtxCopy.IsDebugMode() {
+				e.Config.Logger.Printf("entry point %s not found in rule chain %s, start from the first node", rootCtxCopy.entryPoint, e.id)
+			}
+		}
+		var resumeErr error
+		if nodeId := rootCtxCopy.resumeNodeId; nodeId != "" {
+			//从检查点记录的节点开始执行
+			if node, ok := e.rootRuleChainCtx.GetNodeById(types.RuleNodeId{Id: nodeId, Type: types.NODE}); ok {
+				rootCtxCopy.self = node
+			} else {
+				resumeErr = fmt.Errorf("resume message %s failed: node %s no longer exists in rule chain %s", msg.Id, nodeId, e.id)
+			}
+		}
+		if !accepted {
+			if limitErr == nil {
+				e.limiter.release()
+			}
+			e.rejectMsg(msg, rootCtxCopy, ErrRuleChainStopped)
+			return
+		}
+		if limitErr != nil {
+			inflight.release()
+			e.rejectMsg(msg, rootCtxCopy, limitErr)
 			return
 		}
+		if resumeErr != nil {
+			inflight.release()
+			e.limiter.release()
+			if store := rootCtxCopy.config.CheckpointStore; store != nil {
+				_ = store.Delete(checkpointId(msg.Id, rootCtxCopy.resumeNodeId))
+			}
+			e.rejectMsg(msg, rootCtxCopy, resumeErr)
+			return
+		}
+		if e.rootRuleChainCtx.isEmptyChain() {
+			inflight.release()
+			e.limiter.release()
+			e.rejectMsg(msg, rootCtxCopy, ErrEmptyRuleChain)
+			return
+		}
+		cancel := e.withExecutionTimeout(rootCtxCopy, msg)
+		release := e.activeMsgs.add(rootCtxCopy, msg.Id)
 		msg = e.onStart(rootCtxCopy, msg)
 
 		//用户自定义结束回调
@@ -864,6 +1625,12 @@ func (e *RuleEngine) onMsgAndWait(msg types.RuleMsg, wait bool, opts ...types.Ru
 			c := make(chan struct{})
 			rootCtxCopy.onAllNodeCompleted = func() {
 				defer close(c)
+				inflight.release()
+				e.limiter.release()
+				release()
+				if cancel != nil {
+					cancel()
+				}
 				e.doOnAllNodeCompleted(rootCtxCopy, msg, customFunc)
 			}
 			//执行规则链
@@ -872,6 +1639,12 @@ func (e *RuleEngine) onMsgAndWait(msg types.RuleMsg, wait bool, opts ...types.Ru
 			<-c
 		} else {
 			rootCtxCopy.onAllNodeCompleted = func() {
+				inflight.release()
+				e.limiter.release()
+				release()
+				if cancel != nil {
+					cancel()
+				}
 				e.doOnAllNodeCompleted(rootCtxCopy, msg, customFunc)
 			}
 			//执行规则链
@@ -884,9 +1657,53 @@ func (e *RuleEngine) onMsgAndWait(msg types.RuleMsg, wait bool, opts ...types.Ru
 	}
 }
 
+// msgExpireAt 消息过期的毫秒时间戳，优先使用元数据 types.ExpireAtKey，其次是 types.TTLKey，没有设置有效期返回0
+func msgExpireAt(msg types.RuleMsg) int64 {
+	if v := msg.Metadata.GetValue(types.ExpireAtKey); v != "" {
+		if expireAt, err := strconv.ParseInt(v, 10, 64); err == nil && expireAt > 0 {
+			return expireAt
+		}
+	}
+	if v := msg.Metadata.GetValue(types.TTLKey); v != "" {
+		if ttl, err := strconv.ParseInt(v, 10, 64); err == nil && ttl > 0 {
+			return msg.Ts + ttl
+		}
+	}
+	return 0
+}
+
+// withExecutionTimeout 设置消息执行截止时间，优先使用 types.WithExecutionTimeout，其次是元数据 types.ExecutionTimeoutKey，最后是规则链配置
+// 没有设置超时时间返回nil，否则返回释放截止时间上下文的函数
+func (e *RuleEngine) withExecutionTimeout(ctx *DefaultRuleContext, msg types.RuleMsg) context.CancelFunc {
+	timeout := ctx.executionTimeout
+	if timeout <= 0 {
+		if v, err := strconv.Atoi(msg.Metadata.GetValue(types.ExecutionTimeoutKey)); err == nil && v > 0 {
+			timeout = time.Duration(v) * time.Millisecond
+		}
+	}
+	if timeout <= 0 && ctx.ruleChainCtx != nil {
+		if def := ctx.ruleChainCtx.Definition(); def != nil {
+			timeout = time.Duration(def.RuleChain.ExecutionTimeoutMs) * time.Millisecond
+		}
+	}
+	if timeout <= 0 {
+		return nil
+	}
+	parent := ctx.GetContext()
+	if parent == nil {
+		parent = context.Background()
+	}
+	c, cancel := context.WithTimeout(parent, timeout)
+	ctx.context = c
+	return cancel
+}
+
 // 执行规则链执行开始切面列表
 func (e *RuleEngine) onStart(ctx types.RuleContext, msg types.RuleMsg) types.RuleMsg {
-	for _, aop := range e.startAspects {
+	e.aspectsLock.RLock()
+	startAspects := e.startAspects
+	e.aspectsLock.RUnlock()
+	for _, aop := range startAspects {
 		if aop.PointCut(ctx, msg, "") {
 			msg = aop.Start(ctx, msg)
 		}
@@ -897,7 +1714,10 @@ func (e *RuleEngine) onStart(ctx types.RuleContext, msg types.RuleMsg) types.Rul
 
 // 执行规则链分支链执行结束切面列表
 func (e *RuleEngine) onEnd(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) types.RuleMsg {
-	for _, aop := range e.endAspects {
+	e.aspectsLock.RLock()
+	endAspects := e.endAspects
+	e.aspectsLock.RUnlock()
+	for _, aop := range endAspects {
 		if aop.PointCut(ctx, msg, relationType) {
 			msg = aop.End(ctx, msg, err, relationType)
 		}
@@ -907,7 +1727,10 @@ func (e *RuleEngine) onEnd(ctx types.RuleContext, msg types.RuleMsg, err error,
 
 // 执行规则链所有分支链执行结束切面列表
 func (e *RuleEngine) onAllNodeCompleted(ctx types.RuleContext, msg types.RuleMsg) types.RuleMsg {
-	for _, aop := range e.completedAspects {
+	e.aspectsLock.RLock()
+	completedAspects := e.completedAspects
+	e.aspectsLock.RUnlock()
+	for _, aop := range completedAspects {
 		if aop.PointCut(ctx, msg, "") {
 			msg = aop.Completed(ctx, msg)
 		}
@@ -924,9 +1747,32 @@ func NewConfig(opts ...types.Option) types.Config {
 	if c.ComponentsRegistry == nil {
 		c.ComponentsRegistry = Registry
 	}
+	if c.SharedClients == nil {
+		c.SharedClients = SharedClients
+	}
 	return c
 }
 
+// WithConcurrencyLimit is an option that sets the concurrency limit of the RuleEngine, see RuleEngine.SetConcurrencyLimit.
+func WithConcurrencyLimit(max int, policy string, maxPending int) types.RuleEngineOption {
+	return func(re types.RuleEngine) error {
+		if e, ok := re.(*RuleEngine); ok {
+			e.SetConcurrencyLimit(max, policy, maxPending)
+		}
+		return nil
+	}
+}
+
+// WithRuleChainPool is an option that sets the pool the rule engine resolves sub-chains from first.
+func WithRuleChainPool(pool types.RuleEnginePool) types.RuleEngineOption {
+	return func(re types.RuleEngine) error {
+		if e, ok := re.(*RuleEngine); ok {
+			e.RuleChainPool = pool
+		}
+		return nil
+	}
+}
+
 // WithConfig is an option that sets the Config of the RuleEngine.
 func WithConfig(config types.Config) types.RuleEngineOption {
 	return func(re types.RuleEngine) error {
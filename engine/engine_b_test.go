@@ -19,6 +19,7 @@ package engine
 import (
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/utils/str"
+	"sync"
 	"testing"
 )
 
@@ -57,6 +58,82 @@ func BenchmarkChainChangeMetadataAndMsg(b *testing.B) {
 	}
 }
 
+// BenchmarkGetNodeByIdParallel 并发获取节点，读取节点不加锁
+func BenchmarkGetNodeByIdParallel(b *testing.B) {
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile), WithConfig(NewConfig()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	nodeId := types.RuleNodeId{Id: "s2", Type: types.NODE}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, ok := ctx.GetNodeById(nodeId); !ok {
+				b.Fatal("node not found")
+			}
+		}
+	})
+}
+
+// BenchmarkGetNextNodes 100个协程并发获取同一个节点的子节点，对比无锁路由表和加读写锁的关系缓存
+func BenchmarkGetNextNodes(b *testing.B) {
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile), WithConfig(NewConfig()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer Del(ruleEngine.Id())
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	cacheKey := RelationCache{inNodeId: types.RuleNodeId{Id: "s1", Type: types.NODE}, relationType: types.True}
+	run := func(b *testing.B, getNextNodes func() bool) {
+		const goroutines = 100
+		var wg sync.WaitGroup
+		b.ResetTimer()
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(n int) {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					if !getNextNodes() {
+						b.Error("next nodes not found")
+						return
+					}
+				}
+			}((b.N + goroutines - 1) / goroutines)
+		}
+		wg.Wait()
+	}
+	b.Run("routingTable", func(b *testing.B) {
+		run(b, func() bool {
+			_, ok := ctx.GetNextNodes(cacheKey.inNodeId, cacheKey.relationType)
+			return ok
+		})
+	})
+	b.Run("relationCache", func(b *testing.B) {
+		run(b, func() bool {
+			_, ok := ctx.resolveNextNodes(cacheKey)
+			return ok
+		})
+	})
+}
+
+// BenchmarkChainOnMsgAndWaitParallel 并发处理消息，每个节点跳转都会获取下一个节点
+func BenchmarkChainOnMsgAndWaitParallel(b *testing.B) {
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile), WithConfig(NewConfig()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			metaData := types.NewMetadata()
+			metaData.PutValue("productType", "test01")
+			msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, metaData, "{\"temperature\":35}")
+			ruleEngine.OnMsgAndWait(msg)
+		}
+	})
+}
+
 func BenchmarkCallRestApiNodeGo(b *testing.B) {
 	//不使用协程池
 	config := NewConfig()
@@ -93,3 +170,38 @@ func callRestApiNode(ruleEngine types.RuleEngine) {
 	msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, metaData, "{\"aa\":\"aaaaaaaaaaaaaa\"}")
 	ruleEngine.OnMsg(msg)
 }
+
+// BenchmarkOnMsgBatch 对比循环调用OnMsg和批量提交1000条消息的吞吐量
+func BenchmarkOnMsgBatch(b *testing.B) {
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile), WithConfig(NewConfig()))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer Del(ruleEngine.Id())
+	const batchSize = 1000
+	newMsgs := func() []types.RuleMsg {
+		msgs := make([]types.RuleMsg, batchSize)
+		for i := range msgs {
+			metaData := types.NewMetadata()
+			metaData.PutValue("productType", "test01")
+			msgs[i] = types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, metaData, "{\"temperature\":35}")
+		}
+		return msgs
+	}
+	b.Run("Loop", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			msgs := newMsgs()
+			var wg sync.WaitGroup
+			wg.Add(batchSize)
+			for _, msg := range msgs {
+				ruleEngine.OnMsg(msg, types.WithOnAllNodeCompleted(wg.Done))
+			}
+			wg.Wait()
+		}
+	})
+	b.Run("Batch", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			ruleEngine.(*RuleEngine).OnMsgBatchAndWait(newMsgs())
+		}
+	})
+}
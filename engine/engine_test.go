@@ -18,6 +18,7 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/components/action"
@@ -25,6 +26,7 @@ import (
 	"github.com/rulego/rulego/test/assert"
 	"github.com/rulego/rulego/utils/str"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
@@ -208,14 +210,14 @@ func TestRuleChainChangeMetadataAndMsg(t *testing.T) {
 // test reload rule chain
 func TestReloadRuleChain(t *testing.T) {
 	config1 := NewConfig()
-	config1DebugDone := false
+	var config1DebugDone int32
 	config1.OnDebug = func(chainId, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
 		//config1.Logger.Printf("before reload : flowType=%s,nodeId=%s,msgType=%s,data=%s,metaData=%s,relationType=%s,err=%s", flowType, nodeId, msg.Type, msg.Data, msg.Metadata, relationType, err)
 		if flowType == types.Out && nodeId == "s2" {
 			productType := msg.Metadata.GetValue("productType")
 			assert.Equal(t, "test01", productType)
 		}
-		config1DebugDone = true
+		atomic.StoreInt32(&config1DebugDone, 1)
 	}
 
 	chainId := str.RandomStr(10)
@@ -232,18 +234,18 @@ func TestReloadRuleChain(t *testing.T) {
 
 	time.Sleep(time.Millisecond * 200)
 
-	assert.True(t, config1DebugDone)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&config1DebugDone))
 
 	//config1.Logger.Printf("reload rule chain......")
 	config2 := NewConfig()
-	config2DebugDone := false
+	var config2DebugDone int32
 	config2.OnDebug = func(chainId, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
 		//config2.Logger.Printf("before after : flowType=%s,nodeId=%s,msgType=%s,data=%s,metaData=%s,relationType=%s,err=%s", flowType, nodeId, msg.Type, msg.Data, msg.Metadata, relationType, err)
 		if flowType == types.Out && nodeId == "s3" {
 			productType := msg.Metadata.GetValue("productType")
 			assert.Equal(t, "product02", productType)
 		}
-		config2DebugDone = true
+		atomic.StoreInt32(&config2DebugDone, 1)
 	}
 	//更新规则链
 	err = ruleEngine.ReloadSelf([]byte(updateRuleChainFile), WithConfig(config2))
@@ -251,7 +253,7 @@ func TestReloadRuleChain(t *testing.T) {
 
 	ruleEngine.OnMsg(msg)
 	time.Sleep(time.Millisecond * 200)
-	assert.True(t, config2DebugDone)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&config2DebugDone))
 }
 
 // 测试子规则链
@@ -1222,3 +1224,1649 @@ func TestIteratorNode(t *testing.T) {
 	}))
 	time.Sleep(time.Millisecond * 100)
 }
+
+// 测试重新加载校验失败回滚
+func TestReloadVerifyRollback(t *testing.T) {
+	config := NewConfig(types.WithOnReloadVerify(func(chainCtx types.ChainCtx) error {
+		if chainCtx.Definition().RuleChain.Name == "bad" {
+			return errors.New("verify failed")
+		}
+		return nil
+	}))
+	ruleFile := loadFile("./filter_node.json")
+	ruleEngine, err := New(str.RandomStr(10), ruleFile, WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	newRuleFile := strings.Replace(string(ruleFile), "测试规则链", "bad", 1)
+	err = ruleEngine.ReloadSelf([]byte(newRuleFile))
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "verify failed"))
+	assert.Equal(t, "测试规则链", ruleEngine.Definition().RuleChain.Name)
+	_, ok := ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "node0"})
+	assert.True(t, ok)
+
+	newRuleFile = strings.Replace(string(ruleFile), "测试规则链", "good", 1)
+	err = ruleEngine.ReloadSelf([]byte(newRuleFile))
+	assert.Nil(t, err)
+	assert.Equal(t, "good", ruleEngine.Definition().RuleChain.Name)
+}
+
+// 测试终止节点
+func TestTerminalNode(t *testing.T) {
+	ruleFile := strings.Replace(ruleChainFile, `"name": "过滤",`, `"name": "过滤","terminal": true,`, 1)
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleFile))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var count int32
+	msg := types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "{\"temperature\":41}")
+	ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		atomic.AddInt32(&count, 1)
+		//终止节点不再执行s2
+		assert.Equal(t, "s1", ctx.GetSelfId())
+		assert.Equal(t, types.Completed, relationType)
+	}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+// 测试debug模式执行轨迹
+func TestOnDebugTrace(t *testing.T) {
+	var traceList []types.DebugTrace
+	var lock sync.Mutex
+	config := NewConfig(types.WithOnDebugTrace(func(trace types.DebugTrace) {
+		lock.Lock()
+		defer lock.Unlock()
+		traceList = append(traceList, trace)
+	}))
+	chainId := str.RandomStr(10)
+	ruleEngine, err := New(chainId, []byte(ruleChainFile), WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(chainId)
+
+	msg := types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "{\"temperature\":41}")
+	ruleEngine.OnMsgAndWait(msg)
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Equal(t, 1, len(traceList))
+	trace := traceList[0]
+	assert.Equal(t, chainId, trace.ChainId)
+	assert.Equal(t, msg.Id, trace.MsgId)
+	var steps []string
+	for _, item := range trace.Items {
+		steps = append(steps, item.NodeId+":"+item.FlowType+":"+item.RelationType)
+	}
+	assert.Equal(t, "s1:IN:,s1:OUT:True,s2:IN:True,s2:OUT:Success", strings.Join(steps, ","))
+}
+
+// 测试规则链根上下文
+func TestBaseContext(t *testing.T) {
+	type baseKey struct{}
+	config := NewConfig(types.WithBaseContext(context.WithValue(context.Background(), baseKey{}, "base")))
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile), WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var count int32
+	msg := types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "{\"temperature\":41}")
+	ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		atomic.AddInt32(&count, 1)
+		assert.Equal(t, "base", ctx.GetContext().Value(baseKey{}))
+	}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+}
+
+// 测试节点启用条件
+func TestNodeEnabledIf(t *testing.T) {
+	ruleFile := strings.Replace(ruleChainFile, `"root": true`, `"root": true,"configuration":{"vars":{"env":"staging"}}`, 1)
+	ruleFile = strings.Replace(ruleFile, `"jsScript": "return msg.temperature>10;"`, `"jsScript": "return msg.temperature>10;","enabledIf": "vars.env == 'prod'"`, 1)
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleFile))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var count int32
+	msg := types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "{\"temperature\":5}")
+	ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		atomic.AddInt32(&count, 1)
+		//s1没有启用，直接流转到s2
+		assert.Equal(t, "s2", ctx.GetSelfId())
+		assert.Equal(t, "TEST_MSG_TYPE", msg.Type)
+	}))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+
+	//启用s1
+	err = ruleEngine.ReloadSelf([]byte(strings.Replace(ruleFile, `"env":"staging"`, `"env":"prod"`, 1)))
+	assert.Nil(t, err)
+	ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		atomic.AddInt32(&count, 1)
+		assert.Equal(t, "s1", ctx.GetSelfId())
+		assert.Equal(t, types.False, relationType)
+	}))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&count))
+
+	_, err = New(str.RandomStr(10), []byte(strings.Replace(ruleFile, `vars.env == 'prod'`, `vars.env ==`, 1)))
+	assert.NotNil(t, err)
+
+	//未启用的节点不触发失败分支
+	def := []byte(`{"ruleChain":{"id":"testNodeEnabledIfFailure"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;","enabledIf":"false"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s3","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"},{"fromId":"s1","toId":"s3","type":"Failure"}]}}`)
+	ruleEngine, err = New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	var endNodes []string
+	ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		endNodes = append(endNodes, ctx.GetSelfId())
+	}))
+	assert.Equal(t, []string{"s2"}, endNodes)
+}
+
+// 测试订阅规则链执行事件
+func TestSubscribe(t *testing.T) {
+	ruleEngine, err := New(str.RandomStr(10), []byte(ruleChainFile))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	chainCtx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+
+	subscription := chainCtx.Subscribe(10, SubscribeBlock)
+	dropSubscription := chainCtx.Subscribe(1, SubscribeDrop)
+	msg := types.NewMsg(0, "TEST_MSG_TYPE1", types.JSON, types.NewMetadata(), "{\"temperature\":41}")
+	ruleEngine.OnMsgAndWait(msg)
+
+	var steps []string
+	for i := 0; i < 4; i++ {
+		event := <-subscription.Events()
+		assert.Equal(t, msg.Id, event.MsgId)
+		steps = append(steps, event.NodeId+":"+event.FlowType+":"+event.RelationType)
+	}
+	assert.Equal(t, "s1:IN:,s1:OUT:True,s2:IN:True,s2:OUT:Success", strings.Join(steps, ","))
+	assert.Equal(t, uint64(0), subscription.Dropped())
+	//缓冲区满，丢弃事件
+	assert.Equal(t, 1, len(dropSubscription.Events()))
+	assert.Equal(t, uint64(3), dropSubscription.Dropped())
+
+	//取消订阅后关闭通道，不再发送事件
+	subscription.Unsubscribe()
+	subscription.Unsubscribe()
+	_, ok := <-subscription.Events()
+	assert.False(t, ok)
+	ruleEngine.OnMsgAndWait(msg)
+	assert.Equal(t, uint64(7), dropSubscription.Dropped())
+
+	//订阅在规则链重新加载后保留
+	reloadSubscription := chainCtx.Subscribe(10, SubscribeBlock)
+	assert.Nil(t, ruleEngine.ReloadSelf([]byte(ruleChainFile)))
+	ruleEngine.OnMsgAndWait(msg)
+	steps = nil
+	for i := 0; i < 4; i++ {
+		event := <-reloadSubscription.Events()
+		steps = append(steps, event.NodeId+":"+event.FlowType+":"+event.RelationType)
+	}
+	assert.Equal(t, "s1:IN:,s1:OUT:True,s2:IN:True,s2:OUT:Success", strings.Join(steps, ","))
+	assert.Equal(t, uint64(11), dropSubscription.Dropped())
+
+	//销毁规则链后取消所有订阅
+	chainCtx.Destroy()
+	<-dropSubscription.Events()
+	_, ok = <-dropSubscription.Events()
+	assert.False(t, ok)
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	newMsg := func() types.RuleMsg {
+		return types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+	}
+	waitFor := func(f func() bool) {
+		for i := 0; i < 200 && !f(); i++ {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	t.Run("Drop", func(t *testing.T) {
+		def := []byte(`{"ruleChain":{"id":"testConcurrencyDrop","maxConcurrency":1,"overflowPolicy":"drop"},
+			"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+		re, err := New(str.RandomStr(10), def)
+		assert.Nil(t, err)
+		ruleEngine := re.(*RuleEngine)
+		defer Del(ruleEngine.Id())
+		ruleEngine.OnMsg(newMsg())
+		waitFor(func() bool { return ruleEngine.ConcurrencyStats().InFlight == 1 })
+
+		var endErr error
+		ruleEngine.OnMsgAndWait(newMsg(), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			endErr = err
+		}))
+		assert.Equal(t, ErrConcurrencyLimitExceeded, endErr)
+		assert.Equal(t, int64(1), ruleEngine.ConcurrencyStats().Rejected)
+		waitFor(func() bool { return ruleEngine.ConcurrencyStats().InFlight == 0 })
+		assert.Equal(t, int64(0), ruleEngine.ConcurrencyStats().InFlight)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		def := []byte(`{"ruleChain":{"id":"testConcurrencyBlock"},"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+		re, err := New(str.RandomStr(10), def, WithConcurrencyLimit(2, types.OverflowBlock, 0))
+		assert.Nil(t, err)
+		ruleEngine := re.(*RuleEngine)
+		defer Del(ruleEngine.Id())
+
+		var maxInFlight int64
+		stop := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if v := ruleEngine.ConcurrencyStats().InFlight; v > atomic.LoadInt64(&maxInFlight) {
+						atomic.StoreInt64(&maxInFlight, v)
+					}
+				}
+			}
+		}()
+		var completed int32
+		var wg sync.WaitGroup
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ruleEngine.OnMsgAndWait(newMsg(), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+					if err == nil {
+						atomic.AddInt32(&completed, 1)
+					}
+				}))
+			}()
+		}
+		wg.Wait()
+		close(stop)
+		assert.Equal(t, int32(6), atomic.LoadInt32(&completed))
+		assert.True(t, atomic.LoadInt64(&maxInFlight) <= 2)
+		assert.Equal(t, int64(0), ruleEngine.ConcurrencyStats().Rejected)
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		def := []byte(`{"ruleChain":{"id":"testConcurrencyQueue"},"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+		re, err := New(str.RandomStr(10), def)
+		assert.Nil(t, err)
+		ruleEngine := re.(*RuleEngine)
+		defer Del(ruleEngine.Id())
+		ruleEngine.SetConcurrencyLimit(1, types.OverflowQueue, 1)
+
+		ruleEngine.OnMsg(newMsg())
+		waitFor(func() bool { return ruleEngine.ConcurrencyStats().InFlight == 1 })
+		queued := make(chan error, 1)
+		go ruleEngine.OnMsgAndWait(newMsg(), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			queued <- err
+		}))
+		waitFor(func() bool { return ruleEngine.ConcurrencyStats().Pending == 1 })
+
+		var endErr error
+		ruleEngine.OnMsgAndWait(newMsg(), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			endErr = err
+		}))
+		assert.Equal(t, ErrConcurrencyLimitExceeded, endErr)
+		//排队的消息获取许可后正常执行
+		assert.Nil(t, <-queued)
+		assert.Equal(t, int64(1), ruleEngine.ConcurrencyStats().Rejected)
+		//DSL没有配置并发限制，重新加载不覆盖代码设置
+		assert.Nil(t, ruleEngine.Reload())
+		assert.Equal(t, int64(1), ruleEngine.limiter.max)
+	})
+
+	t.Run("SubChain", func(t *testing.T) {
+		subId := str.RandomStr(10)
+		subDef := []byte(`{"ruleChain":{"id":"` + subId + `","maxConcurrency":1,"overflowPolicy":"drop"},
+			"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+		subEngine, err := New(subId, subDef)
+		assert.Nil(t, err)
+		defer Del(subId)
+		def := []byte(`{"ruleChain":{"id":"testConcurrencyParent"},"metadata":{"nodes":[{"id":"s1","type":"flow","configuration":{"targetId":"` + subId + `"}}]}}`)
+		re, err := New(str.RandomStr(10), def)
+		assert.Nil(t, err)
+		ruleEngine := re.(*RuleEngine)
+		defer Del(ruleEngine.Id())
+
+		var failures int32
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ruleEngine.OnMsgAndWait(newMsg(), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+					if err != nil {
+						atomic.AddInt32(&failures, 1)
+					}
+				}))
+			}()
+		}
+		wg.Wait()
+		//子规则链调用计入子规则链自身的并发限制
+		assert.Equal(t, int32(1), atomic.LoadInt32(&failures))
+		assert.Equal(t, int64(1), subEngine.(*RuleEngine).ConcurrencyStats().Rejected)
+		assert.Equal(t, int64(0), ruleEngine.ConcurrencyStats().Rejected)
+	})
+
+	t.Run("SubChainBlock", func(t *testing.T) {
+		subId := str.RandomStr(10)
+		subDef := []byte(`{"ruleChain":{"id":"` + subId + `","maxConcurrency":1,"overflowPolicy":"block"},
+			"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+		subEngine, err := New(subId, subDef)
+		assert.Nil(t, err)
+		defer Del(subId)
+		def := []byte(`{"ruleChain":{"id":"testConcurrencyParentBlock"},"metadata":{"nodes":[{"id":"s1","type":"flow","configuration":{"targetId":"` + subId + `"}}]}}`)
+		re, err := New(str.RandomStr(10), def)
+		assert.Nil(t, err)
+		ruleEngine := re.(*RuleEngine)
+		defer Del(ruleEngine.Id())
+
+		var failures int32
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				ruleEngine.OnMsgAndWait(newMsg(), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+					if err != nil {
+						atomic.AddInt32(&failures, 1)
+					}
+				}))
+			}()
+		}
+		wg.Wait()
+		//子规则链调用在协程池任务中执行，达到上限不阻塞，直接拒绝
+		assert.Equal(t, int32(1), atomic.LoadInt32(&failures))
+		assert.Equal(t, int64(1), subEngine.(*RuleEngine).ConcurrencyStats().Rejected)
+		assert.Equal(t, int64(0), subEngine.(*RuleEngine).ConcurrencyStats().Pending)
+	})
+
+	t.Run("BlockDeadline", func(t *testing.T) {
+		def := []byte(`{"ruleChain":{"id":"testConcurrencyBlockDeadline"},"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+		re, err := New(str.RandomStr(10), def, WithConcurrencyLimit(1, types.OverflowBlock, 0))
+		assert.Nil(t, err)
+		ruleEngine := re.(*RuleEngine)
+		defer Del(ruleEngine.Id())
+		ruleEngine.OnMsg(newMsg())
+		waitFor(func() bool { return ruleEngine.ConcurrencyStats().InFlight == 1 })
+
+		//等待许可期间超过消息context的截止时间
+		c, cancel := context.WithTimeout(context.Background(), time.Millisecond*5)
+		defer cancel()
+		var endErr error
+		ruleEngine.OnMsgAndWait(newMsg(), types.WithContext(c), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			endErr = err
+		}))
+		assert.True(t, errors.Is(endErr, context.DeadlineExceeded))
+		assert.Equal(t, int64(0), ruleEngine.ConcurrencyStats().Pending)
+		assert.Equal(t, int64(1), ruleEngine.ConcurrencyStats().Rejected)
+	})
+}
+
+func TestExecutionTimeout(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	run := func(ruleEngine types.RuleEngine, metadata types.Metadata, opts ...types.RuleContextOption) error {
+		var endErr error
+		var count int32
+		opts = append(opts, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			atomic.AddInt32(&count, 1)
+			endErr = err
+		}))
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, metadata, "{}"), opts...)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&count))
+		return endErr
+	}
+	slowChain := func(timeoutMs int) []byte {
+		return []byte(`{"ruleChain":{"id":"testExecutionTimeout","executionTimeoutMs":` + strconv.Itoa(timeoutMs) + `},
+			"metadata":{"nodes":[{"id":"s1","type":"test/slow"},{"id":"s2","type":"test/slow"},{"id":"s3","type":"test/slow"}],
+			"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s2","toId":"s3","type":"Success"}]}}`)
+	}
+
+	t.Run("Chain", func(t *testing.T) {
+		ruleEngine, err := New(str.RandomStr(10), slowChain(30))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		endErr := run(ruleEngine, types.NewMetadata())
+		var timeoutErr *ExecutionTimeoutError
+		assert.True(t, errors.As(endErr, &timeoutErr))
+		assert.Equal(t, "s3", timeoutErr.NodeId)
+		assert.True(t, errors.Is(endErr, context.DeadlineExceeded))
+	})
+
+	t.Run("Override", func(t *testing.T) {
+		ruleEngine, err := New(str.RandomStr(10), slowChain(0))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		assert.Nil(t, run(ruleEngine, types.NewMetadata()))
+
+		metadata := types.NewMetadata()
+		metadata.PutValue(types.ExecutionTimeoutKey, "30")
+		var timeoutErr *ExecutionTimeoutError
+		assert.True(t, errors.As(run(ruleEngine, metadata), &timeoutErr))
+		assert.Equal(t, "s3", timeoutErr.NodeId)
+
+		//选项优先于元数据
+		assert.Nil(t, run(ruleEngine, metadata, types.WithExecutionTimeout(time.Second)))
+		assert.True(t, errors.As(run(ruleEngine, types.NewMetadata(), types.WithExecutionTimeout(time.Millisecond*30)), &timeoutErr))
+	})
+
+	t.Run("InterruptScript", func(t *testing.T) {
+		def := []byte(`{"ruleChain":{"id":"testExecutionTimeoutScript","executionTimeoutMs":50},
+			"metadata":{"nodes":[{"id":"s1","type":"jsTransform","configuration":{"jsScript":"while(true){}"}}]}}`)
+		config := NewConfig(types.WithScriptMaxExecutionTime(time.Second * 10))
+		ruleEngine, err := New(str.RandomStr(10), def, WithConfig(config))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		start := time.Now()
+		endErr := run(ruleEngine, types.NewMetadata())
+		assert.NotNil(t, endErr)
+		assert.True(t, strings.Contains(endErr.Error(), context.DeadlineExceeded.Error()))
+		assert.True(t, time.Since(start) < time.Second*5)
+	})
+}
+
+func TestEntryPoints(t *testing.T) {
+	nodeDef := func(id string) string {
+		return `{"id":"` + id + `","type":"jsTransform","configuration":{"jsScript":"metadata['entry']='` + id + `';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}`
+	}
+	def := []byte(`{"ruleChain":{"id":"testEntryPoints"},"metadata":{"entryPoints":{"telemetry":"s1","attributes":"s2"},
+		"nodes":[` + nodeDef("s1") + `,` + nodeDef("s2") + `]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	entry := func(opts ...types.RuleContextOption) string {
+		var result string
+		opts = append(opts, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = msg.Metadata.GetValue("entry")
+		}))
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), opts...)
+		return result
+	}
+	assert.Equal(t, "s1", entry())
+	assert.Equal(t, "s2", entry(types.WithEntryPoint("attributes")))
+	assert.Equal(t, "s1", entry(types.WithEntryPoint("telemetry")))
+	//找不到入口从第一个节点开始执行
+	assert.Equal(t, "s1", entry(types.WithEntryPoint("notFound")))
+
+	//重新加载后入口保留
+	assert.Nil(t, ruleEngine.Reload())
+	assert.Equal(t, "s2", entry(types.WithEntryPoint("attributes")))
+	assert.Equal(t, map[string]string{"telemetry": "s1", "attributes": "s2"}, ruleEngine.RootRuleChainCtx().(*RuleChainCtx).EntryPoints())
+
+	_, err = New(str.RandomStr(10), []byte(`{"ruleChain":{"id":"testEntryPoints"},"metadata":{"entryPoints":{"telemetry":"s3"},"nodes":[`+nodeDef("s1")+`]}}`))
+	assert.Equal(t, "entry point telemetry: node s3 is not a declared node", err.Error())
+}
+
+func TestCheckpoint(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	store := NewMemoryCheckpointStore()
+	chainId := str.RandomStr(10)
+	def := []byte(`{"ruleChain":{"id":"testCheckpoint"},"metadata":{"nodes":[
+		{"id":"s1","type":"jsTransform","configuration":{"jsScript":"metadata['s1']='true';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},
+		{"id":"s2","type":"test/slow"},
+		{"id":"s3","type":"jsTransform","configuration":{"jsScript":"metadata['s3']='true';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s2","toId":"s3","type":"Success"}]}}`)
+	ruleEngine, err := New(chainId, def, WithConfig(NewConfig(types.WithCheckpointStore(store))))
+	assert.Nil(t, err)
+	defer Del(chainId)
+
+	//执行过程中记录即将执行的节点，执行结束后删除
+	done := make(chan struct{})
+	ruleEngine.OnMsg(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnAllNodeCompleted(func() {
+		close(done)
+	}))
+	time.Sleep(time.Millisecond * 10)
+	checkpoints, _ := store.List()
+	assert.Equal(t, 1, len(checkpoints))
+	assert.Equal(t, "s2", checkpoints[0].NodeId)
+	assert.Equal(t, types.Success, checkpoints[0].RelationType)
+	assert.Equal(t, "true", checkpoints[0].Msg.Metadata.GetValue("s1"))
+	<-done
+	checkpoints, _ = store.List()
+	assert.Equal(t, 0, len(checkpoints))
+
+	//从记录的节点恢复执行，不执行之前的节点
+	msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+	_ = store.Save(types.Checkpoint{Id: checkpointId(msg.Id, "s2"), ChainId: chainId, NodeId: "s2", Msg: msg})
+	missingMsg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+	_ = store.Save(types.Checkpoint{Id: checkpointId(missingMsg.Id, "s9"), ChainId: chainId, NodeId: "s9", Msg: missingMsg})
+	_ = store.Save(types.Checkpoint{Id: "other", ChainId: "otherChain", NodeId: "s1", Msg: msg})
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	results := make(map[string]string)
+	var lock sync.Mutex
+	count, err := ruleEngine.(*RuleEngine).ResumePending(types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil {
+			results[msg.Id] = err.Error()
+		} else {
+			results[msg.Id] = msg.Metadata.GetValue("s1") + "," + msg.Metadata.GetValue("s3")
+		}
+	}), types.WithOnAllNodeCompleted(func() {
+		wg.Done()
+	}))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	wg.Wait()
+	assert.Equal(t, ",true", results[msg.Id])
+	assert.Equal(t, "resume message "+missingMsg.Id+" failed: node s9 no longer exists in rule chain "+chainId, results[missingMsg.Id])
+	checkpoints, _ = store.List()
+	assert.Equal(t, 1, len(checkpoints))
+	assert.Equal(t, "otherChain", checkpoints[0].ChainId)
+
+	_, err = ruleEngine.(*RuleEngine).ResumePending()
+	assert.Nil(t, err)
+	ruleEngine2, _ := New(str.RandomStr(10), def)
+	defer Del(ruleEngine2.Id())
+	_, err = ruleEngine2.(*RuleEngine).ResumePending()
+	assert.Equal(t, ErrCheckpointStoreNotConfigured, err)
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	store, err := NewFileCheckpointStore(filepath.Join(t.TempDir(), "checkpoints"))
+	assert.Nil(t, err)
+	metadata := types.NewMetadata()
+	metadata.PutValue("k", "v")
+	msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, metadata, `{"temperature":41}`)
+	assert.Nil(t, store.Save(types.Checkpoint{Id: checkpointId(msg.Id, "s2"), ChainId: "c1", NodeId: "s2", Msg: msg, Ts: 2}))
+	assert.Nil(t, store.Save(types.Checkpoint{Id: "a/b", ChainId: "c1", NodeId: "s1", Msg: msg, Ts: 1}))
+	//相同ID覆盖
+	assert.Nil(t, store.Save(types.Checkpoint{Id: "a/b", ChainId: "c1", NodeId: "s3", Msg: msg, Ts: 1}))
+
+	checkpoints, err := store.List()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(checkpoints))
+	assert.Equal(t, "s3", checkpoints[0].NodeId)
+	assert.Equal(t, "s2", checkpoints[1].NodeId)
+	assert.Equal(t, msg.Id, checkpoints[1].Msg.Id)
+	assert.Equal(t, msg.Data, checkpoints[1].Msg.Data)
+	assert.Equal(t, "v", checkpoints[1].Msg.Metadata.GetValue("k"))
+
+	assert.Nil(t, store.Delete("a/b"))
+	assert.Nil(t, store.Delete("notFound"))
+	checkpoints, _ = store.List()
+	assert.Equal(t, 1, len(checkpoints))
+}
+
+func TestReloadDebounce(t *testing.T) {
+	chainDef := func(version string) []byte {
+		return []byte(`{"ruleChain":{"id":"testReloadDebounce"},"metadata":{"nodes":[` +
+			`{"id":"s1","type":"jsTransform","configuration":{"jsScript":"metadata['version']='` + version + `';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}]}}`)
+	}
+	var reloads int32
+	callback := &CallbackTest{OnReload: func(parentCtx types.NodeCtx, ctx types.NodeCtx, err error) {
+		atomic.AddInt32(&reloads, 1)
+	}}
+	config := NewConfig(types.WithReloadDebounce(time.Millisecond * 100))
+	re, err := New(str.RandomStr(10), chainDef("v1"), WithConfig(config), types.WithAspects(&EngineAspect{Callback: callback}))
+	assert.Nil(t, err)
+	defer Del(re.Id())
+	ruleEngine := re.(*RuleEngine)
+	version := func() string {
+		var result string
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = msg.Metadata.GetValue("version")
+		}))
+		return result
+	}
+	//连续的重新加载只应用最后一个定义
+	for _, v := range []string{"v2", "v3", "v4"} {
+		assert.Nil(t, ruleEngine.ReloadSelf(chainDef(v)))
+		time.Sleep(time.Millisecond * 20)
+	}
+	assert.Equal(t, "v1", version())
+	assert.Equal(t, int32(0), atomic.LoadInt32(&reloads))
+	for i := 0; i < 100 && atomic.LoadInt32(&reloads) == 0; i++ {
+		time.Sleep(time.Millisecond * 10)
+	}
+	time.Sleep(time.Millisecond * 150)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&reloads))
+	assert.Equal(t, "v4", version())
+
+	//立即重新加载，丢弃等待中的定义
+	assert.Nil(t, ruleEngine.ReloadSelf(chainDef("v5")))
+	assert.Nil(t, ruleEngine.ReloadSelfNow(chainDef("v6")))
+	assert.Equal(t, "v6", version())
+	time.Sleep(time.Millisecond * 200)
+	assert.Equal(t, "v6", version())
+	assert.Equal(t, int32(2), atomic.LoadInt32(&reloads))
+
+	//立即应用等待中的定义
+	assert.Nil(t, ruleEngine.ReloadSelf(chainDef("v7")))
+	assert.Nil(t, ruleEngine.FlushReload())
+	assert.Equal(t, "v7", version())
+	assert.Nil(t, ruleEngine.FlushReload())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&reloads))
+}
+
+func TestRecursiveChainInvocation(t *testing.T) {
+	flowChain := func(id, targetId string) []byte {
+		return []byte(`{"ruleChain":{"id":"` + id + `"},"metadata":{"nodes":[{"id":"s1","type":"flow","configuration":{"targetId":"` + targetId + `"}}]}}`)
+	}
+	onMsgErr := func(ruleEngine types.RuleEngine) error {
+		var result error
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = err
+		}))
+		return result
+	}
+	t.Run("self", func(t *testing.T) {
+		ruleEngine, err := New("testRecursiveSelf", flowChain("testRecursiveSelf", "testRecursiveSelf"))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		err = onMsgErr(ruleEngine)
+		assert.True(t, errors.Is(err, ErrRecursiveChainInvocation))
+		assert.Equal(t, "recursive chain invocation: testRecursiveSelf->testRecursiveSelf", err.Error())
+	})
+	t.Run("cycle", func(t *testing.T) {
+		chainA, err := New("testRecursiveA", flowChain("testRecursiveA", "testRecursiveB"))
+		assert.Nil(t, err)
+		defer Del(chainA.Id())
+		chainB, err := New("testRecursiveB", flowChain("testRecursiveB", "testRecursiveA"))
+		assert.Nil(t, err)
+		defer Del(chainB.Id())
+		err = onMsgErr(chainA)
+		assert.True(t, errors.Is(err, ErrRecursiveChainInvocation))
+		assert.Equal(t, "recursive chain invocation: testRecursiveA->testRecursiveB->testRecursiveA", err.Error())
+	})
+	t.Run("maxDepth", func(t *testing.T) {
+		ruleEngine, err := New("testRecursiveDepth", flowChain("testRecursiveDepth", "testRecursiveDepth"), WithConfig(NewConfig(types.WithMaxChainDepth(3))))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		err = onMsgErr(ruleEngine)
+		assert.True(t, errors.Is(err, ErrRecursiveChainInvocation))
+		assert.Equal(t, "recursive chain invocation: chain depth exceeds 3: testRecursiveDepth->testRecursiveDepth->testRecursiveDepth->testRecursiveDepth", err.Error())
+	})
+}
+
+// healthNode 实现健康检查的测试组件，配置down=true时检查失败
+type healthNode struct {
+	down bool
+}
+
+func (n *healthNode) Type() string {
+	return "test/health"
+}
+
+func (n *healthNode) New() types.Node {
+	return &healthNode{}
+}
+
+func (n *healthNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	n.down = configuration["down"] == true
+	return nil
+}
+
+func (n *healthNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	ctx.TellSuccess(msg)
+}
+
+func (n *healthNode) Destroy() {
+}
+
+func (n *healthNode) Check(ctx context.Context) error {
+	if n.down {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestHealthCheck(t *testing.T) {
+	_ = Registry.Register(&healthNode{})
+	defer Registry.Unregister("test/health")
+	def := []byte(`{"ruleChain":{"id":"testHealthCheck"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"test/health","configuration":{}},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`)
+	pool := NewPool()
+	defer pool.Stop()
+	ruleEngine, err := pool.New("healthy", def)
+	assert.Nil(t, err)
+
+	health, err := ruleEngine.(*RuleEngine).HealthCheck(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, "healthy", health.ChainId)
+	assert.True(t, health.Healthy())
+	assert.Equal(t, HealthStatusUp, health.Nodes["s1"].Status)
+	assert.Equal(t, "test/health", health.Nodes["s1"].NodeType)
+	//没有实现健康检查接口的节点状态未知，不影响规则链
+	assert.Equal(t, HealthStatusUnknown, health.Nodes["s2"].Status)
+
+	_, err = pool.New("unhealthy", []byte(strings.Replace(string(def), `"configuration":{}`, `"configuration":{"down":true}`, 1)))
+	assert.Nil(t, err)
+	//初始化失败的降级节点不可用
+	_, err = pool.New("degraded", []byte(strings.Replace(string(def), `"type":"test/health"`, `"type":"test/notFound"`, 1)),
+		WithConfig(NewConfig(types.WithAllowNodeInitFailure(true))))
+	assert.Nil(t, err)
+
+	poolHealth := pool.HealthCheck(context.Background())
+	assert.Equal(t, 3, len(poolHealth))
+	assert.False(t, poolHealth.Healthy())
+	assert.True(t, poolHealth["healthy"].Healthy())
+	assert.False(t, poolHealth["unhealthy"].Healthy())
+	assert.Equal(t, HealthStatusDown, poolHealth["unhealthy"].Nodes["s1"].Status)
+	assert.Equal(t, "connection refused", poolHealth["unhealthy"].Nodes["s1"].Error)
+	assert.False(t, poolHealth["degraded"].Healthy())
+	assert.NotNil(t, poolHealth["degraded"].Nodes["s1"].Err)
+
+	pool.Del("unhealthy")
+	pool.Del("degraded")
+	assert.True(t, pool.HealthCheck(context.Background()).Healthy())
+}
+
+// testSharedClient 共享客户端测试实现
+type testSharedClient struct {
+	server string
+	closed int32
+}
+
+func (c *testSharedClient) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return nil
+}
+
+// sharedClientNode 使用共享客户端的测试组件，配置server相同的节点共享客户端
+type sharedClientNode struct {
+	server        string
+	client        *testSharedClient
+	sharedClients types.SharedClientRegistry
+}
+
+func (n *sharedClientNode) Type() string {
+	return "test/sharedClient"
+}
+
+func (n *sharedClientNode) New() types.Node {
+	return &sharedClientNode{}
+}
+
+func (n *sharedClientNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	n.server = str.ToString(configuration["server"])
+	n.sharedClients = ruleConfig.SharedClients
+	client, err := n.sharedClients.GetSharedClient(n.Type(), n.server, func() (interface{}, error) {
+		return &testSharedClient{server: n.server}, nil
+	})
+	if err != nil {
+		return err
+	}
+	n.client = client.(*testSharedClient)
+	return nil
+}
+
+func (n *sharedClientNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	ctx.TellSuccess(msg)
+}
+
+func (n *sharedClientNode) Destroy() {
+	n.sharedClients.ReleaseSharedClient(n.Type(), n.server)
+}
+
+func TestSharedClientRegistry(t *testing.T) {
+	registry := NewSharedClientRegistry()
+	var created int32
+	factory := func() (interface{}, error) {
+		atomic.AddInt32(&created, 1)
+		time.Sleep(time.Millisecond * 10)
+		return &testSharedClient{}, nil
+	}
+	//并发获取只创建一次
+	var wg sync.WaitGroup
+	clients := make([]interface{}, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i], _ = registry.GetSharedClient("kind", "key", factory)
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+	assert.Equal(t, 10, registry.Refs("kind", "key"))
+	for _, item := range clients {
+		assert.True(t, item == clients[0])
+	}
+	//不同类型相同key互不影响
+	_, _ = registry.GetSharedClient("other", "key", factory)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&created))
+	registry.ReleaseSharedClient("other", "key")
+
+	//最后一个引用释放后关闭
+	for i := 0; i < 9; i++ {
+		registry.ReleaseSharedClient("kind", "key")
+	}
+	assert.Equal(t, int32(0), atomic.LoadInt32(&clients[0].(*testSharedClient).closed))
+	registry.ReleaseSharedClient("kind", "key")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&clients[0].(*testSharedClient).closed))
+	assert.Equal(t, 0, registry.Refs("kind", "key"))
+
+	//创建失败不缓存
+	_, err := registry.GetSharedClient("kind", "key", func() (interface{}, error) {
+		return nil, errors.New("connect error")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, registry.Refs("kind", "key"))
+
+	//创建期间释放最后一个引用，创建完成后关闭
+	got := make(chan interface{})
+	go func() {
+		client, _ := registry.GetSharedClient("kind", "creating", factory)
+		got <- client
+	}()
+	for registry.Refs("kind", "creating") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	registry.ReleaseSharedClient("kind", "creating")
+	client := (<-got).(*testSharedClient)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&client.closed))
+	assert.Equal(t, 0, registry.Refs("kind", "creating"))
+}
+
+func TestSharedClientReload(t *testing.T) {
+	_ = Registry.Register(&sharedClientNode{})
+	defer Registry.Unregister("test/sharedClient")
+	registry := NewSharedClientRegistry()
+	chainDef := func(s2Server string) []byte {
+		return []byte(`{"ruleChain":{"id":"testSharedClient"},"metadata":{"nodes":[` +
+			`{"id":"s1","type":"test/sharedClient","configuration":{"server":"broker1"}},` +
+			`{"id":"s2","type":"test/sharedClient","configuration":{"server":"` + s2Server + `"}}]}}`)
+	}
+	ruleEngine, err := New(str.RandomStr(10), chainDef("broker1"), WithConfig(NewConfig(types.WithSharedClients(registry))))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	ctx := ruleEngine.RootRuleChainCtx().(*RuleChainCtx)
+	client := func(id string) *testSharedClient {
+		nodeCtx, _ := ctx.GetNodeById(types.RuleNodeId{Id: id, Type: types.NODE})
+		return nodeCtx.(*RuleNodeCtx).Node.(*sharedClientNode).client
+	}
+	//配置相同的节点共享客户端
+	broker1 := client("s1")
+	assert.True(t, broker1 == client("s2"))
+	assert.Equal(t, 2, registry.Refs("test/sharedClient", "broker1"))
+
+	//节点配置变化：释放旧的引用，获取新的引用
+	assert.Nil(t, ruleEngine.ReloadChild("s2", []byte(`{"id":"s2","type":"test/sharedClient","configuration":{"server":"broker2"}}`)))
+	assert.Equal(t, 1, registry.Refs("test/sharedClient", "broker1"))
+	assert.Equal(t, 1, registry.Refs("test/sharedClient", "broker2"))
+	assert.Equal(t, "broker2", client("s2").server)
+
+	//重新加载规则链，配置没有变化的客户端继续使用原来的连接
+	assert.Nil(t, ruleEngine.ReloadSelf(chainDef("broker1")))
+	assert.True(t, broker1 == client("s1"))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&broker1.closed))
+	assert.Equal(t, 2, registry.Refs("test/sharedClient", "broker1"))
+	assert.Equal(t, 0, registry.Refs("test/sharedClient", "broker2"))
+
+	ruleEngine.Stop()
+	assert.Equal(t, 0, registry.Refs("test/sharedClient", "broker1"))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&broker1.closed))
+}
+
+// TestOnDebugDetail 调试详细信息包含消息ID、节点执行耗时和解析到的下一个节点
+func TestOnDebugDetail(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	def := []byte(`{"ruleChain":{"id":"testOnDebugDetail","debugMode":true},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"test/slow"},` +
+		`{"id":"s2","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s3","type":"jsFilter","configuration":{"jsScript":"return true;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s1","toId":"s3","type":"Success"}]}}`)
+	details := make(chan types.DebugDetail, 10)
+	var oldCallbackCount int32
+	config := NewConfig(types.WithOnDebugDetail(func(detail types.DebugDetail) {
+		details <- detail
+	}), types.WithOnDebug(func(ruleChainId string, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
+		atomic.AddInt32(&oldCallbackCount, 1)
+	}))
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(config))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+	ruleEngine.OnMsgAndWait(msg)
+
+	received := make(map[string]types.DebugDetail)
+	for i := 0; i < 6; i++ {
+		select {
+		case detail := <-details:
+			assert.Equal(t, msg.Id, detail.MsgId)
+			received[detail.FlowType+":"+detail.NodeId] = detail
+		case <-time.After(time.Second * 3):
+			t.Fatal("debug detail callback not called")
+		}
+	}
+	in := received[types.In+":s1"]
+	assert.Equal(t, time.Duration(0), in.Duration)
+	assert.Equal(t, 0, len(in.NextNodeIds))
+
+	out := received[types.Out+":s1"]
+	assert.Equal(t, types.Success, out.RelationType)
+	assert.True(t, out.Duration >= time.Millisecond*20)
+	assert.Equal(t, []string{"s2", "s3"}, out.NextNodeIds)
+	assert.Equal(t, 0, len(received[types.Out+":s2"].NextNodeIds))
+	assert.Equal(t, types.True, received[types.Out+":s2"].RelationType)
+
+	//原有的回调仍然生效
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&oldCallbackCount))
+}
+
+// TestWithTrace 按树结构收集消息执行轨迹，消息快照超过最大长度被截断
+func TestWithTrace(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testWithTrace"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"metadata['s2']='ok'; return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},` +
+		`{"id":"s3","type":"jsFilter","configuration":{"jsScript":"return false;"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"},{"fromId":"s1","toId":"s3","type":"True"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig(func(c *types.Config) error {
+		c.TraceMaxDataSize = 16
+		return nil
+	})))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var trace types.ExecutionTrace
+	msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), `{"temperature":41,"humidity":90}`)
+	ruleEngine.OnMsgAndWait(msg, types.WithTrace(func(ctx types.RuleContext, executionTrace types.ExecutionTrace) {
+		trace = executionTrace
+	}))
+	assert.Equal(t, ruleEngine.Id(), trace.ChainId)
+	assert.Equal(t, msg.Id, trace.MsgId)
+	assert.True(t, trace.EndTs >= trace.StartTs)
+
+	root := trace.Root
+	assert.NotNil(t, root)
+	assert.Equal(t, "s1", root.NodeId)
+	assert.True(t, root.InMsg.Truncated)
+	assert.Equal(t, `{"temperature":4`+types.TraceTruncatedMarker, root.InMsg.Data)
+	assert.Equal(t, 1, len(root.Outputs))
+	assert.Equal(t, types.True, root.Outputs[0].RelationType)
+	assert.Equal(t, 2, len(root.Children))
+
+	children := make(map[string]*types.TraceNode)
+	for _, child := range root.Children {
+		assert.Equal(t, types.True, child.RelationType)
+		children[child.NodeId] = child
+	}
+	assert.Equal(t, types.Success, children["s2"].Outputs[0].RelationType)
+	assert.Equal(t, "ok", children["s2"].Outputs[0].Msg.Metadata["s2"])
+	assert.Equal(t, "", children["s2"].InMsg.Metadata["s2"])
+	assert.Equal(t, types.False, children["s3"].Outputs[0].RelationType)
+	assert.Equal(t, 0, len(children["s3"].Children))
+}
+
+// TestReplayMsg 使用当前规则链重放记录的消息
+func TestReplayMsg(t *testing.T) {
+	def := `{"ruleChain":{"id":"testReplayMsg"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return msg.temperature > %d;"}},` +
+		`{"id":"s2","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}],` +
+		`"connections":[{"fromId":"s1","toId":"s2","type":"True"}]}}`
+	pool := NewPool()
+	defer pool.Stop()
+	ruleEngine, err := pool.New("testReplayMsg", []byte(fmt.Sprintf(def, 10)))
+	assert.Nil(t, err)
+
+	var recorded types.ExecutionTrace
+	msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), `{"temperature":20}`)
+	ruleEngine.OnMsgAndWait(msg, types.WithTrace(func(ctx types.RuleContext, trace types.ExecutionTrace) {
+		recorded = trace
+	}))
+	assert.NotEqual(t, "", recorded.DefinitionHash)
+	assert.Equal(t, 1, len(recorded.Root.Children))
+
+	trace, err := pool.ReplayMsg("testReplayMsg", msg, WithReplayDefinitionHash(recorded.DefinitionHash))
+	assert.Nil(t, err)
+	assert.Equal(t, recorded.DefinitionHash, trace.DefinitionHash)
+	assert.Equal(t, "true", trace.Root.InMsg.Metadata[ReplayKey])
+	assert.Equal(t, "", msg.Metadata.GetValue(ReplayKey))
+
+	//规则链定义变化后拒绝重放，除非强制重放
+	assert.Nil(t, ruleEngine.ReloadSelf([]byte(fmt.Sprintf(def, 30))))
+	_, err = pool.ReplayMsg("testReplayMsg", msg, WithReplayDefinitionHash(recorded.DefinitionHash))
+	assert.True(t, errors.Is(err, ErrDefinitionChanged))
+	trace, err = pool.ReplayMsg("testReplayMsg", msg, WithReplayDefinitionHash(recorded.DefinitionHash), WithReplayForce())
+	assert.Nil(t, err)
+	assert.Equal(t, types.False, trace.Root.Outputs[0].RelationType)
+	assert.Equal(t, 0, len(trace.Root.Children))
+
+	//从指定节点开始重放
+	trace, err = pool.ReplayMsg("testReplayMsg", msg, WithReplayNode("s2"))
+	assert.Nil(t, err)
+	assert.Equal(t, "s2", trace.Root.NodeId)
+	_, err = pool.ReplayMsg("testReplayMsg", msg, WithReplayNode("notFound"))
+	assert.NotNil(t, err)
+	_, err = pool.ReplayMsg("notFound", msg)
+	assert.NotNil(t, err)
+}
+
+// TestCancelMsg 取消正在执行的消息
+func TestCancelMsg(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	def := []byte(`{"ruleChain":{"id":"testCancelMsg"},
+		"metadata":{"nodes":[{"id":"s1","type":"test/slow"},{"id":"s2","type":"test/slow"},{"id":"s3","type":"test/slow"}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s2","toId":"s3","type":"Success"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	engine := ruleEngine.(*RuleEngine)
+
+	run := func(cancel func(msgId string), opts ...types.RuleContextOption) error {
+		endErr := make(chan error, 3)
+		opts = append(opts, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			endErr <- err
+		}))
+		msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+		ruleEngine.OnMsg(msg, opts...)
+		time.Sleep(time.Millisecond * 10)
+		cancel(msg.Id)
+		select {
+		case err := <-endErr:
+			return err
+		case <-time.After(time.Second * 3):
+			t.Fatal("end callback not called")
+			return nil
+		}
+	}
+
+	endErr := run(func(msgId string) {
+		assert.True(t, engine.CancelMsg(msgId))
+	})
+	var canceledErr *MsgCanceledError
+	assert.True(t, errors.As(endErr, &canceledErr))
+	assert.Equal(t, "s2", canceledErr.NodeId)
+	assert.True(t, errors.Is(endErr, context.Canceled))
+
+	var cancelFunc context.CancelFunc
+	endErr = run(func(msgId string) {
+		cancelFunc()
+	}, WithCancelFunc(func(cancel context.CancelFunc) {
+		cancelFunc = cancel
+	}))
+	assert.True(t, errors.As(endErr, &canceledErr))
+
+	//执行完成后不再记录
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, 0, engine.activeMsgs.count())
+	assert.False(t, engine.CancelMsg("notFound"))
+}
+
+// boundedPool 最多同时执行size个任务的协程池，已满时返回错误
+type boundedPool struct {
+	sem chan struct{}
+}
+
+func newBoundedPool(size int) *boundedPool {
+	return &boundedPool{sem: make(chan struct{}, size)}
+}
+
+func (p *boundedPool) Submit(task func()) error {
+	select {
+	case p.sem <- struct{}{}:
+		go func() {
+			defer func() { <-p.sem }()
+			task()
+		}()
+		return nil
+	default:
+		return errors.New("pool is full")
+	}
+}
+
+func (p *boundedPool) Release() {
+}
+
+// TestPoolOverflowPolicy 协程池已满时按照溢出策略阻塞、拒绝或者排队
+func TestPoolOverflowPolicy(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	def := []byte(`{"ruleChain":{"id":"testPoolOverflowPolicy"},"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+	newEngine := func(policy string, maxPending int) *RuleEngine {
+		config := NewConfig(types.WithPool(newBoundedPool(1)), types.WithPoolOverflowPolicy(policy, maxPending))
+		ruleEngine, err := New(str.RandomStr(10), def, WithConfig(config))
+		assert.Nil(t, err)
+		return ruleEngine.(*RuleEngine)
+	}
+	//同时发送n条消息，返回每条消息的结束错误
+	send := func(ruleEngine *RuleEngine, n int) []error {
+		var wg sync.WaitGroup
+		errs := make([]error, n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			index := i
+			ruleEngine.OnMsg(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"),
+				types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+					errs[index] = err
+					wg.Done()
+				}))
+		}
+		wg.Wait()
+		return errs
+	}
+
+	t.Run("Drop", func(t *testing.T) {
+		ruleEngine := newEngine(types.OverflowDrop, 0)
+		defer Del(ruleEngine.Id())
+		errs := send(ruleEngine, 2)
+		assert.Nil(t, errs[0])
+		assert.True(t, errors.Is(errs[1], ErrPoolExhausted))
+		assert.Equal(t, int64(1), ruleEngine.PoolStats().Rejected)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		ruleEngine := newEngine(types.OverflowBlock, 0)
+		defer Del(ruleEngine.Id())
+		start := time.Now()
+		errs := send(ruleEngine, 3)
+		assert.Equal(t, []error{nil, nil, nil}, errs)
+		//每次只能执行一条消息
+		assert.True(t, time.Since(start) >= time.Millisecond*60)
+		assert.Equal(t, PoolStats{}, ruleEngine.PoolStats())
+	})
+
+	t.Run("Queue", func(t *testing.T) {
+		ruleEngine := newEngine(types.OverflowQueue, 1)
+		defer Del(ruleEngine.Id())
+		errs := send(ruleEngine, 3)
+		assert.Nil(t, errs[0])
+		assert.Nil(t, errs[1])
+		assert.True(t, errors.Is(errs[2], ErrPoolExhausted))
+		time.Sleep(time.Millisecond * 20)
+		assert.Equal(t, PoolStats{Rejected: 1}, ruleEngine.PoolStats())
+	})
+
+	t.Run("SubChain", func(t *testing.T) {
+		//父规则链占用唯一的协程，子规则链由父规则链的协程直接执行，不会死锁
+		pool := newBoundedPool(1)
+		config := NewConfig(types.WithPool(pool))
+		subId := str.RandomStr(10)
+		_, err := New(subId, def, WithConfig(config))
+		assert.Nil(t, err)
+		defer Del(subId)
+		parentDef := []byte(`{"ruleChain":{"id":"testPoolOverflowParent"},"metadata":{"nodes":[{"id":"s1","type":"flow","configuration":{"targetId":"` + subId + `"}}]}}`)
+		parent, err := New(str.RandomStr(10), parentDef, WithConfig(config))
+		assert.Nil(t, err)
+		defer Del(parent.Id())
+		errs := send(parent.(*RuleEngine), 1)
+		assert.Nil(t, errs[0])
+	})
+}
+
+// TestOnMsgBatch 批量处理消息，每条消息相互独立，返回每条消息结果和汇总结果
+func TestOnMsgBatch(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testOnMsgBatch"},"metadata":{"nodes":[` +
+		`{"id":"s1","type":"jsTransform","configuration":{"jsScript":"if (msg.temperature > 50) {throw 'too high';} msg.checked = true; return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	engine := ruleEngine.(*RuleEngine)
+
+	var msgs []types.RuleMsg
+	for i := 0; i < 100; i++ {
+		msgs = append(msgs, types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), `{"temperature":`+strconv.Itoa(i)+`}`))
+	}
+	var ends int32
+	result := engine.OnMsgBatchAndWait(msgs, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+		atomic.AddInt32(&ends, 1)
+	}))
+	assert.Equal(t, int32(100), atomic.LoadInt32(&ends))
+	assert.Equal(t, 51, result.Succeeded)
+	assert.Equal(t, 49, result.Failed)
+	for i, item := range result.Results {
+		assert.Equal(t, msgs[i].Id, item.MsgId)
+		assert.Equal(t, 1, item.Ends)
+		if i > 50 {
+			assert.NotNil(t, item.Err)
+		} else {
+			assert.Nil(t, item.Err)
+			assert.True(t, strings.Contains(item.Msg.Data, `"checked":true`))
+		}
+	}
+
+	//异步批量处理
+	done := make(chan BatchResult, 1)
+	engine.OnMsgBatch(msgs[:10], WithOnBatchEnd(func(result BatchResult) {
+		done <- result
+	}))
+	select {
+	case result = <-done:
+		assert.Equal(t, 10, result.Succeeded)
+	case <-time.After(time.Second * 3):
+		t.Fatal("batch end callback not called")
+	}
+	assert.Equal(t, 0, len(engine.OnMsgBatchAndWait(nil).Results))
+}
+
+// TestPriority 开启优先级调度后，高优先级消息先执行，低优先级消息不会饿死
+func TestPriority(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	def := []byte(`{"ruleChain":{"id":"testPriority"},"metadata":{"nodes":[{"id":"s1","type":"test/slow"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def, WithConfig(NewConfig(types.WithPriorityWorkers(1), types.WithPoolOverflowPolicy(types.OverflowBlock, 10))))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	var lock sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	send := func(name string, opts ...types.RuleContextOption) {
+		wg.Add(1)
+		metadata := types.NewMetadata()
+		if name == "metadataHigh" {
+			metadata.PutValue(types.PriorityKey, types.PriorityHigh)
+		}
+		opts = append(opts, types.WithOnAllNodeCompleted(func() {
+			lock.Lock()
+			order = append(order, name)
+			lock.Unlock()
+			wg.Done()
+		}))
+		ruleEngine.OnMsg(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, metadata, "{}"), opts...)
+	}
+	send("low", types.WithPriority(types.PriorityLow))
+	//等待低优先级消息占用唯一的并发数
+	time.Sleep(time.Millisecond * 5)
+	send("normal1")
+	send("normal2")
+	send("high", types.WithPriority(types.PriorityHigh))
+	send("metadataHigh")
+	wg.Wait()
+	assert.Equal(t, []string{"low", "high", "metadataHigh", "normal1", "normal2"}, order)
+
+	//连续执行burst个更高优先级的任务后，执行一个低优先级的任务
+	dispatcher := newPriorityDispatcher(types.Config{PriorityWorkers: 1, PriorityBurst: 2}, nil)
+	var executed []string
+	task := func(name string) func() {
+		return func() {
+			executed = append(executed, name)
+		}
+	}
+	dispatcher.lock.Lock()
+	for i := 0; i < 4; i++ {
+		dispatcher.lanes[0] = append(dispatcher.lanes[0], task("h"))
+	}
+	dispatcher.lanes[2] = append(dispatcher.lanes[2], task("l"))
+	for next := dispatcher.next(); next != nil; next = dispatcher.next() {
+		next()
+	}
+	dispatcher.lock.Unlock()
+	assert.Equal(t, []string{"h", "h", "l", "h", "h"}, executed)
+	assert.True(t, newPriorityDispatcher(NewConfig(), nil) == nil)
+
+	//所有并发数都在执行任务时，按照溢出策略处理新的任务
+	overflow := func(policy string, maxPending int) ([]error, PoolStats, time.Duration) {
+		config := NewConfig(types.WithPriorityWorkers(1), types.WithPoolOverflowPolicy(policy, maxPending))
+		ruleEngine, err := New(str.RandomStr(10), def, WithConfig(config))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		start := time.Now()
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		for i := range errs {
+			wg.Add(1)
+			index := i
+			ruleEngine.OnMsg(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"),
+				types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+					errs[index] = err
+					wg.Done()
+				}))
+			//等待第一条消息占用唯一的并发数
+			if i == 0 {
+				time.Sleep(time.Millisecond * 5)
+			}
+		}
+		//发送消息的耗时
+		elapsed := time.Since(start)
+		wg.Wait()
+		return errs, ruleEngine.(*RuleEngine).PoolStats(), elapsed
+	}
+	errs, stats, _ := overflow(types.OverflowDrop, 0)
+	assert.Nil(t, errs[0])
+	assert.True(t, errors.Is(errs[1], ErrPoolExhausted))
+	assert.True(t, errors.Is(errs[2], ErrPoolExhausted))
+	assert.Equal(t, PoolStats{Rejected: 2}, stats)
+
+	errs, stats, _ = overflow(types.OverflowQueue, 1)
+	assert.Nil(t, errs[0])
+	assert.Nil(t, errs[1])
+	assert.True(t, errors.Is(errs[2], ErrPoolExhausted))
+	assert.Equal(t, PoolStats{Rejected: 1}, stats)
+
+	//通道中等待的任务已满，OnMsg调用方阻塞等待
+	errs, stats, elapsed := overflow(types.OverflowBlock, 1)
+	assert.Equal(t, []error{nil, nil, nil}, errs)
+	assert.Equal(t, PoolStats{}, stats)
+	assert.True(t, elapsed >= time.Millisecond*15)
+}
+
+func TestMsgExpire(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	def := []byte(`{"ruleChain":{"id":"testMsgExpire"},
+		"metadata":{"nodes":[{"id":"s1","type":"test/slow"},{"id":"s2","type":"test/slow"},{"id":"s3","type":"test/slow"}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s2","toId":"s3","type":"Success"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	engine := ruleEngine.(*RuleEngine)
+
+	run := func(ts int64, key, value string) error {
+		var endErr error
+		metadata := types.NewMetadata()
+		if key != "" {
+			metadata.PutValue(key, value)
+		}
+		msg := types.NewMsg(ts, "TEST_MSG_TYPE", types.JSON, metadata, "{}")
+		ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			endErr = err
+		}))
+		return endErr
+	}
+	now := time.Now().UnixMilli()
+
+	//没有设置有效期
+	assert.Nil(t, run(0, "", ""))
+	assert.Nil(t, run(0, types.TTLKey, "60000"))
+	assert.Nil(t, run(0, types.ExpireAtKey, "invalid"))
+
+	//已经过期，不执行第一个节点
+	endErr := run(0, types.ExpireAtKey, strconv.FormatInt(now-1000, 10))
+	var expiredErr *MsgExpiredError
+	assert.True(t, errors.As(endErr, &expiredErr))
+	assert.Equal(t, "s1", expiredErr.NodeId)
+	assert.True(t, errors.Is(endErr, ErrMsgExpired))
+
+	//有效期从消息时间戳开始计算
+	endErr = run(now-1000, types.TTLKey, "500")
+	assert.True(t, errors.As(endErr, &expiredErr))
+	assert.Equal(t, now-500, expiredErr.ExpireAt)
+
+	//执行过程中过期，停止执行后续节点
+	endErr = run(0, types.ExpireAtKey, strconv.FormatInt(time.Now().UnixMilli()+30, 10))
+	assert.True(t, errors.As(endErr, &expiredErr))
+	assert.NotEqual(t, "s1", expiredErr.NodeId)
+
+	stats := engine.Stats()
+	assert.Equal(t, int64(3), stats.Expired)
+	assert.Equal(t, int64(3), stats.Failed)
+}
+
+func TestWithContextCancel(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+	def := []byte(`{"ruleChain":{"id":"testWithContextCancel"},
+		"metadata":{"nodes":[{"id":"s1","type":"test/slow"},{"id":"s2","type":"test/slow"},{"id":"s3","type":"test/slow"}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"},{"fromId":"s2","toId":"s3","type":"Success"}]}}`)
+	ruleEngine, err := New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+
+	type ctxKey struct{}
+	run := func(callerCtx context.Context, cancel context.CancelFunc) (interface{}, error) {
+		var endErr error
+		var value interface{}
+		msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+		if cancel != nil {
+			time.AfterFunc(time.Millisecond*10, cancel)
+		}
+		ruleEngine.OnMsgAndWait(msg, types.WithContext(callerCtx), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			endErr = err
+			value = ctx.GetContext().Value(ctxKey{})
+		}))
+		return value, endErr
+	}
+
+	//组件可以获取调用方上下文的值
+	value, endErr := run(context.WithValue(context.Background(), ctxKey{}, "caller"), nil)
+	assert.Nil(t, endErr)
+	assert.Equal(t, "caller", value)
+
+	//调用方上下文取消后，不再执行后续节点
+	callerCtx, cancel := context.WithCancel(context.Background())
+	_, endErr = run(callerCtx, cancel)
+	var canceledErr *MsgCanceledError
+	assert.True(t, errors.As(endErr, &canceledErr))
+	assert.Equal(t, "s2", canceledErr.NodeId)
+	assert.True(t, errors.Is(endErr, context.Canceled))
+
+	//调用方上下文已经取消，不执行第一个节点
+	_, endErr = run(callerCtx, nil)
+	assert.True(t, errors.As(endErr, &canceledErr))
+	assert.Equal(t, "s1", canceledErr.NodeId)
+}
+
+func TestNodeTimeout(t *testing.T) {
+	_ = Registry.Register(&slowNode{})
+	defer Registry.Unregister("test/slow")
+
+	run := func(def string) ([]string, []error) {
+		ruleEngine, err := New(str.RandomStr(10), []byte(def))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		var lock sync.Mutex
+		var relationTypes []string
+		var errs []error
+		msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+		ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			lock.Lock()
+			defer lock.Unlock()
+			relationTypes = append(relationTypes, relationType)
+			errs = append(errs, err)
+		}))
+		//等待超时节点的延迟通知
+		time.Sleep(time.Millisecond * 40)
+		lock.Lock()
+		defer lock.Unlock()
+		return relationTypes, errs
+	}
+
+	//节点超时，通过Failure关系路由，忽略节点之后的通知
+	relationTypes, errs := run(`{"ruleChain":{"id":"testNodeTimeout"},
+		"metadata":{"nodes":[{"id":"s1","type":"test/slow","timeout":5},{"id":"s2","type":"test/slow"}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"}]}}`)
+	assert.Equal(t, []string{types.Failure}, relationTypes)
+	var timeoutErr *NodeTimeoutError
+	assert.True(t, errors.As(errs[0], &timeoutErr))
+	assert.Equal(t, "s1", timeoutErr.NodeId)
+	assert.Equal(t, time.Millisecond*5, timeoutErr.Timeout)
+	assert.True(t, errors.Is(errs[0], context.DeadlineExceeded))
+
+	//规则链默认超时时间，节点配置的超时时间优先
+	relationTypes, errs = run(`{"ruleChain":{"id":"testNodeTimeout","nodeTimeoutMs":5},
+		"metadata":{"nodes":[{"id":"s1","type":"test/slow","timeout":1000},{"id":"s2","type":"test/slow"}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"Success"}]}}`)
+	assert.Equal(t, []string{types.Failure}, relationTypes)
+	assert.True(t, errors.As(errs[0], &timeoutErr))
+	assert.Equal(t, "s2", timeoutErr.NodeId)
+
+	//没有超时
+	relationTypes, errs = run(`{"ruleChain":{"id":"testNodeTimeout"},
+		"metadata":{"nodes":[{"id":"s1","type":"test/slow","timeout":1000}]}}`)
+	assert.Equal(t, []string{types.Success}, relationTypes)
+	assert.Nil(t, errs[0])
+}
+
+// flakyNode 前failures次执行失败，之后执行成功
+type flakyNode struct {
+	failures int32
+	attempts int32
+}
+
+func (n *flakyNode) Type() string {
+	return "test/flaky"
+}
+
+func (n *flakyNode) New() types.Node {
+	return &flakyNode{}
+}
+
+func (n *flakyNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	if failures, ok := configuration["failures"].(float64); ok {
+		n.failures = int32(failures)
+	}
+	return nil
+}
+
+func (n *flakyNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	if attempt := atomic.AddInt32(&n.attempts, 1); attempt <= n.failures {
+		ctx.TellFailure(msg, fmt.Errorf("attempt %d failed", attempt))
+	} else {
+		ctx.TellSuccess(msg)
+	}
+}
+
+func (n *flakyNode) Destroy() {
+}
+
+func TestNodeRetry(t *testing.T) {
+	_ = Registry.Register(&flakyNode{})
+	defer Registry.Unregister("test/flaky")
+
+	type endResult struct {
+		relationType string
+		err          error
+		metadata     map[string]string
+	}
+	run := func(failures int, retry string) (endResult, []string) {
+		var lock sync.Mutex
+		var debugAttempts []string
+		config := NewConfig()
+		config.OnDebug = func(chainId, flowType string, nodeId string, msg types.RuleMsg, relationType string, err error) {
+			if nodeId == "f1" {
+				lock.Lock()
+				defer lock.Unlock()
+				debugAttempts = append(debugAttempts, flowType+":"+relationType)
+			}
+		}
+		def := fmt.Sprintf(`{"ruleChain":{"id":"testNodeRetry"},
+			"metadata":{"nodes":[{"id":"f1","type":"test/flaky","debugMode":true,"configuration":{"failures":%d},"retry":%s}]}}`, failures, retry)
+		ruleEngine, err := New(str.RandomStr(10), []byte(def), WithConfig(config))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		var result endResult
+		msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+		ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			result = endResult{relationType: relationType, err: err, metadata: msg.Metadata.Values()}
+		}))
+		//等待异步调试回调
+		time.Sleep(time.Millisecond * 20)
+		lock.Lock()
+		defer lock.Unlock()
+		return result, debugAttempts
+	}
+
+	//重试后成功
+	start := time.Now()
+	result, debugAttempts := run(2, `{"maxAttempts":3,"initialDelayMs":5,"multiplier":2}`)
+	assert.True(t, time.Since(start) >= time.Millisecond*15)
+	assert.Equal(t, types.Success, result.relationType)
+	assert.Nil(t, result.err)
+	assert.Equal(t, "3", result.metadata[RetryAttemptsKey])
+	assert.Equal(t, "attempt 2 failed", result.metadata[RetryLastErrorKey])
+	//调试模式记录每次执行
+	assert.Equal(t, 6, len(debugAttempts))
+	assert.Equal(t, 3, strings.Count(strings.Join(debugAttempts, ","), types.In+":"))
+	assert.Equal(t, 2, strings.Count(strings.Join(debugAttempts, ","), types.Out+":"+types.Failure))
+
+	//重试次数用完
+	result, _ = run(5, `{"maxAttempts":3}`)
+	assert.Equal(t, types.Failure, result.relationType)
+	assert.Equal(t, "attempt 3 failed", result.err.Error())
+	assert.Equal(t, "3", result.metadata[RetryAttemptsKey])
+	assert.Equal(t, "attempt 3 failed", result.metadata[RetryLastErrorKey])
+
+	//不重试的关系类型
+	result, debugAttempts = run(5, `{"maxAttempts":3,"retryOn":["Success"]}`)
+	assert.Equal(t, types.Failure, result.relationType)
+	assert.Equal(t, "", result.metadata[RetryAttemptsKey])
+	assert.Equal(t, 2, len(debugAttempts))
+}
+
+func TestDeadLetter(t *testing.T) {
+	_ = Registry.Register(&flakyNode{})
+	defer Registry.Unregister("test/flaky")
+
+	type endResult struct {
+		relationType string
+		err          error
+		metadata     map[string]string
+	}
+	run := func(def string) ([]endResult, []string) {
+		var lock sync.Mutex
+		var deadLetters []string
+		config := NewConfig(types.WithOnDeadLetter(func(ruleChainId string, nodeId string, msg types.RuleMsg, relationType string, err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			deadLetters = append(deadLetters, nodeId+":"+relationType)
+		}))
+		ruleEngine, err := New(str.RandomStr(10), []byte(def), WithConfig(config))
+		assert.Nil(t, err)
+		defer Del(ruleEngine.Id())
+		var results []endResult
+		msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}")
+		ruleEngine.OnMsgAndWait(msg, types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			lock.Lock()
+			defer lock.Unlock()
+			results = append(results, endResult{relationType: relationType, err: err, metadata: msg.Metadata.Values()})
+		}))
+		//等待异步死信回调
+		time.Sleep(time.Millisecond * 20)
+		lock.Lock()
+		defer lock.Unlock()
+		return results, deadLetters
+	}
+
+	//Failure关系没有连接，发送到死信节点
+	results, deadLetters := run(`{"ruleChain":{"id":"testDeadLetter","deadLetterNodeId":"dl"},
+		"metadata":{"nodes":[{"id":"f1","type":"test/flaky","configuration":{"failures":1}},{"id":"dl","type":"test/flaky"}]}}`)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, types.Success, results[0].relationType)
+	assert.Nil(t, results[0].err)
+	assert.Equal(t, "f1", results[0].metadata[DeadLetterFromKey])
+	assert.Equal(t, types.Failure, results[0].metadata[DeadLetterRelationKey])
+	assert.Equal(t, "attempt 1 failed", results[0].metadata[DeadLetterErrorKey])
+	assert.Equal(t, []string{"f1:" + types.Failure}, deadLetters)
+
+	//节点有其他关系的连接
+	results, deadLetters = run(`{"ruleChain":{"id":"testDeadLetter","deadLetterNodeId":"dl"},
+		"metadata":{"nodes":[{"id":"f1","type":"test/flaky"},{"id":"f2","type":"test/flaky"},{"id":"dl","type":"test/flaky"}],
+		"connections":[{"fromId":"f1","toId":"f2","type":"Failure"}]}}`)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "f1", results[0].metadata[DeadLetterFromKey])
+	assert.Equal(t, types.Success, results[0].metadata[DeadLetterRelationKey])
+	assert.Equal(t, []string{"f1:" + types.Success}, deadLetters)
+
+	//没有任何连接的节点正常结束
+	results, deadLetters = run(`{"ruleChain":{"id":"testDeadLetter","deadLetterNodeId":"dl"},
+		"metadata":{"nodes":[{"id":"f1","type":"test/flaky"},{"id":"dl","type":"test/flaky"}]}}`)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "", results[0].metadata[DeadLetterFromKey])
+	assert.Equal(t, 0, len(deadLetters))
+
+	//严格关系模式
+	results, deadLetters = run(`{"ruleChain":{"id":"testDeadLetter","strictRelations":true},
+		"metadata":{"nodes":[{"id":"f1","type":"test/flaky","configuration":{"failures":1}}]}}`)
+	assert.Equal(t, 1, len(results))
+	var unmatchedErr *UnmatchedRelationError
+	assert.True(t, errors.As(results[0].err, &unmatchedErr))
+	assert.Equal(t, "f1", unmatchedErr.NodeId)
+	assert.Equal(t, types.Failure, unmatchedErr.RelationType)
+	assert.True(t, errors.Is(results[0].err, ErrUnmatchedRelation))
+	assert.Equal(t, 1, len(deadLetters))
+
+	//死信节点不能删除
+	ruleEngine, err := New(str.RandomStr(10), []byte(`{"ruleChain":{"id":"testDeadLetter","deadLetterNodeId":"dl"},
+		"metadata":{"nodes":[{"id":"f1","type":"test/flaky"},{"id":"dl","type":"test/flaky"}]}}`))
+	assert.Nil(t, err)
+	defer Del(ruleEngine.Id())
+	assert.NotNil(t, ruleEngine.(*RuleEngine).RemoveNode("dl", true))
+	_, ok := ruleEngine.RootRuleChainCtx().GetNodeById(types.RuleNodeId{Id: "dl", Type: types.NODE})
+	assert.True(t, ok)
+
+	_, err = New(str.RandomStr(10), []byte(`{"ruleChain":{"id":"testDeadLetter","deadLetterNodeId":"notFound"},
+		"metadata":{"nodes":[{"id":"f1","type":"test/flaky"}]}}`))
+	assert.NotNil(t, err)
+}
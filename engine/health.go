@@ -0,0 +1,158 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"context"
+	"errors"
+	"github.com/rulego/rulego/api/types"
+	"sync"
+	"time"
+)
+
+// HealthStatus 节点健康状态
+type HealthStatus string
+
+const (
+	// HealthStatusUp 节点可用
+	HealthStatusUp HealthStatus = "up"
+	// HealthStatusDown 节点不可用
+	HealthStatusDown HealthStatus = "down"
+	// HealthStatusUnknown 节点没有实现 types.HealthChecker，健康状态未知
+	HealthStatusUnknown HealthStatus = "unknown"
+)
+
+// NodeHealth 节点健康检查结果
+type NodeHealth struct {
+	NodeId   string       `json:"nodeId"`
+	NodeType string       `json:"nodeType"`
+	Status   HealthStatus `json:"status"`
+	//检查耗时
+	Latency time.Duration `json:"latency"`
+	//不可用的原因
+	Error string `json:"error,omitempty"`
+	Err   error  `json:"-"`
+}
+
+// ChainHealth 规则链健康检查结果
+type ChainHealth struct {
+	ChainId string `json:"chainId"`
+	//节点健康检查结果，key:节点ID
+	Nodes map[string]NodeHealth `json:"nodes"`
+}
+
+// Healthy 规则链是否健康，没有不可用的节点返回true，健康状态未知的节点不影响结果
+func (h ChainHealth) Healthy() bool {
+	for _, item := range h.Nodes {
+		if item.Status == HealthStatusDown {
+			return false
+		}
+	}
+	return true
+}
+
+// PoolHealth 规则引擎池健康检查结果，key:规则链ID
+type PoolHealth map[string]ChainHealth
+
+// Healthy 池中所有规则链是否都健康，可以用于就绪探针
+func (h PoolHealth) Healthy() bool {
+	for _, item := range h {
+		if !item.Healthy() {
+			return false
+		}
+	}
+	return true
+}
+
+// HealthCheck 并发检查规则链所有节点的健康状态，节点组件实现了 types.HealthChecker 则调用Check，
+// 否则健康状态为 HealthStatusUnknown。ctx 用于控制检查超时
+func (rc *RuleChainCtx) HealthCheck(ctx context.Context) ChainHealth {
+	rc.RLock()
+	chainId := rc.Id.Id
+	nodeIds := rc.nodeIds
+	nodes := rc.nodes
+	rc.RUnlock()
+	result := ChainHealth{ChainId: chainId, Nodes: make(map[string]NodeHealth, len(nodeIds))}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, id := range nodeIds {
+		nodeCtx, ok := nodes[id]
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func(id types.RuleNodeId, nodeCtx types.NodeCtx) {
+			defer wg.Done()
+			health := checkNode(ctx, id, nodeCtx)
+			lock.Lock()
+			result.Nodes[id.Id] = health
+			lock.Unlock()
+		}(id, nodeCtx)
+	}
+	wg.Wait()
+	return result
+}
+
+// checkNode 检查节点的健康状态
+func checkNode(ctx context.Context, id types.RuleNodeId, nodeCtx types.NodeCtx) NodeHealth {
+	health := NodeHealth{NodeId: id.Id, Status: HealthStatusUnknown}
+	ruleNodeCtx, ok := nodeCtx.(*RuleNodeCtx)
+	if !ok {
+		return health
+	}
+	if ruleNodeCtx.SelfDefinition != nil {
+		health.NodeType = ruleNodeCtx.SelfDefinition.Type
+	}
+	checker, ok := ruleNodeCtx.Node.(types.HealthChecker)
+	if !ok {
+		return health
+	}
+	start := time.Now()
+	err := checker.Check(ctx)
+	if err == nil && ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	health.Latency = time.Since(start)
+	if err != nil {
+		health.Status = HealthStatusDown
+		health.Err = err
+		health.Error = err.Error()
+	} else {
+		health.Status = HealthStatusUp
+	}
+	return health
+}
+
+// HealthCheck 检查根规则链所有节点的健康状态，参考 RuleChainCtx.HealthCheck
+func (e *RuleEngine) HealthCheck(ctx context.Context) (ChainHealth, error) {
+	if !e.Initialized() {
+		return ChainHealth{}, errors.New("HealthCheck error.RuleEngine not initialized")
+	}
+	return e.rootRuleChainCtx.HealthCheck(ctx), nil
+}
+
+// HealthCheck 检查池中所有规则链的健康状态，未初始化的规则链忽略
+func (g *Pool) HealthCheck(ctx context.Context) PoolHealth {
+	result := make(PoolHealth)
+	g.entries.Range(func(key, value any) bool {
+		if health, err := value.(*RuleEngine).HealthCheck(ctx); err == nil {
+			result[key.(string)] = health
+		}
+		return true
+	})
+	return result
+}
@@ -17,9 +17,16 @@
 package engine
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"github.com/expr-lang/expr"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/utils/str"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
 )
 
 const (
@@ -36,10 +43,23 @@ type RuleNodeCtx struct {
 	SelfDefinition *types.RuleNode
 	//规则引擎配置
 	config types.Config
+	//是否已经启动 1:是 0:否，参考 types.ChainStartListener
+	started int32
 }
 
 // InitRuleNodeCtx 初始化RuleNodeCtx
 func InitRuleNodeCtx(config types.Config, chainCtx *RuleChainCtx, selfDefinition *types.RuleNode) (*RuleNodeCtx, error) {
+	if enabled, err := isNodeEnabled(config, chainCtx, selfDefinition); err != nil {
+		return &RuleNodeCtx{}, err
+	} else if !enabled {
+		//不初始化组件，使用直通节点代替
+		return &RuleNodeCtx{
+			Node:           &passThroughNode{nodeType: selfDefinition.Type},
+			ChainCtx:       chainCtx,
+			SelfDefinition: selfDefinition,
+			config:         config,
+		}, nil
+	}
 	node, err := config.ComponentsRegistry.NewNode(selfDefinition.Type)
 	if err != nil {
 		return &RuleNodeCtx{
@@ -73,20 +93,35 @@ func (rn *RuleNodeCtx) Config() types.Config {
 	return rn.config
 }
 
+// IsDebugMode 节点是否调试模式，优先使用 RuleChainCtx.SetNodeDebugMode 设置的调试模式
 func (rn *RuleNodeCtx) IsDebugMode() bool {
+	if rn.ChainCtx != nil {
+		if enable, ok := rn.ChainCtx.debug.nodeMode(rn.SelfDefinition.Id); ok {
+			return enable
+		}
+	}
 	return rn.SelfDefinition.DebugMode
 }
 
+// IsTerminal 是否是终止节点
+func (rn *RuleNodeCtx) IsTerminal() bool {
+	return rn.SelfDefinition != nil && rn.SelfDefinition.Terminal
+}
+
 func (rn *RuleNodeCtx) GetNodeId() types.RuleNodeId {
 	return types.RuleNodeId{Id: rn.SelfDefinition.Id, Type: types.NODE}
 }
 
 func (rn *RuleNodeCtx) ReloadSelf(def []byte) error {
 	if ruleNodeCtx, err := rn.config.Parser.DecodeRuleNode(rn.config, def, rn.ChainCtx); err == nil {
-		//先销毁
+		//先停止并销毁
+		started := rn.stop()
 		rn.Destroy()
 		//重新加载
 		rn.Copy(ruleNodeCtx.(*RuleNodeCtx))
+		if started {
+			rn.start()
+		}
 		return nil
 	} else {
 		return err
@@ -106,6 +141,35 @@ func (rn *RuleNodeCtx) DSL() []byte {
 	return v
 }
 
+// start 规则链启动后调用，组件实现了 types.ChainStartListener 则调用OnChainStart，重复调用只启动一次
+func (rn *RuleNodeCtx) start() {
+	if !atomic.CompareAndSwapInt32(&rn.started, 0, 1) {
+		return
+	}
+	if listener, ok := rn.Node.(types.ChainStartListener); ok {
+		listener.OnChainStart(rn)
+	}
+}
+
+// stop 节点实例销毁前调用，组件实现了 types.ChainStopListener 则调用OnChainStop，没有启动则忽略。返回节点之前是否已经启动
+func (rn *RuleNodeCtx) stop() bool {
+	if !atomic.CompareAndSwapInt32(&rn.started, 1, 0) {
+		return false
+	}
+	if listener, ok := rn.Node.(types.ChainStopListener); ok {
+		listener.OnChainStop()
+	}
+	return true
+}
+
+// Destroy 销毁节点实例，已经启动的节点先停止
+func (rn *RuleNodeCtx) Destroy() {
+	rn.stop()
+	if rn.Node != nil {
+		rn.Node.Destroy()
+	}
+}
+
 // Copy 复制
 func (rn *RuleNodeCtx) Copy(newCtx *RuleNodeCtx) {
 	rn.Node = newCtx.Node
@@ -114,9 +178,83 @@ func (rn *RuleNodeCtx) Copy(newCtx *RuleNodeCtx) {
 	rn.SelfDefinition.Name = newCtx.SelfDefinition.Name
 	rn.SelfDefinition.Type = newCtx.SelfDefinition.Type
 	rn.SelfDefinition.DebugMode = newCtx.SelfDefinition.DebugMode
+	rn.SelfDefinition.Terminal = newCtx.SelfDefinition.Terminal
 	rn.SelfDefinition.Configuration = newCtx.SelfDefinition.Configuration
 }
 
+// SecretMask 导出配置时替换secret明文的掩码
+const SecretMask = "******"
+
+// EffectiveConfig 获取节点实际使用的配置，即替换全局配置和vars变量占位符后的配置
+// 不包含vars和secrets，配置值中出现的secret明文替换为 SecretMask
+func (rn *RuleNodeCtx) EffectiveConfig() types.Configuration {
+	configuration, _ := processVariables(rn.config, rn.ChainCtx, rn.SelfDefinition.Configuration)
+	delete(configuration, types.Vars)
+	delete(configuration, types.Secrets)
+	var secrets []string
+	if rn.ChainCtx != nil {
+		for _, v := range rn.ChainCtx.decryptSecrets {
+			if v != "" {
+				secrets = append(secrets, v)
+			}
+		}
+	}
+	if len(secrets) == 0 {
+		return configuration
+	}
+	//先替换较长的secret，避免较短的secret是其子串时替换不完整
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+	for k, v := range configuration {
+		configuration[k] = maskSecrets(v, secrets)
+	}
+	return configuration
+}
+
+// maskSecrets 递归把配置值中出现的secret明文替换为 SecretMask
+func maskSecrets(value interface{}, secrets []string) interface{} {
+	switch v := value.(type) {
+	case string:
+		for _, secret := range secrets {
+			v = strings.ReplaceAll(v, secret, SecretMask)
+		}
+		return v
+	case map[string]interface{}:
+		var result = make(map[string]interface{}, len(v))
+		for k, item := range v {
+			result[k] = maskSecrets(item, secrets)
+		}
+		return result
+	case types.Configuration:
+		var result = make(types.Configuration, len(v))
+		for k, item := range v {
+			result[k] = maskSecrets(item, secrets)
+		}
+		return result
+	case map[string]string:
+		var result = make(map[string]string, len(v))
+		for k, item := range v {
+			result[k] = maskSecrets(item, secrets).(string)
+		}
+		return result
+	case []interface{}:
+		var result = make([]interface{}, len(v))
+		for i, item := range v {
+			result[i] = maskSecrets(item, secrets)
+		}
+		return result
+	case []string:
+		var result = make([]string, len(v))
+		for i, item := range v {
+			result[i] = maskSecrets(item, secrets).(string)
+		}
+		return result
+	default:
+		return value
+	}
+}
+
 // 使用全局配置替换节点占位符配置，例如：${global.propertyKey}
 func processVariables(config types.Config, chainCtx *RuleChainCtx, configuration types.Configuration) (types.Configuration, error) {
 	var result = make(types.Configuration)
@@ -159,3 +297,139 @@ func copyMap(inputMap map[string]string) map[string]string {
 	}
 	return result
 }
+
+// isNodeEnabled 计算节点配置的enabledIf表达式，没有配置则返回true
+// 表达式可以使用以下变量：
+//   - vars：规则链vars变量，例如：vars.env == 'prod'
+//   - global：全局配置config.Properties，例如：global.region == 'cn'
+//   - env：操作系统环境变量，例如：env.APP_ENV != 'staging'
+//
+// 表达式只在节点初始化时计算一次，后续变量变化需要重新加载规则链才能生效
+func isNodeEnabled(config types.Config, chainCtx *RuleChainCtx, selfDefinition *types.RuleNode) (bool, error) {
+	if selfDefinition.Configuration == nil {
+		return true, nil
+	}
+	expression := strings.TrimSpace(str.ToString(selfDefinition.Configuration[types.EnabledIf]))
+	if expression == "" {
+		return true, nil
+	}
+	program, err := expr.Compile(expression, expr.AllowUndefinedVariables(), expr.AsBool())
+	if err != nil {
+		return false, fmt.Errorf("node %s enabledIf compile error: %w", selfDefinition.Id, err)
+	}
+	var globalEnv = make(map[string]string)
+	if config.Properties != nil {
+		globalEnv = config.Properties.Values()
+	}
+	var varsEnv = make(map[string]string)
+	if chainCtx != nil {
+		varsEnv = copyMap(chainCtx.vars)
+	}
+	var osEnv = make(map[string]string)
+	for _, item := range os.Environ() {
+		if k, v, ok := strings.Cut(item, "="); ok {
+			osEnv[k] = v
+		}
+	}
+	out, err := expr.Run(program, map[string]interface{}{
+		types.Global: globalEnv,
+		types.Vars:   varsEnv,
+		"env":        osEnv,
+	})
+	if err != nil {
+		return false, fmt.Errorf("node %s enabledIf evaluate error: %w", selfDefinition.Id, err)
+	}
+	enabled, _ := out.(bool)
+	return enabled, nil
+}
+
+// NodeInitErrorKey 降级节点输出消息的元数据key，值为节点初始化错误
+const NodeInitErrorKey = "nodeInitError"
+
+// DegradedNode 初始化失败，以降级模式运行的节点
+type DegradedNode struct {
+	//NodeId 节点ID
+	NodeId string
+	//Err 节点初始化错误
+	Err error
+}
+
+// degradedNode 降级节点，用于代替初始化失败的节点，把所有消息通过Failure关系发送
+type degradedNode struct {
+	nodeType string
+	err      error
+}
+
+// newDegradedNodeCtx 创建代替初始化失败节点的降级节点
+func newDegradedNodeCtx(config types.Config, chainCtx *RuleChainCtx, selfDefinition *types.RuleNode, err error) *RuleNodeCtx {
+	return &RuleNodeCtx{
+		Node:           &degradedNode{nodeType: selfDefinition.Type, err: err},
+		ChainCtx:       chainCtx,
+		SelfDefinition: selfDefinition,
+		config:         config,
+	}
+}
+
+func (n *degradedNode) Type() string {
+	return n.nodeType
+}
+
+func (n *degradedNode) New() types.Node {
+	return &degradedNode{nodeType: n.nodeType, err: n.err}
+}
+
+func (n *degradedNode) Init(_ types.Config, _ types.Configuration) error {
+	return nil
+}
+
+func (n *degradedNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	msg.Metadata.PutValue(NodeInitErrorKey, n.err.Error())
+	ctx.TellFailure(msg, n.err)
+}
+
+func (n *degradedNode) Destroy() {
+}
+
+// Check 健康检查，降级节点返回初始化错误
+func (n *degradedNode) Check(_ context.Context) error {
+	return n.err
+}
+
+// passThroughNode 直通节点，用于代替未启用的节点，把消息原样发送给除了失败关系以外的所有下一个节点
+type passThroughNode struct {
+	nodeType string
+}
+
+func (n *passThroughNode) Type() string {
+	return n.nodeType
+}
+
+func (n *passThroughNode) New() types.Node {
+	return &passThroughNode{nodeType: n.nodeType}
+}
+
+func (n *passThroughNode) Init(_ types.Config, _ types.Configuration) error {
+	return nil
+}
+
+func (n *passThroughNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	var relationTypes []string
+	//通过消息所在的规则链获取当前的连接，规则链重新加载或者运行时编辑后仍然有效
+	if chainCtx, ok := ctx.RuleChain().(*RuleChainCtx); ok && chainCtx != nil {
+		for relationType := range chainCtx.RelationFanout(ctx.Self().GetNodeId()) {
+			//未启用的节点没有出错，不触发失败分支
+			if relationType != types.Failure {
+				relationTypes = append(relationTypes, relationType)
+			}
+		}
+	}
+	if len(relationTypes) == 0 {
+		ctx.TellSuccess(msg)
+		return
+	}
+	sort.Strings(relationTypes)
+	ctx.TellNext(msg, relationTypes...)
+}
+
+func (n *passThroughNode) Destroy() {
+}
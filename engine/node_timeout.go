@@ -0,0 +1,95 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// NodeTimeoutError 节点超过执行超时时间没有通知下一个节点，消息通过Failure关系路由，参考 types.RuleNode.Timeout
+type NodeTimeoutError struct {
+	//NodeId 执行超时的节点ID
+	NodeId string
+	//Timeout 节点执行超时时间
+	Timeout time.Duration
+}
+
+func (e *NodeTimeoutError) Error() string {
+	return fmt.Sprintf("node %s execution timeout after %s: %s", e.NodeId, e.Timeout, context.DeadlineExceeded)
+}
+
+// Unwrap 可以通过 errors.Is(err, context.DeadlineExceeded) 判断
+func (e *NodeTimeoutError) Unwrap() error {
+	return context.DeadlineExceeded
+}
+
+const (
+	//节点正在执行
+	nodeRunning int32 = iota
+	//节点已经通知下一个节点
+	nodeTold
+	//节点执行超时
+	nodeTimedOut
+)
+
+// nodeTimeoutGuard 节点执行超时守卫，节点第一次通知下一个节点和超时只有一个生效
+type nodeTimeoutGuard struct {
+	state int32
+	timer *time.Timer
+}
+
+// tell 节点通知下一个节点，返回false表示节点已经超时，忽略该通知
+func (g *nodeTimeoutGuard) tell() bool {
+	if atomic.CompareAndSwapInt32(&g.state, nodeRunning, nodeTold) {
+		g.timer.Stop()
+		return true
+	}
+	return atomic.LoadInt32(&g.state) == nodeTold
+}
+
+// startNodeTimer 节点超过timeout没有通知下一个节点，通过Failure关系路由msg或者按照重试策略重试，并忽略节点之后的通知
+func (ctx *DefaultRuleContext) startNodeTimer(msg types.RuleMsg, timeout time.Duration) {
+	guard := &nodeTimeoutGuard{}
+	ctx.timeoutGuard = guard
+	guard.timer = time.AfterFunc(timeout, func() {
+		if atomic.CompareAndSwapInt32(&guard.state, nodeRunning, nodeTimedOut) {
+			ctx.tellOrRetry(msg, &NodeTimeoutError{NodeId: ctx.self.GetNodeId().Id, Timeout: timeout}, "", types.Failure)
+		}
+	})
+}
+
+// nodeTimeout 节点执行超时时间，优先使用节点配置的超时时间，其次是规则链配置的节点默认超时时间，0表示不限制
+func nodeTimeout(node types.NodeCtx) time.Duration {
+	nodeCtx, ok := node.(*RuleNodeCtx)
+	if !ok || nodeCtx.SelfDefinition == nil {
+		return 0
+	}
+	if timeout := nodeCtx.SelfDefinition.Timeout; timeout > 0 {
+		return time.Duration(timeout) * time.Millisecond
+	}
+	if nodeCtx.ChainCtx != nil {
+		if def := nodeCtx.ChainCtx.Definition(); def != nil && def.RuleChain.NodeTimeoutMs > 0 {
+			return time.Duration(def.RuleChain.NodeTimeoutMs) * time.Millisecond
+		}
+	}
+	return 0
+}
@@ -34,6 +34,12 @@ func (p *JsonParser) DecodeRuleChain(config types.Config, aspects types.AspectLi
 		return nil, err
 	}
 }
+
+// DecodeRuleChainDef 只解析规则链定义，不初始化节点
+func (p *JsonParser) DecodeRuleChainDef(dsl []byte) (types.RuleChain, error) {
+	return ParserRuleChain(dsl)
+}
+
 func (p *JsonParser) DecodeRuleNode(config types.Config, dsl []byte, chainCtx types.Node) (types.Node, error) {
 	if node, err := ParserRuleNode(dsl); err == nil {
 		if chainCtx == nil {
@@ -48,7 +54,7 @@ func (p *JsonParser) DecodeRuleNode(config types.Config, dsl []byte, chainCtx ty
 	}
 }
 func (p *JsonParser) EncodeRuleChain(def interface{}) ([]byte, error) {
-	if v, err := json.Marshal(def); err != nil {
+	if v, err := json.Marshal(syncFirstNodeIndex(def)); err != nil {
 		return nil, err
 	} else {
 		//格式化Json
@@ -64,6 +70,33 @@ func (p *JsonParser) EncodeRuleNode(def interface{}) ([]byte, error) {
 	}
 }
 
+// syncFirstNodeIndex 指定了firstNodeId时，同时输出该节点对应的firstNodeIndex，兼容只支持firstNodeIndex的旧版本
+// 不修改原规则链定义
+func syncFirstNodeIndex(def interface{}) interface{} {
+	var ruleChain types.RuleChain
+	switch v := def.(type) {
+	case *types.RuleChain:
+		if v == nil {
+			return def
+		}
+		ruleChain = *v
+	case types.RuleChain:
+		ruleChain = v
+	default:
+		return def
+	}
+	if ruleChain.Metadata.FirstNodeId == "" {
+		return def
+	}
+	for i, node := range ruleChain.Metadata.Nodes {
+		if node != nil && node.Id == ruleChain.Metadata.FirstNodeId {
+			ruleChain.Metadata.FirstNodeIndex = i
+			return ruleChain
+		}
+	}
+	return def
+}
+
 // ParserRuleChain 通过json解析规则链结构体
 func ParserRuleChain(rootRuleChain []byte) (types.RuleChain, error) {
 	var def types.RuleChain
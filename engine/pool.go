@@ -20,6 +20,7 @@ import (
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/utils/fs"
 	"log"
+	"sort"
 	"strings"
 	"sync"
 )
@@ -31,10 +32,28 @@ var DefaultPool = &Pool{}
 // Pool 规则引擎实例池
 type Pool struct {
 	entries sync.Map
+	//池中规则引擎的默认配置，为nil则使用 NewConfig()
+	config *types.Config
 }
 
-func NewPool() *Pool {
-	return &Pool{}
+// NewPool 创建一个独立的规则引擎实例池，池中的规则引擎只从该池查找子规则链，与全局 DefaultPool 互相隔离
+// config 可选，池中创建的规则引擎的默认配置，可以通过 Pool.New 的 WithConfig 选项覆盖。
+// 多租户场景可以为每个租户创建一个池，相同ID的规则链互不影响
+func NewPool(config ...types.Config) *Pool {
+	p := &Pool{}
+	if len(config) > 0 {
+		c := config[0]
+		p.config = &c
+	}
+	return p
+}
+
+// Config 获取池中规则引擎的默认配置，没有设置返回false
+func (g *Pool) Config() (types.Config, bool) {
+	if g.config == nil {
+		return types.Config{}, false
+	}
+	return *g.config, true
 }
 
 // Load 加载指定文件夹及其子文件夹所有规则链配置（与.json结尾文件），到规则引擎实例池
@@ -70,14 +89,16 @@ func (g *Pool) New(id string, rootRuleChainSrc []byte, opts ...types.RuleEngineO
 	if v, ok := g.entries.Load(id); ok {
 		return v.(*RuleEngine), nil
 	} else {
-		if ruleEngine, err := newRuleEngine(id, rootRuleChainSrc, opts...); err != nil {
+		if g.config != nil {
+			opts = append([]types.RuleEngineOption{WithConfig(*g.config)}, opts...)
+		}
+		if ruleEngine, err := newRuleEngine(id, rootRuleChainSrc, g, opts...); err != nil {
 			return nil, err
 		} else {
 			if ruleEngine.Id() != "" {
 				// Store the new RuleEngine in the entries map with the Id as the key.
 				g.entries.Store(ruleEngine.Id(), ruleEngine)
 			}
-			ruleEngine.RuleChainPool = g
 			return ruleEngine, err
 		}
 
@@ -119,6 +140,22 @@ func (g *Pool) Range(f func(key, value any) bool) {
 	g.entries.Range(f)
 }
 
+// ByTag 获取包含指定标签的规则引擎实例，按照规则链ID排序
+// 用于按照分组批量重新加载或者汇总指标，标签配置参考 TagsKey
+func (g *Pool) ByTag(tag string) []types.RuleEngine {
+	var items []types.RuleEngine
+	g.entries.Range(func(key, value any) bool {
+		if item, ok := value.(*RuleEngine); ok && item.rootRuleChainCtx != nil && item.rootRuleChainCtx.HasTag(tag) {
+			items = append(items, item)
+		}
+		return true
+	})
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Id() < items[j].Id()
+	})
+	return items
+}
+
 func (g *Pool) Reload(opts ...types.RuleEngineOption) {
 	g.entries.Range(func(key, value any) bool {
 		_ = value.(*RuleEngine).Reload(opts...)
@@ -177,7 +214,74 @@ func Reload(opts ...types.RuleEngineOption) {
 	})
 }
 
+// ByTag 获取包含指定标签的规则引擎实例
+func ByTag(tag string) []types.RuleEngine {
+	return DefaultPool.ByTag(tag)
+}
+
 // Range 遍历所有规则引擎实例
 func Range(f func(key, value any) bool) {
 	DefaultPool.entries.Range(f)
 }
+
+// emptyPool 没有可用的子规则链池，并且不允许使用 DefaultPool 时使用的空池
+var emptyPool = &Pool{}
+
+// fallbackPool 没有设置子规则链池时使用的池，types.Config.AllowDefaultPoolFallback=true 使用 DefaultPool，否则使用空池
+func fallbackPool(config types.Config) types.RuleEnginePool {
+	if config.AllowDefaultPoolFallback {
+		return DefaultPool
+	}
+	return emptyPool
+}
+
+// chainedPool 按顺序从多个规则链池查找规则引擎实例，其他操作使用第一个池
+type chainedPool struct {
+	types.RuleEnginePool
+	fallbacks []types.RuleEnginePool
+}
+
+func (p *chainedPool) Get(id string) (types.RuleEngine, bool) {
+	if e, ok := p.RuleEnginePool.Get(id); ok {
+		return e, true
+	}
+	for _, pool := range p.fallbacks {
+		if e, ok := pool.Get(id); ok {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// subChainPool 获取查找子规则链的池，依次从以下池查找：
+// 显式设置的 RuleChainPool、创建规则引擎的池、DefaultPool(需要 types.Config.AllowDefaultPoolFallback=true)
+func (e *RuleEngine) subChainPool() types.RuleEnginePool {
+	var pools []types.RuleEnginePool
+	candidates := []types.RuleEnginePool{e.RuleChainPool, e.createdPool}
+	if e.Config.AllowDefaultPoolFallback {
+		candidates = append(candidates, DefaultPool)
+	}
+	for _, pool := range candidates {
+		if pool != nil && !containsPool(pools, pool) {
+			pools = append(pools, pool)
+		}
+	}
+	switch len(pools) {
+	case 0:
+		return emptyPool
+	case 1:
+		return pools[0]
+	default:
+		return &chainedPool{RuleEnginePool: pools[0], fallbacks: pools[1:]}
+	}
+}
+
+// containsPool 池列表是否包含指定的池
+func containsPool(pools []types.RuleEnginePool, pool types.RuleEnginePool) bool {
+	for _, item := range pools {
+		if item == pool {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// ErrPoolExhausted 协程池已满，消息按照 types.Config.PoolOverflowPolicy 被拒绝
+var ErrPoolExhausted = errors.New("the worker pool is exhausted")
+
+// PoolStats 协程池溢出统计
+type PoolStats struct {
+	//Pending 当前因为协程池已满，阻塞等待或者在队列中等待提交的任务数
+	Pending int64
+	//Rejected 累计因为协程池已满被拒绝的任务数，每个被拒绝的任务结束一个消息分支
+	Rejected int64
+}
+
+// maxSubmitBackoff 协程池已满时重新提交任务的最大间隔
+const maxSubmitBackoff = time.Millisecond * 10
+
+// poolOverflow 协程池已满时，按照溢出策略阻塞、拒绝或者排队等待提交节点执行任务
+type poolOverflow struct {
+	policy     string
+	maxPending int
+	pending    int64
+	rejected   int64
+	lock       sync.Mutex
+	queue      []func()
+	//是否正在提交队列中的任务
+	draining bool
+}
+
+func newPoolOverflow(config types.Config) *poolOverflow {
+	return &poolOverflow{policy: config.PoolOverflowPolicy, maxPending: config.PoolMaxPending}
+}
+
+// submit 提交任务，blockable 调用方是否可以阻塞等待
+// 调用方是协程池中正在执行的任务时不能阻塞，否则所有任务互相等待空闲的协程导致死锁，这时由调用方直接执行该任务
+func (p *poolOverflow) submit(pool types.Pool, task func(), blockable bool) error {
+	if err := pool.Submit(task); err == nil {
+		return nil
+	}
+	switch p.policy {
+	case types.OverflowDrop:
+		atomic.AddInt64(&p.rejected, 1)
+		return ErrPoolExhausted
+	case types.OverflowQueue:
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if len(p.queue) >= p.maxPending {
+			atomic.AddInt64(&p.rejected, 1)
+			return ErrPoolExhausted
+		}
+		p.queue = append(p.queue, task)
+		atomic.AddInt64(&p.pending, 1)
+		if !p.draining {
+			p.draining = true
+			go p.drain(pool)
+		}
+		return nil
+	default:
+		if !blockable {
+			task()
+			return nil
+		}
+		atomic.AddInt64(&p.pending, 1)
+		defer atomic.AddInt64(&p.pending, -1)
+		for backoff := time.Millisecond; pool.Submit(task) != nil; {
+			time.Sleep(backoff)
+			if backoff < maxSubmitBackoff {
+				backoff *= 2
+			}
+		}
+		return nil
+	}
+}
+
+// drain 按顺序提交队列中的任务，直到队列为空
+func (p *poolOverflow) drain(pool types.Pool) {
+	backoff := time.Millisecond
+	for {
+		p.lock.Lock()
+		if len(p.queue) == 0 {
+			p.draining = false
+			p.lock.Unlock()
+			return
+		}
+		task := p.queue[0]
+		p.lock.Unlock()
+		if pool.Submit(task) != nil {
+			time.Sleep(backoff)
+			if backoff < maxSubmitBackoff {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = time.Millisecond
+		p.lock.Lock()
+		p.queue[0] = nil
+		p.queue = p.queue[1:]
+		p.lock.Unlock()
+		atomic.AddInt64(&p.pending, -1)
+	}
+}
+
+func (p *poolOverflow) stats() PoolStats {
+	return PoolStats{
+		Pending:  atomic.LoadInt64(&p.pending),
+		Rejected: atomic.LoadInt64(&p.rejected),
+	}
+}
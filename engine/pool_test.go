@@ -20,6 +20,7 @@ import (
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/test"
 	"github.com/rulego/rulego/test/assert"
+	"strings"
 	"testing"
 	"time"
 )
@@ -120,3 +121,143 @@ func TestRuleGo(t *testing.T) {
 	assert.Equal(t, false, ok)
 
 }
+
+// TestPoolByTag 测试按照标签获取规则引擎实例
+func TestPoolByTag(t *testing.T) {
+	pool := NewPool()
+	newChain := func(id, tags string) []byte {
+		return []byte(`{"ruleChain":{"id":"` + id + `","additionalInfo":{"tags":"` + tags + `"}},"metadata":{"nodes":[{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}}]}}`)
+	}
+	_, err := pool.New("", newChain("c2", "team:iot, env:prod"))
+	assert.Nil(t, err)
+	_, err = pool.New("", newChain("c1", "team:iot,env:dev"))
+	assert.Nil(t, err)
+	c3, err := pool.New("", newChain("c3", ""))
+	assert.Nil(t, err)
+
+	var ids []string
+	for _, item := range pool.ByTag("team:iot") {
+		ids = append(ids, item.Id())
+	}
+	assert.Equal(t, []string{"c1", "c2"}, ids)
+	assert.Equal(t, 1, len(pool.ByTag("env:prod")))
+	assert.Equal(t, 0, len(pool.ByTag("team")))
+
+	//附加标签，重新加载后保留
+	c3.RootRuleChainCtx().(*RuleChainCtx).SetAttribute(TagsKey, []string{"team:iot"})
+	assert.Equal(t, 3, len(pool.ByTag("team:iot")))
+	assert.Nil(t, c3.ReloadSelf(newChain("c3", "env:dev")))
+	assert.Equal(t, []string{"env:dev", "team:iot"}, c3.RootRuleChainCtx().(*RuleChainCtx).Tags())
+	assert.Equal(t, 2, len(pool.ByTag("env:dev")))
+	assert.Equal(t, 3, len(pool.ByTag("team:iot")))
+}
+
+func TestSubChainPoolResolution(t *testing.T) {
+	subDef := func(name string) []byte {
+		return []byte(`{"ruleChain":{"id":"sub"},"metadata":{"nodes":[{"id":"s1","type":"jsTransform","configuration":{"jsScript":"metadata['pool']='` + name + `';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}]}}`)
+	}
+	parentDef := func(targetId string) []byte {
+		return []byte(`{"ruleChain":{"id":"parent"},"metadata":{"nodes":[{"id":"s1","type":"flow","configuration":{"targetId":"` + targetId + `"}}]}}`)
+	}
+	resolve := func(ruleEngine types.RuleEngine) (string, error) {
+		var pool string
+		var resultErr error
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			pool = msg.Metadata.GetValue("pool")
+			resultErr = err
+		}))
+		return pool, resultErr
+	}
+	pool1, pool2 := NewPool(), NewPool()
+	defer pool1.Stop()
+	defer pool2.Stop()
+	_, err := pool1.New("sub", subDef("pool1"))
+	assert.Nil(t, err)
+	_, err = pool2.New("sub", subDef("pool2"))
+	assert.Nil(t, err)
+	parent1, err := pool1.New("parent", parentDef("sub"))
+	assert.Nil(t, err)
+	parent2, err := pool2.New("parent", parentDef("sub"))
+	assert.Nil(t, err)
+
+	//从创建规则引擎的池查找
+	name, err := resolve(parent1)
+	assert.Nil(t, err)
+	assert.Equal(t, "pool1", name)
+	name, err = resolve(parent2)
+	assert.Nil(t, err)
+	assert.Equal(t, "pool2", name)
+
+	//显式设置的池优先
+	assert.Nil(t, parent1.ReloadSelf(parentDef("sub"), WithRuleChainPool(pool2)))
+	name, _ = resolve(parent1)
+	assert.Equal(t, "pool2", name)
+
+	//默认不从DefaultPool查找
+	defaultSub, err := New("subInDefaultPool", subDef("default"))
+	assert.Nil(t, err)
+	defer Del(defaultSub.Id())
+	assert.Nil(t, parent2.ReloadSelf(parentDef("subInDefaultPool")))
+	_, err = resolve(parent2)
+	assert.NotNil(t, err)
+	assert.Nil(t, parent2.ReloadSelf(parentDef("subInDefaultPool"), WithConfig(NewConfig(types.WithAllowDefaultPoolFallback(true)))))
+	name, err = resolve(parent2)
+	assert.Nil(t, err)
+	assert.Equal(t, "default", name)
+}
+
+func TestIsolatedPools(t *testing.T) {
+	subDef := func(name string) []byte {
+		return []byte(`{"ruleChain":{"id":"sub"},"metadata":{"nodes":[{"id":"s1","type":"jsTransform","configuration":{"jsScript":"metadata['pool']='` + name + `';return {'msg':msg,'metadata':metadata,'msgType':msgType};"}}]}}`)
+	}
+	parentDef := []byte(`{"ruleChain":{"id":"parent"},"metadata":{"nodes":[{"id":"s1","type":"flow","configuration":{"targetId":"sub"}}]}}`)
+	resolve := func(ruleEngine types.RuleEngine) string {
+		var pool string
+		ruleEngine.OnMsgAndWait(types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			pool = msg.Metadata.GetValue("pool")
+		}))
+		return pool
+	}
+	pool1 := NewPool(NewConfig(types.WithMaxChainDepth(3)))
+	pool2 := NewPool(NewConfig(types.WithMaxChainDepth(5)))
+	defer pool1.Stop()
+	defer pool2.Stop()
+
+	sub1, err := pool1.New("sub", subDef("pool1"))
+	assert.Nil(t, err)
+	sub2, err := pool2.New("sub", subDef("pool2"))
+	assert.Nil(t, err)
+	parent1, err := pool1.New("parent", parentDef)
+	assert.Nil(t, err)
+	parent2, err := pool2.New("parent", parentDef)
+	assert.Nil(t, err)
+
+	//规则引擎继承池的配置
+	config, ok := pool1.Config()
+	assert.True(t, ok)
+	assert.Equal(t, 3, config.MaxChainDepth)
+	assert.Equal(t, 3, parent1.(*RuleEngine).Config.MaxChainDepth)
+	assert.Equal(t, 5, parent2.(*RuleEngine).Config.MaxChainDepth)
+	//选项覆盖池的配置
+	other, err := pool1.New("other", subDef("other"), WithConfig(NewConfig(types.WithMaxChainDepth(7))))
+	assert.Nil(t, err)
+	assert.Equal(t, 7, other.(*RuleEngine).Config.MaxChainDepth)
+	_, ok = NewPool().Config()
+	assert.False(t, ok)
+
+	//全局池不可见
+	_, ok = DefaultPool.Get("sub")
+	assert.False(t, ok)
+
+	assert.Equal(t, "pool1", resolve(parent1))
+	assert.Equal(t, "pool2", resolve(parent2))
+
+	//重新加载一个池的规则链，不影响另一个池
+	assert.Nil(t, sub1.ReloadSelf(subDef("pool1-v2")))
+	assert.Equal(t, "pool1-v2", resolve(parent1))
+	assert.Equal(t, "pool2", resolve(parent2))
+	assert.True(t, strings.Contains(string(sub2.DSL()), "metadata['pool']='pool2'"))
+
+	pool1.Reload()
+	assert.Equal(t, "pool2", resolve(parent2))
+}
@@ -0,0 +1,185 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// defaultPriorityBurst 默认连续执行更高优先级任务的最大次数
+const defaultPriorityBurst = 8
+
+// priorityLane 优先级对应的通道，没有指定或者无法识别的优先级使用普通优先级通道
+func priorityLane(priority string) int {
+	switch priority {
+	case types.PriorityHigh:
+		return 0
+	case types.PriorityLow:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// priorityDispatcher 按优先级分通道调度节点执行任务，最多同时执行workers个任务，
+// 优先执行高优先级通道的任务，连续执行burst个更高优先级的任务后，如果有等待的低优先级任务，则执行一个低优先级任务，避免饿死
+// 所有工作协程都在执行任务时，新的任务按照 types.Config.PoolOverflowPolicy 进入通道等待、阻塞调用方或者被拒绝
+type priorityDispatcher struct {
+	pool    types.Pool
+	workers int
+	burst   int
+	//溢出策略，记录等待和拒绝的任务数
+	overflow *poolOverflow
+	//通道中等待执行的最大任务数
+	maxPending int
+	lock       sync.Mutex
+	//任务出队时通知阻塞等待的调用方
+	cond  *sync.Cond
+	lanes [3][]func()
+	//通道中等待执行的任务数
+	queued  int
+	running int
+	//连续执行更高优先级任务的次数
+	consecutive int
+}
+
+// newPriorityDispatcher 创建优先级调度器，没有开启优先级调度返回nil
+// 通道中等待执行的最大任务数为 types.Config.PoolMaxPending，没有配置则为workers
+func newPriorityDispatcher(config types.Config, overflow *poolOverflow) *priorityDispatcher {
+	if config.PriorityWorkers <= 0 {
+		return nil
+	}
+	burst := config.PriorityBurst
+	if burst <= 0 {
+		burst = defaultPriorityBurst
+	}
+	maxPending := config.PoolMaxPending
+	if maxPending <= 0 && config.PoolOverflowPolicy != types.OverflowQueue {
+		maxPending = config.PriorityWorkers
+	}
+	if overflow == nil {
+		overflow = newPoolOverflow(config)
+	}
+	d := &priorityDispatcher{pool: config.Pool, workers: config.PriorityWorkers, burst: burst, overflow: overflow, maxPending: maxPending}
+	d.cond = sync.NewCond(&d.lock)
+	return d
+}
+
+// submit 把任务放入优先级对应的通道，有空闲的并发数则启动一个工作协程，被溢出策略拒绝返回 ErrPoolExhausted
+// blockable 调用方是否可以阻塞等待，不能阻塞的调用方(协程池中正在执行的任务)在 OverflowBlock 策略下直接进入通道，避免互相等待导致死锁
+func (d *priorityDispatcher) submit(task func(), priority string, blockable bool) error {
+	lane := priorityLane(priority)
+	d.lock.Lock()
+	if d.running >= d.workers {
+		if err := d.admit(blockable); err != nil {
+			d.lock.Unlock()
+			return err
+		}
+	}
+	d.lanes[lane] = append(d.lanes[lane], task)
+	d.queued++
+	atomic.AddInt64(&d.overflow.pending, 1)
+	if d.running >= d.workers {
+		d.lock.Unlock()
+		return nil
+	}
+	d.running++
+	d.lock.Unlock()
+	//工作协程数量不超过workers，协程池已满时使用新的协程执行
+	if d.pool == nil || d.pool.Submit(d.work) != nil {
+		go d.work()
+	}
+	return nil
+}
+
+// admit 所有工作协程都在执行任务时，按照溢出策略决定新的任务是否可以进入通道，需要持有锁
+func (d *priorityDispatcher) admit(blockable bool) error {
+	switch d.overflow.policy {
+	case types.OverflowDrop:
+		atomic.AddInt64(&d.overflow.rejected, 1)
+		return ErrPoolExhausted
+	case types.OverflowQueue:
+		if d.queued >= d.maxPending {
+			atomic.AddInt64(&d.overflow.rejected, 1)
+			return ErrPoolExhausted
+		}
+	default:
+		if blockable && d.queued >= d.maxPending {
+			atomic.AddInt64(&d.overflow.pending, 1)
+			for d.running >= d.workers && d.queued >= d.maxPending {
+				d.cond.Wait()
+			}
+			atomic.AddInt64(&d.overflow.pending, -1)
+		}
+	}
+	return nil
+}
+
+// work 按优先级执行通道中的任务，直到所有通道为空
+func (d *priorityDispatcher) work() {
+	for {
+		d.lock.Lock()
+		task := d.next()
+		if task == nil {
+			d.running--
+			d.lock.Unlock()
+			return
+		}
+		d.queued--
+		atomic.AddInt64(&d.overflow.pending, -1)
+		d.cond.Broadcast()
+		d.lock.Unlock()
+		task()
+	}
+}
+
+// next 取出下一个任务，需要持有锁
+func (d *priorityDispatcher) next() func() {
+	lane := -1
+	for i := range d.lanes {
+		if len(d.lanes[i]) > 0 {
+			lane = i
+			break
+		}
+	}
+	if lane < 0 {
+		return nil
+	}
+	//找到有等待任务的更低优先级通道
+	lower := -1
+	for i := lane + 1; i < len(d.lanes); i++ {
+		if len(d.lanes[i]) > 0 {
+			lower = i
+			break
+		}
+	}
+	if lower < 0 {
+		d.consecutive = 0
+	} else if d.consecutive >= d.burst {
+		lane = lower
+		d.consecutive = 0
+	} else {
+		d.consecutive++
+	}
+	task := d.lanes[lane][0]
+	d.lanes[lane][0] = nil
+	d.lanes[lane] = d.lanes[lane][1:]
+	return task
+}
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"sync"
+	"time"
+)
+
+// reloadCoalescer 合并短时间内连续的重新加载，只保留最后一个定义
+type reloadCoalescer struct {
+	//等待应用的规则链定义
+	pending []byte
+	//合并窗口定时器，每次入队重新计时
+	timer *time.Timer
+	sync.Mutex
+}
+
+// enqueue 保存等待应用的定义，替换之前未应用的定义，最后一次入队后经过window调用apply
+func (c *reloadCoalescer) enqueue(def []byte, window time.Duration, apply func(def []byte)) {
+	c.Lock()
+	defer c.Unlock()
+	c.pending = def
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(window, func() {
+		if def, ok := c.take(); ok {
+			apply(def)
+		}
+	})
+}
+
+// take 取出等待应用的定义并停止定时器，没有等待应用的定义返回false
+func (c *reloadCoalescer) take() ([]byte, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	def := c.pending
+	c.pending = nil
+	return def, def != nil
+}
+
+// applyPendingReload 应用合并窗口结束后的定义，错误通过Logger输出
+func (e *RuleEngine) applyPendingReload(def []byte) {
+	if err := e.reloadSelf(def); err != nil && e.Config.Logger != nil {
+		e.Config.Logger.Printf("rule chain %s: coalesced reload error: %s", e.id, err.Error())
+	}
+}
+
+// FlushReload 立即应用合并窗口中等待的定义，没有等待的定义返回nil
+func (e *RuleEngine) FlushReload() error {
+	if def, ok := e.pendingReload.take(); ok {
+		return e.reloadSelf(def)
+	}
+	return nil
+}
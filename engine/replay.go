@@ -0,0 +1,116 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/utils/dsl"
+)
+
+// ReplayKey 重放消息在元数据中的标记key，值为"true"，输出到外部系统的节点可以据此跳过副作用
+const ReplayKey = "replay"
+
+// ErrDefinitionChanged 规则链定义已经变化，与记录消息时的定义哈希不一致，参考 WithReplayForce
+var ErrDefinitionChanged = errors.New("the rule chain definition has changed since the message was recorded")
+
+// ReplayOption 重放消息选项
+type ReplayOption func(*replayOptions)
+
+type replayOptions struct {
+	nodeId         string
+	definitionHash string
+	force          bool
+	ctxOpts        []types.RuleContextOption
+}
+
+// WithReplayNode 从指定节点开始重放消息，默认从规则链第一个节点开始
+func WithReplayNode(nodeId string) ReplayOption {
+	return func(o *replayOptions) {
+		o.nodeId = nodeId
+	}
+}
+
+// WithReplayDefinitionHash 记录消息时的规则链定义哈希，例如：types.ExecutionTrace.DefinitionHash
+// 与当前规则链定义哈希不一致时拒绝重放，返回 ErrDefinitionChanged
+func WithReplayDefinitionHash(hash string) ReplayOption {
+	return func(o *replayOptions) {
+		o.definitionHash = hash
+	}
+}
+
+// WithReplayForce 规则链定义哈希不一致时仍然重放
+func WithReplayForce() ReplayOption {
+	return func(o *replayOptions) {
+		o.force = true
+	}
+}
+
+// WithReplayContextOptions 重放消息时使用的消息上下文选项，例如：types.WithOnEnd
+func WithReplayContextOptions(opts ...types.RuleContextOption) ReplayOption {
+	return func(o *replayOptions) {
+		o.ctxOpts = append(o.ctxOpts, opts...)
+	}
+}
+
+// DefinitionHash 当前规则链定义的哈希值，参考 dsl.DefinitionHash
+func (rc *RuleChainCtx) DefinitionHash() string {
+	return dsl.DefinitionHash(*rc.Definition())
+}
+
+// Replay 使用当前规则链重放一条记录的消息，同步执行并返回重放的执行轨迹，用于和记录的执行轨迹对比
+// 重放的消息元数据 ReplayKey 为"true"，不修改传入的消息
+func (e *RuleEngine) Replay(msg types.RuleMsg, opts ...ReplayOption) (types.ExecutionTrace, error) {
+	if !e.Initialized() {
+		return types.ExecutionTrace{}, errors.New("Replay error.RuleEngine not initialized")
+	}
+	var options replayOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.definitionHash != "" && !options.force {
+		if hash := e.rootRuleChainCtx.DefinitionHash(); hash != options.definitionHash {
+			return types.ExecutionTrace{}, fmt.Errorf("replay message %s: %w", msg.Id, ErrDefinitionChanged)
+		}
+	}
+	ctxOpts := options.ctxOpts[:len(options.ctxOpts):len(options.ctxOpts)]
+	if options.nodeId != "" {
+		if _, ok := e.rootRuleChainCtx.GetNodeById(types.RuleNodeId{Id: options.nodeId, Type: types.NODE}); !ok {
+			return types.ExecutionTrace{}, fmt.Errorf("replay message %s: node %s not found in rule chain %s", msg.Id, options.nodeId, e.id)
+		}
+		ctxOpts = append(ctxOpts, withResumeNode(options.nodeId))
+	}
+	var trace types.ExecutionTrace
+	ctxOpts = append(ctxOpts, types.WithTrace(func(ctx types.RuleContext, executionTrace types.ExecutionTrace) {
+		trace = executionTrace
+	}))
+	replayMsg := msg.Copy()
+	replayMsg.Metadata.PutValue(ReplayKey, "true")
+	e.OnMsgAndWait(replayMsg, ctxOpts...)
+	return trace, nil
+}
+
+// ReplayMsg 使用池中指定的规则链重放一条记录的消息，参考 RuleEngine.Replay
+func (g *Pool) ReplayMsg(chainId string, msg types.RuleMsg, opts ...ReplayOption) (types.ExecutionTrace, error) {
+	ruleEngine, ok := g.Get(chainId)
+	if !ok {
+		return types.ExecutionTrace{}, fmt.Errorf("rule chain %s not found", chainId)
+	}
+	return ruleEngine.(*RuleEngine).Replay(msg, opts...)
+}
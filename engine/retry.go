@@ -0,0 +1,135 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+const (
+	//RetryAttemptsKey 节点重试后，节点执行次数在消息元数据中的key，参考 types.RuleNode.Retry
+	RetryAttemptsKey = "retryAttempts"
+	//RetryLastErrorKey 节点重试次数用完后，最后一次执行的错误在消息元数据中的key
+	RetryLastErrorKey = "retryLastError"
+)
+
+// nodeRetry 节点一次执行的重试状态，每次重试创建新的状态
+type nodeRetry struct {
+	policy *types.RetryPolicy
+	//attempt 当前是第几次执行，从1开始
+	attempt int
+	//msg 节点第一次执行的原始消息
+	msg types.RuleMsg
+	//relationType 上一个节点通知该节点的关系类型
+	relationType string
+	//lastErr 上一次执行的错误
+	lastErr error
+	//told 节点是否已经通知下一个节点，只有第一次通知可以触发重试
+	told int32
+}
+
+// nodeRetryPolicy 节点的重试策略，没有配置或者最多只执行一次返回nil
+func nodeRetryPolicy(node types.NodeCtx) *types.RetryPolicy {
+	nodeCtx, ok := node.(*RuleNodeCtx)
+	if !ok || nodeCtx.SelfDefinition == nil {
+		return nil
+	}
+	if policy := nodeCtx.SelfDefinition.Retry; policy != nil && policy.MaxAttempts > 1 {
+		return policy
+	}
+	return nil
+}
+
+// retryable relationTypes 是否包含需要重试的关系类型
+func (r *nodeRetry) retryable(relationTypes []string) bool {
+	retryOn := r.policy.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = []string{types.Failure}
+	}
+	for _, relationType := range relationTypes {
+		for _, item := range retryOn {
+			if relationType == item {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// delay 当前执行失败后，等待下一次执行的时间
+func (r *nodeRetry) delay() time.Duration {
+	multiplier := r.policy.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	delay := float64(r.policy.InitialDelayMs) * math.Pow(multiplier, float64(r.attempt-1))
+	if maxDelay := float64(r.policy.MaxDelayMs); maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+	return time.Duration(delay) * time.Millisecond
+}
+
+// tellOrRetry 节点第一次通知需要重试的关系类型，并且还有重试次数时，延迟后重新执行节点，否则通知执行子节点
+func (ctx *DefaultRuleContext) tellOrRetry(msg types.RuleMsg, err error, defaultRelationType string, relationTypes ...string) {
+	r := ctx.retry
+	if r == nil || !atomic.CompareAndSwapInt32(&r.told, 0, 1) {
+		ctx.tellChildren(msg, err, defaultRelationType, relationTypes...)
+		return
+	}
+	if !r.retryable(relationTypes) || r.attempt >= r.policy.MaxAttempts {
+		if r.attempt > 1 {
+			if msg.Metadata == nil {
+				msg.Metadata = types.NewMetadata()
+			}
+			msg.Metadata.PutValue(RetryAttemptsKey, strconv.Itoa(r.attempt))
+			lastErr := err
+			if lastErr == nil {
+				lastErr = r.lastErr
+			}
+			if lastErr != nil {
+				msg.Metadata.PutValue(RetryLastErrorKey, lastErr.Error())
+			}
+		}
+		ctx.tellChildren(msg, err, defaultRelationType, relationTypes...)
+		return
+	}
+	//调试模式记录失败的执行
+	debugMsg := msg.Copy()
+	if debugMsg.Metadata == nil {
+		debugMsg.Metadata = types.NewMetadata()
+	}
+	debugMsg.Metadata.PutValue(RetryAttemptsKey, strconv.Itoa(r.attempt))
+	ctx.OnDebug(ctx.ruleChainCtx.GetNodeId().Id, types.Out, ctx.self.GetNodeId().Id, debugMsg, relationTypes[0], err)
+
+	next := &nodeRetry{policy: r.policy, attempt: r.attempt + 1, msg: r.msg, relationType: r.relationType, lastErr: err}
+	parent := ctx.parentRuleCtx
+	//通过定时器等待，不占用协程池的协程
+	time.AfterFunc(r.delay(), func() {
+		retryCtx := parent.NewNextNodeRuleContext(ctx.self)
+		retryCtx.retry = next
+		if submitErr := parent.submitNodeTask(func() {
+			retryCtx.execute(next.msg.Copy(), next.relationType)
+		}, false); submitErr != nil {
+			ctx.DoOnEnd(next.msg, submitErr, types.Failure)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"github.com/rulego/rulego/api/types"
+	"io"
+	"sync"
+)
+
+var _ types.SharedClientRegistry = (*SharedClientRegistry)(nil)
+
+// SharedClients 默认共享客户端注册表，NewConfig 默认使用该注册表
+var SharedClients = NewSharedClientRegistry()
+
+// sharedClient 共享客户端
+type sharedClient struct {
+	client interface{}
+	err    error
+	//引用计数
+	refs int
+	//创建完成后关闭
+	ready chan struct{}
+}
+
+// SharedClientRegistry 按照引用计数管理共享客户端的注册表，实现 types.SharedClientRegistry
+type SharedClientRegistry struct {
+	clients map[string]*sharedClient
+	lock    sync.Mutex
+}
+
+// NewSharedClientRegistry 创建共享客户端注册表
+func NewSharedClientRegistry() *SharedClientRegistry {
+	return &SharedClientRegistry{clients: make(map[string]*sharedClient)}
+}
+
+// GetSharedClient 获取共享客户端，引用计数加1，不存在则使用factory创建。
+// 相同key的并发获取只调用一次factory，创建期间不阻塞其他key的获取；创建失败返回错误并且不缓存
+func (r *SharedClientRegistry) GetSharedClient(kind, key string, factory func() (interface{}, error)) (interface{}, error) {
+	id := sharedClientId(kind, key)
+	r.lock.Lock()
+	item, ok := r.clients[id]
+	if ok {
+		item.refs++
+		r.lock.Unlock()
+		<-item.ready
+		return item.client, item.err
+	}
+	item = &sharedClient{refs: 1, ready: make(chan struct{})}
+	r.clients[id] = item
+	r.lock.Unlock()
+
+	item.client, item.err = factory()
+	if item.err != nil {
+		r.lock.Lock()
+		if r.clients[id] == item {
+			delete(r.clients, id)
+		}
+		r.lock.Unlock()
+	}
+	close(item.ready)
+	return item.client, item.err
+}
+
+// ReleaseSharedClient 释放一次引用，引用计数为0时关闭并移除客户端
+// 客户端正在创建时，等待创建完成后再关闭
+func (r *SharedClientRegistry) ReleaseSharedClient(kind, key string) {
+	id := sharedClientId(kind, key)
+	r.lock.Lock()
+	item, ok := r.clients[id]
+	if !ok {
+		r.lock.Unlock()
+		return
+	}
+	item.refs--
+	if item.refs > 0 {
+		r.lock.Unlock()
+		return
+	}
+	delete(r.clients, id)
+	r.lock.Unlock()
+	<-item.ready
+	if item.err == nil {
+		closeClient(item.client)
+	}
+}
+
+// Refs 获取共享客户端的引用计数，不存在返回0
+func (r *SharedClientRegistry) Refs(kind, key string) int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if item, ok := r.clients[sharedClientId(kind, key)]; ok {
+		return item.refs
+	}
+	return 0
+}
+
+// sharedClientId 共享客户端在注册表中的标识
+func sharedClientId(kind, key string) string {
+	return kind + "\x00" + key
+}
+
+// closeClient 关闭客户端，客户端实现了 io.Closer 或者 Close() 方法
+func closeClient(client interface{}) {
+	switch c := client.(type) {
+	case io.Closer:
+		_ = c.Close()
+	case interface{ Close() }:
+		c.Close()
+	}
+}
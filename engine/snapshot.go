@@ -0,0 +1,157 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/utils/str"
+)
+
+// ChainSnapshot 规则链只读快照，用于管理界面轮询展示规则链结构
+// 快照会被缓存并在多个调用方之间共享，调用方不能修改快照的内容
+type ChainSnapshot struct {
+	//Id 规则链ID
+	Id string
+	//Name 规则链名称
+	Name string
+	//DebugMode 规则链是否调试模式，包括 SetDebugMode 设置的调试模式
+	DebugMode bool
+	//Nodes 节点列表，按节点定义顺序排列
+	Nodes []NodeSnapshot
+	//Connections 节点之间以及节点到子规则链的连接，按上一个节点的定义顺序排列
+	Connections []ConnectionSnapshot
+	//VarKeys 规则链声明的vars变量名称，按名称排序
+	VarKeys []string
+	//SecretKeys 规则链声明的secrets名称，按名称排序，不包含secret的值
+	SecretKeys []string
+	//Empty 规则链是否没有任何节点
+	Empty bool
+	//Degraded 是否存在初始化失败、以降级模式运行的节点
+	Degraded bool
+}
+
+// NodeSnapshot 节点只读快照
+type NodeSnapshot struct {
+	//Id 节点ID
+	Id string
+	//Type 节点类型
+	Type string
+	//Name 节点名称
+	Name string
+	//Configuration 节点实际使用的配置副本，secret明文替换为 SecretMask
+	Configuration types.Configuration
+	//Degraded 节点是否初始化失败、以降级模式运行
+	Degraded bool
+	//Err 节点初始化错误，非降级节点为空
+	Err string
+}
+
+// ConnectionSnapshot 连接只读快照
+type ConnectionSnapshot struct {
+	//FromId 上一个节点ID
+	FromId string
+	//ToId 下一个节点ID或者子规则链ID
+	ToId string
+	//ToChain 下一个节点是否是子规则链
+	ToChain bool
+	//Type 关系类型
+	Type string
+	//Priority 连接优先级
+	Priority int
+}
+
+// snapshotCache 规则链快照缓存
+type snapshotCache struct {
+	//缓存对应的定义版本
+	version  uint64
+	snapshot *ChainSnapshot
+}
+
+// Snapshot 获取规则链只读快照，快照在读锁内构建，可以在重新加载期间安全调用
+// 快照按定义版本缓存，定义不变时重复调用只复制调试模式，返回的快照不能修改
+func (rc *RuleChainCtx) Snapshot() ChainSnapshot {
+	version := atomic.LoadUint64(&rc.dslVersion)
+	cache, ok := rc.snapshotCache.Load().(snapshotCache)
+	if !ok || cache.version != version {
+		cache = snapshotCache{version: version, snapshot: rc.buildSnapshot()}
+		rc.snapshotCache.Store(cache)
+	}
+	snapshot := *cache.snapshot
+	snapshot.DebugMode = rc.IsDebugMode()
+	return snapshot
+}
+
+// buildSnapshot 在读锁内构建规则链快照
+func (rc *RuleChainCtx) buildSnapshot() *ChainSnapshot {
+	rc.RLock()
+	defer rc.RUnlock()
+	snapshot := &ChainSnapshot{
+		Id:    rc.Id.Id,
+		Empty: rc.isEmpty,
+	}
+	if rc.SelfDefinition != nil {
+		snapshot.Name = rc.SelfDefinition.RuleChain.Name
+		if configuration := rc.SelfDefinition.RuleChain.Configuration; configuration != nil {
+			snapshot.VarKeys = sortedKeys(str.ToStringMapString(configuration[types.Vars]))
+			snapshot.SecretKeys = sortedKeys(str.ToStringMapString(configuration[types.Secrets]))
+		}
+	}
+	for _, id := range rc.nodeIds {
+		nodeCtx, ok := rc.nodes[id].(*RuleNodeCtx)
+		if !ok {
+			continue
+		}
+		node := NodeSnapshot{
+			Id:            id.Id,
+			Type:          nodeCtx.SelfDefinition.Type,
+			Name:          nodeCtx.SelfDefinition.Name,
+			Configuration: nodeCtx.EffectiveConfig(),
+		}
+		if degraded, ok := nodeCtx.Node.(*degradedNode); ok {
+			node.Degraded = true
+			node.Err = degraded.err.Error()
+			snapshot.Degraded = true
+		}
+		snapshot.Nodes = append(snapshot.Nodes, node)
+		for _, item := range rc.nodeRoutes[id] {
+			snapshot.Connections = append(snapshot.Connections, ConnectionSnapshot{
+				FromId:   item.InId.Id,
+				ToId:     item.OutId.Id,
+				ToChain:  item.OutId.Type == types.CHAIN,
+				Type:     item.RelationType,
+				Priority: item.Priority,
+			})
+		}
+	}
+	return snapshot
+}
+
+// sortedKeys 返回按名称排序的key列表
+func sortedKeys(m map[string]string) []string {
+	if len(m) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ChainStats 规则链消息统计快照
+type ChainStats struct {
+	//Received 累计进入规则链的消息数
+	Received int64
+	//Completed 累计没有错误结束的分支数，每个分支结束触发一次OnEnd
+	Completed int64
+	//Failed 累计有错误结束的分支数，包括规则链拒绝处理的消息
+	Failed int64
+	//DebugDropped 累计被采样策略丢弃的调试事件数，大于0表示调试回调和执行轨迹是不完整的，参考 types.DebugSampling
+	DebugDropped int64
+	//Expired 累计超过有效期停止执行的分支数，这些分支同时计入Failed，参考 types.TTLKey
+	Expired int64
+	//Nodes 节点ID->关系类型->节点通过该关系输出的消息数
+	Nodes map[string]map[string]int64
+	//Latency 节点ID->节点执行耗时分布
+	Latency map[string]LatencyStats
+	//LastResetTime 最后一次重置统计的时间
+	//创建规则链、全量重新加载规则链(ReloadSelf)或者调用ResetStats时重置，ReloadChild不重置
+	LastResetTime time.Time
+}
+
+// chainStats 规则链消息计数器
+type chainStats struct {
+	received  int64
+	completed int64
+	failed    int64
+	//被采样丢弃的调试事件数
+	debugDropped int64
+	//超过有效期停止执行的分支数
+	expired int64
+	lock    sync.RWMutex
+	//节点ID->关系类型->计数器
+	nodes map[string]map[string]*int64
+	//节点ID->执行耗时直方图
+	latency       map[string]*latencyHistogram
+	lastResetTime time.Time
+}
+
+func (s *chainStats) incReceived() {
+	atomic.AddInt64(&s.received, 1)
+}
+
+func (s *chainStats) incEnd(err error) {
+	if err == nil {
+		atomic.AddInt64(&s.completed, 1)
+	} else {
+		atomic.AddInt64(&s.failed, 1)
+	}
+}
+
+func (s *chainStats) incDebugDropped() {
+	atomic.AddInt64(&s.debugDropped, 1)
+}
+
+func (s *chainStats) incExpired() {
+	atomic.AddInt64(&s.expired, 1)
+}
+
+// incRelation 节点通过relationType输出一条消息
+func (s *chainStats) incRelation(nodeId, relationType string) {
+	s.lock.RLock()
+	counter := s.nodes[nodeId][relationType]
+	s.lock.RUnlock()
+	if counter == nil {
+		s.lock.Lock()
+		if s.nodes == nil {
+			s.nodes = make(map[string]map[string]*int64)
+		}
+		relations, ok := s.nodes[nodeId]
+		if !ok {
+			relations = make(map[string]*int64)
+			s.nodes[nodeId] = relations
+		}
+		if counter = relations[relationType]; counter == nil {
+			counter = new(int64)
+			relations[relationType] = counter
+		}
+		s.lock.Unlock()
+	}
+	atomic.AddInt64(counter, 1)
+}
+
+// observeLatency 记录节点一次执行耗时
+func (s *chainStats) observeLatency(nodeId string, d time.Duration) {
+	s.lock.RLock()
+	histogram := s.latency[nodeId]
+	s.lock.RUnlock()
+	if histogram == nil {
+		s.lock.Lock()
+		if s.latency == nil {
+			s.latency = make(map[string]*latencyHistogram)
+		}
+		if histogram = s.latency[nodeId]; histogram == nil {
+			histogram = &latencyHistogram{}
+			s.latency[nodeId] = histogram
+		}
+		s.lock.Unlock()
+	}
+	histogram.observe(d)
+}
+
+func (s *chainStats) reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	atomic.StoreInt64(&s.received, 0)
+	atomic.StoreInt64(&s.completed, 0)
+	atomic.StoreInt64(&s.failed, 0)
+	atomic.StoreInt64(&s.debugDropped, 0)
+	atomic.StoreInt64(&s.expired, 0)
+	s.nodes = nil
+	s.latency = nil
+	s.lastResetTime = time.Now()
+}
+
+func (s *chainStats) snapshot() ChainStats {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	nodes := make(map[string]map[string]int64, len(s.nodes))
+	for nodeId, relations := range s.nodes {
+		item := make(map[string]int64, len(relations))
+		for relationType, counter := range relations {
+			item[relationType] = atomic.LoadInt64(counter)
+		}
+		nodes[nodeId] = item
+	}
+	latency := make(map[string]LatencyStats, len(s.latency))
+	for nodeId, histogram := range s.latency {
+		latency[nodeId] = histogram.snapshot()
+	}
+	return ChainStats{
+		Received:      atomic.LoadInt64(&s.received),
+		Completed:     atomic.LoadInt64(&s.completed),
+		Failed:        atomic.LoadInt64(&s.failed),
+		DebugDropped:  atomic.LoadInt64(&s.debugDropped),
+		Expired:       atomic.LoadInt64(&s.expired),
+		Nodes:         nodes,
+		Latency:       latency,
+		LastResetTime: s.lastResetTime,
+	}
+}
+
+// LatencyStats 节点执行耗时分布，分位数是按照固定桶估算的上界
+// 耗时从节点开始执行到节点第一次通知下一个节点，包括异步组件等待的时间
+type LatencyStats struct {
+	//Count 统计的执行次数
+	Count int64
+	//P50 50分位耗时估算
+	P50 time.Duration
+	//P95 95分位耗时估算
+	P95 time.Duration
+	//P99 99分位耗时估算
+	P99 time.Duration
+	//Max 最大耗时
+	Max time.Duration
+}
+
+// latencyBuckets 耗时直方图桶的上界，超过最后一个上界的耗时记录在溢出桶
+var latencyBuckets = [...]time.Duration{
+	50 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// latencyHistogram 固定桶耗时直方图，记录一次耗时只需要几次原子操作
+type latencyHistogram struct {
+	buckets [len(latencyBuckets) + 1]int64
+	max     int64
+}
+
+func (h *latencyHistogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBuckets) && d > latencyBuckets[i] {
+		i++
+	}
+	atomic.AddInt64(&h.buckets[i], 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if int64(d) <= max || atomic.CompareAndSwapInt64(&h.max, max, int64(d)) {
+			return
+		}
+	}
+}
+
+func (h *latencyHistogram) snapshot() LatencyStats {
+	var buckets [len(latencyBuckets) + 1]int64
+	var count int64
+	for i := range h.buckets {
+		buckets[i] = atomic.LoadInt64(&h.buckets[i])
+		count += buckets[i]
+	}
+	max := time.Duration(atomic.LoadInt64(&h.max))
+	quantile := func(q float64) time.Duration {
+		rank := int64(q*float64(count) + 0.5)
+		if rank < 1 {
+			rank = 1
+		}
+		var cumulative int64
+		for i, n := range buckets {
+			cumulative += n
+			if cumulative >= rank {
+				if i < len(latencyBuckets) && latencyBuckets[i] < max {
+					return latencyBuckets[i]
+				}
+				return max
+			}
+		}
+		return max
+	}
+	stats := LatencyStats{Count: count, Max: max}
+	if count > 0 {
+		stats.P50, stats.P95, stats.P99 = quantile(0.5), quantile(0.95), quantile(0.99)
+	}
+	return stats
+}
@@ -0,0 +1,120 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// messageTracer 收集一条消息的执行轨迹树，参考 types.WithTrace
+type messageTracer struct {
+	onTrace     func(ctx types.RuleContext, trace types.ExecutionTrace)
+	maxDataSize int
+	root        *types.TraceNode
+	lock        sync.Mutex
+}
+
+func newMessageTracer(onTrace func(ctx types.RuleContext, trace types.ExecutionTrace), maxDataSize int) *messageTracer {
+	return &messageTracer{onTrace: onTrace, maxDataSize: maxDataSize}
+}
+
+// enter 消息流入节点，parent为nil表示第一个节点
+func (t *messageTracer) enter(parent *types.TraceNode, nodeId string, msg types.RuleMsg, relationType string) *types.TraceNode {
+	node := &types.TraceNode{
+		NodeId:       nodeId,
+		RelationType: relationType,
+		StartTs:      time.Now().UnixMilli(),
+		InMsg:        t.snapshot(msg),
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if parent == nil {
+		if t.root == nil {
+			t.root = node
+		}
+	} else {
+		parent.Children = append(parent.Children, node)
+	}
+	return node
+}
+
+// exit 消息流出节点，节点可能多次流出
+func (t *messageTracer) exit(node *types.TraceNode, msg types.RuleMsg, relationType string, err error) {
+	if node == nil {
+		return
+	}
+	output := types.TraceOutput{
+		RelationType: relationType,
+		Ts:           time.Now().UnixMilli(),
+		Msg:          t.snapshot(msg),
+	}
+	if err != nil {
+		output.Err = err.Error()
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	node.Outputs = append(node.Outputs, output)
+	node.EndTs = output.Ts
+}
+
+// complete 消息所有节点执行完成，回调执行轨迹
+func (t *messageTracer) complete(ctx types.RuleContext, chainId, definitionHash, msgId string, startTs int64) {
+	t.lock.Lock()
+	root := t.root
+	t.lock.Unlock()
+	t.onTrace(ctx, types.ExecutionTrace{
+		ChainId:        chainId,
+		MsgId:          msgId,
+		StartTs:        startTs,
+		EndTs:          time.Now().UnixMilli(),
+		DefinitionHash: definitionHash,
+		Root:           root,
+	})
+}
+
+// snapshot 创建消息快照，数据和元数据值超过maxDataSize的部分被截断
+func (t *messageTracer) snapshot(msg types.RuleMsg) types.TraceMsg {
+	traceMsg := types.TraceMsg{
+		Type:     msg.Type,
+		DataType: msg.DataType,
+		Metadata: make(map[string]string, len(msg.Metadata)),
+	}
+	var truncated bool
+	traceMsg.Data, truncated = truncate(msg.Data, t.maxDataSize)
+	traceMsg.Truncated = truncated
+	for k, v := range msg.Metadata {
+		traceMsg.Metadata[k], truncated = truncate(v, t.maxDataSize)
+		traceMsg.Truncated = traceMsg.Truncated || truncated
+	}
+	return traceMsg
+}
+
+// truncate 截断超过maxSize字节的字符串并添加截断标记，不截断多字节字符，maxSize<=0不截断
+func truncate(value string, maxSize int) (string, bool) {
+	if maxSize <= 0 || len(value) <= maxSize {
+		return value, false
+	}
+	end := maxSize
+	for end > 0 && !utf8.RuneStart(value[end]) {
+		end--
+	}
+	return value[:end] + types.TraceTruncatedMarker, true
+}
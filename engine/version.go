@@ -0,0 +1,116 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrVersionNotFound 回滚的规则链版本不存在或者已经被丢弃
+var ErrVersionNotFound = errors.New("rule chain version not found")
+
+// ChainVersion 规则链历史定义，重新加载前记录被替换的定义
+type ChainVersion struct {
+	//Version 版本号，同一个规则链实例内递增
+	Version int `json:"version"`
+	//Ts 被替换的时间，单位毫秒
+	Ts int64 `json:"ts"`
+	//Def 规则链定义
+	Def []byte `json:"def"`
+}
+
+// versionHistory 规则链历史定义列表，按照版本号升序，最多保留 Config.MaxVersions 个
+type versionHistory struct {
+	versions []ChainVersion
+	seq      int
+	sync.Mutex
+}
+
+// add 记录一个历史定义，超过max个则丢弃最旧的定义
+func (h *versionHistory) add(def []byte, max int) {
+	h.Lock()
+	defer h.Unlock()
+	h.seq++
+	h.versions = append(h.versions, ChainVersion{Version: h.seq, Ts: time.Now().UnixMilli(), Def: def})
+	if n := len(h.versions) - max; n > 0 {
+		h.versions = append([]ChainVersion{}, h.versions[n:]...)
+	}
+}
+
+// list 获取所有历史定义的副本
+func (h *versionHistory) list() []ChainVersion {
+	h.Lock()
+	defer h.Unlock()
+	return append([]ChainVersion{}, h.versions...)
+}
+
+// get 获取指定版本的历史定义
+func (h *versionHistory) get(version int) (ChainVersion, bool) {
+	h.Lock()
+	defer h.Unlock()
+	for _, item := range h.versions {
+		if item.Version == version {
+			return item, true
+		}
+	}
+	return ChainVersion{}, false
+}
+
+// recordVersion 重新加载前记录当前的规则链定义，新的定义解析失败也会记录
+func (rc *RuleChainCtx) recordVersion() {
+	if rc.config.MaxVersions > 0 && rc.initialized && rc.SelfDefinition != nil {
+		rc.versions.add(rc.DSL(), rc.config.MaxVersions)
+	}
+}
+
+// ListVersions 获取重新加载前记录的规则链历史定义，按照版本号升序
+func (rc *RuleChainCtx) ListVersions() []ChainVersion {
+	return rc.versions.list()
+}
+
+// Rollback 使用指定版本的历史定义重新加载规则链，与 ReloadSelf 一样执行重新加载切面，
+// 回滚前的定义同样被记录为新的版本
+func (rc *RuleChainCtx) Rollback(version int) error {
+	item, ok := rc.versions.get(version)
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	return rc.ReloadSelf(item.Def)
+}
+
+// ListVersions 获取根规则链的历史定义，参考 RuleChainCtx.ListVersions
+func (e *RuleEngine) ListVersions() []ChainVersion {
+	if e.rootRuleChainCtx == nil {
+		return nil
+	}
+	return e.rootRuleChainCtx.ListVersions()
+}
+
+// Rollback 把根规则链回滚到指定版本的历史定义，参考 RuleChainCtx.Rollback
+func (e *RuleEngine) Rollback(version int) error {
+	if !e.Initialized() {
+		return errors.New("Rollback error.RuleEngine not initialized")
+	}
+	item, ok := e.rootRuleChainCtx.versions.get(version)
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrVersionNotFound, version)
+	}
+	return e.ReloadSelfNow(item.Def)
+}
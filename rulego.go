@@ -109,6 +109,7 @@
 package rulego
 
 import (
+	"context"
 	"github.com/rulego/rulego/api/types"
 	"github.com/rulego/rulego/builtin/aspect"
 	"github.com/rulego/rulego/endpoint"
@@ -173,11 +174,33 @@ func (g *RuleGo) Stop() {
 	g.ruleEnginePool.Stop()
 }
 
+// ReplayMsg replays a recorded message against the current definition of the specified rule chain.
+// The replayed message is marked with the engine.ReplayKey metadata, and the execution trace of the replay is returned.
+func ReplayMsg(chainId string, msg types.RuleMsg, opts ...engine.ReplayOption) (types.ExecutionTrace, error) {
+	return Rules.ReplayMsg(chainId, msg, opts...)
+}
+
 // Range iterates over all rule engine instances.
 func (g *RuleGo) Range(f func(key, value any) bool) {
 	g.ruleEnginePool.Range(f)
 }
 
+// ByTag returns the rule engine instances whose rule chain has the specified tag, sorted by ID.
+// See engine.TagsKey for how tags are configured.
+func (g *RuleGo) ByTag(tag string) []types.RuleEngine {
+	return g.ruleEnginePool.ByTag(tag)
+}
+
+// HealthCheck checks the health of the nodes of all rule engine instances, see engine.Pool.HealthCheck.
+func (g *RuleGo) HealthCheck(ctx context.Context) engine.PoolHealth {
+	return g.ruleEnginePool.HealthCheck(ctx)
+}
+
+// ReplayMsg replays a recorded message against the current definition of the specified rule chain, see engine.RuleEngine.Replay.
+func (g *RuleGo) ReplayMsg(chainId string, msg types.RuleMsg, opts ...engine.ReplayOption) (types.ExecutionTrace, error) {
+	return g.ruleEnginePool.ReplayMsg(chainId, msg, opts...)
+}
+
 // Reload reloads all rule engine instances.
 func (g *RuleGo) Reload(opts ...types.RuleEngineOption) {
 	g.ruleEnginePool.Reload(opts...)
@@ -234,6 +257,17 @@ func Reload(opts ...types.RuleEngineOption) {
 	})
 }
 
+// ByTag returns the rule engine instances whose rule chain has the specified tag.
+func ByTag(tag string) []types.RuleEngine {
+	return Rules.ByTag(tag)
+}
+
+// HealthCheck checks the health of the nodes of all rule engine instances.
+// It can be used as a readiness probe: PoolHealth.Healthy() returns false if any node is down.
+func HealthCheck(ctx context.Context) engine.PoolHealth {
+	return Rules.HealthCheck(ctx)
+}
+
 // Range iterates over all rule engine instances.
 func Range(f func(key, value any) bool) {
 	Rules.Range(f)
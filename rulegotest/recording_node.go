@@ -0,0 +1,106 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rulegotest
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/engine"
+	"github.com/rulego/rulego/utils/maps"
+)
+
+// RecordingNodeType 记录节点的组件类型
+const RecordingNodeType = "rulegotest/recording"
+
+// 注册节点
+func init() {
+	_ = engine.Registry.Register(&RecordingNode{})
+}
+
+// RecordingNodeConfiguration 记录节点配置
+type RecordingNodeConfiguration struct {
+	//RelationType 记录消息后通过该关系发送到下一个节点，默认Success
+	RelationType string
+	//Error 不为空则记录消息后通过Failure关系发送该错误，优先于RelationType
+	Error string
+}
+
+// RecordingNode 记录收到的消息的模拟节点，用于测试规则链路由
+// 通过 RecordedMessages 获取规则链中记录节点收到的消息
+type RecordingNode struct {
+	//节点配置
+	Config RecordingNodeConfiguration
+	lock   sync.Mutex
+	msgs   []types.RuleMsg
+}
+
+// Type 组件类型
+func (x *RecordingNode) Type() string {
+	return RecordingNodeType
+}
+
+func (x *RecordingNode) New() types.Node {
+	return &RecordingNode{Config: RecordingNodeConfiguration{RelationType: types.Success}}
+}
+
+// Init 初始化
+func (x *RecordingNode) Init(ruleConfig types.Config, configuration types.Configuration) error {
+	return maps.Map2Struct(configuration, &x.Config)
+}
+
+// OnMsg 处理消息
+func (x *RecordingNode) OnMsg(ctx types.RuleContext, msg types.RuleMsg) {
+	x.lock.Lock()
+	x.msgs = append(x.msgs, msg.Copy())
+	x.lock.Unlock()
+	if x.Config.Error != "" {
+		ctx.TellFailure(msg, errors.New(x.Config.Error))
+	} else {
+		ctx.TellNext(msg, x.Config.RelationType)
+	}
+}
+
+// Destroy 销毁
+func (x *RecordingNode) Destroy() {
+}
+
+// Messages 节点收到的消息，按收到的顺序
+func (x *RecordingNode) Messages() []types.RuleMsg {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	return append([]types.RuleMsg{}, x.msgs...)
+}
+
+// RecordedMessages 规则链中记录节点收到的消息，节点不存在或者不是记录节点返回nil
+func RecordedMessages(ruleEngine types.RuleEngine, nodeId string) []types.RuleMsg {
+	chainCtx, ok := ruleEngine.RootRuleChainCtx().(*engine.RuleChainCtx)
+	if !ok {
+		return nil
+	}
+	nodeCtx, ok := chainCtx.GetNodeById(types.RuleNodeId{Id: nodeId, Type: types.NODE})
+	if !ok {
+		return nil
+	}
+	if ruleNodeCtx, ok := nodeCtx.(*engine.RuleNodeCtx); ok {
+		if node, ok := ruleNodeCtx.Node.(*RecordingNode); ok {
+			return node.Messages()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package rulegotest 规则链测试工具：记录消息的模拟节点、执行消息并等待结束的 RunAndWait 和执行结果断言
+//
+//	result := rulegotest.RunAndWait(ruleEngine, msg, time.Second)
+//	result.AssertReached(t, "s2")
+//	result.AssertRelation(t, "s1", types.True)
+//	result.AssertMetadata(t, "status", "ok")
+package rulegotest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rulego/rulego/api/types"
+)
+
+// End 规则链分支执行结束的结果，每个分支触发一次OnEnd
+type End struct {
+	Msg          types.RuleMsg
+	RelationType string
+	Err          error
+}
+
+// Step 消息经过的一个节点
+type Step struct {
+	//NodeId 节点ID
+	NodeId string
+	//RelationType 上一个节点通知该节点的关系类型，第一个节点为空
+	RelationType string
+	//InMsg 流入节点的消息
+	InMsg types.TraceMsg
+	//Outputs 节点的输出，一个节点可以输出多次
+	Outputs []types.TraceOutput
+}
+
+// Result 消息执行结果
+type Result struct {
+	//Ends 所有分支的结束结果，按结束顺序
+	Ends []End
+	//Steps 消息经过的节点，按执行轨迹树深度优先顺序
+	Steps []Step
+	//Trace 消息执行轨迹树，参考 types.WithTrace
+	Trace types.ExecutionTrace
+	//TimedOut 等待超时，结果可能不完整
+	TimedOut bool
+}
+
+// RunAndWait 执行消息并等待所有节点执行完成，最多等待timeout
+// 通过 types.WithTrace 收集消息经过的节点，不需要开启调试模式
+func RunAndWait(ruleEngine types.RuleEngine, msg types.RuleMsg, timeout time.Duration, opts ...types.RuleContextOption) *Result {
+	result := &Result{}
+	var lock sync.Mutex
+	done := make(chan struct{})
+	opts = append(opts,
+		types.WithOnEnd(func(ctx types.RuleContext, msg types.RuleMsg, err error, relationType string) {
+			lock.Lock()
+			defer lock.Unlock()
+			result.Ends = append(result.Ends, End{Msg: msg, RelationType: relationType, Err: err})
+		}),
+		types.WithTrace(func(ctx types.RuleContext, trace types.ExecutionTrace) {
+			lock.Lock()
+			defer lock.Unlock()
+			result.Trace = trace
+		}),
+		types.WithOnAllNodeCompleted(func() {
+			close(done)
+		}),
+	)
+	ruleEngine.OnMsg(msg, opts...)
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	//超时后返回的结果不再被回调修改
+	completed := &Result{Ends: append([]End{}, result.Ends...), Trace: result.Trace}
+	select {
+	case <-done:
+		completed.Steps = flatten(completed.Trace.Root, nil)
+	default:
+		completed.TimedOut = true
+	}
+	return completed
+}
+
+// flatten 按深度优先顺序展开执行轨迹树
+func flatten(node *types.TraceNode, steps []Step) []Step {
+	if node == nil {
+		return steps
+	}
+	steps = append(steps, Step{NodeId: node.NodeId, RelationType: node.RelationType, InMsg: node.InMsg, Outputs: node.Outputs})
+	for _, child := range node.Children {
+		steps = flatten(child, steps)
+	}
+	return steps
+}
+
+// Reached 消息是否经过该节点
+func (r *Result) Reached(nodeId string) bool {
+	for _, step := range r.Steps {
+		if step.NodeId == nodeId {
+			return true
+		}
+	}
+	return false
+}
+
+// Relations 节点输出的关系类型，节点执行多次时包括每次的输出
+func (r *Result) Relations(nodeId string) []string {
+	var relationTypes []string
+	for _, step := range r.Steps {
+		if step.NodeId == nodeId {
+			for _, output := range step.Outputs {
+				relationTypes = append(relationTypes, output.RelationType)
+			}
+		}
+	}
+	return relationTypes
+}
+
+// AssertCompleted 断言所有节点在超时时间内执行完成
+func (r *Result) AssertCompleted(t testing.TB) {
+	t.Helper()
+	if r.TimedOut {
+		t.Errorf("message not completed in time, %d branches ended", len(r.Ends))
+	}
+}
+
+// AssertReached 断言消息经过该节点
+func (r *Result) AssertReached(t testing.TB, nodeId string) {
+	t.Helper()
+	if !r.Reached(nodeId) {
+		t.Errorf("node %s not reached, reached nodes: %v", nodeId, r.nodeIds())
+	}
+}
+
+// AssertNotReached 断言消息没有经过该节点
+func (r *Result) AssertNotReached(t testing.TB, nodeId string) {
+	t.Helper()
+	if r.Reached(nodeId) {
+		t.Errorf("node %s reached", nodeId)
+	}
+}
+
+// AssertRelation 断言节点通过该关系输出
+func (r *Result) AssertRelation(t testing.TB, nodeId string, relationType string) {
+	t.Helper()
+	relationTypes := r.Relations(nodeId)
+	for _, item := range relationTypes {
+		if item == relationType {
+			return
+		}
+	}
+	t.Errorf("node %s relation %s not found, relations: %v", nodeId, relationType, relationTypes)
+}
+
+// AssertMetadata 断言至少一个分支结束时的消息元数据key的值等于value
+func (r *Result) AssertMetadata(t testing.TB, key, value string) {
+	t.Helper()
+	var values []string
+	for _, end := range r.Ends {
+		v := end.Msg.Metadata.GetValue(key)
+		if v == value {
+			return
+		}
+		values = append(values, v)
+	}
+	t.Errorf("metadata %s=%s not found, values: %v", key, value, values)
+}
+
+// AssertNoError 断言所有分支结束时没有错误
+func (r *Result) AssertNoError(t testing.TB) {
+	t.Helper()
+	for _, end := range r.Ends {
+		if end.Err != nil {
+			t.Errorf("branch ended with relation %s error: %s", end.RelationType, end.Err)
+		}
+	}
+}
+
+// nodeIds 消息经过的节点ID列表
+func (r *Result) nodeIds() []string {
+	var nodeIds []string
+	for _, step := range r.Steps {
+		nodeIds = append(nodeIds, step.NodeId)
+	}
+	return nodeIds
+}
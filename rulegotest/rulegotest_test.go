@@ -0,0 +1,99 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rulegotest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/engine"
+	"github.com/rulego/rulego/test/assert"
+	"github.com/rulego/rulego/utils/str"
+)
+
+// failureRecorder 记录断言失败信息
+type failureRecorder struct {
+	testing.TB
+	failures []string
+}
+
+func (r *failureRecorder) Helper() {
+}
+
+func (r *failureRecorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestRunAndWait(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testRunAndWait"},
+		"metadata":{"nodes":[
+			{"id":"r1","type":"rulegotest/recording","configuration":{"relationType":"True"}},
+			{"id":"r2","type":"rulegotest/recording"},
+			{"id":"r3","type":"rulegotest/recording","configuration":{"error":"r3 failed"}}],
+		"connections":[{"fromId":"r1","toId":"r2","type":"True"},{"fromId":"r1","toId":"r3","type":"False"}]}}`)
+	ruleEngine, err := engine.New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer engine.Del(ruleEngine.Id())
+
+	metadata := types.NewMetadata()
+	metadata.PutValue("status", "ok")
+	msg := types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, metadata, "{}")
+	result := RunAndWait(ruleEngine, msg, time.Second)
+	result.AssertCompleted(t)
+	result.AssertNoError(t)
+	result.AssertReached(t, "r1")
+	result.AssertReached(t, "r2")
+	result.AssertNotReached(t, "r3")
+	result.AssertRelation(t, "r1", types.True)
+	result.AssertMetadata(t, "status", "ok")
+	assert.Equal(t, 1, len(result.Ends))
+	assert.Equal(t, types.Success, result.Ends[0].RelationType)
+	assert.Equal(t, 2, len(result.Steps))
+	assert.Equal(t, types.True, result.Steps[1].RelationType)
+
+	recorded := RecordedMessages(ruleEngine, "r2")
+	assert.Equal(t, 1, len(recorded))
+	assert.Equal(t, msg.Id, recorded[0].Id)
+	assert.Equal(t, 0, len(RecordedMessages(ruleEngine, "r3")))
+	assert.Equal(t, 0, len(RecordedMessages(ruleEngine, "notFound")))
+
+	//断言失败
+	recorder := &failureRecorder{}
+	result.AssertReached(recorder, "r3")
+	result.AssertNotReached(recorder, "r2")
+	result.AssertRelation(recorder, "r1", types.False)
+	result.AssertMetadata(recorder, "status", "failed")
+	assert.Equal(t, 4, len(recorder.failures))
+	assert.Equal(t, "node r3 not reached, reached nodes: [r1 r2]", recorder.failures[0])
+}
+
+func TestRunAndWaitError(t *testing.T) {
+	def := []byte(`{"ruleChain":{"id":"testRunAndWaitError"},
+		"metadata":{"nodes":[{"id":"r1","type":"rulegotest/recording","configuration":{"error":"r1 failed"}}]}}`)
+	ruleEngine, err := engine.New(str.RandomStr(10), def)
+	assert.Nil(t, err)
+	defer engine.Del(ruleEngine.Id())
+
+	result := RunAndWait(ruleEngine, types.NewMsg(0, "TEST_MSG_TYPE", types.JSON, types.NewMetadata(), "{}"), time.Second)
+	result.AssertCompleted(t)
+	result.AssertRelation(t, "r1", types.Failure)
+	recorder := &failureRecorder{}
+	result.AssertNoError(recorder)
+	assert.Equal(t, []string{"branch ended with relation Failure error: r1 failed"}, recorder.failures)
+}
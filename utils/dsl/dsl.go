@@ -0,0 +1,601 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package dsl 规则链DSL工具
+package dsl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/utils/json"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// RelationTypes 返回规则链所有节点连接和子规则链连接使用的关系类型，已去重并按字母排序
+func RelationTypes(def types.RuleChain) []string {
+	var set = make(map[string]struct{})
+	for _, item := range def.Metadata.Connections {
+		set[item.Type] = struct{}{}
+	}
+	for _, item := range def.Metadata.RuleChainConnections {
+		set[item.Type] = struct{}{}
+	}
+	var result = make([]string, 0, len(set))
+	for k := range set {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// DefinitionHash 计算规则链定义内容的SHA-256哈希值，用于判断规则链是否发生变化
+// 哈希值与JSON字段顺序、格式以及节点和子规则链连接的顺序无关，
+// 不包含只用于可视化的additionalInfo，例如：节点位置。节点顺序会影响哈希值，因为firstNodeIndex依赖节点顺序
+// 如果配置包含无法序列化成JSON的值，返回空字符串
+func DefinitionHash(def types.RuleChain) string {
+	def.RuleChain.AdditionalInfo = nil
+	var nodes = make([]*types.RuleNode, 0, len(def.Metadata.Nodes))
+	for _, item := range def.Metadata.Nodes {
+		if item != nil {
+			node := *item
+			node.AdditionalInfo = types.NodeAdditionalInfo{}
+			nodes = append(nodes, &node)
+		}
+	}
+	def.Metadata.Nodes = nodes
+	def.Metadata.Connections = append([]types.NodeConnection(nil), def.Metadata.Connections...)
+	sort.Slice(def.Metadata.Connections, func(i, j int) bool {
+		a, b := def.Metadata.Connections[i], def.Metadata.Connections[j]
+		return a.FromId+"\x00"+a.ToId+"\x00"+a.Type < b.FromId+"\x00"+b.ToId+"\x00"+b.Type
+	})
+	def.Metadata.RuleChainConnections = append([]types.RuleChainConnection(nil), def.Metadata.RuleChainConnections...)
+	sort.Slice(def.Metadata.RuleChainConnections, func(i, j int) bool {
+		a, b := def.Metadata.RuleChainConnections[i], def.Metadata.RuleChainConnections[j]
+		return a.FromId+"\x00"+a.ToId+"\x00"+a.Type < b.FromId+"\x00"+b.ToId+"\x00"+b.Type
+	})
+	//先序列化再反序列化成map，保证配置中的结构体、map等统一按key排序输出
+	b, err := json.Marshal(def)
+	if err != nil {
+		return ""
+	}
+	var canonical interface{}
+	if err = json.Unmarshal(b, &canonical); err != nil {
+		return ""
+	}
+	if b, err = json.Marshal(canonical); err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// NodesReferencingVar 返回配置中引用了指定变量的节点ID，按节点定义顺序排列
+// 递归检查节点配置中的所有字符串，包括组件配置中的 ${vars.varName} 占位符和脚本中的 vars.varName 表达式
+func NodesReferencingVar(def types.RuleChain, varName string) []string {
+	if varName == "" {
+		return nil
+	}
+	//前后不能是标识符字符，避免匹配 myvars.name 或者 vars.name2
+	re := regexp.MustCompile(`(^|[^\w.])` + types.Vars + `\.` + regexp.QuoteMeta(varName) + `($|\W)`)
+	var result []string
+	for _, item := range def.Metadata.Nodes {
+		if item != nil && referencesVar(item.Configuration, re) {
+			result = append(result, item.Id)
+		}
+	}
+	return result
+}
+
+// referencesVar 递归检查配置值是否匹配变量引用
+func referencesVar(value interface{}, re *regexp.Regexp) bool {
+	switch v := value.(type) {
+	case string:
+		return re.MatchString(v)
+	case types.Configuration:
+		for _, item := range v {
+			if referencesVar(item, re) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if referencesVar(item, re) {
+				return true
+			}
+		}
+	case map[string]string:
+		for _, item := range v {
+			if re.MatchString(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if referencesVar(item, re) {
+				return true
+			}
+		}
+	case []string:
+		for _, item := range v {
+			if re.MatchString(item) {
+				return true
+			}
+		}
+	case nil:
+	default:
+		//其他类型的值，例如结构体，序列化后检查
+		if b, err := json.Marshal(v); err == nil {
+			return re.Match(b)
+		}
+	}
+	return false
+}
+
+// InvalidConnectionsError 规则链连接引用了未定义的节点，Problems包含所有有问题的连接，可用于一次性展示所有错误连接
+type InvalidConnectionsError struct {
+	Problems []string
+}
+
+func (e *InvalidConnectionsError) Error() string {
+	return "invalid connections: " + strings.Join(e.Problems, "; ")
+}
+
+// ValidateConnections 校验规则链节点连接和子规则链连接引用的节点是否在metadata.nodes中定义，
+// 子规则链连接的toId是规则链池中的规则链ID，不校验。ID为空的节点不能被连接引用，不参与校验
+// 存在错误返回 *InvalidConnectionsError，可以在保存规则链DSL之前调用
+func ValidateConnections(def types.RuleChain) error {
+	var nodeIds = make(map[string]struct{})
+	for _, item := range def.Metadata.Nodes {
+		if item != nil && item.Id != "" {
+			nodeIds[item.Id] = struct{}{}
+		}
+	}
+	var problems []string
+	for _, item := range def.Metadata.Connections {
+		if _, ok := nodeIds[item.FromId]; !ok {
+			problems = append(problems, fmt.Sprintf("connection %s->%s(%s): fromId %s is not a declared node", item.FromId, item.ToId, item.Type, item.FromId))
+		}
+		if _, ok := nodeIds[item.ToId]; !ok {
+			problems = append(problems, fmt.Sprintf("connection %s->%s(%s): toId %s is not a declared node", item.FromId, item.ToId, item.Type, item.ToId))
+		}
+	}
+	for _, item := range def.Metadata.RuleChainConnections {
+		if _, ok := nodeIds[item.FromId]; !ok {
+			problems = append(problems, fmt.Sprintf("rule chain connection %s->%s(%s): fromId %s is not a declared node", item.FromId, item.ToId, item.Type, item.FromId))
+		}
+	}
+	if len(problems) > 0 {
+		return &InvalidConnectionsError{Problems: problems}
+	}
+	return nil
+}
+
+// InvalidRuleChainError 规则链定义校验不通过，Problems包含所有问题
+type InvalidRuleChainError struct {
+	Problems []string
+}
+
+func (e *InvalidRuleChainError) Error() string {
+	return "invalid rule chain: " + strings.Join(e.Problems, "; ")
+}
+
+// Validate 校验规则链定义的结构，返回所有问题，包括：节点类型为空、节点ID重复、连接引用未定义的节点(参考 ValidateConnections)、
+// 第一个节点、命名入口和死信节点不存在。不初始化组件，也不检测连接环路
+// 存在错误返回 *InvalidRuleChainError
+func Validate(def types.RuleChain) error {
+	var problems []string
+	var nodeTypes = make(map[string]string, len(def.Metadata.Nodes))
+	for index, item := range def.Metadata.Nodes {
+		if item == nil {
+			problems = append(problems, fmt.Sprintf("node at index %d is nil", index))
+			continue
+		}
+		if item.Type == "" {
+			problems = append(problems, fmt.Sprintf("node %s: type is empty", item.Id))
+		}
+		if item.Id == "" {
+			continue
+		}
+		if nodeType, ok := nodeTypes[item.Id]; ok {
+			problems = append(problems, fmt.Sprintf("duplicate node id %s: node types %s and %s", item.Id, nodeType, item.Type))
+		} else {
+			nodeTypes[item.Id] = item.Type
+		}
+	}
+	if err := ValidateConnections(def); err != nil {
+		problems = append(problems, err.(*InvalidConnectionsError).Problems...)
+	}
+	if nodeId := def.Metadata.FirstNodeId; nodeId != "" {
+		if _, ok := nodeTypes[nodeId]; !ok {
+			problems = append(problems, fmt.Sprintf("firstNodeId %s is not a declared node", nodeId))
+		}
+	} else if index := def.Metadata.FirstNodeIndex; len(def.Metadata.Nodes) > 0 && (index < 0 || index >= len(def.Metadata.Nodes)) {
+		problems = append(problems, fmt.Sprintf("firstNodeIndex %d is out of range", index))
+	}
+	var entryPoints []string
+	for name := range def.Metadata.EntryPoints {
+		entryPoints = append(entryPoints, name)
+	}
+	sort.Strings(entryPoints)
+	for _, name := range entryPoints {
+		nodeId := def.Metadata.EntryPoints[name]
+		if _, ok := nodeTypes[nodeId]; !ok {
+			problems = append(problems, fmt.Sprintf("entry point %s: node %s is not a declared node", name, nodeId))
+		}
+	}
+	if nodeId := def.RuleChain.DeadLetterNodeId; nodeId != "" {
+		if _, ok := nodeTypes[nodeId]; !ok {
+			problems = append(problems, fmt.Sprintf("deadLetterNodeId %s is not a declared node", nodeId))
+		}
+	}
+	if len(problems) > 0 {
+		return &InvalidRuleChainError{Problems: problems}
+	}
+	return nil
+}
+
+// Merge 把overlay规则链片段合并到base规则链，返回新的规则链，不修改base和overlay
+// overlay的节点ID加上prefix前缀，并相应改写overlay的节点连接和子规则链连接，然后追加到base之后，
+// 因此base的firstNodeIndex保持不变。规则链配置(例如：vars)按key合并
+// 以下情况返回错误：
+//   - 加上前缀后的节点ID与base节点ID冲突或者为空
+//   - overlay的连接引用了overlay中不存在的节点
+//   - 规则链配置存在相同key但值不同的项
+//   - overlay定义了endpoints，endpoints可能通过节点ID引用节点，无法自动改写
+func Merge(base, overlay types.RuleChain, prefix string) (types.RuleChain, error) {
+	if len(overlay.Metadata.Endpoints) > 0 {
+		return types.RuleChain{}, fmt.Errorf("merge overlay %s: endpoints are not supported", overlay.RuleChain.ID)
+	}
+	result := base
+	result.Metadata.Nodes = make([]*types.RuleNode, 0, len(base.Metadata.Nodes)+len(overlay.Metadata.Nodes))
+	var nodeIds = make(map[string]struct{})
+	for _, item := range base.Metadata.Nodes {
+		if item != nil {
+			node := *item
+			result.Metadata.Nodes = append(result.Metadata.Nodes, &node)
+			nodeIds[node.Id] = struct{}{}
+		}
+	}
+	var overlayIds = make(map[string]struct{})
+	for _, item := range overlay.Metadata.Nodes {
+		if item == nil {
+			continue
+		}
+		node := *item
+		node.Id = prefix + item.Id
+		if node.Id == "" {
+			return types.RuleChain{}, fmt.Errorf("merge overlay %s: node id is empty", overlay.RuleChain.ID)
+		}
+		if _, ok := nodeIds[node.Id]; ok {
+			return types.RuleChain{}, fmt.Errorf("merge overlay %s: node id %s conflicts", overlay.RuleChain.ID, node.Id)
+		}
+		nodeIds[node.Id] = struct{}{}
+		overlayIds[item.Id] = struct{}{}
+		result.Metadata.Nodes = append(result.Metadata.Nodes, &node)
+	}
+	checkNode := func(id string) error {
+		if _, ok := overlayIds[id]; !ok {
+			return fmt.Errorf("merge overlay %s: connection references unknown node %s", overlay.RuleChain.ID, id)
+		}
+		return nil
+	}
+	result.Metadata.Connections = append([]types.NodeConnection(nil), base.Metadata.Connections...)
+	for _, item := range overlay.Metadata.Connections {
+		if err := checkNode(item.FromId); err != nil {
+			return types.RuleChain{}, err
+		}
+		if err := checkNode(item.ToId); err != nil {
+			return types.RuleChain{}, err
+		}
+		item.FromId = prefix + item.FromId
+		item.ToId = prefix + item.ToId
+		result.Metadata.Connections = append(result.Metadata.Connections, item)
+	}
+	result.Metadata.RuleChainConnections = append([]types.RuleChainConnection(nil), base.Metadata.RuleChainConnections...)
+	for _, item := range overlay.Metadata.RuleChainConnections {
+		if err := checkNode(item.FromId); err != nil {
+			return types.RuleChain{}, err
+		}
+		//ToId 是子规则链ID，不加前缀
+		item.FromId = prefix + item.FromId
+		result.Metadata.RuleChainConnections = append(result.Metadata.RuleChainConnections, item)
+	}
+	configuration, err := mergeConfiguration(base.RuleChain.Configuration, overlay.RuleChain.Configuration)
+	if err != nil {
+		return types.RuleChain{}, fmt.Errorf("merge overlay %s: %w", overlay.RuleChain.ID, err)
+	}
+	result.RuleChain.Configuration = configuration
+	return result, nil
+}
+
+// mergeConfiguration 合并规则链配置，map类型的值(例如：vars)按key合并，相同key的值不同则返回错误
+func mergeConfiguration(base, overlay types.Configuration) (types.Configuration, error) {
+	if len(overlay) == 0 {
+		return base, nil
+	}
+	var result = make(types.Configuration, len(base)+len(overlay))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range overlay {
+		baseValue, ok := result[k]
+		if !ok {
+			result[k] = v
+			continue
+		}
+		baseMap, baseIsMap := toMap(baseValue)
+		overlayMap, overlayIsMap := toMap(v)
+		if baseIsMap && overlayIsMap {
+			var merged = make(map[string]interface{}, len(baseMap)+len(overlayMap))
+			for mk, mv := range baseMap {
+				merged[mk] = mv
+			}
+			for mk, mv := range overlayMap {
+				if existing, ok := merged[mk]; ok && !reflect.DeepEqual(existing, mv) {
+					return nil, fmt.Errorf("configuration %s.%s conflicts", k, mk)
+				}
+				merged[mk] = mv
+			}
+			result[k] = merged
+		} else if !reflect.DeepEqual(baseValue, v) {
+			return nil, fmt.Errorf("configuration %s conflicts", k)
+		}
+	}
+	return result, nil
+}
+
+// toMap 把map[string]interface{}或者map[string]string类型的值转换成map[string]interface{}
+func toMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case types.Configuration:
+		return m, true
+	case map[string]string:
+		var result = make(map[string]interface{}, len(m))
+		for k, item := range m {
+			result[k] = item
+		}
+		return result, true
+	}
+	return nil, false
+}
+
+// ChainDiff 两个规则链定义的差异，可用于重新加载前展示变更内容或者记录审计日志
+// 节点按ID匹配，连接按起点、终点和关系类型匹配，与节点和连接的顺序无关
+type ChainDiff struct {
+	//AddedNodes 新增的节点ID
+	AddedNodes []string `json:"addedNodes,omitempty"`
+	//RemovedNodes 删除的节点ID
+	RemovedNodes []string `json:"removedNodes,omitempty"`
+	//ModifiedNodes 修改的节点
+	ModifiedNodes []NodeDiff `json:"modifiedNodes,omitempty"`
+	//AddedConnections 新增的节点连接
+	AddedConnections []types.NodeConnection `json:"addedConnections,omitempty"`
+	//RemovedConnections 删除的节点连接
+	RemovedConnections []types.NodeConnection `json:"removedConnections,omitempty"`
+	//AddedRuleChainConnections 新增的子规则链连接
+	AddedRuleChainConnections []types.RuleChainConnection `json:"addedRuleChainConnections,omitempty"`
+	//RemovedRuleChainConnections 删除的子规则链连接
+	RemovedRuleChainConnections []types.RuleChainConnection `json:"removedRuleChainConnections,omitempty"`
+	//FirstNodeChanged 消息进入规则链的第一个节点是否变化，firstNodeIndex和firstNodeId指向同一个节点视为不变
+	FirstNodeChanged bool `json:"firstNodeChanged,omitempty"`
+	//Vars 规则链vars变量的变化
+	Vars KeyDiff `json:"vars"`
+	//Secrets 规则链secrets的变化，只包含key，不包含值
+	Secrets KeyDiff `json:"secrets"`
+}
+
+// NodeDiff 节点定义的差异
+type NodeDiff struct {
+	//Id 节点ID
+	Id string `json:"id"`
+	//Fields 变化的节点字段，可能的值：type、name、debugMode、terminal
+	Fields []string `json:"fields,omitempty"`
+	//ConfigurationKeys 变化的顶层配置key，包括新增和删除的key
+	ConfigurationKeys []string `json:"configurationKeys,omitempty"`
+}
+
+// KeyDiff key/value配置的差异，只记录key
+type KeyDiff struct {
+	//Added 新增的key
+	Added []string `json:"added,omitempty"`
+	//Removed 删除的key
+	Removed []string `json:"removed,omitempty"`
+	//Modified 值变化的key
+	Modified []string `json:"modified,omitempty"`
+}
+
+// IsEmpty 是否没有任何变化
+func (d KeyDiff) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Modified) == 0
+}
+
+// IsEmpty 规则链定义是否没有任何变化
+func (d ChainDiff) IsEmpty() bool {
+	return len(d.AddedNodes) == 0 && len(d.RemovedNodes) == 0 && len(d.ModifiedNodes) == 0 &&
+		len(d.AddedConnections) == 0 && len(d.RemovedConnections) == 0 &&
+		len(d.AddedRuleChainConnections) == 0 && len(d.RemovedRuleChainConnections) == 0 &&
+		!d.FirstNodeChanged && d.Vars.IsEmpty() && d.Secrets.IsEmpty()
+}
+
+// defaultNodeIdPrefix 没有ID的节点使用的默认ID前缀，与规则引擎分配的节点ID一致
+const defaultNodeIdPrefix = "node"
+
+// Diff 比较两个规则链定义，返回节点、连接、第一个节点以及vars和secrets的变化
+// 节点配置和规则链配置按照JSON语义比较，与key顺序以及map、数字的具体类型无关。
+// 不比较只用于可视化的additionalInfo。secrets只返回变化的key，不返回值
+func Diff(oldDef, newDef types.RuleChain) ChainDiff {
+	var diff ChainDiff
+	oldNodes, oldIds := indexNodes(oldDef)
+	newNodes, newIds := indexNodes(newDef)
+	for _, id := range newIds {
+		oldNode, ok := oldNodes[id]
+		if !ok {
+			diff.AddedNodes = append(diff.AddedNodes, id)
+		} else if nodeDiff := diffNode(id, oldNode, newNodes[id]); len(nodeDiff.Fields) > 0 || len(nodeDiff.ConfigurationKeys) > 0 {
+			diff.ModifiedNodes = append(diff.ModifiedNodes, nodeDiff)
+		}
+	}
+	for _, id := range oldIds {
+		if _, ok := newNodes[id]; !ok {
+			diff.RemovedNodes = append(diff.RemovedNodes, id)
+		}
+	}
+	diff.AddedConnections, diff.RemovedConnections = diffNodeConnections(oldDef.Metadata.Connections, newDef.Metadata.Connections)
+	diff.AddedRuleChainConnections, diff.RemovedRuleChainConnections = diffRuleChainConnections(oldDef.Metadata.RuleChainConnections, newDef.Metadata.RuleChainConnections)
+	diff.FirstNodeChanged = firstNodeId(oldDef, oldIds) != firstNodeId(newDef, newIds)
+	diff.Vars = diffKeys(oldDef.RuleChain.Configuration[types.Vars], newDef.RuleChain.Configuration[types.Vars])
+	diff.Secrets = diffKeys(oldDef.RuleChain.Configuration[types.Secrets], newDef.RuleChain.Configuration[types.Secrets])
+	return diff
+}
+
+// indexNodes 按节点ID索引节点，返回节点映射和按定义顺序排列的节点ID
+func indexNodes(def types.RuleChain) (map[string]*types.RuleNode, []string) {
+	var nodes = make(map[string]*types.RuleNode, len(def.Metadata.Nodes))
+	var ids = make([]string, 0, len(def.Metadata.Nodes))
+	for index, item := range def.Metadata.Nodes {
+		if item == nil {
+			continue
+		}
+		id := item.Id
+		if id == "" {
+			id = fmt.Sprintf(defaultNodeIdPrefix+"%d", index)
+		}
+		nodes[id] = item
+		ids = append(ids, id)
+	}
+	return nodes, ids
+}
+
+// firstNodeId 获取第一个节点ID，优先使用firstNodeId，否则使用firstNodeIndex对应的节点
+func firstNodeId(def types.RuleChain, ids []string) string {
+	if def.Metadata.FirstNodeId != "" {
+		return def.Metadata.FirstNodeId
+	}
+	if index := def.Metadata.FirstNodeIndex; index >= 0 && index < len(ids) {
+		return ids[index]
+	}
+	return ""
+}
+
+// diffNode 比较同一个ID的两个节点定义
+func diffNode(id string, oldNode, newNode *types.RuleNode) NodeDiff {
+	var diff = NodeDiff{Id: id}
+	if oldNode.Type != newNode.Type {
+		diff.Fields = append(diff.Fields, "type")
+	}
+	if oldNode.Name != newNode.Name {
+		diff.Fields = append(diff.Fields, "name")
+	}
+	if oldNode.DebugMode != newNode.DebugMode {
+		diff.Fields = append(diff.Fields, "debugMode")
+	}
+	if oldNode.Terminal != newNode.Terminal {
+		diff.Fields = append(diff.Fields, "terminal")
+	}
+	keys := diffKeys(oldNode.Configuration, newNode.Configuration)
+	diff.ConfigurationKeys = append(append(append(diff.ConfigurationKeys, keys.Added...), keys.Removed...), keys.Modified...)
+	sort.Strings(diff.ConfigurationKeys)
+	return diff
+}
+
+// diffKeys 比较两个key/value配置，值按照JSON语义比较，结果按key排序
+func diffKeys(oldValue, newValue interface{}) KeyDiff {
+	var diff KeyDiff
+	oldMap, _ := toMap(oldValue)
+	newMap, _ := toMap(newValue)
+	for k, v := range newMap {
+		if oldItem, ok := oldMap[k]; !ok {
+			diff.Added = append(diff.Added, k)
+		} else if !jsonEqual(oldItem, v) {
+			diff.Modified = append(diff.Modified, k)
+		}
+	}
+	for k := range oldMap {
+		if _, ok := newMap[k]; !ok {
+			diff.Removed = append(diff.Removed, k)
+		}
+	}
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Modified)
+	return diff
+}
+
+// jsonEqual 序列化成JSON再反序列化后比较，忽略map、结构体和数字的具体类型差异
+func jsonEqual(a, b interface{}) bool {
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+	ab, errA := json.Marshal(a)
+	bb, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	var av, bv interface{}
+	if json.Unmarshal(ab, &av) != nil || json.Unmarshal(bb, &bv) != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// diffNodeConnections 比较节点连接，返回新增和删除的连接，保持连接的定义顺序
+func diffNodeConnections(oldItems, newItems []types.NodeConnection) (added, removed []types.NodeConnection) {
+	var oldSet = make(map[types.NodeConnection]struct{}, len(oldItems))
+	var newSet = make(map[types.NodeConnection]struct{}, len(newItems))
+	for _, item := range oldItems {
+		oldSet[item] = struct{}{}
+	}
+	for _, item := range newItems {
+		newSet[item] = struct{}{}
+		if _, ok := oldSet[item]; !ok {
+			added = append(added, item)
+		}
+	}
+	for _, item := range oldItems {
+		if _, ok := newSet[item]; !ok {
+			removed = append(removed, item)
+		}
+	}
+	return added, removed
+}
+
+// diffRuleChainConnections 比较子规则链连接，返回新增和删除的连接，保持连接的定义顺序
+func diffRuleChainConnections(oldItems, newItems []types.RuleChainConnection) (added, removed []types.RuleChainConnection) {
+	var oldSet = make(map[types.RuleChainConnection]struct{}, len(oldItems))
+	var newSet = make(map[types.RuleChainConnection]struct{}, len(newItems))
+	for _, item := range oldItems {
+		oldSet[item] = struct{}{}
+	}
+	for _, item := range newItems {
+		newSet[item] = struct{}{}
+		if _, ok := oldSet[item]; !ok {
+			added = append(added, item)
+		}
+	}
+	for _, item := range oldItems {
+		if _, ok := newSet[item]; !ok {
+			removed = append(removed, item)
+		}
+	}
+	return added, removed
+}
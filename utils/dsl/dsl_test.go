@@ -0,0 +1,197 @@
+/*
+ * Copyright 2024 The RuleGo Authors.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package dsl
+
+import (
+	"github.com/rulego/rulego/api/types"
+	"github.com/rulego/rulego/test/assert"
+	"github.com/rulego/rulego/utils/json"
+	"strings"
+	"testing"
+)
+
+func TestRelationTypes(t *testing.T) {
+	var def types.RuleChain
+	assert.Equal(t, 0, len(RelationTypes(def)))
+
+	def.Metadata.Connections = []types.NodeConnection{
+		{FromId: "s1", ToId: "s2", Type: types.True},
+		{FromId: "s1", ToId: "s3", Type: types.False},
+		{FromId: "s2", ToId: "s3", Type: types.Success},
+		{FromId: "s3", ToId: "s4", Type: types.Success},
+	}
+	def.Metadata.RuleChainConnections = []types.RuleChainConnection{
+		{FromId: "s4", ToId: "chain01", Type: types.Failure},
+		{FromId: "s4", ToId: "chain02", Type: types.Success},
+	}
+	assert.Equal(t, "Failure,False,Success,True", strings.Join(RelationTypes(def), ","))
+}
+
+func TestDefinitionHash(t *testing.T) {
+	dsl1 := `{"ruleChain":{"id":"test01","name":"test"},"metadata":{"nodes":[
+		{"id":"s1","type":"jsFilter","additionalInfo":{"layoutX":10},"configuration":{"jsScript":"return true;","a":1,"b":{"x":1,"y":2}}},
+		{"id":"s2","type":"log","configuration":{}}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"True"},{"fromId":"s1","toId":"s2","type":"False"}]}}`
+	dsl2 := `{
+	  "metadata": {
+	    "connections": [{"type":"False","fromId":"s1","toId":"s2"},{"type":"True","fromId":"s1","toId":"s2"}],
+	    "nodes": [
+	      {"configuration":{"b":{"y":2,"x":1},"a":1.0,"jsScript":"return true;"},"type":"jsFilter","id":"s1","additionalInfo":{"layoutX":200}},
+	      {"configuration":{},"type":"log","id":"s2"}
+	    ]
+	  },
+	  "ruleChain": {"name":"test","id":"test01"}
+	}`
+	var def1, def2 types.RuleChain
+	assert.Nil(t, json.Unmarshal([]byte(dsl1), &def1))
+	assert.Nil(t, json.Unmarshal([]byte(dsl2), &def2))
+	hash := DefinitionHash(def1)
+	assert.Equal(t, 64, len(hash))
+	assert.Equal(t, hash, DefinitionHash(def2))
+	//不修改原定义
+	assert.Equal(t, 10, def1.Metadata.Nodes[0].AdditionalInfo.LayoutX)
+
+	def2.Metadata.Nodes[0].Configuration["jsScript"] = "return false;"
+	assert.NotEqual(t, hash, DefinitionHash(def2))
+}
+
+func TestNodesReferencingVar(t *testing.T) {
+	var def types.RuleChain
+	def.Metadata.Nodes = []*types.RuleNode{
+		{Id: "s1", Configuration: types.Configuration{"server": "${vars.ip}:${vars.port}"}},
+		{Id: "s2", Configuration: types.Configuration{"jsScript": "return vars.ip == msg.ip && vars.ipList.length>0;"}},
+		{Id: "s3", Configuration: types.Configuration{"headers": map[string]interface{}{"X-Ip": "${ vars.ip }"}}},
+		{Id: "s4", Configuration: types.Configuration{"myvars": "myvars.ip", "list": []interface{}{"vars.ipList", 1}}},
+		{Id: "s5", Configuration: types.Configuration{"topics": []interface{}{map[string]interface{}{"topic": "/device/${vars.ip}"}}}},
+		{Id: "s6"},
+	}
+	assert.Equal(t, "s1,s2,s3,s5", strings.Join(NodesReferencingVar(def, "ip"), ","))
+	assert.Equal(t, "s2,s4", strings.Join(NodesReferencingVar(def, "ipList"), ","))
+	assert.Equal(t, "s1", strings.Join(NodesReferencingVar(def, "port"), ","))
+	assert.Equal(t, 0, len(NodesReferencingVar(def, "name")))
+	assert.Equal(t, 0, len(NodesReferencingVar(def, "")))
+}
+
+func TestMerge(t *testing.T) {
+	var base types.RuleChain
+	base.RuleChain.ID = "base"
+	base.RuleChain.Configuration = types.Configuration{types.Vars: map[string]interface{}{"ip": "127.0.0.1"}}
+	base.Metadata.Nodes = []*types.RuleNode{{Id: "s1", Type: "jsFilter"}, {Id: "s2", Type: "log"}}
+	base.Metadata.Connections = []types.NodeConnection{{FromId: "s1", ToId: "s2", Type: types.True}}
+
+	var overlay types.RuleChain
+	overlay.RuleChain.ID = "fragment"
+	overlay.RuleChain.Configuration = types.Configuration{types.Vars: map[string]string{"ip": "127.0.0.1", "port": "8080"}}
+	overlay.Metadata.Nodes = []*types.RuleNode{{Id: "s1", Type: "jsTransform"}, {Id: "s2", Type: "restApiCall"}}
+	overlay.Metadata.Connections = []types.NodeConnection{{FromId: "s1", ToId: "s2", Type: types.Success}}
+	overlay.Metadata.RuleChainConnections = []types.RuleChainConnection{{FromId: "s2", ToId: "chain01", Type: types.Failure}}
+
+	result, err := Merge(base, overlay, "f_")
+	assert.Nil(t, err)
+	var ids []string
+	for _, item := range result.Metadata.Nodes {
+		ids = append(ids, item.Id)
+	}
+	assert.Equal(t, "s1,s2,f_s1,f_s2", strings.Join(ids, ","))
+	assert.Equal(t, 2, len(result.Metadata.Connections))
+	assert.Equal(t, types.NodeConnection{FromId: "f_s1", ToId: "f_s2", Type: types.Success}, result.Metadata.Connections[1])
+	assert.Equal(t, types.RuleChainConnection{FromId: "f_s2", ToId: "chain01", Type: types.Failure}, result.Metadata.RuleChainConnections[0])
+	vars := result.RuleChain.Configuration[types.Vars].(map[string]interface{})
+	assert.Equal(t, "8080", vars["port"])
+	//不修改原规则链
+	assert.Equal(t, "s1", overlay.Metadata.Nodes[0].Id)
+	assert.Equal(t, 1, len(base.Metadata.Connections))
+	assert.Equal(t, 1, len(base.RuleChain.Configuration[types.Vars].(map[string]interface{})))
+	_, err = json.Marshal(result)
+	assert.Nil(t, err)
+
+	//节点ID冲突
+	_, err = Merge(base, overlay, "")
+	assert.Equal(t, "merge overlay fragment: node id s1 conflicts", err.Error())
+	//变量冲突
+	overlay.RuleChain.Configuration = types.Configuration{types.Vars: map[string]string{"ip": "192.168.1.1"}}
+	_, err = Merge(base, overlay, "f_")
+	assert.Equal(t, "merge overlay fragment: configuration vars.ip conflicts", err.Error())
+	//连接引用不存在的节点
+	overlay.RuleChain.Configuration = nil
+	overlay.Metadata.Connections = append(overlay.Metadata.Connections, types.NodeConnection{FromId: "s2", ToId: "s3", Type: types.Success})
+	_, err = Merge(base, overlay, "f_")
+	assert.Equal(t, "merge overlay fragment: connection references unknown node s3", err.Error())
+}
+
+func TestDiff(t *testing.T) {
+	oldJson := `{"ruleChain":{"id":"chain01","configuration":{"vars":{"ip":"127.0.0.1","port":"8080"},"secrets":{"password":"abc","token":"t1"}}},
+		"metadata":{"firstNodeIndex":0,"nodes":[
+		{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},
+		{"id":"s2","type":"restApiCall","configuration":{"restEndpointUrlPattern":"http://a","requestMethod":"POST","headers":{"a":"1","b":"2"}}},
+		{"id":"s3","type":"log","configuration":{"jsScript":"return msg;"}}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"True"},{"fromId":"s2","toId":"s3","type":"Success"}]}}`
+	//key顺序不同，firstNodeId与原firstNodeIndex指向同一个节点
+	newJson := `{"ruleChain":{"id":"chain01","configuration":{"secrets":{"password":"xyz","apiKey":"k1"},"vars":{"port":"8080","ip":"127.0.0.1"}}},
+		"metadata":{"firstNodeId":"s1","nodes":[
+		{"id":"s4","type":"jsTransform","configuration":{"jsScript":"return {'msg':msg,'metadata':metadata,'msgType':msgType};"}},
+		{"id":"s1","type":"jsFilter","configuration":{"jsScript":"return true;"}},
+		{"id":"s2","type":"restApiCall","debugMode":true,"configuration":{"headers":{"b":"2","a":"1"},"requestMethod":"GET","restEndpointUrlPattern":"http://a","readTimeoutMs":2000}}],
+		"connections":[{"fromId":"s1","toId":"s2","type":"True"},{"fromId":"s2","toId":"s4","type":"Success"}]}}`
+	var oldDef, newDef types.RuleChain
+	assert.Nil(t, json.Unmarshal([]byte(oldJson), &oldDef))
+	assert.Nil(t, json.Unmarshal([]byte(newJson), &newDef))
+
+	diff := Diff(oldDef, newDef)
+	assert.Equal(t, []string{"s4"}, diff.AddedNodes)
+	assert.Equal(t, []string{"s3"}, diff.RemovedNodes)
+	assert.Equal(t, []NodeDiff{{Id: "s2", Fields: []string{"debugMode"}, ConfigurationKeys: []string{"readTimeoutMs", "requestMethod"}}}, diff.ModifiedNodes)
+	assert.Equal(t, []types.NodeConnection{{FromId: "s2", ToId: "s4", Type: types.Success}}, diff.AddedConnections)
+	assert.Equal(t, []types.NodeConnection{{FromId: "s2", ToId: "s3", Type: types.Success}}, diff.RemovedConnections)
+	assert.False(t, diff.FirstNodeChanged)
+	assert.True(t, diff.Vars.IsEmpty())
+	assert.Equal(t, KeyDiff{Added: []string{"apiKey"}, Removed: []string{"token"}, Modified: []string{"password"}}, diff.Secrets)
+	//不包含secret的值
+	b, err := json.Marshal(diff)
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(b), "xyz"))
+	assert.False(t, strings.Contains(string(b), "abc"))
+
+	//第一个节点变化
+	newDef.Metadata.FirstNodeId = "s2"
+	assert.True(t, Diff(oldDef, newDef).FirstNodeChanged)
+
+	assert.True(t, Diff(oldDef, oldDef).IsEmpty())
+	assert.False(t, diff.IsEmpty())
+}
+
+func TestValidate(t *testing.T) {
+	def := types.RuleChain{}
+	def.Metadata.Nodes = []*types.RuleNode{{Id: "s1", Type: "log"}, {Id: "s2", Type: "log"}}
+	def.Metadata.Connections = []types.NodeConnection{{FromId: "s1", ToId: "s2", Type: types.Success}}
+	assert.Nil(t, Validate(def))
+
+	def.Metadata.FirstNodeIndex = 2
+	def.Metadata.EntryPoints = map[string]string{"b": "s9", "a": "s1"}
+	def.RuleChain.DeadLetterNodeId = "dl"
+	def.Metadata.Nodes = append(def.Metadata.Nodes, &types.RuleNode{Id: "s2"})
+	def.Metadata.Connections = append(def.Metadata.Connections, types.NodeConnection{FromId: "s8", ToId: "s2", Type: types.Success})
+	err := Validate(def)
+	assert.NotNil(t, err)
+	assert.Equal(t, []string{
+		"node s2: type is empty",
+		"duplicate node id s2: node types log and ",
+		"connection s8->s2(Success): fromId s8 is not a declared node",
+		"entry point b: node s9 is not a declared node",
+		"deadLetterNodeId dl is not a declared node",
+	}, err.(*InvalidRuleChainError).Problems)
+}